@@ -18,22 +18,29 @@ import (
 	informers "github.com/openfaas/faas-netes/pkg/client/informers/externalversions"
 	v1 "github.com/openfaas/faas-netes/pkg/client/informers/externalversions/openfaas/v1"
 	"github.com/openfaas/faas-netes/pkg/config"
+	"github.com/openfaas/faas-netes/pkg/controller"
 	"github.com/openfaas/faas-netes/pkg/handlers"
 	"github.com/openfaas/faas-netes/pkg/k8s"
+	"github.com/openfaas/faas-netes/pkg/proxy"
+	"github.com/openfaas/faas-netes/pkg/queue"
 	"github.com/openfaas/faas-netes/pkg/signals"
 	version "github.com/openfaas/faas-netes/version"
 	faasProvider "github.com/openfaas/faas-provider"
+	"github.com/openfaas/faas-provider/auth"
 	"github.com/openfaas/faas-provider/logs"
-	"github.com/openfaas/faas-provider/proxy"
+	fproxy "github.com/openfaas/faas-provider/proxy"
 	providertypes "github.com/openfaas/faas-provider/types"
+	"github.com/prometheus/client_golang/prometheus"
 
 	kubeinformers "k8s.io/client-go/informers"
 	v1apps "k8s.io/client-go/informers/apps/v1"
 	v1core "k8s.io/client-go/informers/core/v1"
+	v1discovery "k8s.io/client-go/informers/discovery/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/klog"
+	metricsclientset "k8s.io/metrics/pkg/client/clientset/versioned"
 
 	// required to authenticate against GKE clusters
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
@@ -47,7 +54,8 @@ func main() {
 	var kubeconfig string
 	var masterURL string
 	var (
-		verbose bool
+		verbose  bool
+		operator bool
 	)
 
 	flag.StringVar(&kubeconfig, "kubeconfig", "",
@@ -55,7 +63,7 @@ func main() {
 	flag.BoolVar(&verbose, "verbose", false, "Print verbose config information")
 	flag.StringVar(&masterURL, "master", "",
 		"The address of the Kubernetes API server. Overrides any value in kubeconfig. Only required if out-of-cluster.")
-	flag.Bool("operator", false, "Run as an operator (not available in CE)")
+	flag.BoolVar(&operator, "operator", false, "Run as an operator to reconcile Function custom resources")
 
 	flag.Parse()
 
@@ -92,6 +100,11 @@ Version: %s Commit: %s
 		log.Fatalf("Error building OpenFaaS clientset: %s", err.Error())
 	}
 
+	metricsClient, err := metricsclientset.NewForConfig(clientCmdConfig)
+	if err != nil {
+		log.Fatalf("Error building metrics clientset: %s", err.Error())
+	}
+
 	readConfig := config.ReadConfig{}
 	osEnv := providertypes.OsEnv{}
 	config, err := readConfig.Read(osEnv)
@@ -102,10 +115,17 @@ Version: %s Commit: %s
 
 	config.Fprint(verbose)
 
+	if err := k8s.ValidateLoadBalancer(config.LoadBalancer); err != nil {
+		log.Fatalf("Error reading config: %s", err.Error())
+	}
+
 	deployConfig := k8s.DeploymentConfig{
-		RuntimeHTTPPort: 8080,
-		HTTPProbe:       config.HTTPProbe,
-		SetNonRootUser:  config.SetNonRootUser,
+		RuntimeHTTPPort:   int32(config.FunctionPort),
+		HTTPProbe:         config.HTTPProbe,
+		SetNonRootUser:    config.SetNonRootUser,
+		ProfilesNamespace: config.ProfilesNamespace,
+		ScaleToZero:       config.ScaleToZero,
+		HPA:               config.HPA,
 		ReadinessProbe: &k8s.ProbeConfig{
 			InitialDelaySeconds: int32(2),
 			TimeoutSeconds:      int32(1),
@@ -124,11 +144,18 @@ Version: %s Commit: %s
 		klog.Fatal("DefaultFunctionNamespace must be set")
 	}
 
-	kubeInformerOpt := kubeinformers.WithNamespace(namespaceScope)
-	kubeInformerFactory := kubeinformers.NewSharedInformerFactoryWithOptions(kubeClient, defaultResync, kubeInformerOpt)
+	kubeInformerOpts := []kubeinformers.SharedInformerOption{}
+	faasInformerOpts := []informers.SharedInformerOption{}
+
+	// With a ClusterRole, the informers watch every namespace so that functions
+	// in any namespace annotated with "openfaas" can be managed
+	if !config.ClusterRole {
+		kubeInformerOpts = append(kubeInformerOpts, kubeinformers.WithNamespace(namespaceScope))
+		faasInformerOpts = append(faasInformerOpts, informers.WithNamespace(namespaceScope))
+	}
 
-	faasInformerOpt := informers.WithNamespace(namespaceScope)
-	faasInformerFactory := informers.NewSharedInformerFactoryWithOptions(faasClient, defaultResync, faasInformerOpt)
+	kubeInformerFactory := kubeinformers.NewSharedInformerFactoryWithOptions(kubeClient, defaultResync, kubeInformerOpts...)
+	faasInformerFactory := informers.NewSharedInformerFactoryWithOptions(faasClient, defaultResync, faasInformerOpts...)
 
 	factory := k8s.NewFunctionFactory(kubeClient, deployConfig, faasClient.OpenfaasV1())
 
@@ -139,15 +166,20 @@ Version: %s Commit: %s
 		faasInformerFactory: faasInformerFactory,
 		kubeClient:          kubeClient,
 		faasClient:          faasClient,
+		metricsClient:       metricsClient,
+		operator:            operator,
 	}
 
 	runController(setup)
 }
 
 type customInformers struct {
-	EndpointsInformer  v1core.EndpointsInformer
-	DeploymentInformer v1apps.DeploymentInformer
-	FunctionsInformer  v1.FunctionInformer
+	EndpointsInformer     v1core.EndpointsInformer
+	EndpointSliceInformer v1discovery.EndpointSliceInformer
+	DeploymentInformer    v1apps.DeploymentInformer
+	FunctionsInformer     v1.FunctionInformer
+	ProfilesInformer      v1.ProfileInformer
+	NamespacesInformer    v1core.NamespaceInformer
 }
 
 func startInformers(setup serverSetup, stopCh <-chan struct{}, operator bool) customInformers {
@@ -163,23 +195,53 @@ func startInformers(setup serverSetup, stopCh <-chan struct{}, operator bool) cu
 		}
 	}
 
-	deployments := kubeInformerFactory.Apps().V1().Deployments()
-	go deployments.Informer().Run(stopCh)
-	if ok := cache.WaitForNamedCacheSync("faas-netes:deployments", stopCh, deployments.Informer().HasSynced); !ok {
+	// Profiles are read from their own namespace, which the other informers may not watch
+	profilesInformerFactory := informers.NewSharedInformerFactoryWithOptions(setup.faasClient, defaultResync,
+		informers.WithNamespace(setup.config.ProfilesNamespace))
+	profiles := profilesInformerFactory.Openfaas().V1().Profiles()
+	go profiles.Informer().Run(stopCh)
+	if ok := cache.WaitForNamedCacheSync("faas-netes:profiles", stopCh, profiles.Informer().HasSynced); !ok {
 		log.Fatalf("failed to wait for cache to sync")
 	}
 
-	endpoints := kubeInformerFactory.Core().V1().Endpoints()
-	go endpoints.Informer().Run(stopCh)
-	if ok := cache.WaitForNamedCacheSync("faas-netes:endpoints", stopCh, endpoints.Informer().HasSynced); !ok {
+	deployments := kubeInformerFactory.Apps().V1().Deployments()
+	go deployments.Informer().Run(stopCh)
+	if ok := cache.WaitForNamedCacheSync("faas-netes:deployments", stopCh, deployments.Informer().HasSynced); !ok {
 		log.Fatalf("failed to wait for cache to sync")
 	}
 
-	return customInformers{
-		EndpointsInformer:  endpoints,
+	listers := customInformers{
 		DeploymentInformer: deployments,
 		FunctionsInformer:  functions,
+		ProfilesInformer:   profiles,
+	}
+
+	if setup.config.EndpointSlices {
+		slices := kubeInformerFactory.Discovery().V1().EndpointSlices()
+		go slices.Informer().Run(stopCh)
+		if ok := cache.WaitForNamedCacheSync("faas-netes:endpointslices", stopCh, slices.Informer().HasSynced); !ok {
+			log.Fatalf("failed to wait for cache to sync")
+		}
+		listers.EndpointSliceInformer = slices
+	} else {
+		endpoints := kubeInformerFactory.Core().V1().Endpoints()
+		go endpoints.Informer().Run(stopCh)
+		if ok := cache.WaitForNamedCacheSync("faas-netes:endpoints", stopCh, endpoints.Informer().HasSynced); !ok {
+			log.Fatalf("failed to wait for cache to sync")
+		}
+		listers.EndpointsInformer = endpoints
 	}
+
+	if setup.config.ClusterRole {
+		namespaces := kubeInformerFactory.Core().V1().Namespaces()
+		go namespaces.Informer().Run(stopCh)
+		if ok := cache.WaitForNamedCacheSync("faas-netes:namespaces", stopCh, namespaces.Informer().HasSynced); !ok {
+			log.Fatalf("failed to wait for cache to sync")
+		}
+		listers.NamespacesInformer = namespaces
+	}
+
+	return listers
 }
 
 // runController runs the faas-netes imperative controller
@@ -190,16 +252,147 @@ func runController(setup serverSetup) {
 
 	// set up signals so we handle the first shutdown signal gracefully
 	stopCh := signals.SetupSignalHandler()
-	operator := false
-	listers := startInformers(setup, stopCh, operator)
-	handlers.RegisterEventHandlers(listers.DeploymentInformer, kubeClient, config.DefaultFunctionNamespace)
+	listers := startInformers(setup, stopCh, setup.operator)
+
+	// Profiles are read from the informer's cache on each deploy and update
+	factory.Profiles = listers.ProfilesInformer.Lister()
+
+	namespaces := k8s.NewNamespaces(config.DefaultFunctionNamespace, nil)
+	if listers.NamespacesInformer != nil {
+		namespaces.Lister = listers.NamespacesInformer.Lister()
+
+		// The informers watch every namespace, so their events and listers are
+		// limited to the namespaces annotated with "openfaas"
+		listers.DeploymentInformer = k8s.NewNamespacedDeploymentInformer(listers.DeploymentInformer, namespaces)
+		if listers.FunctionsInformer != nil {
+			listers.FunctionsInformer = k8s.NewNamespacedFunctionInformer(listers.FunctionsInformer, namespaces)
+		}
+		if listers.EndpointSliceInformer != nil {
+			listers.EndpointSliceInformer = k8s.NewNamespacedEndpointSliceInformer(listers.EndpointSliceInformer, namespaces)
+		}
+		if listers.EndpointsInformer != nil {
+			listers.EndpointsInformer = k8s.NewNamespacedEndpointsInformer(listers.EndpointsInformer, namespaces)
+		}
+	}
+
+	// Functions which opted in are left at zero replicas by the validation below
+	handlers.RegisterEventHandlers(listers.DeploymentInformer, kubeClient, config.DefaultFunctionNamespace, factory.Config)
+
 	deployLister := listers.DeploymentInformer.Lister()
-	functionLookup := k8s.NewFunctionLookup(config.DefaultFunctionNamespace, listers.EndpointsInformer.Lister())
-	functionList := k8s.NewFunctionList(config.DefaultFunctionNamespace, deployLister)
+
+	functionList := k8s.NewFunctionList(namespaces, deployLister)
+
+	if setup.operator {
+		ctrl := controller.NewController(kubeClient, setup.faasClient, listers.DeploymentInformer, listers.FunctionsInformer, listers.ProfilesInformer, functionList, factory)
+
+		go func() {
+			if err := ctrl.Run(config.ReconcileWorkers, stopCh); err != nil {
+				klog.Fatalf("Error running operator: %s", err.Error())
+			}
+		}()
+	}
+
+	var functionLookup *k8s.FunctionLookup
+	if config.EndpointSlices {
+		functionLookup = k8s.NewEndpointSliceFunctionLookup(config.DefaultFunctionNamespace, listers.EndpointSliceInformer.Lister())
+		functionLookup.Zone = config.TopologyZone
+	} else {
+		functionLookup = k8s.NewFunctionLookup(config.DefaultFunctionNamespace, listers.EndpointsInformer.Lister())
+	}
+	functionLookup.DeploymentLister = deployLister
+	functionLookup.Namespaces = namespaces
+	functionLookup.Port = int32(config.FunctionPort)
+	functionLookup.ResolveToService = config.ResolveToService
+	functionLookup.ClusterDomain = config.ClusterDomain
+	functionLookup.LoadBalancer = config.LoadBalancer
+	functionLookup.HashHeader = config.LoadBalancerHashHeader
+
+	var circuits k8s.CircuitReader
+	if config.CircuitBreaker {
+		breaker := k8s.NewCircuitBreaker()
+		breaker.ConsecutiveFailures = config.CircuitBreakerFailures
+		breaker.EjectionTime = config.CircuitBreakerEjectionTime
+		breaker.ErrorThreshold = float64(config.CircuitBreakerErrorRate) / 100
+		breaker.OpenTime = config.CircuitBreakerOpenTime
+		breaker.SlowRequest = config.CircuitBreakerSlowRequest
+		prometheus.MustRegister(breaker)
+
+		functionLookup.Breaker = breaker
+		circuits = breaker
+	}
+
+	rollouts := k8s.NewRolloutWatcher(kubeClient, listers.DeploymentInformer)
+	rollouts.MaxWait = config.FaaSConfig.WriteTimeout
+	podMetrics := setup.metricsClient.MetricsV1beta1()
 
 	printFunctionExecutionTime := true
 
-	proxyHandler := proxy.NewHandlerFunc(config.FaaSConfig, functionLookup, printFunctionExecutionTime)
+	invocationTracker := k8s.NewInvocationTracker(config.DefaultFunctionNamespace)
+	var invocations k8s.InvocationCounter = invocationTracker
+	if len(config.PrometheusURL) > 0 {
+		invocations = k8s.NewPrometheusInvocationCounter(config.PrometheusURL, &http.Client{Timeout: 5 * time.Second})
+	}
+
+	var resolver proxy.RequestResolver = functionLookup
+	if config.ScaleToZero {
+		idleScaler := k8s.NewIdleScaler(kubeClient, deployLister, config.ScaleToZeroIdle)
+		idleScaler.Namespaces = namespaces
+		go idleScaler.Run(stopCh)
+
+		var endpointsInformer cache.SharedIndexInformer
+		if config.EndpointSlices {
+			endpointsInformer = listers.EndpointSliceInformer.Informer()
+		} else {
+			endpointsInformer = listers.EndpointsInformer.Informer()
+		}
+
+		scaleFromZero := k8s.NewScaleFromZero(functionLookup, kubeClient, endpointsInformer, config.ScaleFromZeroTimeout, config.ScaleFromZeroQueue)
+		scaleFromZero.Idle = idleScaler
+		resolver = scaleFromZero
+	}
+
+	proxyHandler := proxy.NewHandlerFunc(config.FaaSConfig, resolver, printFunctionExecutionTime)
+
+	if config.Autoscaler {
+		autoscaler := k8s.NewAutoscaler(kubeClient, deployLister, config.DefaultFunctionNamespace)
+		autoscaler.Namespaces = namespaces
+		autoscaler.MaxReplicas = handlers.MaxReplicas
+		autoscaler.TargetRPS = float64(config.AutoscalerTargetRPS)
+		autoscaler.TargetInflight = config.AutoscalerTargetInflight
+		autoscaler.Cooldown = config.AutoscalerCooldown
+		autoscaler.ScaleDownWindow = config.AutoscalerScaleDownWindow
+		go autoscaler.Run(stopCh)
+
+		proxyHandler = autoscaler.Wrap(proxyHandler)
+	}
+
+	// Requests rejected by the limits of a function are counted, but not autoscaled on
+	rateLimiter := k8s.NewRateLimiter(config.DefaultFunctionNamespace, listers.DeploymentInformer.Informer())
+	proxyHandler = invocationTracker.Wrap(rateLimiter.Wrap(proxyHandler))
+
+	var asyncHandler http.HandlerFunc
+	if config.AsyncInvocations {
+		var asyncQueue queue.Queue = queue.NewMemoryQueue(config.AsyncQueueSize)
+		if config.AsyncQueue == "jetstream" {
+			jetStreamQueue, err := queue.NewJetStreamQueue(context.Background(), config.AsyncNATSURL,
+				config.AsyncNATSStream, config.AsyncQueueSize, config.AsyncAckWait)
+			if err != nil {
+				log.Fatalf("Error creating the async queue: %s", err.Error())
+			}
+			asyncQueue = jetStreamQueue
+		}
+
+		worker := queue.NewWorker(asyncQueue, resolver, fproxy.NewProxyClientFromConfig(config.FaaSConfig))
+		worker.Concurrency = config.AsyncWorkers
+		worker.MaxRetries = config.AsyncMaxRetries
+		worker.Limiter = rateLimiter
+		go func() {
+			worker.Run(stopCh)
+			asyncQueue.Close()
+		}()
+
+		asyncHandler = queue.MakeQueueHandler(asyncQueue)
+	}
 
 	if err := handlers.Check(functionList); err != nil {
 		msg := fmt.Sprintf("Function invocations disabled due to error: %s.", err.Error())
@@ -208,21 +401,45 @@ func runController(setup serverSetup) {
 		proxyHandler = func(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, msg, http.StatusMethodNotAllowed)
 		}
+		if asyncHandler != nil {
+			asyncHandler = proxyHandler
+		}
 	}
 
 	bootstrapHandlers := providertypes.FaaSHandlers{
 		FunctionProxy:  proxyHandler,
-		DeleteFunction: handlers.MakeDeleteHandler(config.DefaultFunctionNamespace, kubeClient),
-		DeployFunction: handlers.MakeDeployHandler(config.DefaultFunctionNamespace, factory, functionList),
-		FunctionLister: handlers.MakeFunctionReader(config.DefaultFunctionNamespace, deployLister),
-		FunctionStatus: handlers.MakeReplicaReader(config.DefaultFunctionNamespace, deployLister),
-		ScaleFunction:  handlers.MakeReplicaUpdater(config.DefaultFunctionNamespace, kubeClient),
-		UpdateFunction: handlers.MakeUpdateHandler(config.DefaultFunctionNamespace, factory),
+		DeleteFunction: handlers.MakeDeleteHandler(namespaces, kubeClient, factory.Config),
+		DeployFunction: handlers.MakeDeployHandler(namespaces, factory, functionList, rollouts),
+		FunctionLister: handlers.MakeFunctionReader(namespaces, deployLister, podMetrics, invocations),
+		FunctionStatus: handlers.MakeReplicaReader(namespaces, deployLister, podMetrics, invocations, circuits),
+		ScaleFunction:  handlers.MakeReplicaUpdater(namespaces, kubeClient, factory.Config),
+		UpdateFunction: handlers.MakeUpdateHandler(namespaces, factory, rollouts),
 		Health:         handlers.MakeHealthHandler(),
 		Info:           handlers.MakeInfoHandler(version.BuildVersion(), version.GitCommit),
-		Secrets:        handlers.MakeSecretHandler(config.DefaultFunctionNamespace, kubeClient),
+		Secrets:        handlers.MakeSecretHandler(namespaces, kubeClient),
 		Logs:           logs.NewLogHandlerFunc(k8s.NewLogRequestor(kubeClient, config.DefaultFunctionNamespace), config.FaaSConfig.WriteTimeout),
-		ListNamespaces: handlers.MakeNamespacesLister(config.DefaultFunctionNamespace, kubeClient),
+		ListNamespaces: handlers.MakeNamespacesLister(namespaces),
+		Telemetry:      handlers.MakeTelemetryHandler(namespaces, deployLister, podMetrics),
+	}
+
+	// Namespaces can only be created and deleted with a ClusterRole, otherwise
+	// the route is left unimplemented
+	if config.ClusterRole {
+		bootstrapHandlers.MutateNamespace = handlers.MakeNamespaceMutator(config.DefaultFunctionNamespace, kubeClient)
+	}
+
+	// Routes which are not part of the provider's API are added to its router
+	functionRoutes := newFunctionRoutes(config.FaaSConfig)
+	functionRoutes.Add("rollout", handlers.MakeRolloutStatusHandler(namespaces, rollouts), http.MethodGet)
+	functionRoutes.Add("revisions", handlers.MakeRevisionsReader(namespaces, kubeClient), http.MethodGet)
+	functionRoutes.Add("rollback", handlers.MakeRollbackHandler(namespaces, factory, rollouts), http.MethodPost)
+	functionRoutes.Add("canary/{action:promote|abort|weight}", handlers.MakeCanaryHandler(namespaces, factory), http.MethodPost)
+
+	// Asynchronous invocations are open in the same way as /function/{name}
+	if asyncHandler != nil {
+		faasProvider.Router().HandleFunc("/async-function/{name:["+faasProvider.NameExpression+"]+}", asyncHandler).Methods(http.MethodPost)
+		faasProvider.Router().HandleFunc("/async-function/{name:["+faasProvider.NameExpression+"]+}/", asyncHandler).Methods(http.MethodPost)
+		faasProvider.Router().HandleFunc("/async-function/{name:["+faasProvider.NameExpression+"]+}/{params:.*}", asyncHandler).Methods(http.MethodPost)
 	}
 
 	ctx := context.Background()
@@ -230,13 +447,47 @@ func runController(setup serverSetup) {
 	faasProvider.Serve(ctx, &bootstrapHandlers, &config.FaaSConfig)
 }
 
+// functionRoutes adds routes under /system/function/{name} to the provider's router,
+// with the same basic auth as the routes of the provider's API
+type functionRoutes struct {
+	credentials *auth.BasicAuthCredentials
+}
+
+func newFunctionRoutes(config providertypes.FaaSConfig) *functionRoutes {
+	routes := &functionRoutes{}
+	if config.EnableBasicAuth {
+		reader := auth.ReadBasicAuthFromDisk{SecretMountPath: config.SecretMountPath}
+		credentials, err := reader.Read()
+		if err != nil {
+			log.Fatalf("failed to read basic auth credentials: %s", err)
+		}
+		routes.credentials = credentials
+	}
+	return routes
+}
+
+// Add adds handler for /system/function/{name}/<path>
+func (f *functionRoutes) Add(path string, handler http.HandlerFunc, methods ...string) {
+	if f.credentials != nil {
+		handler = auth.DecorateWithBasicAuth(handler, f.credentials)
+	}
+
+	faasProvider.Router().
+		HandleFunc("/system/function/{name:["+faasProvider.NameExpression+"]+}/"+path, handler).
+		Methods(methods...)
+}
+
 // serverSetup is a container for the config and clients needed to start the
-// faas-netes controller or operator
+// faas-netes controller and optionally the operator
 type serverSetup struct {
 	config              config.BootstrapConfig
 	kubeClient          *kubernetes.Clientset
 	faasClient          *clientset.Clientset
+	metricsClient       *metricsclientset.Clientset
 	functionFactory     k8s.FunctionFactory
 	kubeInformerFactory kubeinformers.SharedInformerFactory
 	faasInformerFactory informers.SharedInformerFactory
+	// operator reconciles Function custom resources in addition to
+	// serving the REST API
+	operator bool
 }
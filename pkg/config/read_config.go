@@ -6,7 +6,9 @@
 package config
 
 import (
+	"fmt"
 	"log"
+	"time"
 
 	ftypes "github.com/openfaas/faas-provider/types"
 )
@@ -30,10 +32,84 @@ func (ReadConfig) Read(hasEnv ftypes.HasEnv) (BootstrapConfig, error) {
 	setNonRootUser := ftypes.ParseBoolValue(hasEnv.Getenv("set_nonroot_user"), false)
 
 	cfg.DefaultFunctionNamespace = ftypes.ParseString(hasEnv.Getenv("function_namespace"), "openfaas-fn")
+	cfg.ProfilesNamespace = ftypes.ParseString(hasEnv.Getenv("profiles_namespace"), "openfaas")
+
+	cfg.FunctionPort = ftypes.ParseIntValue(hasEnv.Getenv("function_port"), 8080)
+	if cfg.FunctionPort < 1 || cfg.FunctionPort > 65535 {
+		return cfg, fmt.Errorf("function_port %d must be between 1 and 65535", cfg.FunctionPort)
+	}
 
 	cfg.HTTPProbe = httpProbe
 	cfg.SetNonRootUser = setNonRootUser
 
+	cfg.ClusterRole = ftypes.ParseBoolValue(hasEnv.Getenv("cluster_role"), false)
+
+	cfg.ReconcileWorkers = ftypes.ParseIntValue(hasEnv.Getenv("reconcile_workers"), 1)
+	cfg.PrometheusURL = ftypes.ParseString(hasEnv.Getenv("prometheus_url"), "")
+
+	cfg.ResolveToService = ftypes.ParseBoolValue(hasEnv.Getenv("resolve_to_service"), false)
+	cfg.ClusterDomain = ftypes.ParseString(hasEnv.Getenv("cluster_domain"), "cluster.local")
+
+	cfg.EndpointSlices = ftypes.ParseBoolValue(hasEnv.Getenv("endpoint_slices"), false)
+	cfg.TopologyZone = ftypes.ParseString(hasEnv.Getenv("topology_zone"), "")
+
+	cfg.LoadBalancer = ftypes.ParseString(hasEnv.Getenv("load_balancer"), "random")
+	cfg.LoadBalancerHashHeader = ftypes.ParseString(hasEnv.Getenv("load_balancer_hash_header"), "X-Session-Id")
+
+	cfg.ScaleToZero = ftypes.ParseBoolValue(hasEnv.Getenv("scale_to_zero"), false)
+	cfg.ScaleToZeroIdle = ftypes.ParseIntOrDurationValue(hasEnv.Getenv("scale_to_zero_idle"), time.Minute*15)
+	cfg.ScaleFromZeroTimeout = ftypes.ParseIntOrDurationValue(hasEnv.Getenv("scale_from_zero_timeout"), cfg.FaaSConfig.WriteTimeout)
+	cfg.ScaleFromZeroQueue = ftypes.ParseIntValue(hasEnv.Getenv("scale_from_zero_queue"), 100)
+	if cfg.ScaleToZero && cfg.ScaleToZeroIdle <= 0 {
+		return cfg, fmt.Errorf("scale_to_zero_idle must be greater than zero")
+	}
+
+	cfg.Autoscaler = ftypes.ParseBoolValue(hasEnv.Getenv("autoscaler"), false)
+	cfg.AutoscalerTargetRPS = ftypes.ParseIntValue(hasEnv.Getenv("autoscaler_target_rps"), 50)
+	cfg.AutoscalerTargetInflight = ftypes.ParseIntValue(hasEnv.Getenv("autoscaler_target_inflight"), 10)
+	cfg.AutoscalerCooldown = ftypes.ParseIntOrDurationValue(hasEnv.Getenv("autoscaler_cooldown"), time.Second*30)
+	cfg.AutoscalerScaleDownWindow = ftypes.ParseIntOrDurationValue(hasEnv.Getenv("autoscaler_scale_down_window"), time.Minute*5)
+	if cfg.Autoscaler && cfg.AutoscalerTargetRPS <= 0 && cfg.AutoscalerTargetInflight <= 0 {
+		return cfg, fmt.Errorf("autoscaler_target_rps or autoscaler_target_inflight must be greater than zero")
+	}
+
+	cfg.HPA = ftypes.ParseBoolValue(hasEnv.Getenv("hpa"), false)
+
+	cfg.AsyncInvocations = ftypes.ParseBoolValue(hasEnv.Getenv("async_invocations"), false)
+	cfg.AsyncWorkers = ftypes.ParseIntValue(hasEnv.Getenv("async_workers"), 10)
+	cfg.AsyncQueueSize = ftypes.ParseIntValue(hasEnv.Getenv("async_queue_size"), 1000)
+	cfg.AsyncMaxRetries = ftypes.ParseIntValue(hasEnv.Getenv("async_max_retries"), 3)
+	cfg.AsyncQueue = ftypes.ParseString(hasEnv.Getenv("async_queue"), "memory")
+	cfg.AsyncNATSURL = ftypes.ParseString(hasEnv.Getenv("async_nats_url"), "nats://nats.openfaas:4222")
+	cfg.AsyncNATSStream = ftypes.ParseString(hasEnv.Getenv("async_nats_stream"), "faas-netes-async")
+	cfg.AsyncAckWait = ftypes.ParseIntOrDurationValue(hasEnv.Getenv("async_ack_wait"), time.Minute*5)
+	if cfg.AsyncInvocations {
+		if cfg.AsyncWorkers < 1 || cfg.AsyncQueueSize < 1 {
+			return cfg, fmt.Errorf("async_workers and async_queue_size must be greater than zero")
+		}
+		if cfg.AsyncQueue != "memory" && cfg.AsyncQueue != "jetstream" {
+			return cfg, fmt.Errorf("async_queue must be memory or jetstream, got: %s", cfg.AsyncQueue)
+		}
+		if cfg.AsyncAckWait <= 0 {
+			return cfg, fmt.Errorf("async_ack_wait must be greater than zero")
+		}
+	}
+
+	cfg.CircuitBreaker = ftypes.ParseBoolValue(hasEnv.Getenv("circuit_breaker"), false)
+	cfg.CircuitBreakerFailures = ftypes.ParseIntValue(hasEnv.Getenv("circuit_breaker_failures"), 5)
+	cfg.CircuitBreakerEjectionTime = ftypes.ParseIntOrDurationValue(hasEnv.Getenv("circuit_breaker_ejection_time"), time.Second*30)
+	cfg.CircuitBreakerErrorRate = ftypes.ParseIntValue(hasEnv.Getenv("circuit_breaker_error_rate"), 50)
+	cfg.CircuitBreakerOpenTime = ftypes.ParseIntOrDurationValue(hasEnv.Getenv("circuit_breaker_open_time"), time.Second*30)
+	cfg.CircuitBreakerSlowRequest = ftypes.ParseIntOrDurationValue(hasEnv.Getenv("circuit_breaker_slow_request"), 0)
+	if cfg.CircuitBreaker {
+		if cfg.CircuitBreakerFailures < 1 || cfg.CircuitBreakerEjectionTime <= 0 || cfg.CircuitBreakerOpenTime <= 0 {
+			return cfg, fmt.Errorf("circuit_breaker_failures, circuit_breaker_ejection_time and circuit_breaker_open_time must be greater than zero")
+		}
+		if cfg.CircuitBreakerErrorRate < 1 || cfg.CircuitBreakerErrorRate > 100 {
+			return cfg, fmt.Errorf("circuit_breaker_error_rate must be a percentage between 1 and 100")
+		}
+	}
+
 	return cfg, nil
 }
 
@@ -48,11 +124,153 @@ type BootstrapConfig struct {
 	// non-root user id.  Currently this is preconfigured to the uid 12000.
 	SetNonRootUser bool
 
+	// FunctionPort is the port that functions listen on, unless overridden with the
+	// com.openfaas.port annotation. Value is set via the function_port environment
+	// variable, and defaults to 8080 for the OpenFaaS watchdog.
+	FunctionPort int
+
 	// DefaultFunctionNamespace defines which namespace in which Functions are deployed.
 	// Value is set via the function_namespace environment variable. If the
 	// variable is not set, it is set to "default".
 	DefaultFunctionNamespace string
 
+	// ClusterRole when set to true manages functions in every namespace annotated
+	// with "openfaas" in addition to DefaultFunctionNamespace, this requires a
+	// ClusterRole. Value is set via the cluster_role environment variable.
+	ClusterRole bool
+
+	// ProfilesNamespace is the namespace in which OpenFaaS Profiles are looked up.
+	// Value is set via the profiles_namespace environment variable.
+	ProfilesNamespace string
+
+	// ReconcileWorkers is the number of workers used to reconcile Function
+	// objects when running as an operator.
+	ReconcileWorkers int
+
+	// PrometheusURL is the address of the Prometheus instance used by the gateway,
+	// i.e. http://prometheus.openfaas:9090. When set, invocation counts are read from
+	// Prometheus instead of the in-process counter.
+	PrometheusURL string
+
+	// ResolveToService when set to true proxies invocations to the function's Service
+	// at http://<name>.<namespace>.svc.<cluster-domain>:<port> instead of to a pod IP,
+	// this is required by service meshes. Value is set via the resolve_to_service
+	// environment variable.
+	ResolveToService bool
+
+	// ClusterDomain is the DNS domain of the cluster, i.e. cluster.local
+	// Value is set via the cluster_domain environment variable.
+	ClusterDomain string
+
+	// EndpointSlices when set to true resolves functions from their discovery.k8s.io/v1
+	// EndpointSlices instead of the deprecated core/v1 Endpoints.
+	// Value is set via the endpoint_slices environment variable.
+	EndpointSlices bool
+
+	// TopologyZone is the zone faas-netes runs in, when set the topology hints of
+	// EndpointSlices are used to prefer endpoints in the same zone.
+	// Value is set via the topology_zone environment variable.
+	TopologyZone string
+
+	// LoadBalancer is the default strategy used to pick an endpoint when proxying
+	// to a function: random, round-robin, least-outstanding or consistent-hash.
+	// Value is set via the load_balancer environment variable, and can be
+	// overridden per function with the com.openfaas.loadbalancer annotation.
+	LoadBalancer string
+
+	// LoadBalancerHashHeader is the request header hashed by the consistent-hash
+	// strategy. Value is set via the load_balancer_hash_header environment variable.
+	LoadBalancerHashHeader string
+
+	// ScaleToZero when set to true scales functions with the com.openfaas.scale.zero
+	// label to zero replicas once they are idle, and holds the requests made to them
+	// while they are scaled up again. Value is set via the scale_to_zero environment
+	// variable.
+	ScaleToZero bool
+	// ScaleToZeroIdle is how long a function has to be idle for before it is scaled
+	// to zero, unless overridden with the com.openfaas.scale.zero-duration label.
+	// Value is set via the scale_to_zero_idle environment variable.
+	ScaleToZeroIdle time.Duration
+	// ScaleFromZeroTimeout is how long a request is held for while its function is
+	// scaled up from zero. Value is set via the scale_from_zero_timeout environment
+	// variable, and defaults to the write timeout.
+	ScaleFromZeroTimeout time.Duration
+	// ScaleFromZeroQueue is the number of requests which can be held for each function
+	// while it is scaled up from zero, further requests are rejected.
+	// Value is set via the scale_from_zero_queue environment variable.
+	ScaleFromZeroQueue int
+	// Autoscaler when set to true scales functions between their com.openfaas.scale.min
+	// and com.openfaas.scale.max labels by the requests proxied to them.
+	// Value is set via the autoscaler environment variable.
+	Autoscaler bool
+	// AutoscalerTargetRPS is the requests per second each replica of a function is
+	// scaled for, 0 disables it. Value is set via the autoscaler_target_rps
+	// environment variable.
+	AutoscalerTargetRPS int
+	// AutoscalerTargetInflight is the requests in flight each replica of a function
+	// is scaled for, 0 disables it. Value is set via the autoscaler_target_inflight
+	// environment variable.
+	AutoscalerTargetInflight int
+	// AutoscalerCooldown is the minimum time between two changes to the replicas of
+	// a function. Value is set via the autoscaler_cooldown environment variable.
+	AutoscalerCooldown time.Duration
+	// AutoscalerScaleDownWindow is how long the highest replicas recommended for a
+	// function are kept before it is scaled down. Value is set via the
+	// autoscaler_scale_down_window environment variable.
+	AutoscalerScaleDownWindow time.Duration
+	// HPA when set to true creates a HorizontalPodAutoscaler for each function with
+	// the com.openfaas.scale.type label. Value is set via the hpa environment variable.
+	HPA bool
+	// AsyncInvocations when set to true accepts requests to /async-function/{name},
+	// which are held in the AsyncQueue and invoked by a pool of workers. Value is set via
+	// the async_invocations environment variable.
+	AsyncInvocations bool
+	// AsyncWorkers is the number of asynchronous requests invoked at once. Value is
+	// set via the async_workers environment variable.
+	AsyncWorkers int
+	// AsyncQueueSize is the number of asynchronous requests held before further
+	// requests are rejected. Value is set via the async_queue_size environment variable.
+	AsyncQueueSize int
+	// AsyncMaxRetries is the number of times an asynchronous request is retried when
+	// its function is unavailable. Value is set via the async_max_retries environment
+	// variable.
+	AsyncMaxRetries int
+	// AsyncQueue is where asynchronous requests are held, "memory" or "jetstream" for
+	// a NATS JetStream stream which keeps them when faas-netes restarts. Value is set
+	// via the async_queue environment variable.
+	AsyncQueue string
+	// AsyncNATSURL is the NATS server of the jetstream queue. Value is set via the
+	// async_nats_url environment variable.
+	AsyncNATSURL string
+	// AsyncNATSStream is the name of the stream of the jetstream queue. Value is set
+	// via the async_nats_stream environment variable.
+	AsyncNATSStream string
+	// AsyncAckWait is how long a request taken from the jetstream queue can go without
+	// being reported in progress before it is redelivered, faas-netes reports it
+	// until it is acked. Value is set via the async_ack_wait environment variable.
+	AsyncAckWait time.Duration
+	// CircuitBreaker when set to true ejects the endpoints of functions which fail
+	// repeatedly, and rejects the requests to functions with a high error rate. Value
+	// is set via the circuit_breaker environment variable.
+	CircuitBreaker bool
+	// CircuitBreakerFailures is the number of failed requests in a row which ejects an
+	// endpoint. Value is set via the circuit_breaker_failures environment variable.
+	CircuitBreakerFailures int
+	// CircuitBreakerEjectionTime is how long an endpoint is first ejected for. Value is
+	// set via the circuit_breaker_ejection_time environment variable.
+	CircuitBreakerEjectionTime time.Duration
+	// CircuitBreakerErrorRate is the percentage of failed requests which opens the
+	// circuit of a function. Value is set via the circuit_breaker_error_rate
+	// environment variable.
+	CircuitBreakerErrorRate int
+	// CircuitBreakerOpenTime is how long the requests to a function are rejected once
+	// its circuit opens. Value is set via the circuit_breaker_open_time environment
+	// variable.
+	CircuitBreakerOpenTime time.Duration
+	// CircuitBreakerSlowRequest is the latency above which a request counts as failed,
+	// 0 disables it. Value is set via the circuit_breaker_slow_request environment
+	// variable.
+	CircuitBreakerSlowRequest time.Duration
 	// FaaSConfig contains the configuration for the FaaSProvider
 	FaaSConfig ftypes.FaaSConfig
 }
@@ -66,11 +284,56 @@ func (c BootstrapConfig) Fprint(verbose bool) {
 
 	log.Printf("ImagePullPolicy: %s\n", "Always")
 	log.Printf("DefaultFunctionNamespace: %s\n", c.DefaultFunctionNamespace)
+	log.Printf("ProfilesNamespace: %s\n", c.ProfilesNamespace)
+	log.Printf("ClusterRole: %v\n", c.ClusterRole)
+	log.Printf("FunctionPort: %d\n", c.FunctionPort)
+	log.Printf("LoadBalancer: %s\n", c.LoadBalancer)
+	log.Printf("EndpointSlices: %v\n", c.EndpointSlices)
+	log.Printf("ResolveToService: %v\n", c.ResolveToService)
+	log.Printf("ScaleToZero: %v\n", c.ScaleToZero)
+	log.Printf("Autoscaler: %v\n", c.Autoscaler)
+	log.Printf("HPA: %v\n", c.HPA)
+	log.Printf("AsyncInvocations: %v\n", c.AsyncInvocations)
+	log.Printf("CircuitBreaker: %v\n", c.CircuitBreaker)
 
 	if verbose {
 		log.Printf("MaxIdleConns: %d\n", c.FaaSConfig.MaxIdleConns)
 		log.Printf("MaxIdleConnsPerHost: %d\n", c.FaaSConfig.MaxIdleConnsPerHost)
 		log.Printf("HTTPProbe: %v\n", c.HTTPProbe)
 		log.Printf("SetNonRootUser: %v\n", c.SetNonRootUser)
+		log.Printf("ReconcileWorkers: %d\n", c.ReconcileWorkers)
+		log.Printf("PrometheusURL: %s\n", c.PrometheusURL)
+		log.Printf("LoadBalancerHashHeader: %s\n", c.LoadBalancerHashHeader)
+		log.Printf("TopologyZone: %s\n", c.TopologyZone)
+		log.Printf("ClusterDomain: %s\n", c.ClusterDomain)
+		if c.ScaleToZero {
+			log.Printf("ScaleToZeroIdle: %s\n", c.ScaleToZeroIdle)
+			log.Printf("ScaleFromZeroTimeout: %s\n", c.ScaleFromZeroTimeout)
+			log.Printf("ScaleFromZeroQueue: %d\n", c.ScaleFromZeroQueue)
+		}
+		if c.AsyncInvocations {
+			log.Printf("AsyncWorkers: %d\n", c.AsyncWorkers)
+			log.Printf("AsyncQueueSize: %d\n", c.AsyncQueueSize)
+			log.Printf("AsyncMaxRetries: %d\n", c.AsyncMaxRetries)
+			log.Printf("AsyncQueue: %s\n", c.AsyncQueue)
+			if c.AsyncQueue == "jetstream" {
+				log.Printf("AsyncNATSURL: %s\n", c.AsyncNATSURL)
+				log.Printf("AsyncNATSStream: %s\n", c.AsyncNATSStream)
+				log.Printf("AsyncAckWait: %s\n", c.AsyncAckWait)
+			}
+		}
+		if c.Autoscaler {
+			log.Printf("AutoscalerTargetRPS: %d\n", c.AutoscalerTargetRPS)
+			log.Printf("AutoscalerTargetInflight: %d\n", c.AutoscalerTargetInflight)
+			log.Printf("AutoscalerCooldown: %s\n", c.AutoscalerCooldown)
+			log.Printf("AutoscalerScaleDownWindow: %s\n", c.AutoscalerScaleDownWindow)
+		}
+		if c.CircuitBreaker {
+			log.Printf("CircuitBreakerFailures: %d\n", c.CircuitBreakerFailures)
+			log.Printf("CircuitBreakerEjectionTime: %s\n", c.CircuitBreakerEjectionTime)
+			log.Printf("CircuitBreakerErrorRate: %d\n", c.CircuitBreakerErrorRate)
+			log.Printf("CircuitBreakerOpenTime: %s\n", c.CircuitBreakerOpenTime)
+			log.Printf("CircuitBreakerSlowRequest: %s\n", c.CircuitBreakerSlowRequest)
+		}
 	}
 }
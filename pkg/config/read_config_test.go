@@ -8,6 +8,7 @@ package config
 
 import (
 	"testing"
+	"time"
 )
 
 type EnvBucket struct {
@@ -72,3 +73,175 @@ func TestRead_HTTPProbeConfig_true(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestRead_ClusterDomain(t *testing.T) {
+	cases := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{"default", "", "cluster.local"},
+		{"custom", "cluster.example", "cluster.example"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			env := NewEnvBucket()
+			if len(tc.value) > 0 {
+				env.Setenv("cluster_domain", tc.value)
+			}
+
+			config, err := ReadConfig{}.Read(env)
+			if err != nil {
+				t.Fatalf("Unexpected error while reading env %s", err.Error())
+			}
+
+			if config.ClusterDomain != tc.want {
+				t.Errorf("want: %s, got: %s", tc.want, config.ClusterDomain)
+			}
+		})
+	}
+}
+
+func TestRead_ScaleToZero(t *testing.T) {
+	env := NewEnvBucket()
+	env.Setenv("write_timeout", "45s")
+
+	config, err := ReadConfig{}.Read(env)
+	if err != nil {
+		t.Fatalf("Unexpected error while reading env %s", err.Error())
+	}
+
+	if config.ScaleToZero {
+		t.Errorf("want scale to zero to be disabled by default")
+	}
+	if config.ScaleToZeroIdle != time.Minute*15 {
+		t.Errorf("want: %s, got: %s", time.Minute*15, config.ScaleToZeroIdle)
+	}
+	if config.ScaleFromZeroTimeout != time.Second*45 {
+		t.Errorf("want the write timeout: %s, got: %s", time.Second*45, config.ScaleFromZeroTimeout)
+	}
+
+	env.Setenv("scale_to_zero", "true")
+	env.Setenv("scale_to_zero_idle", "0")
+	if _, err := (ReadConfig{}).Read(env); err == nil {
+		t.Errorf("want an error for an idle duration of zero")
+	}
+}
+
+func TestRead_Autoscaler(t *testing.T) {
+	env := NewEnvBucket()
+
+	config, err := ReadConfig{}.Read(env)
+	if err != nil {
+		t.Fatalf("Unexpected error while reading env %s", err.Error())
+	}
+
+	if config.Autoscaler {
+		t.Errorf("want the autoscaler to be disabled by default")
+	}
+	if config.AutoscalerTargetRPS != 50 {
+		t.Errorf("want: %d, got: %d", 50, config.AutoscalerTargetRPS)
+	}
+	if config.AutoscalerTargetInflight != 10 {
+		t.Errorf("want: %d, got: %d", 10, config.AutoscalerTargetInflight)
+	}
+	if config.AutoscalerCooldown != time.Second*30 {
+		t.Errorf("want: %s, got: %s", time.Second*30, config.AutoscalerCooldown)
+	}
+	if config.AutoscalerScaleDownWindow != time.Minute*5 {
+		t.Errorf("want: %s, got: %s", time.Minute*5, config.AutoscalerScaleDownWindow)
+	}
+
+	env.Setenv("autoscaler", "true")
+	env.Setenv("autoscaler_target_rps", "0")
+	env.Setenv("autoscaler_target_inflight", "0")
+	if _, err := (ReadConfig{}).Read(env); err == nil {
+		t.Errorf("want an error when both targets are zero")
+	}
+}
+
+func TestRead_AsyncInvocations(t *testing.T) {
+	env := NewEnvBucket()
+
+	config, err := ReadConfig{}.Read(env)
+	if err != nil {
+		t.Fatalf("Unexpected error while reading env %s", err.Error())
+	}
+
+	if config.AsyncInvocations {
+		t.Errorf("want async invocations to be disabled by default")
+	}
+	if config.AsyncWorkers != 10 || config.AsyncQueueSize != 1000 || config.AsyncMaxRetries != 3 {
+		t.Errorf("want: 10 workers, 1000 queued and 3 retries, got: %d, %d and %d",
+			config.AsyncWorkers, config.AsyncQueueSize, config.AsyncMaxRetries)
+	}
+
+	if config.AsyncQueue != "memory" {
+		t.Errorf("want: %s, got: %s", "memory", config.AsyncQueue)
+	}
+	if config.AsyncAckWait != time.Minute*5 {
+		t.Errorf("want: %s, got: %s", time.Minute*5, config.AsyncAckWait)
+	}
+
+	env.Setenv("async_invocations", "true")
+	env.Setenv("async_workers", "0")
+	if _, err := (ReadConfig{}).Read(env); err == nil {
+		t.Errorf("want an error for zero workers")
+	}
+
+	env.Setenv("async_workers", "10")
+	env.Setenv("async_queue", "kafka")
+	if _, err := (ReadConfig{}).Read(env); err == nil {
+		t.Errorf("want an error for an unknown queue")
+	}
+
+	env.Setenv("async_queue", "jetstream")
+	env.Setenv("async_nats_url", "nats://nats.example:4222")
+	config, err = ReadConfig{}.Read(env)
+	if err != nil {
+		t.Fatalf("Unexpected error while reading env %s", err.Error())
+	}
+	if config.AsyncNATSURL != "nats://nats.example:4222" || config.AsyncNATSStream != "faas-netes-async" {
+		t.Errorf("want: %s and %s, got: %s and %s", "nats://nats.example:4222", "faas-netes-async",
+			config.AsyncNATSURL, config.AsyncNATSStream)
+	}
+}
+
+func TestRead_CircuitBreaker(t *testing.T) {
+	env := NewEnvBucket()
+
+	config, err := ReadConfig{}.Read(env)
+	if err != nil {
+		t.Fatalf("Unexpected error while reading env %s", err.Error())
+	}
+
+	if config.CircuitBreaker {
+		t.Errorf("want the circuit breaker to be disabled by default")
+	}
+	if config.CircuitBreakerFailures != 5 || config.CircuitBreakerErrorRate != 50 {
+		t.Errorf("want: 5 failures and a 50%% error rate, got: %d and %d",
+			config.CircuitBreakerFailures, config.CircuitBreakerErrorRate)
+	}
+	if config.CircuitBreakerEjectionTime != time.Second*30 {
+		t.Errorf("want: %s, got: %s", time.Second*30, config.CircuitBreakerEjectionTime)
+	}
+	if config.CircuitBreakerSlowRequest != 0 {
+		t.Errorf("want: %s, got: %s", time.Duration(0), config.CircuitBreakerSlowRequest)
+	}
+
+	env.Setenv("circuit_breaker", "true")
+	env.Setenv("circuit_breaker_slow_request", "2s")
+	config, err = ReadConfig{}.Read(env)
+	if err != nil {
+		t.Fatalf("Unexpected error while reading env %s", err.Error())
+	}
+	if config.CircuitBreakerSlowRequest != time.Second*2 {
+		t.Errorf("want: %s, got: %s", time.Second*2, config.CircuitBreakerSlowRequest)
+	}
+
+	env.Setenv("circuit_breaker_error_rate", "101")
+	if _, err := (ReadConfig{}).Read(env); err == nil {
+		t.Errorf("want an error for an error rate above 100")
+	}
+}
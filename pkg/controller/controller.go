@@ -0,0 +1,435 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	faasv1 "github.com/openfaas/faas-netes/pkg/apis/openfaas/v1"
+	clientset "github.com/openfaas/faas-netes/pkg/client/clientset/versioned"
+	v1 "github.com/openfaas/faas-netes/pkg/client/informers/externalversions/openfaas/v1"
+	listers "github.com/openfaas/faas-netes/pkg/client/listers/openfaas/v1"
+	"github.com/openfaas/faas-netes/pkg/handlers"
+	"github.com/openfaas/faas-netes/pkg/k8s"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	v1apps "k8s.io/client-go/informers/apps/v1"
+	"k8s.io/client-go/kubernetes"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog"
+)
+
+const functionKind = "Function"
+
+// Controller reconciles openfaas.com/v1 Function objects into a Deployment and
+// a Service, using the same specs as the REST API.
+type Controller struct {
+	kubeClient kubernetes.Interface
+	faasClient clientset.Interface
+
+	deploymentsLister appslisters.DeploymentLister
+	deploymentsSynced cache.InformerSynced
+
+	functionsLister listers.FunctionLister
+	functionsSynced cache.InformerSynced
+
+	profilesSynced cache.InformerSynced
+
+	// functionList counts the functions in every managed namespace
+	functionList *k8s.FunctionList
+
+	// workqueue is a rate limited work queue of Function keys in the
+	// namespace/name format.
+	workqueue workqueue.TypedRateLimitingInterface[string]
+
+	factory k8s.FunctionFactory
+}
+
+// NewController creates a Controller and registers its event handlers with the
+// Deployment, Function and Profile informers. The Profile informer must watch
+// the namespace of the Profiles.
+func NewController(
+	kubeClient kubernetes.Interface,
+	faasClient clientset.Interface,
+	deploymentInformer v1apps.DeploymentInformer,
+	functionInformer v1.FunctionInformer,
+	profileInformer v1.ProfileInformer,
+	functionList *k8s.FunctionList,
+	factory k8s.FunctionFactory) *Controller {
+
+	c := &Controller{
+		kubeClient:        kubeClient,
+		faasClient:        faasClient,
+		deploymentsLister: deploymentInformer.Lister(),
+		deploymentsSynced: deploymentInformer.Informer().HasSynced,
+		functionsLister:   functionInformer.Lister(),
+		functionsSynced:   functionInformer.Informer().HasSynced,
+		profilesSynced:    profileInformer.Informer().HasSynced,
+		functionList:      functionList,
+		workqueue: workqueue.NewTypedRateLimitingQueueWithConfig(
+			workqueue.DefaultTypedControllerRateLimiter[string](),
+			workqueue.TypedRateLimitingQueueConfig[string]{Name: "Functions"}),
+		factory: factory,
+	}
+
+	functionInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: c.enqueueFunction,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldFn, ok := oldObj.(*faasv1.Function)
+			if !ok {
+				return
+			}
+			newFn, ok := newObj.(*faasv1.Function)
+			if !ok {
+				return
+			}
+			// Periodic resyncs send updates for unchanged objects
+			if oldFn.ResourceVersion == newFn.ResourceVersion {
+				return
+			}
+			c.enqueueFunction(newObj)
+		},
+	})
+
+	deploymentInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: c.handleDeployment,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldDeploy, ok := oldObj.(*appsv1.Deployment)
+			if !ok {
+				return
+			}
+			newDeploy, ok := newObj.(*appsv1.Deployment)
+			if !ok {
+				return
+			}
+			if oldDeploy.ResourceVersion == newDeploy.ResourceVersion {
+				return
+			}
+			c.handleDeployment(newObj)
+		},
+		DeleteFunc: c.handleDeployment,
+	})
+
+	profileInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: c.handleProfile,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldProfile, ok := oldObj.(*faasv1.Profile)
+			if !ok {
+				return
+			}
+			newProfile, ok := newObj.(*faasv1.Profile)
+			if !ok {
+				return
+			}
+			// Only a change to the spec has to be applied to the functions
+			if oldProfile.Generation == newProfile.Generation {
+				return
+			}
+			c.handleProfile(newObj)
+		},
+		DeleteFunc: c.handleProfile,
+	})
+
+	return c
+}
+
+// Run waits for the informer caches to sync, then starts workers to process
+// the queue until stopCh is closed.
+func (c *Controller) Run(workers int, stopCh <-chan struct{}) error {
+	defer utilruntime.HandleCrash()
+	defer c.workqueue.ShutDown()
+
+	if ok := cache.WaitForNamedCacheSync("faas-netes:operator", stopCh, c.deploymentsSynced, c.functionsSynced, c.profilesSynced); !ok {
+		return fmt.Errorf("failed to wait for caches to sync")
+	}
+
+	klog.Infof("Starting %d operator workers", workers)
+	for i := 0; i < workers; i++ {
+		go wait.Until(c.runWorker, time.Second, stopCh)
+	}
+
+	<-stopCh
+	klog.Info("Shutting down operator workers")
+
+	return nil
+}
+
+func (c *Controller) runWorker() {
+	for c.processNextWorkItem() {
+	}
+}
+
+func (c *Controller) processNextWorkItem() bool {
+	key, shutdown := c.workqueue.Get()
+	if shutdown {
+		return false
+	}
+	defer c.workqueue.Done(key)
+
+	if err := c.syncHandler(context.Background(), key); err != nil {
+		c.workqueue.AddRateLimited(key)
+		utilruntime.HandleError(fmt.Errorf("error syncing %q: %s, requeuing", key, err.Error()))
+		return true
+	}
+
+	c.workqueue.Forget(key)
+	return true
+}
+
+// syncHandler converges the Deployment and Service for a Function with its
+// spec, then writes the result back to the Function's status.
+func (c *Controller) syncHandler(ctx context.Context, key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("invalid resource key: %s", key))
+		return nil
+	}
+
+	function, err := c.functionsLister.Functions(namespace).Get(name)
+	if err != nil {
+		// Owned objects are removed by the garbage collector
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	request := toFunctionDeployment(function)
+
+	deploymentSpec, serviceSpec, err := handlers.MakeFunctionSpecs(request, c.factory)
+	if err != nil {
+		// An invalid spec will not become valid on retry, so it is only
+		// reported in the status.
+		return c.updateFunctionStatus(ctx, function, nil, reasonInvalidSpec, err)
+	}
+
+	ownerRef := *metav1.NewControllerRef(function, faasv1.SchemeGroupVersion.WithKind(functionKind))
+	deploymentSpec.OwnerReferences = []metav1.OwnerReference{ownerRef}
+	serviceSpec.OwnerReferences = []metav1.OwnerReference{ownerRef}
+
+	deployment, reason, err := c.reconcileDeployment(ctx, function, deploymentSpec)
+	if err != nil {
+		if statusErr := c.updateFunctionStatus(ctx, function, deployment, reason, err); statusErr != nil {
+			return statusErr
+		}
+		if reason == reasonResourceExists || reason == reasonLimitExceeded {
+			return nil
+		}
+		return err
+	}
+
+	if reason, err := c.reconcileService(ctx, function, serviceSpec); err != nil {
+		if statusErr := c.updateFunctionStatus(ctx, function, deployment, reason, err); statusErr != nil {
+			return statusErr
+		}
+		if reason == reasonResourceExists {
+			return nil
+		}
+		return err
+	}
+
+	return c.updateFunctionStatus(ctx, function, deployment, reasonReconciled, nil)
+}
+
+// appliedProfiles returns references to the Profiles that were applied to the
+// Deployment, with the generation of each Profile at the time it was applied.
+func (c *Controller) appliedProfiles(deployment *appsv1.Deployment) []faasv1.AppliedProfile {
+	if deployment == nil {
+		return nil
+	}
+
+	names := k8s.ParseProfileNames(deployment.Spec.Template.Annotations)
+	if len(names) == 0 {
+		return nil
+	}
+
+	generations := k8s.ParseProfileGenerations(deployment.Annotations)
+
+	applied := []faasv1.AppliedProfile{}
+	for _, name := range names {
+		applied = append(applied, faasv1.AppliedProfile{
+			ProfileRef: faasv1.ResourceRef{
+				Name:      name,
+				Namespace: c.factory.Config.ProfilesNamespace,
+			},
+			ObservedGeneration: generations[name],
+		})
+	}
+
+	return applied
+}
+
+func (c *Controller) reconcileDeployment(ctx context.Context, function *faasv1.Function, desired *appsv1.Deployment) (*appsv1.Deployment, string, error) {
+	deployments := c.kubeClient.AppsV1().Deployments(function.Namespace)
+
+	existing, err := c.deploymentsLister.Deployments(function.Namespace).Get(desired.Name)
+	if errors.IsNotFound(err) {
+		count, err := c.functionList.Count()
+		if err != nil {
+			return nil, reasonDeploymentFailed, err
+		}
+		if count+1 > handlers.MaxFunctions {
+			return nil, reasonLimitExceeded, fmt.Errorf("unable to create function, maximum: %d", handlers.MaxFunctions)
+		}
+
+		created, err := deployments.Create(ctx, desired, metav1.CreateOptions{FieldManager: k8s.FieldManager})
+		if err != nil {
+			return nil, reasonDeploymentFailed, fmt.Errorf("unable create Deployment: %s", err.Error())
+		}
+		klog.Infof("Deployment created: %s.%s", created.Name, created.Namespace)
+		return created, reasonReconciled, nil
+	} else if err != nil {
+		return nil, reasonDeploymentFailed, err
+	}
+
+	if !metav1.IsControlledBy(existing, function) {
+		return existing, reasonResourceExists, fmt.Errorf("deployment %s.%s already exists and is not managed by Function %s", existing.Name, existing.Namespace, function.Name)
+	}
+
+	// The Deployment is applied on each sync, server-side apply does not change
+	// it when nothing differs. A comparison of the fields instead would miss the
+	// fields which were removed from the Function or its Profiles.
+	//
+	// Replicas are owned by whatever scales the function, they are only applied
+	// to enforce the minimum from the com.openfaas.scale.min label, unless the
+	// function was scaled to zero.
+	updated := desired.DeepCopy()
+	updated.Spec.Replicas = nil
+	scaledToZero := existing.Spec.Replicas != nil && *existing.Spec.Replicas == 0 &&
+		c.factory.Config.ScaleToZero && k8s.ScaleToZeroEnabled(desired.Spec.Template.Labels)
+	if !scaledToZero && (existing.Spec.Replicas == nil || *existing.Spec.Replicas < *desired.Spec.Replicas) {
+		updated.Spec.Replicas = desired.Spec.Replicas
+	}
+
+	if err := k8s.UpgradeDeploymentManagedFields(ctx, c.kubeClient, existing); err != nil {
+		return existing, reasonDeploymentFailed, fmt.Errorf("unable update Deployment: %s", err.Error())
+	}
+
+	res, err := k8s.ApplyDeployment(ctx, c.kubeClient, function.Namespace, updated, false)
+	if err != nil {
+		return existing, reasonDeploymentFailed, fmt.Errorf("unable update Deployment: %s", err.Error())
+	}
+	if res.ResourceVersion != existing.ResourceVersion {
+		klog.Infof("Deployment updated: %s.%s", res.Name, res.Namespace)
+	}
+
+	return res, reasonReconciled, nil
+}
+
+func (c *Controller) reconcileService(ctx context.Context, function *faasv1.Function, desired *corev1.Service) (string, error) {
+	services := c.kubeClient.CoreV1().Services(function.Namespace)
+
+	existing, err := services.Get(ctx, desired.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		if _, err := services.Create(ctx, desired, metav1.CreateOptions{FieldManager: k8s.FieldManager}); err != nil {
+			return reasonServiceFailed, fmt.Errorf("failed create Service: %s", err.Error())
+		}
+		klog.Infof("Service created: %s.%s", desired.Name, function.Namespace)
+		return reasonReconciled, nil
+	} else if err != nil {
+		return reasonServiceFailed, err
+	}
+
+	if !metav1.IsControlledBy(existing, function) {
+		return reasonResourceExists, fmt.Errorf("service %s.%s already exists and is not managed by Function %s", existing.Name, existing.Namespace, function.Name)
+	}
+
+	if err := k8s.UpgradeServiceManagedFields(ctx, c.kubeClient, existing); err != nil {
+		return reasonServiceFailed, fmt.Errorf("unable update Service: %s", err.Error())
+	}
+
+	// The Service is applied on each sync in the same way as the Deployment
+	res, err := k8s.ApplyService(ctx, c.kubeClient, function.Namespace, desired, false)
+	if err != nil {
+		return reasonServiceFailed, fmt.Errorf("unable update Service: %s", err.Error())
+	}
+	if res.ResourceVersion != existing.ResourceVersion {
+		klog.Infof("Service updated: %s.%s", desired.Name, function.Namespace)
+	}
+
+	return reasonReconciled, nil
+}
+
+func (c *Controller) enqueueFunction(obj interface{}) {
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	c.workqueue.Add(key)
+}
+
+// handleDeployment enqueues the Function that owns a Deployment so that its
+// status is refreshed when the rollout progresses.
+func (c *Controller) handleDeployment(obj interface{}) {
+	var object metav1.Object
+	var ok bool
+	if object, ok = obj.(metav1.Object); !ok {
+		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+		if !ok {
+			return
+		}
+		if object, ok = tombstone.Obj.(metav1.Object); !ok {
+			return
+		}
+	}
+
+	ownerRef := metav1.GetControllerOf(object)
+	if ownerRef == nil || ownerRef.Kind != functionKind {
+		return
+	}
+
+	function, err := c.functionsLister.Functions(object.GetNamespace()).Get(ownerRef.Name)
+	if err != nil {
+		return
+	}
+
+	c.enqueueFunction(function)
+}
+
+// handleProfile enqueues the Functions which reference a Profile, so that a change
+// to the Profile is applied to their Deployments.
+func (c *Controller) handleProfile(obj interface{}) {
+	var object metav1.Object
+	var ok bool
+	if object, ok = obj.(metav1.Object); !ok {
+		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+		if !ok {
+			return
+		}
+		if object, ok = tombstone.Obj.(metav1.Object); !ok {
+			return
+		}
+	}
+
+	if object.GetNamespace() != c.factory.Config.ProfilesNamespace {
+		return
+	}
+
+	functions, err := c.functionsLister.List(labels.Everything())
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+
+	for _, function := range functions {
+		if function.Spec.Annotations == nil {
+			continue
+		}
+		if slices.Contains(k8s.ParseProfileNames(*function.Spec.Annotations), object.GetName()) {
+			c.enqueueFunction(function)
+		}
+	}
+}
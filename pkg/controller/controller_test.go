@@ -0,0 +1,435 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	faasv1 "github.com/openfaas/faas-netes/pkg/apis/openfaas/v1"
+	faasfake "github.com/openfaas/faas-netes/pkg/client/clientset/versioned/fake"
+	informers "github.com/openfaas/faas-netes/pkg/client/informers/externalversions"
+	listers "github.com/openfaas/faas-netes/pkg/client/listers/openfaas/v1"
+	"github.com/openfaas/faas-netes/pkg/k8s"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubeinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+type fixture struct {
+	kubeClient  *fake.Clientset
+	faasClient  *faasfake.Clientset
+	controller  *Controller
+	deployments cache.Indexer
+	functions   cache.Indexer
+}
+
+func newFixture(t *testing.T, function *faasv1.Function, deployments ...*appsv1.Deployment) *fixture {
+	t.Helper()
+
+	return newFixtureWithProfiles(t, function, nil, deployments...)
+}
+
+func newFixtureWithProfiles(t *testing.T, function *faasv1.Function, profiles []*faasv1.Profile, deployments ...*appsv1.Deployment) *fixture {
+	t.Helper()
+
+	kubeObjects := []runtime.Object{}
+	for _, d := range deployments {
+		kubeObjects = append(kubeObjects, d)
+	}
+
+	kubeClient := fake.NewClientset(kubeObjects...)
+	faasObjects := []runtime.Object{function}
+	for _, p := range profiles {
+		faasObjects = append(faasObjects, p)
+	}
+
+	faasClient := faasfake.NewSimpleClientset(faasObjects...)
+
+	kubeInformerFactory := kubeinformers.NewSharedInformerFactory(kubeClient, 0)
+	faasInformerFactory := informers.NewSharedInformerFactory(faasClient, 0)
+
+	deploymentInformer := kubeInformerFactory.Apps().V1().Deployments()
+	functionInformer := faasInformerFactory.Openfaas().V1().Functions()
+	profileInformer := faasInformerFactory.Openfaas().V1().Profiles()
+
+	factory := k8s.NewFunctionFactory(kubeClient, k8s.DeploymentConfig{
+		RuntimeHTTPPort:   8080,
+		LivenessProbe:     &k8s.ProbeConfig{},
+		ReadinessProbe:    &k8s.ProbeConfig{},
+		ProfilesNamespace: "openfaas",
+	}, faasClient.OpenfaasV1())
+
+	functionList := k8s.NewFunctionList(k8s.NewNamespaces("openfaas-fn", nil), deploymentInformer.Lister())
+
+	c := NewController(kubeClient, faasClient, deploymentInformer, functionInformer, profileInformer, functionList, factory)
+
+	functionInformer.Informer().GetIndexer().Add(function)
+	for _, d := range deployments {
+		deploymentInformer.Informer().GetIndexer().Add(d)
+	}
+
+	return &fixture{
+		kubeClient:  kubeClient,
+		faasClient:  faasClient,
+		controller:  c,
+		deployments: deploymentInformer.Informer().GetIndexer(),
+		functions:   functionInformer.Informer().GetIndexer(),
+	}
+}
+
+// sync reconciles function, as the informers would see it along with the
+// Deployment which was written by the last sync
+func (f *fixture) sync(t *testing.T, function *faasv1.Function) {
+	t.Helper()
+
+	ctx := context.Background()
+	if _, err := f.faasClient.OpenfaasV1().Functions(function.Namespace).Update(ctx, function, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	f.functions.Update(function)
+
+	deployment, err := f.kubeClient.AppsV1().Deployments(function.Namespace).Get(ctx, function.Name, metav1.GetOptions{})
+	if err == nil {
+		f.deployments.Update(deployment)
+	}
+
+	if err := f.controller.syncHandler(ctx, function.Namespace+"/"+function.Name); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+func newFunction(name string) *faasv1.Function {
+	return &faasv1.Function{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       name,
+			Namespace:  "openfaas-fn",
+			UID:        "abc-123",
+			Generation: 2,
+		},
+		Spec: faasv1.FunctionSpec{
+			Name:        name,
+			Image:       "ghcr.io/openfaas/alpine:latest",
+			Handler:     "env",
+			Environment: &map[string]string{"write_debug": "true"},
+		},
+	}
+}
+
+func Test_syncHandler_CreatesDeploymentAndService(t *testing.T) {
+	function := newFunction("env")
+	f := newFixture(t, function)
+
+	if err := f.controller.syncHandler(context.Background(), "openfaas-fn/env"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	deployment, err := f.kubeClient.AppsV1().Deployments("openfaas-fn").Get(context.Background(), "env", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected Deployment to be created: %s", err)
+	}
+
+	if !metav1.IsControlledBy(deployment, function) {
+		t.Errorf("expected Deployment to be controlled by the Function")
+	}
+
+	container := deployment.Spec.Template.Spec.Containers[0]
+	if container.Image != function.Spec.Image {
+		t.Errorf("want image: %s, got: %s", function.Spec.Image, container.Image)
+	}
+
+	service, err := f.kubeClient.CoreV1().Services("openfaas-fn").Get(context.Background(), "env", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected Service to be created: %s", err)
+	}
+
+	if !metav1.IsControlledBy(service, function) {
+		t.Errorf("expected Service to be controlled by the Function")
+	}
+
+	got, err := f.faasClient.OpenfaasV1().Functions("openfaas-fn").Get(context.Background(), "env", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if got.Status.ObservedGeneration != function.Generation {
+		t.Errorf("want observedGeneration: %d, got: %d", function.Generation, got.Status.ObservedGeneration)
+	}
+
+	if !meta.IsStatusConditionTrue(got.Status.Conditions, conditionReady) {
+		t.Errorf("want condition %s to be true, got: %v", conditionReady, got.Status.Conditions)
+	}
+
+	if got.Status.Replicas != 1 {
+		t.Errorf("want replicas: %d, got: %d", 1, got.Status.Replicas)
+	}
+}
+
+func Test_syncHandler_UpdatesOwnedDeployment(t *testing.T) {
+	function := newFunction("env")
+	f := newFixture(t, function)
+
+	if err := f.controller.syncHandler(context.Background(), "openfaas-fn/env"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	deployment, _ := f.kubeClient.AppsV1().Deployments("openfaas-fn").Get(context.Background(), "env", metav1.GetOptions{})
+	replicas := int32(3)
+	deployment.Spec.Replicas = &replicas
+	deployment.Status.AvailableReplicas = 3
+
+	updated := function.DeepCopy()
+	updated.Spec.Image = "ghcr.io/openfaas/alpine:3.20"
+
+	f = newFixture(t, updated, deployment)
+
+	if err := f.controller.syncHandler(context.Background(), "openfaas-fn/env"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	got, _ := f.kubeClient.AppsV1().Deployments("openfaas-fn").Get(context.Background(), "env", metav1.GetOptions{})
+	if got.Spec.Template.Spec.Containers[0].Image != updated.Spec.Image {
+		t.Errorf("want image: %s, got: %s", updated.Spec.Image, got.Spec.Template.Spec.Containers[0].Image)
+	}
+
+	if *got.Spec.Replicas != replicas {
+		t.Errorf("want replicas to be preserved: %d, got: %d", replicas, *got.Spec.Replicas)
+	}
+
+	fn, _ := f.faasClient.OpenfaasV1().Functions("openfaas-fn").Get(context.Background(), "env", metav1.GetOptions{})
+	if !meta.IsStatusConditionTrue(fn.Status.Conditions, conditionHealthy) {
+		t.Errorf("want condition %s to be true, got: %v", conditionHealthy, fn.Status.Conditions)
+	}
+}
+
+func Test_syncHandler_UnownedDeploymentIsNotUpdated(t *testing.T) {
+	function := newFunction("env")
+
+	existing := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "env",
+			Namespace: "openfaas-fn",
+			Labels:    map[string]string{"faas_function": "env"},
+		},
+	}
+
+	f := newFixture(t, function, existing)
+
+	if err := f.controller.syncHandler(context.Background(), "openfaas-fn/env"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	fn, _ := f.faasClient.OpenfaasV1().Functions("openfaas-fn").Get(context.Background(), "env", metav1.GetOptions{})
+	ready := meta.FindStatusCondition(fn.Status.Conditions, conditionReady)
+	if ready == nil || ready.Status != metav1.ConditionFalse {
+		t.Fatalf("want condition %s to be false, got: %v", conditionReady, fn.Status.Conditions)
+	}
+
+	if ready.Reason != reasonResourceExists {
+		t.Errorf("want reason: %s, got: %s", reasonResourceExists, ready.Reason)
+	}
+}
+
+func Test_syncHandler_InvalidSpecIsReported(t *testing.T) {
+	function := newFunction("env")
+	function.Spec.Name = "Not_Valid"
+
+	f := newFixture(t, function)
+
+	if err := f.controller.syncHandler(context.Background(), "openfaas-fn/env"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	fn, _ := f.faasClient.OpenfaasV1().Functions("openfaas-fn").Get(context.Background(), "env", metav1.GetOptions{})
+	ready := meta.FindStatusCondition(fn.Status.Conditions, conditionReady)
+	if ready == nil || ready.Reason != reasonInvalidSpec {
+		t.Fatalf("want reason: %s, got: %v", reasonInvalidSpec, fn.Status.Conditions)
+	}
+}
+
+func Test_syncHandler_RemovesAnnotation(t *testing.T) {
+	function := newFunction("env")
+	function.Spec.Annotations = &map[string]string{"topic": "cron"}
+	f := newFixture(t, function)
+
+	f.sync(t, function)
+
+	deployment, _ := f.kubeClient.AppsV1().Deployments("openfaas-fn").Get(context.Background(), "env", metav1.GetOptions{})
+	if _, ok := deployment.Annotations["topic"]; !ok {
+		t.Fatalf("want the topic annotation, got: %v", deployment.Annotations)
+	}
+
+	updated := function.DeepCopy()
+	updated.Spec.Annotations = nil
+	f.sync(t, updated)
+
+	deployment, _ = f.kubeClient.AppsV1().Deployments("openfaas-fn").Get(context.Background(), "env", metav1.GetOptions{})
+	if _, ok := deployment.Annotations["topic"]; ok {
+		t.Errorf("want the topic annotation to be removed, got: %v", deployment.Annotations)
+	}
+	if _, ok := deployment.Spec.Template.Annotations["topic"]; ok {
+		t.Errorf("want the topic annotation to be removed from the template, got: %v", deployment.Spec.Template.Annotations)
+	}
+}
+
+func Test_syncHandler_RemovesToleration(t *testing.T) {
+	function := newFunction("env")
+	function.Spec.Annotations = &map[string]string{k8s.ProfileAnnotationKey: "spot"}
+
+	profile := &faasv1.Profile{
+		ObjectMeta: metav1.ObjectMeta{Name: "spot", Namespace: "openfaas"},
+		Spec: faasv1.ProfileSpec{
+			Tolerations: []corev1.Toleration{
+				{Key: "spot", Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoSchedule},
+			},
+		},
+	}
+
+	f := newFixtureWithProfiles(t, function, []*faasv1.Profile{profile})
+	f.sync(t, function)
+
+	deployment, _ := f.kubeClient.AppsV1().Deployments("openfaas-fn").Get(context.Background(), "env", metav1.GetOptions{})
+	if got := len(deployment.Spec.Template.Spec.Tolerations); got != 1 {
+		t.Fatalf("want: %d tolerations, got: %d", 1, got)
+	}
+
+	profile.Spec.Tolerations = nil
+	if _, err := f.faasClient.OpenfaasV1().Profiles("openfaas").Update(context.Background(), profile, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	f.sync(t, function)
+
+	deployment, _ = f.kubeClient.AppsV1().Deployments("openfaas-fn").Get(context.Background(), "env", metav1.GetOptions{})
+	if tolerations := deployment.Spec.Template.Spec.Tolerations; len(tolerations) != 0 {
+		t.Errorf("want the toleration to be removed, got: %v", tolerations)
+	}
+}
+
+func Test_syncHandler_RemovesProfile(t *testing.T) {
+	function := newFunction("env")
+	function.Spec.Annotations = &map[string]string{k8s.ProfileAnnotationKey: "spot"}
+
+	runtimeClass := "gvisor"
+	profile := &faasv1.Profile{
+		ObjectMeta: metav1.ObjectMeta{Name: "spot", Namespace: "openfaas"},
+		Spec: faasv1.ProfileSpec{
+			Tolerations:      []corev1.Toleration{{Key: "spot", Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoSchedule}},
+			RuntimeClassName: &runtimeClass,
+			Affinity: &corev1.Affinity{
+				NodeAffinity: &corev1.NodeAffinity{
+					RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+						NodeSelectorTerms: []corev1.NodeSelectorTerm{{
+							MatchExpressions: []corev1.NodeSelectorRequirement{{Key: "node.kubernetes.io/lifecycle", Operator: corev1.NodeSelectorOpIn, Values: []string{"spot"}}},
+						}},
+					},
+				},
+			},
+		},
+	}
+
+	f := newFixtureWithProfiles(t, function, []*faasv1.Profile{profile})
+	f.sync(t, function)
+
+	deployment, _ := f.kubeClient.AppsV1().Deployments("openfaas-fn").Get(context.Background(), "env", metav1.GetOptions{})
+	podSpec := deployment.Spec.Template.Spec
+	if len(podSpec.Tolerations) != 1 || podSpec.Affinity == nil || podSpec.RuntimeClassName == nil {
+		t.Fatalf("want the Profile to be applied, got tolerations: %v, affinity: %v, runtimeClassName: %v", podSpec.Tolerations, podSpec.Affinity, podSpec.RuntimeClassName)
+	}
+
+	updated := function.DeepCopy()
+	updated.Spec.Annotations = nil
+	f.sync(t, updated)
+
+	deployment, _ = f.kubeClient.AppsV1().Deployments("openfaas-fn").Get(context.Background(), "env", metav1.GetOptions{})
+	podSpec = deployment.Spec.Template.Spec
+	if len(podSpec.Tolerations) != 0 {
+		t.Errorf("want the Profile's tolerations to be removed, got: %v", podSpec.Tolerations)
+	}
+	if podSpec.Affinity != nil {
+		t.Errorf("want the Profile's affinity to be removed, got: %v", podSpec.Affinity)
+	}
+	if podSpec.RuntimeClassName != nil {
+		t.Errorf("want the Profile's runtimeClassName to be removed, got: %s", *podSpec.RuntimeClassName)
+	}
+}
+
+func Test_toFunctionDeployment_CopiesMaps(t *testing.T) {
+	function := newFunction("env")
+	function.Spec.Annotations = &map[string]string{"topic": "cron"}
+
+	request := toFunctionDeployment(function)
+	(*request.Annotations)["prometheus.io.scrape"] = "false"
+
+	if len(*function.Spec.Annotations) != 1 {
+		t.Errorf("want the Function's annotations to be unchanged, got: %v", *function.Spec.Annotations)
+	}
+
+	if request.EnvProcess != function.Spec.Handler {
+		t.Errorf("want envProcess: %s, got: %s", function.Spec.Handler, request.EnvProcess)
+	}
+}
+
+func Test_syncHandler_ReportsAppliedProfileGeneration(t *testing.T) {
+	function := newFunction("env")
+	function.Spec.Annotations = &map[string]string{k8s.ProfileAnnotationKey: "spot"}
+
+	profile := &faasv1.Profile{
+		ObjectMeta: metav1.ObjectMeta{Name: "spot", Namespace: "openfaas", Generation: 3},
+	}
+
+	f := newFixtureWithProfiles(t, function, []*faasv1.Profile{profile})
+
+	if err := f.controller.syncHandler(context.Background(), "openfaas-fn/env"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	deployment, _ := f.kubeClient.AppsV1().Deployments("openfaas-fn").Get(context.Background(), "env", metav1.GetOptions{})
+
+	// A newer generation of the Profile which has not been applied yet
+	profile.Generation = 4
+	if _, err := f.faasClient.OpenfaasV1().Profiles("openfaas").Update(context.Background(), profile, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	applied := f.controller.appliedProfiles(deployment)
+	if len(applied) != 1 {
+		t.Fatalf("want 1 applied profile, got: %d", len(applied))
+	}
+	if applied[0].ProfileRef.Name != "spot" || applied[0].ObservedGeneration != 3 {
+		t.Errorf("want spot at generation 3, got: %s at %d", applied[0].ProfileRef.Name, applied[0].ObservedGeneration)
+	}
+}
+
+func Test_handleProfile_EnqueuesReferencingFunctions(t *testing.T) {
+	function := newFunction("env")
+	function.Spec.Annotations = &map[string]string{k8s.ProfileAnnotationKey: "gpu,spot"}
+
+	f := newFixture(t, function)
+
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	indexer.Add(function)
+	indexer.Add(newFunction("figlet"))
+	f.controller.functionsLister = listers.NewFunctionLister(indexer)
+
+	f.controller.handleProfile(&faasv1.Profile{ObjectMeta: metav1.ObjectMeta{Name: "spot", Namespace: "openfaas-fn"}})
+	if f.controller.workqueue.Len() != 0 {
+		t.Fatalf("want a Profile outside of the profiles namespace to be ignored, got: %d", f.controller.workqueue.Len())
+	}
+
+	f.controller.handleProfile(&faasv1.Profile{ObjectMeta: metav1.ObjectMeta{Name: "spot", Namespace: "openfaas"}})
+	if f.controller.workqueue.Len() != 1 {
+		t.Fatalf("want 1 Function to be enqueued, got: %d", f.controller.workqueue.Len())
+	}
+
+	key, _ := f.controller.workqueue.Get()
+	if key != "openfaas-fn/env" {
+		t.Errorf("want: %s, got: %s", "openfaas-fn/env", key)
+	}
+}
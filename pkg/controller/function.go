@@ -0,0 +1,154 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+package controller
+
+import (
+	"context"
+
+	faasv1 "github.com/openfaas/faas-netes/pkg/apis/openfaas/v1"
+	types "github.com/openfaas/faas-provider/types"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// conditionReady indicates that the Function's desired state has been
+	// applied by the operator
+	conditionReady = "Ready"
+
+	// conditionHealthy indicates that all replicas of the Function are
+	// available to serve traffic
+	conditionHealthy = "Healthy"
+)
+
+const (
+	reasonReconciled       = "Reconciled"
+	reasonInvalidSpec      = "InvalidSpec"
+	reasonResourceExists   = "ResourceExists"
+	reasonLimitExceeded    = "LimitExceeded"
+	reasonDeploymentFailed = "DeploymentFailed"
+	reasonServiceFailed    = "ServiceFailed"
+
+	reasonReplicasAvailable   = "ReplicasAvailable"
+	reasonReplicasUnavailable = "ReplicasUnavailable"
+)
+
+// toFunctionDeployment converts a Function into the request type used by the
+// REST API. Maps are copied, so that the informer's cache is never mutated.
+func toFunctionDeployment(function *faasv1.Function) types.FunctionDeployment {
+	spec := function.Spec
+
+	request := types.FunctionDeployment{
+		Service:                spec.Name,
+		Image:                  spec.Image,
+		Namespace:              function.Namespace,
+		EnvProcess:             spec.Handler,
+		Constraints:            spec.Constraints,
+		Secrets:                spec.Secrets,
+		ReadOnlyRootFilesystem: spec.ReadOnlyRootFilesystem,
+	}
+
+	if spec.Environment != nil {
+		request.EnvVars = copyMap(*spec.Environment)
+	}
+
+	if spec.Labels != nil {
+		labels := copyMap(*spec.Labels)
+		request.Labels = &labels
+	}
+
+	if spec.Annotations != nil {
+		annotations := copyMap(*spec.Annotations)
+		request.Annotations = &annotations
+	}
+
+	if spec.Limits != nil {
+		request.Limits = &types.FunctionResources{
+			Memory: spec.Limits.Memory,
+			CPU:    spec.Limits.CPU,
+		}
+	}
+
+	if spec.Requests != nil {
+		request.Requests = &types.FunctionResources{
+			Memory: spec.Requests.Memory,
+			CPU:    spec.Requests.CPU,
+		}
+	}
+
+	return request
+}
+
+func copyMap(in map[string]string) map[string]string {
+	out := make(map[string]string, len(in))
+	for k, v := range in {
+		out[k] = v
+	}
+	return out
+}
+
+// updateFunctionStatus writes the result of a reconciliation to the Function's
+// status subresource. The update is skipped when nothing has changed.
+func (c *Controller) updateFunctionStatus(ctx context.Context, function *faasv1.Function, deployment *appsv1.Deployment, reason string, reconcileErr error) error {
+	fn := function.DeepCopy()
+	fn.Status = makeFunctionStatus(function, deployment, reason, reconcileErr)
+	if reconcileErr == nil {
+		fn.Status.Profiles = c.appliedProfiles(deployment)
+	}
+
+	if equality.Semantic.DeepEqual(function.Status, fn.Status) {
+		return nil
+	}
+
+	_, err := c.faasClient.OpenfaasV1().Functions(fn.Namespace).UpdateStatus(ctx, fn, metav1.UpdateOptions{})
+	return err
+}
+
+func makeFunctionStatus(function *faasv1.Function, deployment *appsv1.Deployment, reason string, reconcileErr error) faasv1.FunctionStatus {
+	status := *function.Status.DeepCopy()
+	status.ObservedGeneration = function.Generation
+
+	ready := metav1.Condition{
+		Type:               conditionReady,
+		Status:             metav1.ConditionTrue,
+		Reason:             reason,
+		ObservedGeneration: function.Generation,
+	}
+	if reconcileErr != nil {
+		ready.Status = metav1.ConditionFalse
+		ready.Message = reconcileErr.Error()
+	}
+	meta.SetStatusCondition(&status.Conditions, ready)
+
+	if deployment == nil || !metav1.IsControlledBy(deployment, function) {
+		status.Replicas = 0
+		status.AvailableReplicas = 0
+		status.UnavailableReplicas = 0
+		meta.RemoveStatusCondition(&status.Conditions, conditionHealthy)
+		return status
+	}
+
+	if deployment.Spec.Replicas != nil {
+		status.Replicas = *deployment.Spec.Replicas
+	}
+	status.AvailableReplicas = deployment.Status.AvailableReplicas
+	status.UnavailableReplicas = deployment.Status.UnavailableReplicas
+
+	healthy := metav1.Condition{
+		Type:               conditionHealthy,
+		Status:             metav1.ConditionTrue,
+		Reason:             reasonReplicasAvailable,
+		ObservedGeneration: function.Generation,
+	}
+	if status.Replicas == 0 || status.AvailableReplicas < status.Replicas {
+		healthy.Status = metav1.ConditionFalse
+		healthy.Reason = reasonReplicasUnavailable
+	}
+	meta.SetStatusCondition(&status.Conditions, healthy)
+
+	return status
+}
@@ -0,0 +1,353 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/openfaas/faas-netes/pkg/k8s"
+	types "github.com/openfaas/faas-provider/types"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// CanaryWeightRequest changes the weight of the canary of a function
+type CanaryWeightRequest struct {
+	// Weight is the percentage of requests sent to the canary, from 0 to 100
+	Weight *int `json:"weight"`
+}
+
+// MakeCanaryHandler promotes or aborts the canary of a function, or changes its
+// weight. Promoting updates the function to the spec of its canary and then removes
+// the canary, aborting only removes the canary.
+func MakeCanaryHandler(namespaces *k8s.Namespaces, factory k8s.FunctionFactory) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if r.Body != nil {
+			defer r.Body.Close()
+		}
+
+		functionName := mux.Vars(r)["name"]
+
+		lookupNamespace, err := functionNamespace(r, namespaces)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if _, err := getFunctionDeployment(ctx, factory.Client, lookupNamespace, functionName); err != nil {
+			status, _ := ProcessErrorReasons(err)
+			http.Error(w, err.Error(), status)
+			return
+		}
+
+		canary, err := getCanaryDeployment(ctx, factory.Client, lookupNamespace, functionName)
+		if err != nil {
+			status, _ := ProcessErrorReasons(err)
+			http.Error(w, err.Error(), status)
+			return
+		}
+
+		image := canary.Spec.Template.Spec.Containers[0].Image
+
+		switch action := mux.Vars(r)["action"]; action {
+		case "weight":
+			req := CanaryWeightRequest{}
+			body, _ := io.ReadAll(r.Body)
+			if err := json.Unmarshal(body, &req); err != nil {
+				http.Error(w, fmt.Sprintf("unable to unmarshal request: %s", err.Error()), http.StatusBadRequest)
+				return
+			}
+
+			if req.Weight == nil {
+				http.Error(w, "weight is required", http.StatusBadRequest)
+				return
+			}
+
+			weight, err := k8s.ParseCanaryWeight(strconv.Itoa(*req.Weight))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			if err := setCanaryState(ctx, factory.Client, lookupNamespace, functionName, k8s.CanaryActive, image, &weight); err != nil {
+				log.Printf("Unable to set the canary weight: %s.%s, error: %s", functionName, lookupNamespace, err.Error())
+				status, _ := ProcessErrorReasons(err)
+				http.Error(w, "unable to set the canary weight", status)
+				return
+			}
+
+			log.Printf("Canary weight set: %s.%s to %d%%\n", functionName, lookupNamespace, weight)
+
+		case "promote":
+			request := functionFromTemplate(functionName, lookupNamespace, canary.Spec.Template)
+			if err := ValidateDeployRequest(&request, factory.Config); err != nil {
+				http.Error(w, fmt.Sprintf("validation failed: %s", err.Error()), http.StatusBadRequest)
+				return
+			}
+
+			if _, status, err := updateDeploymentSpec(ctx, lookupNamespace, factory, request, false); err != nil {
+				log.Printf("error promoting canary: %s.%s, error: %s\n", functionName, lookupNamespace, err)
+				http.Error(w, fmt.Sprintf("unable to promote canary: %s", err.Error()), status)
+				return
+			}
+
+			if _, status, err := updateService(ctx, lookupNamespace, factory, request, false); err != nil {
+				log.Printf("error promoting canary: %s.%s, error: %s\n", functionName, lookupNamespace, err)
+				http.Error(w, fmt.Sprintf("unable to promote canary: %s", err.Error()), status)
+				return
+			}
+
+			if err := finishCanary(ctx, factory.Client, lookupNamespace, functionName, k8s.CanaryPromoted, image); err != nil {
+				log.Printf("Unable to remove the canary: %s.%s, error: %s", functionName, lookupNamespace, err.Error())
+				status, _ := ProcessErrorReasons(err)
+				http.Error(w, "canary promoted, but unable to remove the canary", status)
+				return
+			}
+
+			log.Printf("Canary promoted: %s.%s to image: %s\n", functionName, lookupNamespace, image)
+
+		case "abort":
+			if err := finishCanary(ctx, factory.Client, lookupNamespace, functionName, k8s.CanaryAborted, image); err != nil {
+				log.Printf("Unable to remove the canary: %s.%s, error: %s", functionName, lookupNamespace, err.Error())
+				status, _ := ProcessErrorReasons(err)
+				http.Error(w, "unable to remove the canary", status)
+				return
+			}
+
+			log.Printf("Canary aborted: %s.%s\n", functionName, lookupNamespace)
+
+		default:
+			http.Error(w, fmt.Sprintf("unknown canary action: %q", action), http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// canaryRequested returns the weight given by the canary query parameter, false is
+// returned when no canary was requested
+func canaryRequested(r *http.Request) (int, bool, error) {
+	v := r.URL.Query().Get("canary")
+	if len(v) == 0 {
+		return 0, false, nil
+	}
+
+	weight, err := k8s.ParseCanaryWeight(v)
+	if err != nil {
+		return 0, false, err
+	}
+
+	return weight, true, nil
+}
+
+// deployCanary creates or updates the canary of a function with the spec of request,
+// the function itself is left unchanged
+func deployCanary(
+	ctx context.Context,
+	functionNamespace string,
+	factory k8s.FunctionFactory,
+	request types.FunctionDeployment,
+	weight int,
+	dryRun bool) (*appsv1.Deployment, *corev1.Service, int, error) {
+
+	function, err := getFunctionDeployment(ctx, factory.Client, functionNamespace, request.Service)
+	if err != nil {
+		status, _ := ProcessErrorReasons(err)
+		return nil, nil, status, err
+	}
+
+	if err := isAnonymous(request.Image); err != nil {
+		return nil, nil, http.StatusBadRequest, err
+	}
+
+	secrets := k8s.NewSecretsClient(factory.Client)
+	existingSecrets, err := secrets.GetSecrets(functionNamespace, request.Secrets)
+	if err != nil {
+		return nil, nil, http.StatusBadRequest, err
+	}
+
+	desired, err := makeDeploymentSpec(request, existingSecrets, factory)
+	if err != nil {
+		return nil, nil, http.StatusBadRequest, err
+	}
+
+	for _, labels := range []map[string]string{function.Spec.Template.Labels, desired.Spec.Template.Labels} {
+		if err := validateCanaryScaling(labels, factory.Config); err != nil {
+			return nil, nil, http.StatusBadRequest, err
+		}
+	}
+
+	desiredService, err := makeServiceSpec(request, factory)
+	if err != nil {
+		return nil, nil, http.StatusBadRequest, err
+	}
+
+	asCanary(desired, desiredService)
+
+	// Force a new rollout, even when the image tag has not changed
+	desired.Spec.Template.Labels["uid"] = fmt.Sprintf("%d", time.Now().Nanosecond())
+
+	// The canary can be given all of the traffic, so it runs as many replicas as the function
+	desired.Spec.Replicas = function.Spec.Replicas
+
+	if err := verifyCanaryName(ctx, factory.Client, functionNamespace, request.Service); err != nil {
+		return nil, nil, http.StatusConflict, err
+	}
+
+	deployment, err := k8s.ApplyDeployment(ctx, factory.Client, functionNamespace, desired, dryRun)
+	if err != nil {
+		status, _ := ProcessErrorReasons(err)
+		return nil, nil, status, err
+	}
+
+	service, err := k8s.ApplyService(ctx, factory.Client, functionNamespace, desiredService, dryRun)
+	if err != nil {
+		status, _ := ProcessErrorReasons(err)
+		return nil, nil, status, err
+	}
+
+	if !dryRun {
+		if err := setCanaryState(ctx, factory.Client, functionNamespace, request.Service, k8s.CanaryActive, request.Image, &weight); err != nil {
+			status, _ := ProcessErrorReasons(err)
+			return nil, nil, status, err
+		}
+	}
+
+	return deployment, service, http.StatusAccepted, nil
+}
+
+// validateCanaryScaling returns an error when the function given by labels is scaled
+// to zero or by an HPA. The replicas of the canary are copied from the function once,
+// and the canary has no faas_function label, so it would not be scaled with it.
+func validateCanaryScaling(labels map[string]string, config k8s.DeploymentConfig) error {
+	if config.ScaleToZero && k8s.ScaleToZeroEnabled(labels) {
+		return fmt.Errorf("a canary is not supported for a function which scales to zero")
+	}
+	if config.HPA && k8s.HPAEnabled(labels) {
+		return fmt.Errorf("a canary is not supported for a function which is scaled by an HPA")
+	}
+	return nil
+}
+
+// asCanary renames the Deployment and Service of a function to those of its canary,
+// and selects the canary's pods by the canary label instead of faas_function
+func asCanary(deployment *appsv1.Deployment, service *corev1.Service) {
+	functionName := deployment.Name
+
+	relabel := func(labels map[string]string) {
+		delete(labels, "faas_function")
+		labels[k8s.CanaryLabel] = functionName
+	}
+
+	deployment.Name = k8s.CanaryName(functionName)
+	relabel(deployment.Labels)
+	relabel(deployment.Spec.Selector.MatchLabels)
+	relabel(deployment.Spec.Template.Labels)
+
+	service.Name = k8s.CanaryName(functionName)
+	relabel(service.Labels)
+	relabel(service.Spec.Selector)
+}
+
+// getCanaryDeployment returns the Deployment of the canary of a function, a
+// Deployment with the canary's name which is not its canary is reported as not found
+func getCanaryDeployment(ctx context.Context, client kubernetes.Interface, namespace, functionName string) (*appsv1.Deployment, error) {
+	name := k8s.CanaryName(functionName)
+
+	deployment, err := client.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	if deployment.Labels[k8s.CanaryLabel] != functionName {
+		return nil, errors.NewNotFound(appsv1.Resource("deployments"), name)
+	}
+
+	return deployment, nil
+}
+
+// verifyCanaryName checks that the name of the canary is not taken by a Deployment or
+// Service which is not the canary of the function, such as another function
+func verifyCanaryName(ctx context.Context, client kubernetes.Interface, namespace, functionName string) error {
+	name := k8s.CanaryName(functionName)
+
+	deployment, err := client.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err == nil && deployment.Labels[k8s.CanaryLabel] != functionName {
+		return fmt.Errorf("the Deployment %s.%s exists and is not the canary of %s", name, namespace, functionName)
+	}
+
+	service, err := client.CoreV1().Services(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err == nil && service.Labels[k8s.CanaryLabel] != functionName {
+		return fmt.Errorf("the Service %s.%s exists and is not the canary of %s", name, namespace, functionName)
+	}
+
+	return nil
+}
+
+// setCanaryState records the state of a function's canary on its Deployment, weight
+// is only recorded for an active canary
+func setCanaryState(ctx context.Context, client kubernetes.Interface, namespace, functionName, state, image string, weight *int) error {
+	annotations := map[string]string{
+		k8s.CanaryStateAnnotation: state,
+		k8s.CanaryImageAnnotation: image,
+	}
+	if weight != nil {
+		annotations[k8s.CanaryWeightAnnotation] = strconv.Itoa(*weight)
+	}
+
+	_, err := k8s.ApplyCanaryAnnotations(ctx, client, namespace, functionName, annotations)
+	return err
+}
+
+// finishCanary sends all of the traffic back to the function and then removes its canary
+func finishCanary(ctx context.Context, client kubernetes.Interface, namespace, functionName, state, image string) error {
+	if err := setCanaryState(ctx, client, namespace, functionName, state, image, nil); err != nil {
+		return err
+	}
+
+	return deleteCanary(ctx, client, namespace, functionName)
+}
+
+// deleteCanary removes the Deployment and Service of the canary of a function, when
+// it has one
+func deleteCanary(ctx context.Context, client kubernetes.Interface, namespace, functionName string) error {
+	name := k8s.CanaryName(functionName)
+	background := metav1.DeletePropagationBackground
+	opts := metav1.DeleteOptions{PropagationPolicy: &background}
+
+	deployment, err := client.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	if err == nil && deployment.Labels[k8s.CanaryLabel] == functionName {
+		if err := client.AppsV1().Deployments(namespace).Delete(ctx, name, opts); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	service, err := client.CoreV1().Services(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	if err == nil && service.Labels[k8s.CanaryLabel] == functionName {
+		if err := client.CoreV1().Services(namespace).Delete(ctx, name, opts); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,227 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/openfaas/faas-netes/pkg/k8s"
+	types "github.com/openfaas/faas-provider/types"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// createCanary creates figlet:1.0 with a canary of figlet:2.0 and a weight of 20,
+// and returns the canary handler
+func (f *testFixture) createCanary(t *testing.T) http.HandlerFunc {
+	t.Helper()
+
+	f.createFunction(t, figletFunction("1.0"))
+
+	request := figletFunction("2.0")
+	request.EnvVars = map[string]string{"version": "2.0"}
+	if rr := updateCanary(f.updateHandler(t), request, "20"); rr.Code != http.StatusAccepted {
+		t.Fatalf("want: %d, got: %d, body: %s", http.StatusAccepted, rr.Code, rr.Body.String())
+	}
+
+	return MakeCanaryHandler(f.namespaces, f.factory)
+}
+
+func updateCanary(handler http.HandlerFunc, request types.FunctionDeployment, weight string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(request)
+
+	rr := httptest.NewRecorder()
+	handler(rr, httptest.NewRequest(http.MethodPut, "/system/functions?canary="+weight, strings.NewReader(string(body))))
+	return rr
+}
+
+func canaryAction(handler http.HandlerFunc, action, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/system/function/figlet/canary/"+action, strings.NewReader(body))
+	req = mux.SetURLVars(req, map[string]string{"name": "figlet", "action": action})
+
+	rr := httptest.NewRecorder()
+	handler(rr, req)
+	return rr
+}
+
+func Test_MakeUpdateHandler_DeploysCanary(t *testing.T) {
+	f := newTestFixture()
+	f.createCanary(t)
+
+	function := f.deployment(t, "figlet")
+	if got := function.Spec.Template.Spec.Containers[0].Image; got != "localhost:5000/figlet:1.0" {
+		t.Errorf("want the function to be unchanged, got image: %s", got)
+	}
+	if got := k8s.CanaryWeight(function); got != 20 {
+		t.Errorf("want canary weight: %d, got: %d", 20, got)
+	}
+	if got := function.Annotations[k8s.CanaryImageAnnotation]; got != "localhost:5000/figlet:2.0" {
+		t.Errorf("want canary image: %s, got: %s", "localhost:5000/figlet:2.0", got)
+	}
+
+	canary := f.deployment(t, "figlet-canary")
+	if got := canary.Spec.Template.Spec.Containers[0].Image; got != "localhost:5000/figlet:2.0" {
+		t.Errorf("want canary image: %s, got: %s", "localhost:5000/figlet:2.0", got)
+	}
+	if _, ok := canary.Spec.Template.Labels["faas_function"]; ok {
+		t.Errorf("want the canary's pods not to be selected by the function's Service")
+	}
+	if got := canary.Spec.Selector.MatchLabels[k8s.CanaryLabel]; got != "figlet" {
+		t.Errorf("want canary selector: %s, got: %s", "figlet", got)
+	}
+
+	service, err := f.kube.CoreV1().Services("openfaas-fn").Get(context.Background(), "figlet-canary", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got := service.Spec.Selector[k8s.CanaryLabel]; got != "figlet" || len(service.Spec.Selector) != 1 {
+		t.Errorf("want the canary Service to select the canary's pods, got: %v", service.Spec.Selector)
+	}
+}
+
+func Test_MakeCanaryHandler_Weight(t *testing.T) {
+	f := newTestFixture()
+	handler := f.createCanary(t)
+
+	if rr := canaryAction(handler, "weight", `{"weight": 101}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("want: %d, got: %d, body: %s", http.StatusBadRequest, rr.Code, rr.Body.String())
+	}
+	if rr := canaryAction(handler, "weight", `{}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("want: %d, got: %d, body: %s", http.StatusBadRequest, rr.Code, rr.Body.String())
+	}
+
+	rr := canaryAction(handler, "weight", `{"weight": 100}`)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("want: %d, got: %d, body: %s", http.StatusAccepted, rr.Code, rr.Body.String())
+	}
+
+	function := f.deployment(t, "figlet")
+	if got := k8s.CanaryWeight(function); got != 100 {
+		t.Errorf("want canary weight: %d, got: %d", 100, got)
+	}
+	if got := function.Annotations[k8s.CanaryImageAnnotation]; got != "localhost:5000/figlet:2.0" {
+		t.Errorf("want the canary image to be kept, got: %q", got)
+	}
+}
+
+func Test_MakeCanaryHandler_Promote(t *testing.T) {
+	f := newTestFixture()
+	handler := f.createCanary(t)
+
+	rr := canaryAction(handler, "promote", "")
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("want: %d, got: %d, body: %s", http.StatusAccepted, rr.Code, rr.Body.String())
+	}
+
+	function := f.deployment(t, "figlet")
+	container := function.Spec.Template.Spec.Containers[0]
+	if container.Image != "localhost:5000/figlet:2.0" {
+		t.Errorf("want the function updated to the canary's image, got: %s", container.Image)
+	}
+	if len(container.Env) != 1 || container.Env[0].Value != "2.0" {
+		t.Errorf("want the env-vars of the canary, got: %v", container.Env)
+	}
+	if got := function.Spec.Template.Labels["faas_function"]; got != "figlet" {
+		t.Errorf("want the faas_function label to be kept, got: %q", got)
+	}
+	if _, ok := function.Spec.Template.Labels[k8s.CanaryLabel]; ok {
+		t.Errorf("want no canary label on the function's pods")
+	}
+
+	if got := function.Annotations[k8s.CanaryStateAnnotation]; got != k8s.CanaryPromoted {
+		t.Errorf("want canary state: %s, got: %s", k8s.CanaryPromoted, got)
+	}
+	if _, ok := function.Annotations[k8s.CanaryWeightAnnotation]; ok {
+		t.Errorf("want the canary weight to be removed")
+	}
+
+	if _, err := f.kube.AppsV1().Deployments("openfaas-fn").Get(context.Background(), "figlet-canary", metav1.GetOptions{}); err == nil {
+		t.Errorf("want the canary Deployment to be removed")
+	}
+}
+
+func Test_MakeCanaryHandler_Abort(t *testing.T) {
+	f := newTestFixture()
+	handler := f.createCanary(t)
+
+	rr := canaryAction(handler, "abort", "")
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("want: %d, got: %d, body: %s", http.StatusAccepted, rr.Code, rr.Body.String())
+	}
+
+	function := f.deployment(t, "figlet")
+	if got := function.Spec.Template.Spec.Containers[0].Image; got != "localhost:5000/figlet:1.0" {
+		t.Errorf("want the function to be unchanged, got image: %s", got)
+	}
+	if got := function.Annotations[k8s.CanaryStateAnnotation]; got != k8s.CanaryAborted {
+		t.Errorf("want canary state: %s, got: %s", k8s.CanaryAborted, got)
+	}
+	if got := k8s.CanaryWeight(function); got != 0 {
+		t.Errorf("want canary weight: %d, got: %d", 0, got)
+	}
+
+	if _, err := f.kube.AppsV1().Deployments("openfaas-fn").Get(context.Background(), "figlet-canary", metav1.GetOptions{}); err == nil {
+		t.Errorf("want the canary Deployment to be removed")
+	}
+	if _, err := f.kube.CoreV1().Services("openfaas-fn").Get(context.Background(), "figlet-canary", metav1.GetOptions{}); err == nil {
+		t.Errorf("want the canary Service to be removed")
+	}
+
+	// There is no canary left to abort
+	if rr := canaryAction(handler, "abort", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("want: %d, got: %d, body: %s", http.StatusNotFound, rr.Code, rr.Body.String())
+	}
+}
+
+func Test_MakeUpdateHandler_CanaryRejected(t *testing.T) {
+	taken := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "figlet-canary",
+			Namespace: "openfaas-fn",
+			Labels:    map[string]string{"faas_function": "figlet-canary"},
+		},
+	}
+
+	cases := []struct {
+		name       string
+		objects    []runtime.Object
+		weight     string
+		labels     map[string]string
+		wantStatus int
+	}{
+		{name: "invalid weight", weight: "120", wantStatus: http.StatusBadRequest},
+		{name: "name taken", objects: []runtime.Object{taken}, weight: "20", wantStatus: http.StatusConflict},
+		{name: "scale to zero", weight: "20", labels: map[string]string{k8s.ScaleToZeroLabel: "true"}, wantStatus: http.StatusBadRequest},
+		{name: "HPA", weight: "20", labels: map[string]string{k8s.ScaleTypeLabel: "cpu"}, wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newTestFixture(tc.objects...)
+			f.factory.Config.ScaleToZero = true
+			f.factory.Config.HPA = true
+			f.createFunction(t, figletFunction("1.0"))
+
+			request := figletFunction("2.0")
+			request.Labels = &tc.labels
+			request.Requests = &types.FunctionResources{CPU: "100m"}
+
+			rr := updateCanary(f.updateHandler(t), request, tc.weight)
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("want: %d, got: %d, body: %s", tc.wantStatus, rr.Code, rr.Body.String())
+			}
+
+			if _, err := f.kube.AppsV1().Deployments("openfaas-fn").Get(context.Background(), "figlet-canary", metav1.GetOptions{}); err == nil {
+				t.Errorf("want no canary to be deployed")
+			}
+		})
+	}
+}
@@ -11,8 +11,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 
+	"github.com/openfaas/faas-netes/pkg/k8s"
 	"github.com/openfaas/faas-provider/types"
 	appsv1 "k8s.io/api/apps/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
@@ -21,26 +23,21 @@ import (
 )
 
 // MakeDeleteHandler delete a function
-func MakeDeleteHandler(defaultNamespace string, clientset *kubernetes.Clientset) http.HandlerFunc {
+func MakeDeleteHandler(namespaces *k8s.Namespaces, clientset *kubernetes.Clientset, config k8s.DeploymentConfig) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		defer r.Body.Close()
 
 		q := r.URL.Query()
 		namespace := q.Get("namespace")
 
-		lookupNamespace := defaultNamespace
+		lookupNamespace := namespaces.DefaultNamespace
 
 		if len(namespace) > 0 {
 			lookupNamespace = namespace
 		}
 
-		if lookupNamespace == "kube-system" {
-			http.Error(w, "unable to list within the kube-system namespace", http.StatusUnauthorized)
-			return
-		}
-
-		if lookupNamespace != defaultNamespace {
-			http.Error(w, fmt.Sprintf("namespace must be: %s", defaultNamespace), http.StatusBadRequest)
+		if err := namespaces.Validate(lookupNamespace); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
@@ -77,7 +74,7 @@ func MakeDeleteHandler(defaultNamespace string, clientset *kubernetes.Clientset)
 		}
 
 		if isFunction(deployment) {
-			err := deleteFunction(lookupNamespace, clientset, request, w)
+			err := deleteFunction(lookupNamespace, clientset, config, request, w)
 			if err != nil {
 				return
 			}
@@ -101,7 +98,7 @@ func isFunction(deployment *appsv1.Deployment) bool {
 	return false
 }
 
-func deleteFunction(functionNamespace string, clientset *kubernetes.Clientset, request types.DeleteFunctionRequest, w http.ResponseWriter) error {
+func deleteFunction(functionNamespace string, clientset *kubernetes.Clientset, config k8s.DeploymentConfig, request types.DeleteFunctionRequest, w http.ResponseWriter) error {
 	foregroundPolicy := metav1.DeletePropagationForeground
 	opts := &metav1.DeleteOptions{PropagationPolicy: &foregroundPolicy}
 
@@ -130,5 +127,15 @@ func deleteFunction(functionNamespace string, clientset *kubernetes.Clientset, r
 		w.Write([]byte(svcErr.Error()))
 		return fmt.Errorf("error deleting function's service")
 	}
+
+	if err := deleteCanary(context.TODO(), clientset, functionNamespace, request.FunctionName); err != nil {
+		log.Printf("Unable to delete the canary of: %s.%s, error: %s", request.FunctionName, functionNamespace, err.Error())
+	}
+
+	if config.HPA {
+		if _, err := k8s.DeleteHPA(context.TODO(), clientset, functionNamespace, request.FunctionName, false); err != nil {
+			log.Printf("Unable to delete the HPA of: %s.%s, error: %s", request.FunctionName, functionNamespace, err.Error())
+		}
+	}
 	return nil
 }
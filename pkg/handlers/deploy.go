@@ -16,12 +16,14 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/openfaas/faas-netes/pkg/k8s"
 
 	types "github.com/openfaas/faas-provider/types"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
@@ -30,8 +32,11 @@ import (
 // initialReplicasCount how many replicas to start of creating for a function
 const initialReplicasCount = 1
 
-// MakeDeployHandler creates a handler to create new functions in the cluster
-func MakeDeployHandler(functionNamespace string, factory k8s.FunctionFactory, functionList *k8s.FunctionList) http.HandlerFunc {
+// MakeDeployHandler creates a handler to create new functions in the cluster. When the
+// function already exists it is updated instead and 200 is returned rather than 202,
+// unless the "upsert=false" query parameter is given, then 409 is returned. With
+// "wait=<duration>" the response is sent once the rollout completes or fails.
+func MakeDeployHandler(namespaces *k8s.Namespaces, factory k8s.FunctionFactory, functionList *k8s.FunctionList, rollouts *k8s.RolloutWatcher) http.HandlerFunc {
 	secrets := k8s.NewSecretsClient(factory.Client)
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -50,19 +55,55 @@ func MakeDeployHandler(functionNamespace string, factory k8s.FunctionFactory, fu
 			return
 		}
 
-		if err := ValidateDeployRequest(&request); err != nil {
+		if err := ValidateDeployRequest(&request, factory.Config); err != nil {
 			wrappedErr := fmt.Errorf("validation failed: %s", err.Error())
 			http.Error(w, wrappedErr.Error(), http.StatusBadRequest)
 			return
 		}
 
-		namespace := functionNamespace
+		namespace := namespaces.DefaultNamespace
 		if len(request.Namespace) > 0 {
 			namespace = request.Namespace
 		}
 
-		if namespace != functionNamespace {
-			http.Error(w, fmt.Sprintf("namespace must be: %s", functionNamespace), http.StatusBadRequest)
+		if err := namespaces.Validate(namespace); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		dryRun, err := dryRunRequested(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		wait, err := waitRequested(r, rollouts)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		ctx := r.Context()
+
+		existing, err := factory.Client.AppsV1().Deployments(namespace).Get(ctx, request.Service, metav1.GetOptions{})
+		if err != nil && !k8serrors.IsNotFound(err) {
+			status, _ := ProcessErrorReasons(err)
+			http.Error(w, fmt.Sprintf("unable to get Deployment: %s", err.Error()), status)
+			return
+		}
+
+		if err == nil {
+			if r.URL.Query().Get("upsert") == "false" {
+				http.Error(w, fmt.Sprintf("function %s.%s already exists", request.Service, namespace), http.StatusConflict)
+				return
+			}
+
+			if existing.Labels["faas_function"] != request.Service {
+				http.Error(w, fmt.Sprintf("Deployment %s.%s already exists and is not a function", request.Service, namespace), http.StatusConflict)
+				return
+			}
+
+			upsertFunction(ctx, w, r, namespace, factory, request, dryRun, rollouts, wait)
 			return
 		}
 
@@ -86,6 +127,14 @@ func MakeDeployHandler(functionNamespace string, factory k8s.FunctionFactory, fu
 			return
 		}
 
+		serviceSpec, err := makeServiceSpec(request, factory)
+		if err != nil {
+			wrappedErr := fmt.Errorf("failed create Service spec: %s", err.Error())
+			log.Println(wrappedErr)
+			http.Error(w, wrappedErr.Error(), http.StatusBadRequest)
+			return
+		}
+
 		count, err := functionList.Count()
 		if err != nil {
 			err := fmt.Errorf("unable to count functions: %s", err.Error())
@@ -99,38 +148,235 @@ func MakeDeployHandler(functionNamespace string, factory k8s.FunctionFactory, fu
 			return
 		}
 
+		createOpts := metav1.CreateOptions{FieldManager: k8s.FieldManager, DryRun: k8s.DryRunOptions(dryRun)}
+
 		deploy := factory.Client.AppsV1().Deployments(namespace)
-		if _, err = deploy.Create(context.TODO(), deploymentSpec, metav1.CreateOptions{}); err != nil {
+		deployment, err := deploy.Create(ctx, deploymentSpec, createOpts)
+		if err != nil {
 			wrappedErr := fmt.Errorf("unable create Deployment: %s", err.Error())
 			log.Println(wrappedErr)
-			http.Error(w, wrappedErr.Error(), http.StatusInternalServerError)
+
+			status, _ := ProcessErrorReasons(err)
+			http.Error(w, wrappedErr.Error(), status)
 			return
 		}
 
-		log.Printf("Deployment created: %s.%s\n", request.Service, namespace)
+		if !dryRun {
+			log.Printf("Deployment created: %s.%s\n", request.Service, namespace)
+		}
 
-		service := factory.Client.CoreV1().Services(namespace)
-		serviceSpec, err := makeServiceSpec(request, factory)
+		service, err := createOrAdoptService(ctx, factory, namespace, serviceSpec, dryRun)
 		if err != nil {
-			wrappedErr := fmt.Errorf("failed create Service spec: %s", err.Error())
+			wrappedErr := fmt.Errorf("failed create Service: %s", err.Error())
 			log.Println(wrappedErr)
-			http.Error(w, wrappedErr.Error(), http.StatusBadRequest)
+
+			// Roll back the Deployment, so that the function can be deployed again
+			if !dryRun {
+				if rollbackErr := deleteFunctionDeployment(factory, namespace, request.Service); rollbackErr != nil {
+					log.Printf("unable to roll back Deployment: %s.%s, error: %s\n", request.Service, namespace, rollbackErr)
+					wrappedErr = fmt.Errorf("%s, and unable to roll back Deployment: %s", wrappedErr.Error(), rollbackErr.Error())
+				}
+			}
+
+			status, _ := ProcessErrorReasons(err)
+			http.Error(w, wrappedErr.Error(), status)
 			return
 		}
 
-		if _, err = service.Create(context.TODO(), serviceSpec, metav1.CreateOptions{}); err != nil {
-			wrappedErr := fmt.Errorf("failed create Service: %s", err.Error())
+		if err := syncHPA(ctx, factory, namespace, request, deployment, dryRun); err != nil {
+			wrappedErr := fmt.Errorf("failed create HPA: %s", err.Error())
 			log.Println(wrappedErr)
-			http.Error(w, wrappedErr.Error(), http.StatusBadRequest)
+
+			// Roll back the Service and Deployment, so that the function can be deployed again
+			if !dryRun {
+				if rollbackErr := deleteFunctionService(factory, namespace, request.Service); rollbackErr != nil {
+					log.Printf("unable to roll back Service: %s.%s, error: %s\n", request.Service, namespace, rollbackErr)
+					wrappedErr = fmt.Errorf("%s, and unable to roll back Service: %s", wrappedErr.Error(), rollbackErr.Error())
+				}
+				if rollbackErr := deleteFunctionDeployment(factory, namespace, request.Service); rollbackErr != nil {
+					log.Printf("unable to roll back Deployment: %s.%s, error: %s\n", request.Service, namespace, rollbackErr)
+					wrappedErr = fmt.Errorf("%s, and unable to roll back Deployment: %s", wrappedErr.Error(), rollbackErr.Error())
+				}
+			}
+
+			status, _ := ProcessErrorReasons(err)
+			http.Error(w, wrappedErr.Error(), status)
 			return
 		}
 
-		log.Printf("Service created: %s.%s\n", request.Service, namespace)
+		if dryRun {
+			writeDryRun(w, r, deployment, service)
+			return
+		}
+
+		if wait > 0 {
+			waitForRollout(w, r, rollouts, namespace, request.Service, deployment.Generation, wait, http.StatusAccepted)
+			return
+		}
 
 		w.WriteHeader(http.StatusAccepted)
 	}
 }
 
+// upsertFunction updates an existing function in the same way as the update handler,
+// the Service is created when it is missing.
+func upsertFunction(ctx context.Context, w http.ResponseWriter, r *http.Request, namespace string, factory k8s.FunctionFactory, request types.FunctionDeployment, dryRun bool, rollouts *k8s.RolloutWatcher, wait time.Duration) {
+	deployment, status, err := updateDeploymentSpec(ctx, namespace, factory, request, dryRun)
+	if err != nil {
+		wrappedErr := fmt.Errorf("unable update Deployment: %s.%s, error: %s", request.Service, namespace, err.Error())
+		log.Println(wrappedErr)
+		http.Error(w, wrappedErr.Error(), status)
+		return
+	}
+
+	service, status, err := updateService(ctx, namespace, factory, request, dryRun)
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			service, err = createMissingService(ctx, namespace, factory, request, dryRun)
+			status, _ = ProcessErrorReasons(err)
+		}
+
+		if err != nil {
+			wrappedErr := fmt.Errorf("unable update Service: %s.%s, error: %s", request.Service, namespace, err.Error())
+			log.Println(wrappedErr)
+			http.Error(w, wrappedErr.Error(), status)
+			return
+		}
+	}
+
+	if err := syncHPA(ctx, factory, namespace, request, deployment, dryRun); err != nil {
+		wrappedErr := fmt.Errorf("unable update HPA: %s.%s, error: %s", request.Service, namespace, err.Error())
+		log.Println(wrappedErr)
+
+		status, _ := ProcessErrorReasons(err)
+		http.Error(w, wrappedErr.Error(), status)
+		return
+	}
+
+	if dryRun {
+		writeDryRun(w, r, deployment, service)
+		return
+	}
+
+	log.Printf("Function updated: %s.%s\n", request.Service, namespace)
+
+	if wait > 0 {
+		waitForRollout(w, r, rollouts, namespace, request.Service, deployment.Generation, wait, http.StatusOK)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func createMissingService(ctx context.Context, namespace string, factory k8s.FunctionFactory, request types.FunctionDeployment, dryRun bool) (*corev1.Service, error) {
+	service, err := makeServiceSpec(request, factory)
+	if err != nil {
+		return nil, err
+	}
+
+	return createOrAdoptService(ctx, factory, namespace, service, dryRun)
+}
+
+// createOrAdoptService creates the Service of a function. A Service which already
+// exists for the function, i.e. one left behind by a failed delete, is updated
+// instead, but a Service which belongs to something else is never overwritten.
+func createOrAdoptService(ctx context.Context, factory k8s.FunctionFactory, namespace string, service *corev1.Service, dryRun bool) (*corev1.Service, error) {
+	services := factory.Client.CoreV1().Services(namespace)
+
+	created, err := services.Create(ctx, service, metav1.CreateOptions{FieldManager: k8s.FieldManager, DryRun: k8s.DryRunOptions(dryRun)})
+	if err == nil {
+		if !dryRun {
+			log.Printf("Service created: %s.%s\n", service.Name, namespace)
+		}
+		return created, nil
+	}
+
+	if !k8serrors.IsAlreadyExists(err) {
+		return nil, err
+	}
+
+	existing, getErr := services.Get(ctx, service.Name, metav1.GetOptions{})
+	if getErr != nil {
+		return nil, getErr
+	}
+
+	if !isFunctionService(existing, service.Name) {
+		return nil, err
+	}
+
+	adopted, err := k8s.ApplyService(ctx, factory.Client, namespace, service, dryRun)
+	if err != nil {
+		return nil, err
+	}
+
+	if !dryRun {
+		log.Printf("Service adopted: %s.%s\n", service.Name, namespace)
+	}
+	return adopted, nil
+}
+
+// isFunctionService returns true when service selects the pods of the function
+func isFunctionService(service *corev1.Service, functionName string) bool {
+	return service.Labels["faas_function"] == functionName ||
+		service.Spec.Selector["faas_function"] == functionName
+}
+
+// deleteFunctionDeployment rolls back a Deployment created by the deploy handler. The
+// request's context is not used so that a cancelled request still rolls back, and
+// the Deployment is removed straight away so that a retry is not blocked by it.
+func deleteFunctionDeployment(factory k8s.FunctionFactory, namespace, name string) error {
+	background := metav1.DeletePropagationBackground
+
+	err := factory.Client.AppsV1().Deployments(namespace).
+		Delete(context.Background(), name, metav1.DeleteOptions{PropagationPolicy: &background})
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return err
+	}
+
+	log.Printf("Deployment rolled back: %s.%s\n", name, namespace)
+	return nil
+}
+
+// deleteFunctionService rolls back a Service created by the deploy handler, in the same
+// way as deleteFunctionDeployment.
+func deleteFunctionService(factory k8s.FunctionFactory, namespace, name string) error {
+	err := factory.Client.CoreV1().Services(namespace).
+		Delete(context.Background(), name, metav1.DeleteOptions{})
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return err
+	}
+
+	log.Printf("Service rolled back: %s.%s\n", name, namespace)
+	return nil
+}
+
+// MakeFunctionSpecs validates a function and builds the Deployment and Service that
+// the deploy handler would create for it. It is used by the operator to reconcile
+// Function objects with the same logic as the REST API.
+func MakeFunctionSpecs(request types.FunctionDeployment, factory k8s.FunctionFactory) (*appsv1.Deployment, *corev1.Service, error) {
+	if err := ValidateDeployRequest(&request, factory.Config); err != nil {
+		return nil, nil, fmt.Errorf("validation failed: %s", err.Error())
+	}
+
+	secrets := k8s.NewSecretsClient(factory.Client)
+	existingSecrets, err := secrets.GetSecrets(request.Namespace, request.Secrets)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to fetch secrets: %s", err.Error())
+	}
+
+	deploymentSpec, err := makeDeploymentSpec(request, existingSecrets, factory)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed create Deployment spec: %s", err.Error())
+	}
+
+	serviceSpec, err := makeServiceSpec(request, factory)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed create Service spec: %s", err.Error())
+	}
+
+	return deploymentSpec, serviceSpec, nil
+}
+
 func makeDeploymentSpec(request types.FunctionDeployment, existingSecrets map[string]*corev1.Secret, factory k8s.FunctionFactory) (*appsv1.Deployment, error) {
 	envVars := buildEnvVars(&request)
 
@@ -164,6 +410,11 @@ func makeDeploymentSpec(request types.FunctionDeployment, existingSecrets map[st
 		return nil, err
 	}
 
+	port, err := factory.FunctionPort(request)
+	if err != nil {
+		return nil, err
+	}
+
 	deploymentSpec := &appsv1.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:        request.Service,
@@ -178,20 +429,8 @@ func makeDeploymentSpec(request types.FunctionDeployment, existingSecrets map[st
 					"faas_function": request.Service,
 				},
 			},
-			Replicas: initialReplicas,
-			Strategy: appsv1.DeploymentStrategy{
-				Type: appsv1.RollingUpdateDeploymentStrategyType,
-				RollingUpdate: &appsv1.RollingUpdateDeployment{
-					MaxUnavailable: &intstr.IntOrString{
-						Type:   intstr.Int,
-						IntVal: int32(0),
-					},
-					MaxSurge: &intstr.IntOrString{
-						Type:   intstr.Int,
-						IntVal: int32(1),
-					},
-				},
-			},
+			Replicas:             initialReplicas,
+			Strategy:             defaultDeploymentStrategy(),
 			RevisionHistoryLimit: int32p(10),
 			Template: corev1.PodTemplateSpec{
 				ObjectMeta: metav1.ObjectMeta{
@@ -200,18 +439,11 @@ func makeDeploymentSpec(request types.FunctionDeployment, existingSecrets map[st
 					Annotations: annotations,
 				},
 				Spec: corev1.PodSpec{
-					NodeSelector: map[string]string{},
 					Containers: []corev1.Container{
 						{
-							Name:  request.Service,
-							Image: request.Image,
-							Ports: []corev1.ContainerPort{
-								{
-									Name:          "http",
-									ContainerPort: factory.Config.RuntimeHTTPPort,
-									Protocol:      corev1.ProtocolTCP,
-								},
-							},
+							Name:            request.Service,
+							Image:           request.Image,
+							Ports:           makeContainerPorts(port),
 							Env:             envVars,
 							Resources:       *resources,
 							ImagePullPolicy: corev1.PullAlways,
@@ -236,15 +468,51 @@ func makeDeploymentSpec(request types.FunctionDeployment, existingSecrets map[st
 		return nil, err
 	}
 
+	if err := factory.ConfigureConstraints(request, deploymentSpec); err != nil {
+		return nil, err
+	}
+
+	profiles, err := factory.GetProfiles(factory.Config.ProfilesNamespace, annotations)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := factory.ApplyProfiles(profiles, deploymentSpec); err != nil {
+		return nil, err
+	}
+
 	return deploymentSpec, nil
 }
 
+// defaultDeploymentStrategy rolls out one new replica at a time, without
+// reducing the number of available replicas
+func defaultDeploymentStrategy() appsv1.DeploymentStrategy {
+	return appsv1.DeploymentStrategy{
+		Type: appsv1.RollingUpdateDeploymentStrategyType,
+		RollingUpdate: &appsv1.RollingUpdateDeployment{
+			MaxUnavailable: &intstr.IntOrString{
+				Type:   intstr.Int,
+				IntVal: int32(0),
+			},
+			MaxSurge: &intstr.IntOrString{
+				Type:   intstr.Int,
+				IntVal: int32(1),
+			},
+		},
+	}
+}
+
 func makeServiceSpec(request types.FunctionDeployment, factory k8s.FunctionFactory) (*corev1.Service, error) {
 	annotations, err := buildAnnotations(request)
 	if err != nil {
 		return nil, err
 	}
 
+	port, err := factory.FunctionPort(request)
+	if err != nil {
+		return nil, err
+	}
+
 	serviceSpec := &corev1.Service{
 		TypeMeta: metav1.TypeMeta{
 			Kind:       "Service",
@@ -253,29 +521,46 @@ func makeServiceSpec(request types.FunctionDeployment, factory k8s.FunctionFacto
 		ObjectMeta: metav1.ObjectMeta{
 			Name:        request.Service,
 			Annotations: annotations,
+			Labels: map[string]string{
+				"faas_function": request.Service,
+			},
 		},
 		Spec: corev1.ServiceSpec{
 			Type: corev1.ServiceTypeClusterIP,
 			Selector: map[string]string{
 				"faas_function": request.Service,
 			},
-			Ports: []corev1.ServicePort{
-				{
-					Name:     "http",
-					Protocol: corev1.ProtocolTCP,
-					Port:     factory.Config.RuntimeHTTPPort,
-					TargetPort: intstr.IntOrString{
-						Type:   intstr.Int,
-						IntVal: factory.Config.RuntimeHTTPPort,
-					},
-				},
-			},
+			Ports: makeServicePorts(port),
 		},
 	}
 
 	return serviceSpec, nil
 }
 
+func makeContainerPorts(port int32) []corev1.ContainerPort {
+	return []corev1.ContainerPort{
+		{
+			Name:          "http",
+			ContainerPort: port,
+			Protocol:      corev1.ProtocolTCP,
+		},
+	}
+}
+
+func makeServicePorts(port int32) []corev1.ServicePort {
+	return []corev1.ServicePort{
+		{
+			Name:     "http",
+			Protocol: corev1.ProtocolTCP,
+			Port:     port,
+			TargetPort: intstr.IntOrString{
+				Type:   intstr.Int,
+				IntVal: port,
+			},
+		},
+	}
+}
+
 func buildAnnotations(request types.FunctionDeployment) (map[string]string, error) {
 	var annotations map[string]string
 	if request.Annotations != nil {
@@ -4,11 +4,22 @@
 package handlers
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/openfaas/faas-netes/pkg/k8s"
 	types "github.com/openfaas/faas-provider/types"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
 
 	apiv1 "k8s.io/api/core/v1"
 )
@@ -129,11 +140,9 @@ func Test_SetNonRootUser(t *testing.T) {
 	for _, s := range scenarios {
 		t.Run(s.name, func(t *testing.T) {
 			request := types.FunctionDeployment{Service: "testfunc", Image: "alpine:latest"}
-			factory := k8s.NewFunctionFactory(fake.NewSimpleClientset(), k8s.DeploymentConfig{
-				LivenessProbe:  &k8s.ProbeConfig{},
-				ReadinessProbe: &k8s.ProbeConfig{},
-				SetNonRootUser: s.setNonRoot,
-			}, nil)
+			factory := newTestFactory(fake.NewSimpleClientset())
+			factory.Config.SetNonRootUser = s.setNonRoot
+
 			deployment, err := makeDeploymentSpec(request, map[string]*apiv1.Secret{}, factory)
 			if err != nil {
 				t.Errorf("unexpected makeDeploymentSpec error: %s", err.Error())
@@ -231,3 +240,196 @@ func Test_buildEnvVars_FourSortedKeys(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func Test_FunctionPort_FlowsToSpecs(t *testing.T) {
+	scenarios := []struct {
+		name        string
+		annotations *map[string]string
+		want        int32
+	}{
+		{"uses the global port", nil, 8080},
+		{"uses the port annotation", &map[string]string{k8s.PortAnnotation: "3000"}, 3000},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			request := types.FunctionDeployment{Service: "testfunc", Image: "alpine:latest", Annotations: s.annotations}
+			factory := newTestFactory(fake.NewSimpleClientset())
+			factory.Config.HTTPProbe = true
+
+			deployment, err := makeDeploymentSpec(request, map[string]*apiv1.Secret{}, factory)
+			if err != nil {
+				t.Fatalf("unexpected makeDeploymentSpec error: %s", err.Error())
+			}
+
+			container := deployment.Spec.Template.Spec.Containers[0]
+			if got := container.Ports[0].ContainerPort; got != s.want {
+				t.Errorf("want container port: %d, got: %d", s.want, got)
+			}
+			if got := container.ReadinessProbe.HTTPGet.Port.IntVal; got != s.want {
+				t.Errorf("want readiness probe port: %d, got: %d", s.want, got)
+			}
+
+			service, err := makeServiceSpec(request, factory)
+			if err != nil {
+				t.Fatalf("unexpected makeServiceSpec error: %s", err.Error())
+			}
+			if got := service.Spec.Ports[0].Port; got != s.want {
+				t.Errorf("want service port: %d, got: %d", s.want, got)
+			}
+			if got := service.Spec.Ports[0].TargetPort.IntVal; got != s.want {
+				t.Errorf("want service target port: %d, got: %d", s.want, got)
+			}
+		})
+	}
+}
+
+func deploy(handler http.HandlerFunc, request types.FunctionDeployment) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(request)
+
+	rr := httptest.NewRecorder()
+	handler(rr, httptest.NewRequest(http.MethodPost, "/system/functions", strings.NewReader(string(body))))
+	return rr
+}
+
+func Test_MakeDeployHandler_RollsBackWhenServiceFails(t *testing.T) {
+	failServices := true
+
+	f := newTestFixture()
+	f.kube.PrependReactor("create", "services", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if !failServices {
+			return false, nil, nil
+		}
+		return true, nil, fmt.Errorf("etcdserver: request timed out")
+	})
+
+	handler := f.deployHandler(nil)
+
+	rr := deploy(handler, types.FunctionDeployment{Service: "figlet", Image: "localhost:5000/figlet:1.0"})
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("want: %d, got: %d, body: %s", http.StatusInternalServerError, rr.Code, rr.Body.String())
+	}
+
+	deployments, _ := f.kube.AppsV1().Deployments("openfaas-fn").List(context.Background(), metav1.ListOptions{})
+	if len(deployments.Items) != 0 {
+		t.Errorf("want no orphaned Deployments, got: %d", len(deployments.Items))
+	}
+
+	// A retry succeeds once the Service can be created
+	failServices = false
+	if rr := deploy(handler, types.FunctionDeployment{Service: "figlet", Image: "localhost:5000/figlet:1.0"}); rr.Code != http.StatusAccepted {
+		t.Errorf("want: %d, got: %d, body: %s", http.StatusAccepted, rr.Code, rr.Body.String())
+	}
+}
+
+func Test_MakeDeployHandler_ReportsFailedRollback(t *testing.T) {
+	f := newTestFixture()
+	f.kube.PrependReactor("create", "services", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, fmt.Errorf("etcdserver: request timed out")
+	})
+	f.kube.PrependReactor("delete", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, fmt.Errorf("connection refused")
+	})
+
+	rr := deploy(f.deployHandler(nil), types.FunctionDeployment{Service: "figlet", Image: "localhost:5000/figlet:1.0"})
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("want: %d, got: %d", http.StatusInternalServerError, rr.Code)
+	}
+
+	if !strings.Contains(rr.Body.String(), "unable to roll back Deployment") {
+		t.Errorf("want the failed roll back to be reported, got: %s", rr.Body.String())
+	}
+}
+
+func Test_MakeDeployHandler_ExistingService(t *testing.T) {
+	cases := []struct {
+		name           string
+		selector       map[string]string
+		wantStatus     int
+		wantDeployment bool
+		wantPorts      int
+	}{
+		{name: "adopts the Service of the function", selector: map[string]string{"faas_function": "figlet"}, wantStatus: http.StatusAccepted, wantDeployment: true, wantPorts: 1},
+		{name: "keeps other Services", selector: map[string]string{"app": "figlet-web"}, wantStatus: http.StatusConflict},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newTestFixture(&apiv1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: "figlet", Namespace: "openfaas-fn"},
+				Spec:       apiv1.ServiceSpec{Selector: tc.selector},
+			})
+
+			rr := deploy(f.deployHandler(nil), types.FunctionDeployment{Service: "figlet", Image: "localhost:5000/figlet:1.0"})
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("want: %d, got: %d, body: %s", tc.wantStatus, rr.Code, rr.Body.String())
+			}
+
+			_, err := f.kube.AppsV1().Deployments("openfaas-fn").Get(context.Background(), "figlet", metav1.GetOptions{})
+			if got := err == nil; got != tc.wantDeployment {
+				t.Errorf("want a Deployment: %v, got: %v", tc.wantDeployment, err)
+			}
+
+			service, _ := f.kube.CoreV1().Services("openfaas-fn").Get(context.Background(), "figlet", metav1.GetOptions{})
+			if len(service.Spec.Ports) != tc.wantPorts || !reflect.DeepEqual(service.Spec.Selector, tc.selector) {
+				t.Errorf("want %d ports and selector: %v, got: %v and %v", tc.wantPorts, tc.selector, service.Spec.Ports, service.Spec.Selector)
+			}
+		})
+	}
+}
+
+func Test_MakeDeployHandler_ExistingFunction(t *testing.T) {
+	cases := []struct {
+		name          string
+		query         string
+		deleteService bool
+		wantStatus    int
+		wantImage     string
+	}{
+		{name: "upsert", wantStatus: http.StatusOK, wantImage: "localhost:5000/figlet:2.0"},
+		{name: "upsert creates the missing Service", deleteService: true, wantStatus: http.StatusOK, wantImage: "localhost:5000/figlet:2.0"},
+		{name: "strict create", query: "?upsert=false", wantStatus: http.StatusConflict, wantImage: "localhost:5000/figlet:1.0"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newTestFixture()
+			handler := f.deployHandler(nil)
+
+			request := types.FunctionDeployment{Service: "figlet", Image: "localhost:5000/figlet:1.0"}
+			if rr := deploy(handler, request); rr.Code != http.StatusAccepted {
+				t.Fatalf("want create: %d, got: %d, body: %s", http.StatusAccepted, rr.Code, rr.Body.String())
+			}
+
+			if tc.deleteService {
+				f.kube.CoreV1().Services("openfaas-fn").Delete(context.Background(), "figlet", metav1.DeleteOptions{})
+			}
+
+			request.Image = "localhost:5000/figlet:2.0"
+			body, _ := json.Marshal(request)
+			rr := httptest.NewRecorder()
+			handler(rr, httptest.NewRequest(http.MethodPost, "/system/functions"+tc.query, strings.NewReader(string(body))))
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("want: %d, got: %d, body: %s", tc.wantStatus, rr.Code, rr.Body.String())
+			}
+
+			if image := f.deployment(t, "figlet").Spec.Template.Spec.Containers[0].Image; image != tc.wantImage {
+				t.Errorf("want: %s, got: %s", tc.wantImage, image)
+			}
+			if _, err := f.kube.CoreV1().Services("openfaas-fn").Get(context.Background(), "figlet", metav1.GetOptions{}); err != nil {
+				t.Errorf("want the Service to exist, got: %s", err)
+			}
+		})
+	}
+}
+
+func Test_MakeDeployHandler_DoesNotUpsertOtherDeployments(t *testing.T) {
+	f := newTestFixture(&appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "figlet", Namespace: "openfaas-fn", Labels: map[string]string{"app": "figlet-web"}},
+	})
+
+	rr := deploy(f.deployHandler(nil), types.FunctionDeployment{Service: "figlet", Image: "localhost:5000/figlet:1.0"})
+	if rr.Code != http.StatusConflict {
+		t.Errorf("want: %d, got: %d, body: %s", http.StatusConflict, rr.Code, rr.Body.String())
+	}
+}
@@ -0,0 +1,72 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/yaml"
+)
+
+// dryRunRequested parses the dryRun query parameter, which accepts "true" or "All"
+// in the same way as the Kubernetes API
+func dryRunRequested(r *http.Request) (bool, error) {
+	switch v := r.URL.Query().Get("dryRun"); v {
+	case "", "false":
+		return false, nil
+	case "true", metav1.DryRunAll:
+		return true, nil
+	default:
+		return false, fmt.Errorf("dryRun must be one of: true, All or false, got: %q", v)
+	}
+}
+
+// writeDryRun writes the objects rendered by a server-side dry-run as a v1 List, in
+// YAML when requested by the Accept header or output=yaml, otherwise in JSON
+func writeDryRun(w http.ResponseWriter, r *http.Request, deployment *appsv1.Deployment, service *corev1.Service) {
+	deployment = deployment.DeepCopy()
+	deployment.TypeMeta = metav1.TypeMeta{Kind: "Deployment", APIVersion: "apps/v1"}
+	deployment.ManagedFields = nil
+
+	service = service.DeepCopy()
+	service.TypeMeta = metav1.TypeMeta{Kind: "Service", APIVersion: "v1"}
+	service.ManagedFields = nil
+
+	list := corev1.List{
+		TypeMeta: metav1.TypeMeta{Kind: "List", APIVersion: "v1"},
+		Items: []runtime.RawExtension{
+			{Object: deployment},
+			{Object: service},
+		},
+	}
+
+	out, err := json.Marshal(list)
+	if err != nil {
+		log.Printf("Unable to marshal dry-run result: %s", err.Error())
+		http.Error(w, "unable to marshal dry-run result", http.StatusInternalServerError)
+		return
+	}
+
+	contentType := "application/json"
+	if r.URL.Query().Get("output") == "yaml" || strings.Contains(r.Header.Get("Accept"), "yaml") {
+		if out, err = yaml.JSONToYAML(out); err != nil {
+			log.Printf("Unable to marshal dry-run result: %s", err.Error())
+			http.Error(w, "unable to marshal dry-run result", http.StatusInternalServerError)
+			return
+		}
+		contentType = "application/yaml"
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(out)
+}
@@ -0,0 +1,231 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	types "github.com/openfaas/faas-provider/types"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	appsv1apply "k8s.io/client-go/applyconfigurations/apps/v1"
+	corev1apply "k8s.io/client-go/applyconfigurations/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"sigs.k8s.io/yaml"
+)
+
+// withServerDryRun makes kube answer requests which set DryRun like the API server,
+// the result is computed on a copy of the object and is never persisted
+func withServerDryRun(kube *fake.Clientset) {
+	kube.PrependReactor("*", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		shadow := fake.NewClientset()
+
+		switch a := action.(type) {
+		case k8stesting.CreateActionImpl:
+			if len(a.CreateOptions.DryRun) == 0 {
+				return false, nil, nil
+			}
+		case k8stesting.PatchActionImpl:
+			if len(a.PatchOptions.DryRun) == 0 {
+				return false, nil, nil
+			}
+			if existing, err := kube.Tracker().Get(a.GetResource(), a.GetNamespace(), a.GetName()); err == nil {
+				shadow.Tracker().Add(existing)
+			}
+		default:
+			return false, nil, nil
+		}
+
+		obj, err := shadow.Invokes(action, nil)
+		return true, obj, err
+	})
+}
+
+type dryRunList struct {
+	Kind  string            `json:"kind"`
+	Items []json.RawMessage `json:"items"`
+}
+
+func readDryRun(t *testing.T, body []byte) (*appsv1.Deployment, *corev1.Service) {
+	t.Helper()
+
+	list := dryRunList{}
+	if err := json.Unmarshal(body, &list); err != nil {
+		t.Fatalf("unexpected error: %s, body: %s", err, string(body))
+	}
+	if list.Kind != "List" || len(list.Items) != 2 {
+		t.Fatalf("want a List with 2 items, got: %s", string(body))
+	}
+
+	deployment := &appsv1.Deployment{}
+	service := &corev1.Service{}
+	if err := json.Unmarshal(list.Items[0], deployment); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := json.Unmarshal(list.Items[1], service); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	return deployment, service
+}
+
+func Test_dryRunRequested(t *testing.T) {
+	cases := []struct {
+		query   string
+		want    bool
+		wantErr bool
+	}{
+		{query: "", want: false},
+		{query: "dryRun=false", want: false},
+		{query: "dryRun=true", want: true},
+		{query: "dryRun=All", want: true},
+		{query: "dryRun=all", wantErr: true},
+		{query: "dryRun=yes", wantErr: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.query, func(t *testing.T) {
+			got, err := dryRunRequested(httptest.NewRequest(http.MethodPost, "/system/functions?"+tc.query, nil))
+			if tc.wantErr != (err != nil) {
+				t.Fatalf("want error: %v, got: %v", tc.wantErr, err)
+			}
+			if got != tc.want {
+				t.Errorf("want: %v, got: %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func Test_MakeDeployHandler_DryRun(t *testing.T) {
+	cases := []struct {
+		name            string
+		query           string
+		accept          string
+		wantStatus      int
+		wantContentType string
+	}{
+		{name: "json", query: "dryRun=true", wantStatus: http.StatusOK, wantContentType: "application/json"},
+		{name: "yaml", query: "dryRun=All", accept: "application/yaml", wantStatus: http.StatusOK, wantContentType: "application/yaml"},
+		{name: "invalid", query: "dryRun=maybe", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newTestFixture()
+			withServerDryRun(f.kube)
+
+			body, _ := json.Marshal(types.FunctionDeployment{Service: "figlet", Image: "localhost:5000/figlet:1.0"})
+			req := httptest.NewRequest(http.MethodPost, "/system/functions?"+tc.query, strings.NewReader(string(body)))
+			if len(tc.accept) > 0 {
+				req.Header.Set("Accept", tc.accept)
+			}
+
+			rr := httptest.NewRecorder()
+			f.deployHandler(nil)(rr, req)
+
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("want: %d, got: %d, body: %s", tc.wantStatus, rr.Code, rr.Body.String())
+			}
+
+			deployments, _ := f.kube.AppsV1().Deployments("openfaas-fn").List(context.Background(), metav1.ListOptions{})
+			services, _ := f.kube.CoreV1().Services("openfaas-fn").List(context.Background(), metav1.ListOptions{})
+			if len(deployments.Items) != 0 || len(services.Items) != 0 {
+				t.Errorf("want nothing to be created, got: %d Deployments and %d Services", len(deployments.Items), len(services.Items))
+			}
+
+			if tc.wantStatus != http.StatusOK {
+				return
+			}
+			if got := rr.Header().Get("Content-Type"); got != tc.wantContentType {
+				t.Errorf("want: %s, got: %s", tc.wantContentType, got)
+			}
+
+			out := rr.Body.Bytes()
+			if tc.wantContentType == "application/yaml" {
+				var err error
+				if out, err = yaml.YAMLToJSON(out); err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+			}
+
+			deployment, service := readDryRun(t, out)
+			if deployment.Kind != "Deployment" || deployment.Name != "figlet" {
+				t.Errorf("want the figlet Deployment, got: %s/%s", deployment.Kind, deployment.Name)
+			}
+			if image := deployment.Spec.Template.Spec.Containers[0].Image; image != "localhost:5000/figlet:1.0" {
+				t.Errorf("want: %s, got: %s", "localhost:5000/figlet:1.0", image)
+			}
+			if service.Kind != "Service" || service.Spec.Selector["faas_function"] != "figlet" {
+				t.Errorf("want the figlet Service, got: %s with selector: %v", service.Kind, service.Spec.Selector)
+			}
+		})
+	}
+}
+
+func Test_MakeUpdateHandler_DryRun(t *testing.T) {
+	f := newTestFixture()
+	f.createFunction(t, figletFunction("1.0"))
+	handler := f.updateHandler(t)
+	withServerDryRun(f.kube)
+	f.kube.ClearActions()
+
+	body, _ := json.Marshal(types.FunctionDeployment{Service: "figlet", Image: "localhost:5000/figlet:2.0", Namespace: "openfaas-fn"})
+	rr := httptest.NewRecorder()
+	handler(rr, httptest.NewRequest(http.MethodPut, "/system/functions?dryRun=true", strings.NewReader(string(body))))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want: %d, got: %d, body: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	deployment, _ := readDryRun(t, rr.Body.Bytes())
+	if image := deployment.Spec.Template.Spec.Containers[0].Image; image != "localhost:5000/figlet:2.0" {
+		t.Errorf("want the rendered image: %s, got: %s", "localhost:5000/figlet:2.0", image)
+	}
+
+	got, _ := f.kube.AppsV1().Deployments("openfaas-fn").Get(context.Background(), "figlet", metav1.GetOptions{})
+	if image := got.Spec.Template.Spec.Containers[0].Image; image != "localhost:5000/figlet:1.0" {
+		t.Errorf("want the Deployment to be unchanged: %s, got: %s", "localhost:5000/figlet:1.0", image)
+	}
+
+	// The managed fields are only upgraded by an update which is persisted
+	for _, action := range f.kube.Actions() {
+		if patch, ok := action.(k8stesting.PatchActionImpl); ok && len(patch.PatchOptions.DryRun) == 0 {
+			t.Errorf("want no patch for a dry-run, got: %s of %s", patch.GetPatchType(), patch.GetResource().Resource)
+		}
+	}
+}
+
+func Test_MakeUpdateHandler_DryRunReportsConflict(t *testing.T) {
+	f := newTestFixture()
+	f.createFunction(t, figletFunction("1.0"))
+	handler := f.updateHandler(t)
+	withServerDryRun(f.kube)
+
+	// The managed fields of faas-netes still need to be upgraded, and another
+	// field manager owns the image
+	pinned := appsv1apply.Deployment("figlet", "openfaas-fn").
+		WithSpec(appsv1apply.DeploymentSpec().
+			WithTemplate(corev1apply.PodTemplateSpec().
+				WithSpec(corev1apply.PodSpec().
+					WithContainers(corev1apply.Container().WithName("figlet").WithImage("localhost:5000/figlet:pinned")))))
+	if _, err := f.kube.AppsV1().Deployments("openfaas-fn").Apply(context.Background(), pinned, metav1.ApplyOptions{FieldManager: "gitops", Force: true}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	body, _ := json.Marshal(types.FunctionDeployment{Service: "figlet", Image: "localhost:5000/figlet:2.0", Namespace: "openfaas-fn"})
+	rr := httptest.NewRecorder()
+	handler(rr, httptest.NewRequest(http.MethodPut, "/system/functions?dryRun=true", strings.NewReader(string(body))))
+
+	if rr.Code != http.StatusConflict {
+		t.Errorf("want: %d, got: %d, body: %s", http.StatusConflict, rr.Code, rr.Body.String())
+	}
+}
@@ -0,0 +1,175 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/openfaas/faas-netes/pkg/k8s"
+	types "github.com/openfaas/faas-provider/types"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubeinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	v1 "k8s.io/client-go/listers/apps/v1"
+)
+
+// testFixture is a fake cluster with the factory and namespaces that the handlers
+// under test are made with. The Config of the factory can be changed before the
+// handlers are made.
+type testFixture struct {
+	kube       *fake.Clientset
+	factory    k8s.FunctionFactory
+	namespaces *k8s.Namespaces
+}
+
+// newTestFixture returns a fake cluster which holds objects, and manages the
+// functions of the openfaas-fn namespace
+func newTestFixture(objects ...runtime.Object) *testFixture {
+	kube := fake.NewClientset(objects...)
+
+	return &testFixture{
+		kube:       kube,
+		factory:    newTestFactory(kube),
+		namespaces: k8s.NewNamespaces("openfaas-fn", nil),
+	}
+}
+
+// newTestFactory returns a FunctionFactory for functions served on port 8080
+func newTestFactory(kube kubernetes.Interface) k8s.FunctionFactory {
+	return k8s.NewFunctionFactory(kube, k8s.DeploymentConfig{
+		RuntimeHTTPPort: 8080,
+		LivenessProbe:   &k8s.ProbeConfig{},
+		ReadinessProbe:  &k8s.ProbeConfig{},
+	}, nil)
+}
+
+// figletFunction returns a request for the figlet function in openfaas-fn
+func figletFunction(version string) types.FunctionDeployment {
+	return types.FunctionDeployment{
+		Service:   "figlet",
+		Image:     "localhost:5000/figlet:" + version,
+		Namespace: "openfaas-fn",
+	}
+}
+
+// createFunction creates the Deployment and Service of request with the field
+// manager of faas-netes, as the deploy handler would. The Deployment is passed
+// to each of modify before it is created.
+func (f *testFixture) createFunction(t *testing.T, request types.FunctionDeployment, modify ...func(*appsv1.Deployment)) {
+	t.Helper()
+
+	deployment, service, err := MakeFunctionSpecs(request, f.factory)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	for _, m := range modify {
+		m(deployment)
+	}
+
+	ctx := context.Background()
+	if _, err := f.kube.AppsV1().Deployments(request.Namespace).Create(ctx, deployment, metav1.CreateOptions{FieldManager: k8s.FieldManager}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := f.kube.CoreV1().Services(request.Namespace).Create(ctx, service, metav1.CreateOptions{FieldManager: k8s.FieldManager}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+// deployment returns the Deployment called name in openfaas-fn
+func (f *testFixture) deployment(t *testing.T, name string) *appsv1.Deployment {
+	t.Helper()
+
+	deployment, err := f.kube.AppsV1().Deployments("openfaas-fn").Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return deployment
+}
+
+// rolloutWatcher returns a RolloutWatcher whose informer runs until the end of
+// the test
+func (f *testFixture) rolloutWatcher(t *testing.T) *k8s.RolloutWatcher {
+	t.Helper()
+
+	factory := kubeinformers.NewSharedInformerFactory(f.kube, 0)
+	deployments := factory.Apps().V1().Deployments()
+	deployments.Informer()
+
+	stopCh := make(chan struct{})
+	t.Cleanup(func() { close(stopCh) })
+
+	factory.Start(stopCh)
+	factory.WaitForCacheSync(stopCh)
+
+	rollouts := k8s.NewRolloutWatcher(f.kube, deployments)
+	rollouts.PollInterval = time.Millisecond * 10
+	return rollouts
+}
+
+func (f *testFixture) deployHandler(rollouts *k8s.RolloutWatcher) http.HandlerFunc {
+	informerFactory := kubeinformers.NewSharedInformerFactory(f.kube, 0)
+	functionList := k8s.NewFunctionList(f.namespaces, informerFactory.Apps().V1().Deployments().Lister())
+
+	return MakeDeployHandler(f.namespaces, f.factory, functionList, rollouts)
+}
+
+func (f *testFixture) updateHandler(t *testing.T) http.HandlerFunc {
+	return MakeUpdateHandler(f.namespaces, f.factory, f.rolloutWatcher(t))
+}
+
+// newTestDeploymentLister returns a lister of the Deployments of the functions
+// called names in openfaas-fn
+func newTestDeploymentLister(t *testing.T, names ...string) v1.DeploymentLister {
+	t.Helper()
+
+	factory := kubeinformers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+	informer := factory.Apps().V1().Deployments()
+
+	for _, name := range names {
+		err := informer.Informer().GetIndexer().Add(&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "openfaas-fn",
+				Labels:    map[string]string{"faas_function": name},
+			},
+			Spec: appsv1.DeploymentSpec{
+				Template: corev1.PodTemplateSpec{
+					Spec: corev1.PodSpec{
+						Containers: []corev1.Container{{Name: name, Image: "ghcr.io/openfaas/" + name}},
+					},
+				},
+			},
+		})
+		if err != nil {
+			t.Fatalf("unable to add deployment: %s", err)
+		}
+	}
+
+	return informer.Lister()
+}
+
+// newTestNamespaces returns the managed namespaces, which are openfaas-fn and
+// the namespaces called names, annotated with "openfaas"
+func newTestNamespaces(names ...string) *k8s.Namespaces {
+	factory := kubeinformers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+	informer := factory.Core().V1().Namespaces()
+	for _, name := range names {
+		informer.Informer().GetIndexer().Add(&corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Annotations: map[string]string{k8s.NamespaceAnnotation: "1"},
+			},
+		})
+	}
+
+	return k8s.NewNamespaces("openfaas-fn", informer.Lister())
+}
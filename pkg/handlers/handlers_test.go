@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"testing"
 
+	"github.com/openfaas/faas-netes/pkg/k8s"
 	types "github.com/openfaas/faas-provider/types"
 )
 
@@ -97,7 +98,7 @@ func Test_ValidateDeployRequest_InvalidRequest(t *testing.T) {
 			Image:   testCase.imageName,
 		}
 
-		err := ValidateDeployRequest(&request)
+		err := ValidateDeployRequest(&request, k8s.DeploymentConfig{})
 		if err == nil {
 			t.Fatalf("Expected error for scenario: %s", testCase.scenario)
 		}
@@ -113,7 +114,7 @@ func Test_ValidateDeploymentRequest_ValidRequest(t *testing.T) {
 		Image:   "test-image",
 	}
 
-	err := ValidateDeployRequest(&request)
+	err := ValidateDeployRequest(&request, k8s.DeploymentConfig{})
 	if err != nil {
 		t.Errorf("unexpected ValidateDeploymentRequest error: %s", err.Error())
 	}
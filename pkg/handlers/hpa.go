@@ -0,0 +1,57 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+package handlers
+
+import (
+	"context"
+	"log"
+
+	"github.com/openfaas/faas-netes/pkg/k8s"
+	types "github.com/openfaas/faas-provider/types"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// syncHPA creates or updates the HorizontalPodAutoscaler of a function from its
+// labels, or deletes it when the function is no longer scaled by one. The HPA is
+// owned by deployment, so that it is also removed when the Deployment is deleted
+// by other means than the API.
+func syncHPA(ctx context.Context, factory k8s.FunctionFactory, namespace string, request types.FunctionDeployment, deployment *appsv1.Deployment, dryRun bool) error {
+	if !factory.Config.HPA {
+		return nil
+	}
+
+	labels := map[string]string{}
+	if request.Labels != nil {
+		labels = *request.Labels
+	}
+
+	if !k8s.HPAEnabled(labels) {
+		deleted, err := k8s.DeleteHPA(ctx, factory.Client, namespace, request.Service, dryRun)
+		if deleted && !dryRun {
+			log.Printf("HPA deleted: %s.%s\n", request.Service, namespace)
+		}
+		return err
+	}
+
+	hpa, err := k8s.MakeHPA(request.Service, labels, MaxReplicas)
+	if err != nil {
+		return err
+	}
+
+	if deployment != nil && len(deployment.UID) > 0 {
+		hpa.OwnerReferences = []metav1.OwnerReference{
+			*metav1.NewControllerRef(deployment, appsv1.SchemeGroupVersion.WithKind("Deployment")),
+		}
+	}
+
+	if _, err := k8s.ApplyHPA(ctx, factory.Client, namespace, hpa, dryRun); err != nil {
+		return err
+	}
+
+	if !dryRun {
+		log.Printf("HPA applied: %s.%s\n", request.Service, namespace)
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/openfaas/faas-netes/pkg/k8s"
+	types "github.com/openfaas/faas-provider/types"
+	appsv1 "k8s.io/api/apps/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func Test_MakeDeployHandler_SyncsHPA(t *testing.T) {
+	f := newTestFixture()
+	f.factory.Config.HPA = true
+	handler := f.deployHandler(nil)
+	ctx := context.Background()
+
+	request := types.FunctionDeployment{
+		Service:  "figlet",
+		Image:    "localhost:5000/figlet:1.0",
+		Labels:   &map[string]string{"com.openfaas.scale.type": "cpu", "com.openfaas.scale.target": "70", "com.openfaas.scale.max": "4"},
+		Requests: &types.FunctionResources{CPU: "100m"},
+	}
+	if rr := deploy(handler, request); rr.Code != http.StatusAccepted {
+		t.Fatalf("want: %d, got: %d, body: %s", http.StatusAccepted, rr.Code, rr.Body.String())
+	}
+
+	hpa, err := f.kube.AutoscalingV2().HorizontalPodAutoscalers("openfaas-fn").Get(ctx, "figlet", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("want an HPA for figlet, got: %s", err)
+	}
+	if hpa.Spec.MaxReplicas != 4 {
+		t.Errorf("want max replicas: %d, got: %d", 4, hpa.Spec.MaxReplicas)
+	}
+	if got := *hpa.Spec.Metrics[0].Resource.Target.AverageUtilization; got != 70 {
+		t.Errorf("want target: %d, got: %d", 70, got)
+	}
+
+	// The HPA is updated with the function
+	(*request.Labels)["com.openfaas.scale.target"] = "40"
+	if rr := deploy(handler, request); rr.Code != http.StatusOK {
+		t.Fatalf("want: %d, got: %d, body: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	hpa, _ = f.kube.AutoscalingV2().HorizontalPodAutoscalers("openfaas-fn").Get(ctx, "figlet", metav1.GetOptions{})
+	if got := *hpa.Spec.Metrics[0].Resource.Target.AverageUtilization; got != 40 {
+		t.Errorf("want target: %d, got: %d", 40, got)
+	}
+
+	// The HPA is removed once the function is no longer scaled by it
+	request.Labels = nil
+	if rr := deploy(handler, request); rr.Code != http.StatusOK {
+		t.Fatalf("want: %d, got: %d, body: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	_, err = f.kube.AutoscalingV2().HorizontalPodAutoscalers("openfaas-fn").Get(ctx, "figlet", metav1.GetOptions{})
+	if !k8serrors.IsNotFound(err) {
+		t.Errorf("want the HPA to be deleted, got: %v", err)
+	}
+}
+
+func Test_MakeUpdateHandler_KeepsReplicasOfHPA(t *testing.T) {
+	f := newTestFixture()
+	f.factory.Config.HPA = true
+	f.createFunction(t, figletFunction("1.0"))
+	handler := f.updateHandler(t)
+	ctx := context.Background()
+
+	request := types.FunctionDeployment{
+		Service:   "figlet",
+		Image:     "localhost:5000/figlet:1.0",
+		Namespace: "openfaas-fn",
+		Labels:    &map[string]string{"com.openfaas.scale.type": "memory", "com.openfaas.scale.min": "3"},
+		Requests:  &types.FunctionResources{Memory: "128Mi"},
+	}
+	if rr := update(handler, request); rr.Code != http.StatusAccepted {
+		t.Fatalf("want: %d, got: %d, body: %s", http.StatusAccepted, rr.Code, rr.Body.String())
+	}
+
+	deployment, _ := f.kube.AppsV1().Deployments("openfaas-fn").Get(ctx, "figlet", metav1.GetOptions{})
+	if got := *deployment.Spec.Replicas; got != 1 {
+		t.Errorf("want the replicas to be left to the HPA at: %d, got: %d", 1, got)
+	}
+
+	hpa, err := f.kube.AutoscalingV2().HorizontalPodAutoscalers("openfaas-fn").Get(ctx, "figlet", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("want an HPA for figlet, got: %s", err)
+	}
+	if *hpa.Spec.MinReplicas != 3 {
+		t.Errorf("want min replicas: %d, got: %d", 3, *hpa.Spec.MinReplicas)
+	}
+}
+
+func Test_MakeUpdateHandler_HPAIsOwnedByDeployment(t *testing.T) {
+	f := newTestFixture()
+	f.factory.Config.HPA = true
+	f.createFunction(t, figletFunction("1.0"), func(d *appsv1.Deployment) {
+		d.UID = "figlet-uid"
+	})
+	handler := f.updateHandler(t)
+
+	request := figletFunction("1.0")
+	request.Labels = &map[string]string{"com.openfaas.scale.type": "cpu"}
+	request.Requests = &types.FunctionResources{CPU: "100m"}
+	if rr := update(handler, request); rr.Code != http.StatusAccepted {
+		t.Fatalf("want: %d, got: %d, body: %s", http.StatusAccepted, rr.Code, rr.Body.String())
+	}
+
+	hpa, err := f.kube.AutoscalingV2().HorizontalPodAutoscalers("openfaas-fn").Get(context.Background(), "figlet", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("want an HPA for figlet, got: %s", err)
+	}
+
+	owner := metav1.GetControllerOf(hpa)
+	if owner == nil || owner.Kind != "Deployment" || owner.Name != "figlet" || owner.UID != "figlet-uid" {
+		t.Errorf("want the HPA to be owned by the figlet Deployment, got: %v", hpa.OwnerReferences)
+	}
+}
+
+func Test_MakeRollbackHandler_SyncsHPA(t *testing.T) {
+	f := newTestFixture()
+	f.factory.Config.HPA = true
+	f.createRevisions(t)
+	ctx := context.Background()
+
+	// The HPA of the current revision, the previous revision is not scaled by an HPA
+	hpa, err := k8s.MakeHPA("figlet", map[string]string{"com.openfaas.scale.type": "cpu"}, MaxReplicas)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := f.kube.AutoscalingV2().HorizontalPodAutoscalers("openfaas-fn").Create(ctx, hpa, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	rr := rollback(MakeRollbackHandler(f.namespaces, f.factory, nil), "")
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("want: %d, got: %d, body: %s", http.StatusAccepted, rr.Code, rr.Body.String())
+	}
+
+	_, err = f.kube.AutoscalingV2().HorizontalPodAutoscalers("openfaas-fn").Get(ctx, "figlet", metav1.GetOptions{})
+	if !k8serrors.IsNotFound(err) {
+		t.Errorf("want the HPA to be deleted with the rollback, got: %v", err)
+	}
+}
+
+func Test_MakeDeployHandler_RollsBackWhenHPAFails(t *testing.T) {
+	f := newTestFixture()
+	f.factory.Config.HPA = true
+	f.kube.PrependReactor("patch", "horizontalpodautoscalers", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, fmt.Errorf("etcdserver: request timed out")
+	})
+
+	request := types.FunctionDeployment{
+		Service:  "figlet",
+		Image:    "localhost:5000/figlet:1.0",
+		Labels:   &map[string]string{"com.openfaas.scale.type": "cpu"},
+		Requests: &types.FunctionResources{CPU: "100m"},
+	}
+	rr := deploy(f.deployHandler(nil), request)
+	if rr.Code != http.StatusInternalServerError {
+		t.Fatalf("want: %d, got: %d, body: %s", http.StatusInternalServerError, rr.Code, rr.Body.String())
+	}
+
+	ctx := context.Background()
+	if _, err := f.kube.AppsV1().Deployments("openfaas-fn").Get(ctx, "figlet", metav1.GetOptions{}); !k8serrors.IsNotFound(err) {
+		t.Errorf("want the Deployment to be rolled back, got: %v", err)
+	}
+	if _, err := f.kube.CoreV1().Services("openfaas-fn").Get(ctx, "figlet", metav1.GetOptions{}); !k8serrors.IsNotFound(err) {
+		t.Errorf("want the Service to be rolled back, got: %v", err)
+	}
+}
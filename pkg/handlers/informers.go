@@ -7,9 +7,9 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/openfaas/faas-netes/pkg/k8s"
 	appsv1 "k8s.io/api/apps/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	v1apps "k8s.io/client-go/informers/apps/v1"
 	"k8s.io/client-go/kubernetes"
@@ -17,14 +17,14 @@ import (
 	"k8s.io/klog"
 )
 
-func RegisterEventHandlers(deploymentInformer v1apps.DeploymentInformer, kubeClient *kubernetes.Clientset, namespace string) {
+func RegisterEventHandlers(deploymentInformer v1apps.DeploymentInformer, kubeClient *kubernetes.Clientset, namespace string, config k8s.DeploymentConfig) {
 	deploymentInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc: func(obj interface{}) {
 			deployment, ok := obj.(*appsv1.Deployment)
 			if !ok || deployment == nil {
 				return
 			}
-			if err := applyValidation(deployment, kubeClient); err != nil {
+			if err := applyValidation(deployment, kubeClient, config); err != nil {
 				klog.Info(err)
 			}
 		},
@@ -33,7 +33,7 @@ func RegisterEventHandlers(deploymentInformer v1apps.DeploymentInformer, kubeCli
 			if !ok || deployment == nil {
 				return
 			}
-			if err := applyValidation(deployment, kubeClient); err != nil {
+			if err := applyValidation(deployment, kubeClient, config); err != nil {
 				klog.Info(err)
 			}
 		},
@@ -46,13 +46,13 @@ func RegisterEventHandlers(deploymentInformer v1apps.DeploymentInformer, kubeCli
 	}
 
 	for _, deployment := range list {
-		if err := applyValidation(deployment, kubeClient); err != nil {
+		if err := applyValidation(deployment, kubeClient, config); err != nil {
 			klog.Info(err)
 		}
 	}
 }
 
-func applyValidation(deployment *appsv1.Deployment, kubeClient *kubernetes.Clientset) error {
+func applyValidation(deployment *appsv1.Deployment, kubeClient *kubernetes.Clientset, config k8s.DeploymentConfig) error {
 	if deployment.Spec.Replicas == nil {
 		return nil
 	}
@@ -61,26 +61,30 @@ func applyValidation(deployment *appsv1.Deployment, kubeClient *kubernetes.Clien
 		return nil
 	}
 
+	// The replicas of a function with an HPA are set by the HPA, within the bounds
+	// it was validated with
+	if config.HPA && k8s.HPAEnabled(deployment.Spec.Template.Labels) {
+		return nil
+	}
+
 	current := *deployment.Spec.Replicas
-	var target int
+	var target int32
 	if current == 0 {
+		if config.ScaleToZero && k8s.ScaleToZeroEnabled(deployment.Spec.Template.Labels) {
+			return nil
+		}
 		target = 1
 	} else if current > MaxReplicas {
 		target = MaxReplicas
 	} else {
 		return nil
 	}
-	clone := deployment.DeepCopy()
-
-	value := int32(target)
-	clone.Spec.Replicas = &value
 
-	if _, err := kubeClient.AppsV1().Deployments(deployment.Namespace).
-		Update(context.Background(), clone, metav1.UpdateOptions{}); err != nil {
+	if err := k8s.ScaleDeployment(context.Background(), kubeClient, deployment.Namespace, deployment.Name, target); err != nil {
 		if errors.IsConflict(err) {
 			return nil
 		}
-		return fmt.Errorf("error scaling %s to %d replicas: %w", deployment.Name, value, err)
+		return fmt.Errorf("error scaling %s to %d replicas: %w", deployment.Name, target, err)
 	}
 
 	return nil
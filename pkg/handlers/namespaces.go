@@ -15,22 +15,31 @@ import (
 	"log"
 	"net/http"
 
+	"github.com/gorilla/mux"
+	"github.com/openfaas/faas-netes/pkg/k8s"
+	types "github.com/openfaas/faas-provider/types"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	klog "k8s.io/klog"
 )
 
 // MakeNamespacesLister builds a list of namespaces with an "openfaas" tag, or the default name
-func MakeNamespacesLister(defaultNamespace string, clientset kubernetes.Interface) http.HandlerFunc {
+func MakeNamespacesLister(namespaces *k8s.Namespaces) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		if r.Body != nil {
 			defer r.Body.Close()
 		}
 
-		namespaces := []string{defaultNamespace}
+		list, err := namespaces.List()
+		if err != nil {
+			klog.Errorf("Failed to list namespaces: %s", err.Error())
+			http.Error(w, "Failed to list namespaces", http.StatusInternalServerError)
+			return
+		}
 
-		out, err := json.Marshal(namespaces)
+		out, err := json.Marshal(list)
 		if err != nil {
 			klog.Errorf("Failed to list namespaces: %s", err.Error())
 			http.Error(w, "Failed to list namespaces", http.StatusInternalServerError)
@@ -49,9 +58,9 @@ type NamespaceResolver func(r *http.Request) (namespace string, err error)
 
 // NewNamespaceResolver returns a generic namespace resolver that will inspect both the GET query
 // parameters and the request body. It looks for the query param or json key "namespace".
-func NewNamespaceResolver(defaultNamespace string, kube kubernetes.Interface) NamespaceResolver {
+func NewNamespaceResolver(namespaces *k8s.Namespaces) NamespaceResolver {
 	return func(r *http.Request) (string, error) {
-		req := struct{ Namespace string }{Namespace: defaultNamespace}
+		req := struct{ Namespace string }{Namespace: namespaces.DefaultNamespace}
 
 		switch r.Method {
 		case http.MethodGet:
@@ -62,10 +71,6 @@ func NewNamespaceResolver(defaultNamespace string, kube kubernetes.Interface) Na
 				req.Namespace = namespace
 			}
 
-			if req.Namespace != defaultNamespace {
-				return "", fmt.Errorf("namespace %s is not allowed", req.Namespace)
-			}
-
 		case http.MethodPost, http.MethodPut, http.MethodDelete:
 			body, _ := io.ReadAll(r.Body)
 			err := json.Unmarshal(body, &req)
@@ -74,20 +79,234 @@ func NewNamespaceResolver(defaultNamespace string, kube kubernetes.Interface) Na
 				return "", fmt.Errorf("unable to unmarshal json request")
 			}
 
-			if req.Namespace != defaultNamespace {
-				return "", fmt.Errorf("namespace %s is not allowed", req.Namespace)
-			}
-
 			// Reconstruct Body
 			r.Body = io.NopCloser(bytes.NewBuffer(body))
 		}
 
-		if req.Namespace != defaultNamespace {
-			return "", fmt.Errorf("unable to manage secrets within the %s namespace", req.Namespace)
+		if err := namespaces.Validate(req.Namespace); err != nil {
+			return "", fmt.Errorf("namespace %s is not allowed", req.Namespace)
+		}
+
+		return req.Namespace, nil
+	}
+}
+
+// MakeNamespaceMutator creates a handler to get, create, update and delete the
+// namespaces that OpenFaaS manages. Namespaces are created with the "openfaas"
+// annotation and namespaces without it, or kube-system, cannot be changed.
+func MakeNamespaceMutator(defaultNamespace string, clientset kubernetes.Interface) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Body != nil {
+			defer r.Body.Close()
+		}
+
+		name := mux.Vars(r)["name"]
+		if len(name) == 0 {
+			http.Error(w, "namespace name is required", http.StatusBadRequest)
+			return
+		}
+
+		if name == "kube-system" {
+			http.Error(w, "unable to manage the kube-system namespace", http.StatusForbidden)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			getNamespace(defaultNamespace, clientset, name, w, r)
+		case http.MethodPost:
+			createNamespace(clientset, name, w, r)
+		case http.MethodPut:
+			updateNamespace(defaultNamespace, clientset, name, w, r)
+		case http.MethodDelete:
+			deleteNamespace(defaultNamespace, clientset, name, w, r)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
 		}
+	}
+}
+
+func getNamespace(defaultNamespace string, clientset kubernetes.Interface, name string, w http.ResponseWriter, r *http.Request) {
+	ns, ok := getManagedNamespace(defaultNamespace, clientset, name, w, r)
+	if !ok {
+		return
+	}
+
+	out, err := json.Marshal(types.FunctionNamespace{
+		Name:        ns.Name,
+		Annotations: ns.Annotations,
+		Labels:      ns.Labels,
+	})
+	if err != nil {
+		klog.Errorf("Failed to marshal namespace %s: %s", name, err.Error())
+		http.Error(w, "Failed to marshal namespace", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(out)
+}
+
+func createNamespace(clientset kubernetes.Interface, name string, w http.ResponseWriter, r *http.Request) {
+	req, err := readNamespaceRequest(name, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ns := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Labels:      req.Labels,
+			Annotations: withNamespaceAnnotation(req.Annotations),
+		},
+	}
+
+	if _, err := clientset.CoreV1().Namespaces().Create(r.Context(), ns, metav1.CreateOptions{}); err != nil {
+		status, reason := ProcessErrorReasons(err)
+		log.Printf("Namespace create error reason: %s, %v\n", reason, err)
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	log.Printf("Namespace created: %s\n", name)
+	w.WriteHeader(http.StatusCreated)
+}
+
+func updateNamespace(defaultNamespace string, clientset kubernetes.Interface, name string, w http.ResponseWriter, r *http.Request) {
+	req, err := readNamespaceRequest(name, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-		return defaultNamespace, nil
+	ns, ok := getManagedNamespace(defaultNamespace, clientset, name, w, r)
+	if !ok {
+		return
 	}
+
+	// Labels and annotations from the request are merged in, so that those added
+	// by other tools, i.e. for a service mesh, are kept
+	ns.Labels = mergeStringMaps(ns.Labels, req.Labels)
+	ns.Annotations = withNamespaceAnnotation(mergeStringMaps(ns.Annotations, req.Annotations))
+
+	if _, err := clientset.CoreV1().Namespaces().Update(r.Context(), ns, metav1.UpdateOptions{}); err != nil {
+		status, reason := ProcessErrorReasons(err)
+		log.Printf("Namespace update error reason: %s, %v\n", reason, err)
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	log.Printf("Namespace updated: %s\n", name)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func deleteNamespace(defaultNamespace string, clientset kubernetes.Interface, name string, w http.ResponseWriter, r *http.Request) {
+	if name == defaultNamespace {
+		http.Error(w, fmt.Sprintf("unable to delete the default namespace: %s", defaultNamespace), http.StatusBadRequest)
+		return
+	}
+
+	if _, ok := getManagedNamespace(defaultNamespace, clientset, name, w, r); !ok {
+		return
+	}
+
+	functions, err := clientset.AppsV1().Deployments(name).List(r.Context(), metav1.ListOptions{LabelSelector: "faas_function"})
+	if err != nil {
+		status, reason := ProcessErrorReasons(err)
+		log.Printf("Function list error reason: %s, %v\n", reason, err)
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	if len(functions.Items) > 0 {
+		http.Error(w, fmt.Sprintf("namespace %s has %d function(s), remove them before deleting the namespace", name, len(functions.Items)), http.StatusConflict)
+		return
+	}
+
+	if err := clientset.CoreV1().Namespaces().Delete(r.Context(), name, metav1.DeleteOptions{}); err != nil {
+		status, reason := ProcessErrorReasons(err)
+		log.Printf("Namespace delete error reason: %s, %v\n", reason, err)
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	log.Printf("Namespace deleted: %s\n", name)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// getManagedNamespace returns the namespace when it is managed by OpenFaaS, otherwise
+// it writes an error to w and returns false
+func getManagedNamespace(defaultNamespace string, clientset kubernetes.Interface, name string, w http.ResponseWriter, r *http.Request) (*corev1.Namespace, bool) {
+	ns, err := clientset.CoreV1().Namespaces().Get(r.Context(), name, metav1.GetOptions{})
+	if err != nil {
+		status, reason := ProcessErrorReasons(err)
+		log.Printf("Namespace get error reason: %s, %v\n", reason, err)
+		http.Error(w, err.Error(), status)
+		return nil, false
+	}
+
+	if _, ok := ns.Annotations[k8s.NamespaceAnnotation]; !ok && ns.Name != defaultNamespace {
+		http.Error(w, fmt.Sprintf("namespace %s is not annotated with %q", name, k8s.NamespaceAnnotation), http.StatusForbidden)
+		return nil, false
+	}
+
+	return ns, true
+}
+
+// readNamespaceRequest reads an optional types.FunctionNamespace from the body of r,
+// the name in the body must match the name in the path when given
+func readNamespaceRequest(name string, r *http.Request) (types.FunctionNamespace, error) {
+	req := types.FunctionNamespace{}
+	if r.Body == nil {
+		return req, nil
+	}
+
+	body, _ := io.ReadAll(r.Body)
+	if len(body) == 0 {
+		return req, nil
+	}
+
+	if err := json.Unmarshal(body, &req); err != nil {
+		return req, fmt.Errorf("unable to unmarshal namespace request: %s", err.Error())
+	}
+
+	if len(req.Name) > 0 && req.Name != name {
+		return req, fmt.Errorf("namespace name in the body: %s, does not match the path: %s", req.Name, name)
+	}
+
+	return req, nil
+}
+
+func withNamespaceAnnotation(annotations map[string]string) map[string]string {
+	out := map[string]string{}
+	for k, v := range annotations {
+		out[k] = v
+	}
+
+	if _, ok := out[k8s.NamespaceAnnotation]; !ok {
+		out[k8s.NamespaceAnnotation] = "1"
+	}
+
+	return out
+}
+
+// mergeStringMaps returns a copy of existing with the keys from values set
+func mergeStringMaps(existing, values map[string]string) map[string]string {
+	if len(existing) == 0 && len(values) == 0 {
+		return existing
+	}
+
+	out := make(map[string]string, len(existing)+len(values))
+	for k, v := range existing {
+		out[k] = v
+	}
+	for k, v := range values {
+		out[k] = v
+	}
+
+	return out
 }
 
 // ListNamespaces lists all namespaces annotated with openfaas true
@@ -107,7 +326,7 @@ func ListNamespaces(defaultNamespace string, clientset kubernetes.Interface) []s
 	}
 
 	for _, n := range namespaces.Items {
-		if _, ok := n.Annotations["openfaas"]; ok {
+		if _, ok := n.Annotations[k8s.NamespaceAnnotation]; ok {
 			set = append(set, n.Name)
 		}
 	}
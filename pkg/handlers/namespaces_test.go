@@ -3,7 +3,23 @@
 
 package handlers
 
-import "testing"
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/openfaas/faas-netes/pkg/k8s"
+	types "github.com/openfaas/faas-provider/types"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
 
 func Test_findNamespace_Found(t *testing.T) {
 	got := findNamespace("fn", []string{"fn", "openfaas-fn"})
@@ -22,3 +38,234 @@ func Test_findNamespace_NotFound(t *testing.T) {
 		t.Errorf("findNamespace - want: %v, got %v", want, got)
 	}
 }
+
+func Test_MakeNamespacesLister_ListsAnnotatedNamespaces(t *testing.T) {
+	handler := MakeNamespacesLister(newTestNamespaces("staging-fn", "dev-fn"))
+
+	rr := httptest.NewRecorder()
+	handler(rr, httptest.NewRequest(http.MethodGet, "/system/namespaces", nil))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want: %d, got: %d", http.StatusOK, rr.Code)
+	}
+
+	got := []string{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	want := []string{"dev-fn", "openfaas-fn", "staging-fn"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want: %v, got: %v", want, got)
+	}
+}
+
+func Test_NewNamespaceResolver_AnnotatedNamespace(t *testing.T) {
+	resolve := NewNamespaceResolver(newTestNamespaces("staging-fn"))
+
+	cases := []struct {
+		namespace string
+		wantErr   bool
+	}{
+		{namespace: "staging-fn"},
+		{namespace: "openfaas-fn"},
+		{namespace: "default", wantErr: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.namespace, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/system/secrets?namespace="+tc.namespace, nil)
+
+			got, err := resolve(r)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("want an error, got namespace: %s", got)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if got != tc.namespace {
+				t.Errorf("want: %s, got: %s", tc.namespace, got)
+			}
+		})
+	}
+}
+
+func newNamespaceMutatorRequest(method, name, body string) *http.Request {
+	r := httptest.NewRequest(method, "/system/namespace/"+name, strings.NewReader(body))
+	return mux.SetURLVars(r, map[string]string{"name": name})
+}
+
+func Test_MakeNamespaceMutator(t *testing.T) {
+	kube := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "openfaas-fn", Annotations: map[string]string{k8s.NamespaceAnnotation: "1"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "busy-fn", Annotations: map[string]string{k8s.NamespaceAnnotation: "1"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "kube-system"}},
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "figlet", Namespace: "busy-fn", Labels: map[string]string{"faas_function": "figlet"}}},
+	)
+
+	handler := MakeNamespaceMutator("openfaas-fn", kube)
+
+	cases := []struct {
+		name       string
+		method     string
+		namespace  string
+		body       string
+		wantStatus int
+	}{
+		{name: "create", method: http.MethodPost, namespace: "staging-fn", body: `{"labels":{"team":"a"}}`, wantStatus: http.StatusCreated},
+		{name: "create existing", method: http.MethodPost, namespace: "staging-fn", wantStatus: http.StatusConflict},
+		{name: "create with a mismatched name", method: http.MethodPost, namespace: "dev-fn", body: `{"name":"other"}`, wantStatus: http.StatusBadRequest},
+		{name: "get", method: http.MethodGet, namespace: "staging-fn", wantStatus: http.StatusOK},
+		{name: "get missing", method: http.MethodGet, namespace: "missing", wantStatus: http.StatusNotFound},
+		{name: "get without the annotation", method: http.MethodGet, namespace: "default", wantStatus: http.StatusForbidden},
+		{name: "get kube-system", method: http.MethodGet, namespace: "kube-system", wantStatus: http.StatusForbidden},
+		{name: "update", method: http.MethodPut, namespace: "staging-fn", body: `{"annotations":{"owner":"b"}}`, wantStatus: http.StatusAccepted},
+		{name: "update without the annotation", method: http.MethodPut, namespace: "default", body: `{}`, wantStatus: http.StatusForbidden},
+		{name: "delete with functions", method: http.MethodDelete, namespace: "busy-fn", wantStatus: http.StatusConflict},
+		{name: "delete the default namespace", method: http.MethodDelete, namespace: "openfaas-fn", wantStatus: http.StatusBadRequest},
+		{name: "delete kube-system", method: http.MethodDelete, namespace: "kube-system", wantStatus: http.StatusForbidden},
+		{name: "delete", method: http.MethodDelete, namespace: "staging-fn", wantStatus: http.StatusAccepted},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			handler(rr, newNamespaceMutatorRequest(tc.method, tc.namespace, tc.body))
+
+			if rr.Code != tc.wantStatus {
+				t.Errorf("want: %d, got: %d, body: %s", tc.wantStatus, rr.Code, rr.Body.String())
+			}
+		})
+	}
+}
+
+func Test_MakeNamespaceMutator_KeepsAnnotation(t *testing.T) {
+	kube := fake.NewSimpleClientset()
+	handler := MakeNamespaceMutator("openfaas-fn", kube)
+
+	rr := httptest.NewRecorder()
+	handler(rr, newNamespaceMutatorRequest(http.MethodPost, "staging-fn", `{"labels":{"team":"a"}}`))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("want: %d, got: %d", http.StatusCreated, rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	handler(rr, newNamespaceMutatorRequest(http.MethodPut, "staging-fn", `{"annotations":{"owner":"b"}}`))
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("want: %d, got: %d", http.StatusAccepted, rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	handler(rr, newNamespaceMutatorRequest(http.MethodGet, "staging-fn", ""))
+
+	got := types.FunctionNamespace{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	want := types.FunctionNamespace{
+		Name:        "staging-fn",
+		Annotations: map[string]string{"owner": "b", k8s.NamespaceAnnotation: "1"},
+		Labels:      map[string]string{"team": "a"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want: %+v, got: %+v", want, got)
+	}
+}
+
+func Test_MakeNamespaceMutator_UpdateKeepsOtherLabels(t *testing.T) {
+	kube := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name:        "staging-fn",
+			Labels:      map[string]string{"istio-injection": "enabled", "team": "a"},
+			Annotations: map[string]string{k8s.NamespaceAnnotation: "1", "linkerd.io/inject": "enabled"},
+		}},
+	)
+	handler := MakeNamespaceMutator("openfaas-fn", kube)
+
+	rr := httptest.NewRecorder()
+	handler(rr, newNamespaceMutatorRequest(http.MethodPut, "staging-fn", `{"labels":{"team":"b"},"annotations":{"owner":"c"}}`))
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("want: %d, got: %d, body: %s", http.StatusAccepted, rr.Code, rr.Body.String())
+	}
+
+	ns, err := kube.CoreV1().Namespaces().Get(context.Background(), "staging-fn", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	wantLabels := map[string]string{"istio-injection": "enabled", "team": "b"}
+	if !reflect.DeepEqual(ns.Labels, wantLabels) {
+		t.Errorf("want: %v, got: %v", wantLabels, ns.Labels)
+	}
+
+	wantAnnotations := map[string]string{k8s.NamespaceAnnotation: "1", "linkerd.io/inject": "enabled", "owner": "c"}
+	if !reflect.DeepEqual(ns.Annotations, wantAnnotations) {
+		t.Errorf("want: %v, got: %v", wantAnnotations, ns.Annotations)
+	}
+}
+
+func Test_MakeNamespaceMutator_DoesNotListNamespaces(t *testing.T) {
+	kube := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "staging-fn", Annotations: map[string]string{k8s.NamespaceAnnotation: "1"}}},
+	)
+	handler := MakeNamespaceMutator("openfaas-fn", kube)
+
+	rr := httptest.NewRecorder()
+	handler(rr, newNamespaceMutatorRequest(http.MethodGet, "staging-fn", ""))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want: %d, got: %d", http.StatusOK, rr.Code)
+	}
+
+	for _, action := range kube.Actions() {
+		if action.GetVerb() == "list" {
+			t.Errorf("want the namespace to be checked without listing every namespace, got: %s %s", action.GetVerb(), action.GetResource().Resource)
+		}
+	}
+}
+
+func Test_Handlers_RejectKubeSystem(t *testing.T) {
+	f := newTestFixture()
+	f.namespaces = newTestNamespaces("kube-system")
+	lister := newTestDeploymentLister(t)
+
+	body, _ := json.Marshal(types.FunctionDeployment{Service: "figlet", Image: "localhost:5000/figlet:1.0", Namespace: "kube-system"})
+
+	cases := []struct {
+		name    string
+		handler http.HandlerFunc
+		req     *http.Request
+	}{
+		{
+			name:    "update",
+			handler: f.updateHandler(t),
+			req:     httptest.NewRequest(http.MethodPut, "/system/functions", strings.NewReader(string(body))),
+		},
+		{
+			name:    "list",
+			handler: MakeFunctionReader(f.namespaces, lister, nil, nil),
+			req:     httptest.NewRequest(http.MethodGet, "/system/functions?namespace=kube-system", nil),
+		},
+		{
+			name:    "telemetry",
+			handler: MakeTelemetryHandler(f.namespaces, lister, nil),
+			req:     httptest.NewRequest(http.MethodGet, "/system/functions/telemetry?namespace=kube-system", nil),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			tc.handler(rr, tc.req)
+
+			if rr.Code != http.StatusBadRequest {
+				t.Errorf("want: %d, got: %d, body: %s", http.StatusBadRequest, rr.Code, rr.Body.String())
+			}
+		})
+	}
+}
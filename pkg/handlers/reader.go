@@ -7,8 +7,8 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
 	"log"
 	"net/http"
 
@@ -17,30 +17,27 @@ import (
 	"k8s.io/apimachinery/pkg/selection"
 	v1 "k8s.io/client-go/listers/apps/v1"
 	klog "k8s.io/klog"
+	metricsv1beta1 "k8s.io/metrics/pkg/client/clientset/versioned/typed/metrics/v1beta1"
 
 	"github.com/openfaas/faas-netes/pkg/k8s"
 )
 
 // MakeFunctionReader handler for reading functions deployed in the cluster as deployments.
-func MakeFunctionReader(defaultNamespace string, deploymentLister v1.DeploymentLister) http.HandlerFunc {
+// The CPU and memory used by each function is included when ?usage=true is given.
+func MakeFunctionReader(namespaces *k8s.Namespaces, deploymentLister v1.DeploymentLister, metrics metricsv1beta1.PodMetricsesGetter, invocations k8s.InvocationCounter) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		q := r.URL.Query()
 		namespace := q.Get("namespace")
 
-		lookupNamespace := defaultNamespace
+		lookupNamespace := namespaces.DefaultNamespace
 
 		if len(namespace) > 0 {
 			lookupNamespace = namespace
 		}
 
-		if lookupNamespace != defaultNamespace {
-			http.Error(w, fmt.Sprintf("namespace must be: %s", defaultNamespace), http.StatusBadRequest)
-			return
-		}
-
-		if lookupNamespace == "kube-system" {
-			http.Error(w, "unable to list within the kube-system namespace", http.StatusUnauthorized)
+		if err := namespaces.Validate(lookupNamespace); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
@@ -52,6 +49,16 @@ func MakeFunctionReader(defaultNamespace string, deploymentLister v1.DeploymentL
 			return
 		}
 
+		if err := addInvocationCounts(r.Context(), invocations, lookupNamespace, functions); err != nil {
+			log.Printf("Unable to read invocation counts: %s", err.Error())
+		}
+
+		if usageRequested(r) {
+			if err := addFunctionUsage(r.Context(), metrics, lookupNamespace, functions); err != nil {
+				log.Printf("Unable to read function usage: %s", err.Error())
+			}
+		}
+
 		functionBytes, err := json.Marshal(functions)
 		if err != nil {
 			klog.Errorf("Failed to marshal functions: %s", err.Error())
@@ -93,3 +100,21 @@ func getServiceList(functionNamespace string, deploymentLister v1.DeploymentList
 
 	return functions, nil
 }
+
+// addInvocationCounts sets the InvocationCount of each function from counter
+func addInvocationCounts(ctx context.Context, counter k8s.InvocationCounter, namespace string, functions []types.FunctionStatus) error {
+	if counter == nil || len(functions) == 0 {
+		return nil
+	}
+
+	counts, err := counter.InvocationCounts(ctx, namespace)
+	if err != nil {
+		return err
+	}
+
+	for i := range functions {
+		functions[i].InvocationCount = counts[functions[i].Name]
+	}
+
+	return nil
+}
@@ -18,6 +18,7 @@ import (
 	"k8s.io/apimachinery/pkg/api/errors"
 	v1 "k8s.io/client-go/listers/apps/v1"
 	klog "k8s.io/klog"
+	metricsv1beta1 "k8s.io/metrics/pkg/client/clientset/versioned/typed/metrics/v1beta1"
 )
 
 // MaxReplicas licensed for OpenFaaS CE is 5/5
@@ -29,7 +30,9 @@ const MaxReplicas = 5
 const MaxFunctions = 15
 
 // MakeReplicaReader reads the amount of replicas for a deployment
-func MakeReplicaReader(defaultNamespace string, lister v1.DeploymentLister) http.HandlerFunc {
+// The CPU and memory used by the function is included when ?usage=true is given, and
+// the state of its circuit is added to its annotations when circuits is set.
+func MakeReplicaReader(namespaces *k8s.Namespaces, lister v1.DeploymentLister, metrics metricsv1beta1.PodMetricsesGetter, invocations k8s.InvocationCounter, circuits k8s.CircuitReader) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		vars := mux.Vars(r)
@@ -38,14 +41,14 @@ func MakeReplicaReader(defaultNamespace string, lister v1.DeploymentLister) http
 		q := r.URL.Query()
 		namespace := q.Get("namespace")
 
-		lookupNamespace := defaultNamespace
+		lookupNamespace := namespaces.DefaultNamespace
 
 		if len(namespace) > 0 {
 			lookupNamespace = namespace
 		}
 
-		if lookupNamespace != defaultNamespace {
-			http.Error(w, fmt.Sprintf("namespace must be: %s", defaultNamespace), http.StatusBadRequest)
+		if err := namespaces.Validate(lookupNamespace); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
@@ -62,6 +65,22 @@ func MakeReplicaReader(defaultNamespace string, lister v1.DeploymentLister) http
 			return
 		}
 
+		functions := []types.FunctionStatus{*function}
+		if err := addInvocationCounts(r.Context(), invocations, lookupNamespace, functions); err != nil {
+			log.Printf("Unable to read invocation count for: %s, %s", functionName, err.Error())
+		}
+
+		if usageRequested(r) {
+			if err := addFunctionUsage(r.Context(), metrics, lookupNamespace, functions); err != nil {
+				log.Printf("Unable to read usage for: %s, %s", functionName, err.Error())
+			}
+		}
+		function = &functions[0]
+
+		if circuits != nil {
+			addCircuitStatus(circuits.Status(functionName+"."+lookupNamespace), function)
+		}
+
 		functionBytes, err := json.Marshal(function)
 		if err != nil {
 			klog.Errorf("Failed to marshal function: %s", err.Error())
@@ -75,6 +94,22 @@ func MakeReplicaReader(defaultNamespace string, lister v1.DeploymentLister) http
 	}
 }
 
+// addCircuitStatus adds the state of the circuit to the annotations of function, which
+// are copied as they are shared with the lister's cache
+func addCircuitStatus(status k8s.CircuitStatus, function *types.FunctionStatus) {
+	annotations := map[string]string{}
+	if function.Annotations != nil {
+		for k, v := range *function.Annotations {
+			annotations[k] = v
+		}
+	}
+
+	for k, v := range status.Annotations() {
+		annotations[k] = v
+	}
+	function.Annotations = &annotations
+}
+
 // getService returns a function/service or nil if not found
 func getService(functionNamespace string, functionName string, lister v1.DeploymentLister) (*types.FunctionStatus, error) {
 
@@ -15,13 +15,14 @@ import (
 	"net/http"
 
 	"github.com/gorilla/mux"
+	"github.com/openfaas/faas-netes/pkg/k8s"
 	"github.com/openfaas/faas-provider/types"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 )
 
 // MakeReplicaUpdater updates desired count of replicas
-func MakeReplicaUpdater(defaultNamespace string, clientset *kubernetes.Clientset) http.HandlerFunc {
+func MakeReplicaUpdater(namespaces *k8s.Namespaces, clientset *kubernetes.Clientset, config k8s.DeploymentConfig) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		log.Println("Update replicas")
 
@@ -31,14 +32,14 @@ func MakeReplicaUpdater(defaultNamespace string, clientset *kubernetes.Clientset
 		q := r.URL.Query()
 		namespace := q.Get("namespace")
 
-		lookupNamespace := defaultNamespace
+		lookupNamespace := namespaces.DefaultNamespace
 
 		if len(namespace) > 0 {
 			lookupNamespace = namespace
 		}
 
-		if lookupNamespace != defaultNamespace {
-			http.Error(w, fmt.Sprintf("namespace must be: %s", defaultNamespace), http.StatusBadRequest)
+		if err := namespaces.Validate(lookupNamespace); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
@@ -57,12 +58,6 @@ func MakeReplicaUpdater(defaultNamespace string, clientset *kubernetes.Clientset
 			}
 		}
 
-		if req.Replicas == 0 {
-			http.Error(w, "replicas cannot be set to 0 in OpenFaaS CE",
-				http.StatusBadRequest)
-			return
-		}
-
 		options := metav1.GetOptions{
 			TypeMeta: metav1.TypeMeta{
 				Kind:       "Deployment",
@@ -79,6 +74,12 @@ func MakeReplicaUpdater(defaultNamespace string, clientset *kubernetes.Clientset
 			return
 		}
 
+		if req.Replicas == 0 && !(config.ScaleToZero && k8s.ScaleToZeroEnabled(deployment.Spec.Template.Labels)) {
+			http.Error(w, "replicas cannot be set to 0 in OpenFaaS CE",
+				http.StatusBadRequest)
+			return
+		}
+
 		oldReplicas := *deployment.Spec.Replicas
 		replicas := int32(req.Replicas)
 		if replicas >= MaxReplicas {
@@ -87,11 +88,7 @@ func MakeReplicaUpdater(defaultNamespace string, clientset *kubernetes.Clientset
 
 		log.Printf("Set replicas - %s %s, %d => %d\n", functionName, lookupNamespace, oldReplicas, replicas)
 
-		deployment.Spec.Replicas = &replicas
-
-		if _, err = clientset.AppsV1().Deployments(lookupNamespace).
-			Update(context.TODO(), deployment, metav1.UpdateOptions{}); err != nil {
-
+		if err := k8s.ScaleDeployment(context.TODO(), clientset, lookupNamespace, functionName, replicas); err != nil {
 			log.Printf("unable to update function deployment: %s, %s", functionName, err)
 			http.Error(w, fmt.Sprintf("unable to update function deployment: %s", functionName), http.StatusInternalServerError)
 			return
@@ -0,0 +1,316 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/openfaas/faas-netes/pkg/k8s"
+	types "github.com/openfaas/faas-provider/types"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// RollbackRequest selects the revision to roll a function back to
+type RollbackRequest struct {
+	// Revision to roll back to, the previous revision is used when it is omitted
+	Revision int64 `json:"revision,omitempty"`
+}
+
+// MakeRevisionsReader lists the revisions of a function which are kept by its
+// Deployment, the newest revision first
+func MakeRevisionsReader(namespaces *k8s.Namespaces, client kubernetes.Interface) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		functionName := mux.Vars(r)["name"]
+
+		lookupNamespace, err := functionNamespace(r, namespaces)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		deployment, err := getFunctionDeployment(r.Context(), client, lookupNamespace, functionName)
+		if err != nil {
+			status, _ := ProcessErrorReasons(err)
+			http.Error(w, err.Error(), status)
+			return
+		}
+
+		replicaSets, err := k8s.ListRevisions(r.Context(), client, deployment)
+		if err != nil {
+			log.Printf("Unable to list revisions: %s.%s, error: %s", functionName, lookupNamespace, err.Error())
+			status, _ := ProcessErrorReasons(err)
+			http.Error(w, "unable to list revisions", status)
+			return
+		}
+
+		revisions := []k8s.FunctionRevision{}
+		for _, rs := range replicaSets {
+			revisions = append(revisions, k8s.AsFunctionRevision(deployment, rs))
+		}
+
+		res, err := json.Marshal(revisions)
+		if err != nil {
+			log.Printf("Unable to marshal revisions: %s", err.Error())
+			http.Error(w, "unable to marshal revisions", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(res)
+	}
+}
+
+// MakeRollbackHandler rolls a function back to one of its revisions. The function is
+// read from the pod template of the revision's ReplicaSet and then updated in the
+// same way as the update handler, so the rollback becomes a new revision.
+func MakeRollbackHandler(namespaces *k8s.Namespaces, factory k8s.FunctionFactory, rollouts *k8s.RolloutWatcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if r.Body != nil {
+			defer r.Body.Close()
+		}
+
+		functionName := mux.Vars(r)["name"]
+
+		lookupNamespace, err := functionNamespace(r, namespaces)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		rollback := RollbackRequest{}
+		if body, _ := io.ReadAll(r.Body); len(body) > 0 {
+			if err := json.Unmarshal(body, &rollback); err != nil {
+				http.Error(w, fmt.Sprintf("unable to unmarshal request: %s", err.Error()), http.StatusBadRequest)
+				return
+			}
+		}
+
+		dryRun, err := dryRunRequested(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		wait, err := waitRequested(r, rollouts)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		current, err := getFunctionDeployment(ctx, factory.Client, lookupNamespace, functionName)
+		if err != nil {
+			status, _ := ProcessErrorReasons(err)
+			http.Error(w, err.Error(), status)
+			return
+		}
+
+		replicaSets, err := k8s.ListRevisions(ctx, factory.Client, current)
+		if err != nil {
+			log.Printf("Unable to list revisions: %s.%s, error: %s", functionName, lookupNamespace, err.Error())
+			status, _ := ProcessErrorReasons(err)
+			http.Error(w, "unable to list revisions", status)
+			return
+		}
+
+		target, err := findRevision(replicaSets, k8s.Revision(&current.ObjectMeta), rollback.Revision)
+		if err != nil {
+			status, _ := ProcessErrorReasons(err)
+			http.Error(w, err.Error(), status)
+			return
+		}
+
+		request := functionFromTemplate(functionName, lookupNamespace, target.Spec.Template)
+		if err := ValidateDeployRequest(&request, factory.Config); err != nil {
+			http.Error(w, fmt.Sprintf("validation failed: %s", err.Error()), http.StatusBadRequest)
+			return
+		}
+
+		deployment, status, err := updateDeploymentSpec(ctx, lookupNamespace, factory, request, dryRun)
+		if err != nil {
+			log.Printf("error rolling back deployment: %s.%s, error: %s\n", functionName, lookupNamespace, err)
+			http.Error(w, fmt.Sprintf("unable to roll back Deployment: %s", err.Error()), status)
+			return
+		}
+
+		service, status, err := updateService(ctx, lookupNamespace, factory, request, dryRun)
+		if err != nil {
+			log.Printf("error rolling back service: %s.%s, error: %s\n", functionName, lookupNamespace, err)
+			http.Error(w, fmt.Sprintf("unable to roll back Service: %s", err.Error()), status)
+			return
+		}
+
+		if err := syncHPA(ctx, factory, lookupNamespace, request, deployment, dryRun); err != nil {
+			log.Printf("error rolling back HPA: %s.%s, error: %s\n", functionName, lookupNamespace, err)
+			status, _ := ProcessErrorReasons(err)
+			http.Error(w, fmt.Sprintf("unable to roll back HPA: %s", err.Error()), status)
+			return
+		}
+
+		if dryRun {
+			writeDryRun(w, r, deployment, service)
+			return
+		}
+
+		log.Printf("Function rolled back: %s.%s to revision: %d\n", functionName, lookupNamespace, k8s.Revision(&target.ObjectMeta))
+
+		if wait > 0 {
+			waitForRollout(w, r, rollouts, lookupNamespace, functionName, deployment.Generation, wait, http.StatusAccepted)
+			return
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// functionNamespace returns the namespace given by the namespace query parameter,
+// or the default namespace
+func functionNamespace(r *http.Request, namespaces *k8s.Namespaces) (string, error) {
+	namespace := namespaces.DefaultNamespace
+	if v := r.URL.Query().Get("namespace"); len(v) > 0 {
+		namespace = v
+	}
+
+	if err := namespaces.Validate(namespace); err != nil {
+		return "", err
+	}
+
+	return namespace, nil
+}
+
+// getFunctionDeployment returns the Deployment of a function, a Deployment which is
+// not a function is reported as not found
+func getFunctionDeployment(ctx context.Context, client kubernetes.Interface, namespace, name string) (*appsv1.Deployment, error) {
+	deployment, err := client.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	if deployment.Labels["faas_function"] != name {
+		return nil, errors.NewNotFound(appsv1.Resource("deployments"), name)
+	}
+
+	return deployment, nil
+}
+
+// findRevision returns the ReplicaSet of revision, when revision is zero the
+// newest revision before current is returned
+func findRevision(replicaSets []appsv1.ReplicaSet, current, revision int64) (*appsv1.ReplicaSet, error) {
+	if revision == current && revision != 0 {
+		return nil, errors.NewBadRequest(fmt.Sprintf("revision %d is the current revision", revision))
+	}
+
+	for i, rs := range replicaSets {
+		got := k8s.Revision(&rs.ObjectMeta)
+		if (revision == 0 && got < current) || (revision != 0 && got == revision) {
+			return &replicaSets[i], nil
+		}
+	}
+
+	if revision == 0 {
+		return nil, errors.NewNotFound(appsv1.Resource("revisions"), fmt.Sprintf("before %d", current))
+	}
+
+	return nil, errors.NewNotFound(appsv1.Resource("revisions"), fmt.Sprintf("%d", revision))
+}
+
+// functionFromTemplate reads the deployment request of a function from the pod
+// template which makeDeploymentSpec created for it
+func functionFromTemplate(name, namespace string, template corev1.PodTemplateSpec) types.FunctionDeployment {
+	container := template.Spec.Containers[0]
+	for _, c := range template.Spec.Containers {
+		if c.Name == name {
+			container = c
+			break
+		}
+	}
+
+	request := types.FunctionDeployment{
+		Service:   name,
+		Namespace: namespace,
+		Image:     container.Image,
+		EnvVars:   map[string]string{},
+	}
+
+	for _, e := range container.Env {
+		if e.ValueFrom != nil {
+			continue
+		}
+
+		if e.Name == k8s.EnvProcessName {
+			request.EnvProcess = e.Value
+			continue
+		}
+		request.EnvVars[e.Name] = e.Value
+	}
+
+	labels := map[string]string{}
+	for k, v := range template.Labels {
+		switch k {
+		case "faas_function", k8s.CanaryLabel, "uid", appsv1.DefaultDeploymentUniqueLabelKey:
+		default:
+			labels[k] = v
+		}
+	}
+	if len(labels) > 0 {
+		request.Labels = &labels
+	}
+
+	if len(template.Annotations) > 0 {
+		annotations := map[string]string{}
+		for k, v := range template.Annotations {
+			if k != k8s.ConstraintsAnnotation {
+				annotations[k] = v
+			}
+		}
+		request.Annotations = &annotations
+	}
+
+	// The secrets are read from the projected volume which is named after the function
+	deployment := appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec:       appsv1.DeploymentSpec{Template: template},
+	}
+	if secrets := k8s.ReadFunctionSecretsSpec(deployment); len(secrets) > 0 {
+		request.Secrets = secrets
+	}
+	if constraints := k8s.ReadFunctionConstraints(deployment); len(constraints) > 0 {
+		request.Constraints = constraints
+	}
+
+	request.Requests = functionResources(container.Resources.Requests)
+	request.Limits = functionResources(container.Resources.Limits)
+
+	if sc := container.SecurityContext; sc != nil && sc.ReadOnlyRootFilesystem != nil {
+		request.ReadOnlyRootFilesystem = *sc.ReadOnlyRootFilesystem
+	}
+
+	return request
+}
+
+func functionResources(list corev1.ResourceList) *types.FunctionResources {
+	resources := &types.FunctionResources{}
+	if memory, ok := list[corev1.ResourceMemory]; ok {
+		resources.Memory = memory.String()
+	}
+	if cpu, ok := list[corev1.ResourceCPU]; ok {
+		resources.CPU = cpu.String()
+	}
+
+	if len(resources.Memory) == 0 && len(resources.CPU) == 0 {
+		return nil
+	}
+	return resources
+}
@@ -0,0 +1,196 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/openfaas/faas-netes/pkg/k8s"
+	types "github.com/openfaas/faas-provider/types"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// createRevisions creates the revisions 1 to 3 of figlet with the images 1.0
+// to 3.0, revision 3 is current
+func (f *testFixture) createRevisions(t *testing.T) {
+	t.Helper()
+
+	ctx := context.Background()
+	controller := true
+
+	for i, version := range []string{"1.0", "2.0", "3.0"} {
+		revision := []string{"1", "2", "3"}[i]
+
+		request := figletFunction(version)
+		request.EnvVars = map[string]string{"version": version}
+		deployment, _, err := MakeFunctionSpecs(request, f.factory)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		template := deployment.Spec.Template.DeepCopy()
+		template.Labels[appsv1.DefaultDeploymentUniqueLabelKey] = "hash-" + revision
+
+		rs := &appsv1.ReplicaSet{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "figlet-hash-" + revision,
+				Namespace:   "openfaas-fn",
+				Labels:      template.Labels,
+				Annotations: map[string]string{k8s.RevisionAnnotation: revision},
+				OwnerReferences: []metav1.OwnerReference{
+					{APIVersion: "apps/v1", Kind: "Deployment", Name: "figlet", UID: "figlet-uid", Controller: &controller},
+				},
+			},
+			Spec: appsv1.ReplicaSetSpec{Template: *template},
+		}
+		if _, err := f.kube.AppsV1().ReplicaSets("openfaas-fn").Create(ctx, rs, metav1.CreateOptions{}); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if revision == "3" {
+			f.createFunction(t, request, func(deployment *appsv1.Deployment) {
+				deployment.UID = "figlet-uid"
+				deployment.Annotations = map[string]string{k8s.RevisionAnnotation: revision}
+			})
+		}
+	}
+}
+
+func rollback(handler http.HandlerFunc, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/system/function/figlet/rollback", strings.NewReader(body))
+	req = mux.SetURLVars(req, map[string]string{"name": "figlet"})
+
+	rr := httptest.NewRecorder()
+	handler(rr, req)
+	return rr
+}
+
+func Test_functionFromTemplate_RoundTrip(t *testing.T) {
+	factory := newTestFactory(fake.NewSimpleClientset())
+
+	want := types.FunctionDeployment{
+		Service:                "figlet",
+		Namespace:              "openfaas-fn",
+		Image:                  "localhost:5000/figlet:1.0",
+		EnvProcess:             "figlet",
+		EnvVars:                map[string]string{"write_debug": "true"},
+		Labels:                 &map[string]string{"com.openfaas.scale.min": "2"},
+		Annotations:            &map[string]string{"topic": "cron"},
+		Constraints:            []string{"kubernetes.io/arch=arm64"},
+		Limits:                 &types.FunctionResources{Memory: "128Mi"},
+		Requests:               &types.FunctionResources{Memory: "64Mi", CPU: "100m"},
+		ReadOnlyRootFilesystem: true,
+	}
+
+	deployment, err := makeDeploymentSpec(want, nil, factory)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	got := functionFromTemplate("figlet", "openfaas-fn", deployment.Spec.Template)
+
+	if got.Image != want.Image || got.EnvProcess != want.EnvProcess || got.ReadOnlyRootFilesystem != want.ReadOnlyRootFilesystem {
+		t.Errorf("want: %s, %s, %v, got: %s, %s, %v", want.Image, want.EnvProcess, want.ReadOnlyRootFilesystem, got.Image, got.EnvProcess, got.ReadOnlyRootFilesystem)
+	}
+	if !reflect.DeepEqual(got.EnvVars, want.EnvVars) {
+		t.Errorf("want env-vars: %v, got: %v", want.EnvVars, got.EnvVars)
+	}
+	if got.Labels == nil || !reflect.DeepEqual(*got.Labels, *want.Labels) {
+		t.Errorf("want labels: %v, got: %v", *want.Labels, got.Labels)
+	}
+	if got.Annotations == nil || (*got.Annotations)["topic"] != "cron" {
+		t.Errorf("want the topic annotation, got: %v", got.Annotations)
+	}
+	if !reflect.DeepEqual(got.Constraints, want.Constraints) {
+		t.Errorf("want constraints: %v, got: %v", want.Constraints, got.Constraints)
+	}
+	if !reflect.DeepEqual(got.Limits, want.Limits) || !reflect.DeepEqual(got.Requests, want.Requests) {
+		t.Errorf("want limits: %v and requests: %v, got: %v and %v", want.Limits, want.Requests, got.Limits, got.Requests)
+	}
+}
+
+func Test_MakeRevisionsReader(t *testing.T) {
+	f := newTestFixture()
+	f.createRevisions(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/system/function/figlet/revisions", nil)
+	req = mux.SetURLVars(req, map[string]string{"name": "figlet"})
+
+	rr := httptest.NewRecorder()
+	MakeRevisionsReader(f.namespaces, f.kube)(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want: %d, got: %d, body: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	revisions := []k8s.FunctionRevision{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &revisions); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(revisions) != 3 {
+		t.Fatalf("want: %d revisions, got: %d", 3, len(revisions))
+	}
+	if revisions[0].Revision != 3 || !revisions[0].Current || revisions[0].Image != "localhost:5000/figlet:3.0" {
+		t.Errorf("want the current revision 3 first, got: %+v", revisions[0])
+	}
+	if revisions[1].EnvHash == revisions[0].EnvHash {
+		t.Errorf("want a different env hash for each revision")
+	}
+}
+
+func Test_MakeRollbackHandler(t *testing.T) {
+	cases := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantImage  string
+	}{
+		{name: "previous revision", body: "", wantStatus: http.StatusAccepted, wantImage: "localhost:5000/figlet:2.0"},
+		{name: "chosen revision", body: `{"revision": 1}`, wantStatus: http.StatusAccepted, wantImage: "localhost:5000/figlet:1.0"},
+		{name: "current revision", body: `{"revision": 3}`, wantStatus: http.StatusBadRequest, wantImage: "localhost:5000/figlet:3.0"},
+		{name: "missing revision", body: `{"revision": 7}`, wantStatus: http.StatusNotFound, wantImage: "localhost:5000/figlet:3.0"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newTestFixture()
+			f.createRevisions(t)
+			handler := MakeRollbackHandler(f.namespaces, f.factory, nil)
+
+			rr := rollback(handler, tc.body)
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("want: %d, got: %d, body: %s", tc.wantStatus, rr.Code, rr.Body.String())
+			}
+
+			container := f.deployment(t, "figlet").Spec.Template.Spec.Containers[0]
+			if container.Image != tc.wantImage {
+				t.Errorf("want image: %s, got: %s", tc.wantImage, container.Image)
+			}
+
+			version := strings.TrimPrefix(tc.wantImage, "localhost:5000/figlet:")
+			if len(container.Env) != 1 || container.Env[0].Value != version {
+				t.Errorf("want the env-vars of version %s, got: %v", version, container.Env)
+			}
+		})
+	}
+}
+
+func Test_MakeRollbackHandler_MissingFunction(t *testing.T) {
+	f := newTestFixture()
+
+	rr := rollback(MakeRollbackHandler(f.namespaces, f.factory, nil), "")
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("want: %d, got: %d, body: %s", http.StatusNotFound, rr.Code, rr.Body.String())
+	}
+}
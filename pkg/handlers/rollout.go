@@ -0,0 +1,126 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/openfaas/faas-netes/pkg/k8s"
+	"k8s.io/apimachinery/pkg/api/errors"
+)
+
+// MakeRolloutStatusHandler returns the status of the latest rollout of a function,
+// with ?wait=<duration> the response is sent once the rollout completes or fails.
+func MakeRolloutStatusHandler(namespaces *k8s.Namespaces, rollouts *k8s.RolloutWatcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		functionName := mux.Vars(r)["name"]
+
+		lookupNamespace := namespaces.DefaultNamespace
+		if namespace := r.URL.Query().Get("namespace"); len(namespace) > 0 {
+			lookupNamespace = namespace
+		}
+
+		if err := namespaces.Validate(lookupNamespace); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		wait, err := waitRequested(r, rollouts)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		status, err := rollouts.Status(r.Context(), lookupNamespace, functionName)
+		if err != nil {
+			if errors.IsNotFound(err) {
+				http.Error(w, fmt.Sprintf("function: %s.%s not found", functionName, lookupNamespace), http.StatusNotFound)
+				return
+			}
+
+			log.Printf("Unable to read rollout status: %s.%s, error: %s", functionName, lookupNamespace, err.Error())
+			http.Error(w, "unable to read rollout status", http.StatusInternalServerError)
+			return
+		}
+
+		if wait > 0 && !status.Complete && !status.Failed {
+			waitForRollout(w, r, rollouts, lookupNamespace, functionName, 0, wait, http.StatusOK)
+			return
+		}
+
+		writeRolloutStatus(w, status, http.StatusOK)
+	}
+}
+
+// waitRequested parses the wait query parameter i.e. wait=2m, zero is returned when
+// the caller does not want to wait for the rollout
+func waitRequested(r *http.Request, rollouts *k8s.RolloutWatcher) (time.Duration, error) {
+	v := r.URL.Query().Get("wait")
+	if len(v) == 0 {
+		return 0, nil
+	}
+
+	if rollouts == nil {
+		return 0, fmt.Errorf("waiting for a rollout is not supported")
+	}
+
+	wait, err := time.ParseDuration(v)
+	if err != nil || wait <= 0 {
+		return 0, fmt.Errorf("wait must be a positive duration i.e. 60s, got: %q", v)
+	}
+
+	if rollouts.MaxWait > 0 && wait >= rollouts.MaxWait {
+		return 0, fmt.Errorf("wait must be shorter than: %s", rollouts.MaxWait)
+	}
+
+	return wait, nil
+}
+
+// waitForRollout waits for the rollout of generation of a function and writes its
+// status. successStatus is written when the rollout completes, 500 when it fails and
+// 504 when it is still progressing once wait has passed.
+func waitForRollout(w http.ResponseWriter, r *http.Request, rollouts *k8s.RolloutWatcher, namespace, name string, generation int64, wait time.Duration, successStatus int) {
+	status, err := rollouts.Wait(r.Context(), namespace, name, generation, wait)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			http.Error(w, fmt.Sprintf("function: %s.%s not found", name, namespace), http.StatusNotFound)
+			return
+		}
+
+		log.Printf("Unable to wait for rollout: %s.%s, error: %s", name, namespace, err.Error())
+		http.Error(w, "unable to wait for rollout", http.StatusInternalServerError)
+		return
+	}
+
+	code := successStatus
+	if status.Failed {
+		code = http.StatusInternalServerError
+	} else if !status.Complete {
+		code = http.StatusGatewayTimeout
+	}
+
+	if code != successStatus {
+		log.Printf("Rollout not complete: %s.%s, reason: %s, %s", name, namespace, status.Reason, status.Message)
+	}
+
+	writeRolloutStatus(w, status, code)
+}
+
+func writeRolloutStatus(w http.ResponseWriter, status *k8s.RolloutStatus, code int) {
+	res, err := json.Marshal(status)
+	if err != nil {
+		log.Printf("Unable to marshal rollout status: %s", err.Error())
+		http.Error(w, "unable to marshal rollout status", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(res)
+}
@@ -0,0 +1,153 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/openfaas/faas-netes/pkg/k8s"
+	types "github.com/openfaas/faas-provider/types"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func readRolloutStatus(t *testing.T, rr *httptest.ResponseRecorder) k8s.RolloutStatus {
+	t.Helper()
+
+	status := k8s.RolloutStatus{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &status); err != nil {
+		t.Fatalf("unexpected error: %s, body: %s", err, rr.Body.String())
+	}
+	return status
+}
+
+func Test_waitRequested(t *testing.T) {
+	rollouts := &k8s.RolloutWatcher{MaxWait: time.Minute}
+
+	cases := []struct {
+		name     string
+		query    string
+		rollouts *k8s.RolloutWatcher
+		want     time.Duration
+		wantErr  bool
+	}{
+		{name: "not given", query: "", rollouts: rollouts},
+		{name: "duration", query: "wait=30s", rollouts: rollouts, want: time.Second * 30},
+		{name: "invalid duration", query: "wait=soon", rollouts: rollouts, wantErr: true},
+		{name: "negative duration", query: "wait=-1s", rollouts: rollouts, wantErr: true},
+		{name: "longer than the write timeout", query: "wait=1m", rollouts: rollouts, wantErr: true},
+		{name: "not supported", query: "wait=30s", wantErr: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := waitRequested(httptest.NewRequest(http.MethodPost, "/system/functions?"+tc.query, nil), tc.rollouts)
+			if tc.wantErr != (err != nil) {
+				t.Fatalf("want error: %v, got: %v", tc.wantErr, err)
+			}
+			if got != tc.want {
+				t.Errorf("want: %s, got: %s", tc.want, got)
+			}
+		})
+	}
+}
+
+func Test_MakeRolloutStatusHandler(t *testing.T) {
+	replicas := int32(1)
+	f := newTestFixture(&appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "figlet", Namespace: "openfaas-fn", Labels: map[string]string{"faas_function": "figlet"}},
+		Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+		Status:     appsv1.DeploymentStatus{Replicas: 1, UpdatedReplicas: 1, AvailableReplicas: 1},
+	})
+
+	handler := MakeRolloutStatusHandler(f.namespaces, f.rolloutWatcher(t))
+
+	cases := []struct {
+		name       string
+		function   string
+		query      string
+		wantStatus int
+	}{
+		{name: "complete rollout", function: "figlet", wantStatus: http.StatusOK},
+		{name: "complete rollout with wait", function: "figlet", query: "?wait=5s", wantStatus: http.StatusOK},
+		{name: "missing function", function: "nodeinfo", wantStatus: http.StatusNotFound},
+		{name: "unmanaged namespace", function: "figlet", query: "?namespace=kube-system", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/system/function/"+tc.function+"/rollout"+tc.query, nil)
+			req = mux.SetURLVars(req, map[string]string{"name": tc.function})
+
+			rr := httptest.NewRecorder()
+			handler(rr, req)
+
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("want: %d, got: %d, body: %s", tc.wantStatus, rr.Code, rr.Body.String())
+			}
+
+			if tc.wantStatus == http.StatusOK {
+				if status := readRolloutStatus(t, rr); !status.Complete {
+					t.Errorf("want a complete rollout, got: %+v", status)
+				}
+			}
+		})
+	}
+}
+
+func Test_MakeUpdateHandler_WaitsForRollout(t *testing.T) {
+	f := newTestFixture()
+	f.createFunction(t, figletFunction("1.0"))
+	handler := f.updateHandler(t)
+
+	body, _ := json.Marshal(types.FunctionDeployment{Service: "figlet", Image: "localhost:5000/figlet:2.0", Namespace: "openfaas-fn"})
+
+	// No replicas have become available
+	rr := httptest.NewRecorder()
+	handler(rr, httptest.NewRequest(http.MethodPut, "/system/functions?wait=50ms", strings.NewReader(string(body))))
+	if rr.Code != http.StatusGatewayTimeout {
+		t.Fatalf("want: %d, got: %d, body: %s", http.StatusGatewayTimeout, rr.Code, rr.Body.String())
+	}
+	if status := readRolloutStatus(t, rr); status.Complete || len(status.Message) == 0 {
+		t.Errorf("want an incomplete rollout with a message, got: %+v", status)
+	}
+
+	ready := f.deployment(t, "figlet")
+	ready.Status = appsv1.DeploymentStatus{Replicas: 1, UpdatedReplicas: 1, AvailableReplicas: 1}
+	if _, err := f.kube.AppsV1().Deployments("openfaas-fn").UpdateStatus(context.Background(), ready, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	rr = httptest.NewRecorder()
+	handler(rr, httptest.NewRequest(http.MethodPut, "/system/functions?wait=5s", strings.NewReader(string(body))))
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("want: %d, got: %d, body: %s", http.StatusAccepted, rr.Code, rr.Body.String())
+	}
+	if status := readRolloutStatus(t, rr); !status.Complete {
+		t.Errorf("want a complete rollout, got: %+v", status)
+	}
+}
+
+func Test_MakeDeployHandler_WaitTimesOut(t *testing.T) {
+	f := newTestFixture()
+	handler := f.deployHandler(f.rolloutWatcher(t))
+
+	body, _ := json.Marshal(types.FunctionDeployment{Service: "figlet", Image: "localhost:5000/figlet:1.0"})
+	rr := httptest.NewRecorder()
+	handler(rr, httptest.NewRequest(http.MethodPost, "/system/functions?wait=50ms", strings.NewReader(string(body))))
+
+	if rr.Code != http.StatusGatewayTimeout {
+		t.Fatalf("want: %d, got: %d, body: %s", http.StatusGatewayTimeout, rr.Code, rr.Body.String())
+	}
+	if status := readRolloutStatus(t, rr); status.Name != "figlet" || status.Complete {
+		t.Errorf("want an incomplete rollout of figlet, got: %+v", status)
+	}
+}
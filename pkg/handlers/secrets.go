@@ -15,9 +15,9 @@ import (
 
 // MakeSecretHandler makes a handler for Create/List/Delete/Update of
 // secrets in the Kubernetes API
-func MakeSecretHandler(defaultNamespace string, kube kubernetes.Interface) http.HandlerFunc {
+func MakeSecretHandler(namespaces *k8s.Namespaces, kube kubernetes.Interface) http.HandlerFunc {
 	handler := SecretsHandler{
-		LookupNamespace: NewNamespaceResolver(defaultNamespace, kube),
+		LookupNamespace: NewNamespaceResolver(namespaces),
 		Secrets:         k8s.NewSecretsClient(kube),
 	}
 	return handler.ServeHTTP
@@ -14,6 +14,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/openfaas/faas-netes/pkg/k8s"
 	types "github.com/openfaas/faas-provider/types"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	testclient "k8s.io/client-go/kubernetes/fake"
@@ -25,7 +26,7 @@ const secretLabelValue = "openfaas"
 func Test_SecretsHandler(t *testing.T) {
 	namespace := "of-fnc"
 	kube := testclient.NewSimpleClientset()
-	secretsHandler := MakeSecretHandler(namespace, kube).ServeHTTP
+	secretsHandler := MakeSecretHandler(k8s.NewNamespaces(namespace, nil), kube).ServeHTTP
 	secretName := "testsecret"
 
 	t.Run("create managed secrets", func(t *testing.T) {
@@ -273,7 +274,7 @@ func Test_SecretsHandler(t *testing.T) {
 func Test_SecretsHandler_ListEmpty(t *testing.T) {
 	namespace := "of-fnc"
 	kube := testclient.NewSimpleClientset()
-	secretsHandler := MakeSecretHandler(namespace, kube).ServeHTTP
+	secretsHandler := MakeSecretHandler(k8s.NewNamespaces(namespace, nil), kube).ServeHTTP
 
 	req := httptest.NewRequest("GET", "http://example.com/foo", nil)
 	w := httptest.NewRecorder()
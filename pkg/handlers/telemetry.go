@@ -0,0 +1,106 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/openfaas/faas-netes/pkg/k8s"
+	types "github.com/openfaas/faas-provider/types"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	v1 "k8s.io/client-go/listers/apps/v1"
+	klog "k8s.io/klog"
+	metricsv1beta1 "k8s.io/metrics/pkg/client/clientset/versioned/typed/metrics/v1beta1"
+)
+
+// MakeTelemetryHandler reports the CPU and memory used by each function in a namespace,
+// aggregated over all of its pods, as read from the metrics.k8s.io API.
+func MakeTelemetryHandler(namespaces *k8s.Namespaces, deploymentLister v1.DeploymentLister, metrics metricsv1beta1.PodMetricsesGetter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		q := r.URL.Query()
+		namespace := q.Get("namespace")
+
+		lookupNamespace := namespaces.DefaultNamespace
+
+		if len(namespace) > 0 {
+			lookupNamespace = namespace
+		}
+
+		if err := namespaces.Validate(lookupNamespace); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		functions, err := getServiceList(lookupNamespace, deploymentLister)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if err := addFunctionUsage(r.Context(), metrics, lookupNamespace, functions); err != nil {
+			log.Printf("Unable to read function usage: %s", err.Error())
+			http.Error(w, "Unable to read function usage", http.StatusServiceUnavailable)
+			return
+		}
+
+		functionBytes, err := json.Marshal(functions)
+		if err != nil {
+			klog.Errorf("Failed to marshal functions: %s", err.Error())
+			http.Error(w, "Failed to marshal functions", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(functionBytes)
+	}
+}
+
+// usageRequested returns true when the caller opted into usage with ?usage=true
+func usageRequested(r *http.Request) bool {
+	v, _ := strconv.ParseBool(r.URL.Query().Get("usage"))
+	return v
+}
+
+// addFunctionUsage sets the Usage of each function from the metrics of its pods.
+// Functions without any pod metrics, i.e. when scaled to zero, are left unchanged.
+func addFunctionUsage(ctx context.Context, metrics metricsv1beta1.PodMetricsesGetter, namespace string, functions []types.FunctionStatus) error {
+	if metrics == nil || len(functions) == 0 {
+		return nil
+	}
+
+	var sel labels.Selector
+	if len(functions) == 1 {
+		sel = labels.SelectorFromSet(labels.Set{"faas_function": functions[0].Name})
+	} else {
+		req, err := labels.NewRequirement("faas_function", selection.Exists, []string{})
+		if err != nil {
+			return err
+		}
+		sel = labels.NewSelector().Add(*req)
+	}
+
+	list, err := metrics.PodMetricses(namespace).List(ctx, metav1.ListOptions{LabelSelector: sel.String()})
+	if err != nil {
+		return fmt.Errorf("unable to query pod metrics in %s: %s", namespace, err.Error())
+	}
+
+	usage := k8s.AggregateFunctionUsage(list.Items)
+	for i := range functions {
+		if u, ok := usage[functions[i].Name]; ok {
+			functions[i].Usage = u
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,231 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/openfaas/faas-netes/pkg/k8s"
+	types "github.com/openfaas/faas-provider/types"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+	metricsv1beta1 "k8s.io/metrics/pkg/apis/metrics/v1beta1"
+	metricsfake "k8s.io/metrics/pkg/client/clientset/versioned/fake"
+)
+
+// newFakePodMetrics returns a metrics clientset with the usage of the pods of two functions
+func newFakePodMetrics(t *testing.T) *metricsfake.Clientset {
+	t.Helper()
+
+	pod := func(function, cpu, memory string) *metricsv1beta1.PodMetrics {
+		return &metricsv1beta1.PodMetrics{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      function + "-1",
+				Namespace: "openfaas-fn",
+				Labels:    map[string]string{"faas_function": function},
+			},
+			Containers: []metricsv1beta1.ContainerMetrics{{
+				Name: function,
+				Usage: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse(cpu),
+					corev1.ResourceMemory: resource.MustParse(memory),
+				},
+			}},
+		}
+	}
+
+	client := metricsfake.NewSimpleClientset()
+
+	// PodMetrics are served as "pods", which the tracker does not guess from their kind
+	pods := metricsv1beta1.SchemeGroupVersion.WithResource("pods")
+	for _, item := range []*metricsv1beta1.PodMetrics{pod("nodeinfo", "10m", "20Mi"), pod("figlet", "100m", "1Gi")} {
+		if err := client.Tracker().Create(pods, item, item.Namespace); err != nil {
+			t.Fatalf("unable to add pod metrics: %s", err)
+		}
+	}
+
+	return client
+}
+
+func Test_TelemetryHandler(t *testing.T) {
+	lister := newTestDeploymentLister(t, "nodeinfo", "figlet", "idle")
+	handler := MakeTelemetryHandler(k8s.NewNamespaces("openfaas-fn", nil), lister, newFakePodMetrics(t).MetricsV1beta1())
+
+	req := httptest.NewRequest(http.MethodGet, "/system/telemetry", nil)
+	rr := httptest.NewRecorder()
+	handler(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want status: %d, got: %d, body: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	functions := []types.FunctionStatus{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &functions); err != nil {
+		t.Fatalf("unable to unmarshal response: %s", err)
+	}
+
+	usage := map[string]*types.FunctionUsage{}
+	for _, fn := range functions {
+		usage[fn.Name] = fn.Usage
+	}
+
+	if len(usage) != 3 {
+		t.Fatalf("want 3 functions, got: %d", len(usage))
+	}
+	if usage["nodeinfo"] == nil || usage["nodeinfo"].CPU != 10 {
+		t.Errorf("want nodeinfo CPU: 10, got: %v", usage["nodeinfo"])
+	}
+	if usage["figlet"] == nil || usage["figlet"].TotalMemoryBytes != 1024*1024*1024 {
+		t.Errorf("want figlet memory: %d, got: %v", 1024*1024*1024, usage["figlet"])
+	}
+	if usage["idle"] != nil {
+		t.Errorf("want no usage for idle, got: %v", usage["idle"])
+	}
+}
+
+func Test_TelemetryHandler_MetricsUnavailable(t *testing.T) {
+	lister := newTestDeploymentLister(t, "nodeinfo")
+
+	metrics := metricsfake.NewSimpleClientset()
+	metrics.PrependReactor("list", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, fmt.Errorf("the server could not find the requested resource")
+	})
+	handler := MakeTelemetryHandler(k8s.NewNamespaces("openfaas-fn", nil), lister, metrics.MetricsV1beta1())
+
+	req := httptest.NewRequest(http.MethodGet, "/system/telemetry", nil)
+	rr := httptest.NewRecorder()
+	handler(rr, req)
+
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("want status: %d, got: %d", http.StatusServiceUnavailable, rr.Code)
+	}
+}
+
+func Test_FunctionReader_Usage(t *testing.T) {
+	lister := newTestDeploymentLister(t, "nodeinfo")
+	handler := MakeFunctionReader(k8s.NewNamespaces("openfaas-fn", nil), lister, newFakePodMetrics(t).MetricsV1beta1(), nil)
+
+	cases := []struct {
+		name      string
+		url       string
+		wantUsage bool
+	}{
+		{"usage is opt-in", "/system/functions", false},
+		{"usage when requested", "/system/functions?usage=true", true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			handler(rr, httptest.NewRequest(http.MethodGet, tc.url, nil))
+
+			functions := []types.FunctionStatus{}
+			if err := json.Unmarshal(rr.Body.Bytes(), &functions); err != nil {
+				t.Fatalf("unable to unmarshal response: %s", err)
+			}
+
+			if len(functions) != 1 {
+				t.Fatalf("want 1 function, got: %d", len(functions))
+			}
+			if got := functions[0].Usage != nil; got != tc.wantUsage {
+				t.Errorf("want usage: %t, got: %t", tc.wantUsage, got)
+			}
+		})
+	}
+}
+
+func Test_ReplicaReader_Usage(t *testing.T) {
+	lister := newTestDeploymentLister(t, "nodeinfo", "figlet")
+	handler := MakeReplicaReader(k8s.NewNamespaces("openfaas-fn", nil), lister, newFakePodMetrics(t).MetricsV1beta1(), nil, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/system/function/nodeinfo?usage=true", nil)
+	req = mux.SetURLVars(req, map[string]string{"name": "nodeinfo"})
+	rr := httptest.NewRecorder()
+	handler(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want status: %d, got: %d", http.StatusOK, rr.Code)
+	}
+
+	function := types.FunctionStatus{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &function); err != nil {
+		t.Fatalf("unable to unmarshal response: %s", err)
+	}
+
+	if function.Usage == nil || function.Usage.CPU != 10 {
+		t.Errorf("want nodeinfo CPU: 10, got: %v", function.Usage)
+	}
+}
+
+func Test_ReplicaReader_CircuitStatus(t *testing.T) {
+	lister := newTestDeploymentLister(t, "nodeinfo")
+
+	breaker := k8s.NewCircuitBreaker()
+	for i := 0; i < breaker.ConsecutiveFailures; i++ {
+		breaker.Record("nodeinfo.openfaas-fn", "10.0.0.1:8080", http.StatusBadGateway, nil, time.Millisecond)
+	}
+
+	handler := MakeReplicaReader(k8s.NewNamespaces("openfaas-fn", nil), lister, nil, nil, breaker)
+
+	req := httptest.NewRequest(http.MethodGet, "/system/function/nodeinfo", nil)
+	req = mux.SetURLVars(req, map[string]string{"name": "nodeinfo"})
+	rr := httptest.NewRecorder()
+	handler(rr, req)
+
+	function := types.FunctionStatus{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &function); err != nil {
+		t.Fatalf("unable to unmarshal response: %s", err)
+	}
+
+	if function.Annotations == nil {
+		t.Fatalf("want annotations with the circuit status")
+	}
+	annotations := *function.Annotations
+
+	if got := annotations[k8s.CircuitStateAnnotation]; got != k8s.CircuitClosed {
+		t.Errorf("want: %s, got: %s", k8s.CircuitClosed, got)
+	}
+	if got := annotations[k8s.EjectedEndpointsAnnotation]; got != "10.0.0.1:8080" {
+		t.Errorf("want: %s, got: %s", "10.0.0.1:8080", got)
+	}
+}
+
+func Test_FunctionReader_InvocationCount(t *testing.T) {
+	lister := newTestDeploymentLister(t, "nodeinfo", "figlet")
+
+	tracker := k8s.NewInvocationTracker("openfaas-fn")
+	tracker.Add("nodeinfo", "openfaas-fn", http.StatusOK)
+	tracker.Add("nodeinfo", "openfaas-fn", http.StatusInternalServerError)
+
+	handler := MakeFunctionReader(k8s.NewNamespaces("openfaas-fn", nil), lister, nil, tracker)
+
+	rr := httptest.NewRecorder()
+	handler(rr, httptest.NewRequest(http.MethodGet, "/system/functions", nil))
+
+	functions := []types.FunctionStatus{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &functions); err != nil {
+		t.Fatalf("unable to unmarshal response: %s", err)
+	}
+
+	counts := map[string]float64{}
+	for _, fn := range functions {
+		counts[fn.Name] = fn.InvocationCount
+	}
+
+	if counts["nodeinfo"] != 2 {
+		t.Errorf("want nodeinfo invocations: 2, got: %f", counts["nodeinfo"])
+	}
+	if counts["figlet"] != 0 {
+		t.Errorf("want figlet invocations: 0, got: %f", counts["figlet"])
+	}
+}
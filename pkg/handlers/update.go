@@ -14,12 +14,17 @@ import (
 
 	"github.com/openfaas/faas-netes/pkg/k8s"
 	types "github.com/openfaas/faas-provider/types"
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
-// MakeUpdateHandler update specified function
-func MakeUpdateHandler(defaultNamespace string, factory k8s.FunctionFactory) http.HandlerFunc {
+// MakeUpdateHandler update specified function, with "wait=<duration>" the response
+// is sent once the rollout completes or fails. With "canary=<weight>" the update is
+// deployed as a canary of the function, which is sent weight percent of its requests.
+// A canary runs the replicas the function had when it was deployed and is not scaled
+// with it, so it is refused for functions which scale to zero or by an HPA.
+func MakeUpdateHandler(namespaces *k8s.Namespaces, factory k8s.FunctionFactory, rollouts *k8s.RolloutWatcher) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 		if r.Body != nil {
@@ -36,46 +41,81 @@ func MakeUpdateHandler(defaultNamespace string, factory k8s.FunctionFactory) htt
 			return
 		}
 
-		if err := ValidateDeployRequest(&request); err != nil {
+		if err := ValidateDeployRequest(&request, factory.Config); err != nil {
 			wrappedErr := fmt.Errorf("validation failed: %s", err.Error())
 			http.Error(w, wrappedErr.Error(), http.StatusBadRequest)
 			return
 		}
 
-		lookupNamespace := defaultNamespace
+		lookupNamespace := namespaces.DefaultNamespace
 		if len(request.Namespace) > 0 {
 			lookupNamespace = request.Namespace
 		}
 
-		if lookupNamespace != defaultNamespace {
-			http.Error(w, fmt.Sprintf("namespace must be: %s", defaultNamespace), http.StatusBadRequest)
+		if err := namespaces.Validate(lookupNamespace); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		if lookupNamespace == "kube-system" {
-			http.Error(w, "unable to list within the kube-system namespace", http.StatusUnauthorized)
+		dryRun, err := dryRunRequested(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		annotations, err := buildAnnotations(request)
+		wait, err := waitRequested(r, rollouts)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		if status, err := updateDeploymentSpec(ctx, lookupNamespace, factory, request, annotations); err != nil {
-			if !k8s.IsNotFound(err) {
-				log.Printf("error updating deployment: %s.%s, error: %s\n", request.Service, lookupNamespace, err)
+		weight, canary, err := canaryRequested(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 
+		if canary {
+			deployment, service, status, err := deployCanary(ctx, lookupNamespace, factory, request, weight, dryRun)
+			if err != nil {
+				if !k8s.IsNotFound(err) {
+					log.Printf("error deploying canary: %s.%s, error: %s\n", request.Service, lookupNamespace, err)
+				}
+
+				wrappedErr := fmt.Errorf("unable to deploy canary: %s.%s, error: %s", request.Service, lookupNamespace, err.Error())
+				http.Error(w, wrappedErr.Error(), status)
 				return
 			}
 
+			if dryRun {
+				writeDryRun(w, r, deployment, service)
+				return
+			}
+
+			log.Printf("Canary deployed: %s.%s with weight: %d%%\n", request.Service, lookupNamespace, weight)
+
+			if wait > 0 {
+				waitForRollout(w, r, rollouts, lookupNamespace, deployment.Name, deployment.Generation, wait, http.StatusAccepted)
+				return
+			}
+
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+
+		deployment, status, err := updateDeploymentSpec(ctx, lookupNamespace, factory, request, dryRun)
+		if err != nil {
+			if !k8s.IsNotFound(err) {
+				log.Printf("error updating deployment: %s.%s, error: %s\n", request.Service, lookupNamespace, err)
+			}
+
 			wrappedErr := fmt.Errorf("unable update Deployment: %s.%s, error: %s", request.Service, lookupNamespace, err.Error())
 			http.Error(w, wrappedErr.Error(), status)
 			return
 		}
 
-		if status, err := updateService(lookupNamespace, factory, request, annotations); err != nil {
+		service, status, err := updateService(ctx, lookupNamespace, factory, request, dryRun)
+		if err != nil {
 			if !k8s.IsNotFound(err) {
 				log.Printf("error updating service: %s.%s, error: %s\n", request.Service, lookupNamespace, err)
 			}
@@ -85,6 +125,25 @@ func MakeUpdateHandler(defaultNamespace string, factory k8s.FunctionFactory) htt
 			return
 		}
 
+		if err := syncHPA(ctx, factory, lookupNamespace, request, deployment, dryRun); err != nil {
+			log.Printf("error updating HPA: %s.%s, error: %s\n", request.Service, lookupNamespace, err)
+
+			status, _ := ProcessErrorReasons(err)
+			wrappedErr := fmt.Errorf("unable update HPA: %s.%s, error: %s", request.Service, lookupNamespace, err.Error())
+			http.Error(w, wrappedErr.Error(), status)
+			return
+		}
+
+		if dryRun {
+			writeDryRun(w, r, deployment, service)
+			return
+		}
+
+		if wait > 0 {
+			waitForRollout(w, r, rollouts, lookupNamespace, request.Service, deployment.Generation, wait, http.StatusAccepted)
+			return
+		}
+
 		w.WriteHeader(http.StatusAccepted)
 	}
 }
@@ -94,118 +153,118 @@ func updateDeploymentSpec(
 	functionNamespace string,
 	factory k8s.FunctionFactory,
 	request types.FunctionDeployment,
-	annotations map[string]string) (int, error) {
+	dryRun bool) (*appsv1.Deployment, int, error) {
 
 	getOpts := metav1.GetOptions{}
 
 	deployment, findDeployErr := factory.Client.AppsV1().
 		Deployments(functionNamespace).
-		Get(context.TODO(), request.Service, getOpts)
+		Get(ctx, request.Service, getOpts)
 
 	if findDeployErr != nil {
-		return http.StatusNotFound, findDeployErr
+		return nil, http.StatusNotFound, findDeployErr
 	}
 
 	if err := isAnonymous(request.Image); err != nil {
-		return http.StatusBadRequest, err
+		return nil, http.StatusBadRequest, err
 	}
 
-	if len(deployment.Spec.Template.Spec.Containers) > 0 {
-		deployment.Spec.Template.Spec.Containers[0].Image = request.Image
-
-		deployment.Spec.Template.Spec.Containers[0].ImagePullPolicy = corev1.PullAlways
-
-		deployment.Spec.Template.Spec.Containers[0].Env = buildEnvVars(&request)
-
-		factory.ConfigureReadOnlyRootFilesystem(request, deployment)
-		factory.ConfigureContainerUserID(deployment)
-		deployment.Spec.Template.Spec.NodeSelector = map[string]string{}
-
-		labels := map[string]string{
-			"faas_function": request.Service,
-			"uid":           fmt.Sprintf("%d", time.Now().Nanosecond()),
-		}
+	secrets := k8s.NewSecretsClient(factory.Client)
+	existingSecrets, err := secrets.GetSecrets(functionNamespace, request.Secrets)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
 
-		if request.Labels != nil {
-			if min := getMinReplicaCount(*request.Labels); min != nil {
-				deployment.Spec.Replicas = min
-			}
+	desired, err := makeDeploymentSpec(request, existingSecrets, factory)
+	if err != nil {
+		log.Println(err)
+		return nil, http.StatusBadRequest, err
+	}
 
-			for k, v := range *request.Labels {
-				labels[k] = v
+	// Force a new rollout, even when the image tag has not changed
+	desired.Spec.Template.Labels["uid"] = fmt.Sprintf("%d", time.Now().Nanosecond())
+
+	// The replicas are set by the scaler or an HPA, so they are only applied to raise
+	// them to the minimum, a function which was scaled to zero is left to be scaled up
+	// by its next request, and the HPA of a function raises them to its own minimum
+	desired.Spec.Replicas = nil
+	scaledToZero := deployment.Spec.Replicas != nil && *deployment.Spec.Replicas == 0 &&
+		factory.Config.ScaleToZero && k8s.ScaleToZeroEnabled(desired.Spec.Template.Labels)
+	scaledByHPA := factory.Config.HPA && k8s.HPAEnabled(desired.Spec.Template.Labels)
+	if request.Labels != nil && !scaledToZero && !scaledByHPA {
+		if min := getMinReplicaCount(*request.Labels); min != nil {
+			if deployment.Spec.Replicas == nil || *deployment.Spec.Replicas < *min {
+				desired.Spec.Replicas = min
 			}
 		}
+	}
 
-		// deployment.Labels = labels
-		deployment.Spec.Template.ObjectMeta.Labels = labels
-
-		deployment.Annotations = annotations
-		deployment.Spec.Template.Annotations = annotations
-		deployment.Spec.Template.ObjectMeta.Annotations = annotations
-
-		resources, resourceErr := createResources(request)
-		if resourceErr != nil {
-			return http.StatusBadRequest, resourceErr
-		}
-
-		deployment.Spec.Template.Spec.Containers[0].Resources = *resources
-
-		secrets := k8s.NewSecretsClient(factory.Client)
-		existingSecrets, err := secrets.GetSecrets(functionNamespace, request.Secrets)
-		if err != nil {
-			return http.StatusBadRequest, err
-		}
-
-		err = factory.ConfigureSecrets(request, deployment, existingSecrets)
-		if err != nil {
-			log.Println(err)
-			return http.StatusBadRequest, err
-		}
-
-		probes, err := factory.MakeProbes(request)
+	// A dry-run must not change the Deployment, so its fields are upgraded by the
+	// next update instead
+	if dryRun {
+		applied, err := k8s.DryRunApplyDeployment(ctx, factory.Client, functionNamespace, desired, deployment)
 		if err != nil {
-			return http.StatusBadRequest, err
+			status, _ := ProcessErrorReasons(err)
+			return nil, status, err
 		}
-
-		deployment.Spec.Template.Spec.Containers[0].LivenessProbe = probes.Liveness
-		deployment.Spec.Template.Spec.Containers[0].ReadinessProbe = probes.Readiness
-
+		return applied, http.StatusAccepted, nil
 	}
 
-	if _, updateErr := factory.Client.AppsV1().
-		Deployments(functionNamespace).
-		Update(context.TODO(), deployment, metav1.UpdateOptions{}); updateErr != nil {
+	if err := k8s.UpgradeDeploymentManagedFields(ctx, factory.Client, deployment); err != nil {
+		status, _ := ProcessErrorReasons(err)
+		return nil, status, err
+	}
 
-		return http.StatusInternalServerError, updateErr
+	applied, err := k8s.ApplyDeployment(ctx, factory.Client, functionNamespace, desired, false)
+	if err != nil {
+		status, _ := ProcessErrorReasons(err)
+		return nil, status, err
 	}
 
-	return http.StatusAccepted, nil
+	return applied, http.StatusAccepted, nil
 }
 
 func updateService(
+	ctx context.Context,
 	functionNamespace string,
 	factory k8s.FunctionFactory,
 	request types.FunctionDeployment,
-	annotations map[string]string) (int, error) {
+	dryRun bool) (*corev1.Service, int, error) {
 
 	getOpts := metav1.GetOptions{}
 
 	service, findServiceErr := factory.Client.CoreV1().
 		Services(functionNamespace).
-		Get(context.TODO(), request.Service, getOpts)
+		Get(ctx, request.Service, getOpts)
 
 	if findServiceErr != nil {
-		return http.StatusNotFound, findServiceErr
+		return nil, http.StatusNotFound, findServiceErr
 	}
 
-	service.Annotations = annotations
+	desired, err := makeServiceSpec(request, factory)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
 
-	if _, updateErr := factory.Client.CoreV1().
-		Services(functionNamespace).
-		Update(context.TODO(), service, metav1.UpdateOptions{}); updateErr != nil {
+	if dryRun {
+		applied, err := k8s.DryRunApplyService(ctx, factory.Client, functionNamespace, desired, service)
+		if err != nil {
+			status, _ := ProcessErrorReasons(err)
+			return nil, status, err
+		}
+		return applied, http.StatusAccepted, nil
+	}
+
+	if err := k8s.UpgradeServiceManagedFields(ctx, factory.Client, service); err != nil {
+		status, _ := ProcessErrorReasons(err)
+		return nil, status, err
+	}
 
-		return http.StatusInternalServerError, updateErr
+	applied, err := k8s.ApplyService(ctx, factory.Client, functionNamespace, desired, false)
+	if err != nil {
+		status, _ := ProcessErrorReasons(err)
+		return nil, status, err
 	}
 
-	return http.StatusAccepted, nil
+	return applied, http.StatusAccepted, nil
 }
@@ -0,0 +1,267 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	faasv1 "github.com/openfaas/faas-netes/pkg/apis/openfaas/v1"
+	faaslisters "github.com/openfaas/faas-netes/pkg/client/listers/openfaas/v1"
+	"github.com/openfaas/faas-netes/pkg/k8s"
+	types "github.com/openfaas/faas-provider/types"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	appsv1apply "k8s.io/client-go/applyconfigurations/apps/v1"
+	corev1apply "k8s.io/client-go/applyconfigurations/core/v1"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+)
+
+func update(handler http.HandlerFunc, request types.FunctionDeployment) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(request)
+
+	rr := httptest.NewRecorder()
+	handler(rr, httptest.NewRequest(http.MethodPut, "/system/functions", strings.NewReader(string(body))))
+	return rr
+}
+
+func Test_MakeUpdateHandler_KeepsFieldsOfOtherManagers(t *testing.T) {
+	f := newTestFixture()
+	f.createFunction(t, figletFunction("1.0"))
+	handler := f.updateHandler(t)
+	ctx := context.Background()
+
+	// A sidecar injected by another controller
+	sidecar := appsv1apply.Deployment("figlet", "openfaas-fn").
+		WithSpec(appsv1apply.DeploymentSpec().
+			WithTemplate(corev1apply.PodTemplateSpec().
+				WithSpec(corev1apply.PodSpec().
+					WithContainers(corev1apply.Container().WithName("proxy").WithImage("envoy:1.0")))))
+	if _, err := f.kube.AppsV1().Deployments("openfaas-fn").Apply(ctx, sidecar, metav1.ApplyOptions{FieldManager: "mesh-injector"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// The replicas set by an autoscaler
+	scaled, _ := f.kube.AppsV1().Deployments("openfaas-fn").Get(ctx, "figlet", metav1.GetOptions{})
+	scaled.Spec.Replicas = int32p(4)
+	if _, err := f.kube.AppsV1().Deployments("openfaas-fn").Update(ctx, scaled, metav1.UpdateOptions{FieldManager: "autoscaler"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	rr := update(handler, types.FunctionDeployment{
+		Service:     "figlet",
+		Image:       "localhost:5000/figlet:2.0",
+		Namespace:   "openfaas-fn",
+		Labels:      &map[string]string{"com.openfaas.scale.min": "2"},
+		Annotations: &map[string]string{k8s.PortAnnotation: "3000"},
+	})
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("want: %d, got: %d, body: %s", http.StatusAccepted, rr.Code, rr.Body.String())
+	}
+
+	got, _ := f.kube.AppsV1().Deployments("openfaas-fn").Get(ctx, "figlet", metav1.GetOptions{})
+
+	images := map[string]string{}
+	for _, c := range got.Spec.Template.Spec.Containers {
+		images[c.Name] = c.Image
+	}
+	if images["figlet"] != "localhost:5000/figlet:2.0" {
+		t.Errorf("want the new image, got: %s", images["figlet"])
+	}
+	if images["proxy"] != "envoy:1.0" {
+		t.Errorf("want the sidecar to be kept, got containers: %v", images)
+	}
+
+	if got.Spec.Replicas == nil || *got.Spec.Replicas != 4 {
+		t.Errorf("want the autoscaler's replicas: 4, got: %v", got.Spec.Replicas)
+	}
+
+	service, _ := f.kube.CoreV1().Services("openfaas-fn").Get(ctx, "figlet", metav1.GetOptions{})
+	if port := service.Spec.Ports[0].TargetPort.IntValue(); port != 3000 {
+		t.Errorf("want the Service to target port 3000, got: %d", port)
+	}
+}
+
+func Test_MakeUpdateHandler_RemovesDroppedFields(t *testing.T) {
+	f := newTestFixture()
+	f.createFunction(t, figletFunction("1.0"))
+	handler := f.updateHandler(t)
+
+	rr := update(handler, types.FunctionDeployment{
+		Service:   "figlet",
+		Image:     "localhost:5000/figlet:1.0",
+		Namespace: "openfaas-fn",
+		EnvVars:   map[string]string{"write_debug": "true"},
+	})
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("want: %d, got: %d, body: %s", http.StatusAccepted, rr.Code, rr.Body.String())
+	}
+
+	rr = update(handler, types.FunctionDeployment{
+		Service:   "figlet",
+		Image:     "localhost:5000/figlet:1.0",
+		Namespace: "openfaas-fn",
+	})
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("want: %d, got: %d, body: %s", http.StatusAccepted, rr.Code, rr.Body.String())
+	}
+
+	got, _ := f.kube.AppsV1().Deployments("openfaas-fn").Get(context.Background(), "figlet", metav1.GetOptions{})
+	if env := got.Spec.Template.Spec.Containers[0].Env; len(env) != 0 {
+		t.Errorf("want the removed env-var to be removed, got: %v", env)
+	}
+}
+
+func Test_MakeUpdateHandler_ConflictIsReported(t *testing.T) {
+	f := newTestFixture()
+	f.createFunction(t, figletFunction("1.0"))
+	handler := f.updateHandler(t)
+	ctx := context.Background()
+
+	// Take the first update so that faas-netes applies the image
+	if rr := update(handler, types.FunctionDeployment{Service: "figlet", Image: "localhost:5000/figlet:1.0", Namespace: "openfaas-fn"}); rr.Code != http.StatusAccepted {
+		t.Fatalf("want: %d, got: %d, body: %s", http.StatusAccepted, rr.Code, rr.Body.String())
+	}
+
+	pinned := appsv1apply.Deployment("figlet", "openfaas-fn").
+		WithSpec(appsv1apply.DeploymentSpec().
+			WithTemplate(corev1apply.PodTemplateSpec().
+				WithSpec(corev1apply.PodSpec().
+					WithContainers(corev1apply.Container().WithName("figlet").WithImage("localhost:5000/figlet:pinned")))))
+	if _, err := f.kube.AppsV1().Deployments("openfaas-fn").Apply(ctx, pinned, metav1.ApplyOptions{FieldManager: "gitops", Force: true}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	rr := update(handler, types.FunctionDeployment{Service: "figlet", Image: "localhost:5000/figlet:2.0", Namespace: "openfaas-fn"})
+	if rr.Code != http.StatusConflict {
+		t.Errorf("want: %d, got: %d, body: %s", http.StatusConflict, rr.Code, rr.Body.String())
+	}
+}
+
+func Test_MakeUpdateHandler_RemovesProfile(t *testing.T) {
+	f := newTestFixture()
+	f.factory.Config.ProfilesNamespace = "openfaas"
+
+	profiles := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	profiles.Add(&faasv1.Profile{
+		ObjectMeta: metav1.ObjectMeta{Name: "spot", Namespace: "openfaas"},
+		Spec: faasv1.ProfileSpec{
+			Tolerations: []corev1.Toleration{{Key: "spot", Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoSchedule}},
+			Affinity: &corev1.Affinity{
+				NodeAffinity: &corev1.NodeAffinity{
+					RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+						NodeSelectorTerms: []corev1.NodeSelectorTerm{{
+							MatchExpressions: []corev1.NodeSelectorRequirement{{Key: "node.kubernetes.io/lifecycle", Operator: corev1.NodeSelectorOpIn, Values: []string{"spot"}}},
+						}},
+					},
+				},
+			},
+		},
+	})
+	f.factory.Profiles = faaslisters.NewProfileLister(profiles)
+
+	request := figletFunction("1.0")
+	f.createFunction(t, request)
+	handler := f.updateHandler(t)
+	ctx := context.Background()
+
+	request.Annotations = &map[string]string{k8s.ProfileAnnotationKey: "spot"}
+	if rr := update(handler, request); rr.Code != http.StatusAccepted {
+		t.Fatalf("want: %d, got: %d, body: %s", http.StatusAccepted, rr.Code, rr.Body.String())
+	}
+
+	got, _ := f.kube.AppsV1().Deployments("openfaas-fn").Get(ctx, "figlet", metav1.GetOptions{})
+	if len(got.Spec.Template.Spec.Tolerations) != 1 || got.Spec.Template.Spec.Affinity == nil {
+		t.Fatalf("want the Profile to be applied, got tolerations: %v, affinity: %v", got.Spec.Template.Spec.Tolerations, got.Spec.Template.Spec.Affinity)
+	}
+
+	request.Annotations = nil
+	if rr := update(handler, request); rr.Code != http.StatusAccepted {
+		t.Fatalf("want: %d, got: %d, body: %s", http.StatusAccepted, rr.Code, rr.Body.String())
+	}
+
+	got, _ = f.kube.AppsV1().Deployments("openfaas-fn").Get(ctx, "figlet", metav1.GetOptions{})
+	if tolerations := got.Spec.Template.Spec.Tolerations; len(tolerations) != 0 {
+		t.Errorf("want the Profile's tolerations to be removed, got: %v", tolerations)
+	}
+	if affinity := got.Spec.Template.Spec.Affinity; affinity != nil {
+		t.Errorf("want the Profile's affinity to be removed, got: %v", affinity)
+	}
+}
+
+func Test_MakeUpdateHandler_KeepsReplicas(t *testing.T) {
+	f := newTestFixture()
+	f.createFunction(t, figletFunction("1.0"))
+	handler := f.updateHandler(t)
+	ctx := context.Background()
+
+	cases := []struct {
+		name   string
+		labels *map[string]string
+		want   int32
+	}{
+		{name: "raised to the minimum", labels: &map[string]string{"com.openfaas.scale.min": "3"}, want: 3},
+		{name: "at the minimum", labels: &map[string]string{"com.openfaas.scale.min": "3"}, want: 3},
+		{name: "without a minimum", want: 3},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := update(handler, types.FunctionDeployment{Service: "figlet", Image: "localhost:5000/figlet:1.0", Namespace: "openfaas-fn", Labels: tc.labels})
+			if rr.Code != http.StatusAccepted {
+				t.Fatalf("want: %d, got: %d, body: %s", http.StatusAccepted, rr.Code, rr.Body.String())
+			}
+
+			got, _ := f.kube.AppsV1().Deployments("openfaas-fn").Get(ctx, "figlet", metav1.GetOptions{})
+			if got.Spec.Replicas == nil || *got.Spec.Replicas != tc.want {
+				t.Errorf("want: %d, got: %v", tc.want, got.Spec.Replicas)
+			}
+		})
+	}
+}
+
+func Test_MakeUpdateHandler_RetriesManagedFieldsConflict(t *testing.T) {
+	cases := []struct {
+		name       string
+		conflicts  int
+		wantStatus int
+	}{
+		{name: "a single conflict is retried", conflicts: 1, wantStatus: http.StatusAccepted},
+		{name: "a conflict which persists is reported", conflicts: 100, wantStatus: http.StatusConflict},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newTestFixture()
+			f.createFunction(t, figletFunction("1.0"))
+			handler := f.updateHandler(t)
+
+			// Another writer changes the Deployment between the GET and the patch
+			// of its managedFields
+			conflicts := tc.conflicts
+			f.kube.PrependReactor("patch", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				if action.(k8stesting.PatchAction).GetPatchType() != apitypes.JSONPatchType || conflicts == 0 {
+					return false, nil, nil
+				}
+				conflicts--
+				return true, nil, k8serrors.NewConflict(schema.GroupResource{Group: "apps", Resource: "deployments"}, "figlet", fmt.Errorf("the object has been modified"))
+			})
+
+			rr := update(handler, types.FunctionDeployment{Service: "figlet", Image: "localhost:5000/figlet:2.0", Namespace: "openfaas-fn"})
+			if rr.Code != tc.wantStatus {
+				t.Errorf("want: %d, got: %d, body: %s", tc.wantStatus, rr.Code, rr.Body.String())
+			}
+		})
+	}
+}
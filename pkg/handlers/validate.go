@@ -17,6 +17,7 @@ import (
 	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/name"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/openfaas/faas-netes/pkg/k8s"
 	types "github.com/openfaas/faas-provider/types"
 )
 
@@ -35,8 +36,9 @@ func validateService(service string) error {
 	return fmt.Errorf("service: (%s) is invalid, must be a valid DNS entry", service)
 }
 
-// ValidateDeployRequest validates that the service name is valid for Kubernetes
-func ValidateDeployRequest(request *types.FunctionDeployment) error {
+// ValidateDeployRequest validates that the service name is valid for Kubernetes, and
+// that the function only uses the scaling labels enabled in config
+func ValidateDeployRequest(request *types.FunctionDeployment, config k8s.DeploymentConfig) error {
 
 	if request.Service == "" {
 		return fmt.Errorf("service: is required")
@@ -51,34 +53,134 @@ func ValidateDeployRequest(request *types.FunctionDeployment) error {
 		return fmt.Errorf("image: is required")
 	}
 
-	if err := validateScalingLabels(request); err != nil {
+	if err := validateScalingLabels(request, config); err != nil {
+		return err
+	}
+
+	if err := validateConstraints(request); err != nil {
+		return err
+	}
+
+	if err := validateLoadBalancer(request); err != nil {
+		return err
+	}
+
+	if err := validatePort(request); err != nil {
+		return err
+	}
+
+	if err := validatePolicy(request); err != nil {
+		return err
+	}
+
+	if err := validateLimits(request); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func validateScalingLabels(request *types.FunctionDeployment) error {
-	if request.Labels == nil {
+// validateLoadBalancer checks the load balancing strategy given by annotation
+func validateLoadBalancer(request *types.FunctionDeployment) error {
+	if request.Annotations == nil {
 		return nil
 	}
 
-	labels := *request.Labels
-	if v, ok := labels["com.openfaas.scale.zero"]; ok {
-		if v == "true" {
-			return fmt.Errorf("com.openfaas.scale.zero not available for Community Edition")
+	if v, ok := (*request.Annotations)[k8s.LoadBalancerAnnotation]; ok {
+		if err := k8s.ValidateLoadBalancer(v); err != nil {
+			return fmt.Errorf("%s: %s", k8s.LoadBalancerAnnotation, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// validateConstraints checks that each constraint can be converted into a
+// node selector or node affinity
+func validateConstraints(request *types.FunctionDeployment) error {
+	if _, _, err := k8s.ParseConstraints(request.Constraints); err != nil {
+		return fmt.Errorf("constraints: %s", err.Error())
+	}
+
+	return nil
+}
+
+// validatePort checks the port given by annotation is within the range 1-65535
+func validatePort(request *types.FunctionDeployment) error {
+	if request.Annotations == nil {
+		return nil
+	}
+
+	if v, ok := (*request.Annotations)[k8s.PortAnnotation]; ok {
+		if _, err := k8s.ParsePort(v); err != nil {
+			return fmt.Errorf("%s: %s", k8s.PortAnnotation, err.Error())
 		}
 	}
-	if _, ok := labels["com.openfaas.scale.zero-duration"]; ok {
-		return fmt.Errorf("com.openfaas.scale.zero-duration not available for Community Edition")
+
+	return nil
+}
+
+// validatePolicy checks the timeout and retries given by annotation
+func validatePolicy(request *types.FunctionDeployment) error {
+	if request.Annotations == nil {
+		return nil
+	}
+
+	if _, err := k8s.ParsePolicy(*request.Annotations); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// validateLimits checks the rate limit and the requests in flight given by annotation
+func validateLimits(request *types.FunctionDeployment) error {
+	if request.Annotations == nil {
+		return nil
+	}
+
+	if _, err := k8s.ParseLimits(*request.Annotations); err != nil {
+		return err
 	}
 
-	if _, ok := labels["com.openfaas.scale.target"]; ok {
-		return fmt.Errorf("com.openfaas.scale.target not available for Community Edition")
+	return nil
+}
+
+func validateScalingLabels(request *types.FunctionDeployment, config k8s.DeploymentConfig) error {
+	if request.Labels == nil {
+		return nil
 	}
 
-	if _, ok := labels["com.openfaas.scale.type"]; ok {
-		return fmt.Errorf("com.openfaas.scale.type not available for Community Edition")
+	labels := *request.Labels
+	if config.ScaleToZero {
+		if v, ok := labels[k8s.ScaleToZeroDurationLabel]; ok {
+			if _, err := types.ParseIntOrDuration(v); err != nil {
+				return fmt.Errorf("%s: %s", k8s.ScaleToZeroDurationLabel, err.Error())
+			}
+		}
+	} else {
+		if v, ok := labels["com.openfaas.scale.zero"]; ok {
+			if v == "true" {
+				return fmt.Errorf("com.openfaas.scale.zero not available for Community Edition")
+			}
+		}
+		if _, ok := labels["com.openfaas.scale.zero-duration"]; ok {
+			return fmt.Errorf("com.openfaas.scale.zero-duration not available for Community Edition")
+		}
+	}
+
+	if config.HPA {
+		if err := validateHPA(request); err != nil {
+			return err
+		}
+	} else {
+		if _, ok := labels["com.openfaas.scale.target"]; ok {
+			return fmt.Errorf("com.openfaas.scale.target not available for Community Edition")
+		}
+
+		if _, ok := labels["com.openfaas.scale.type"]; ok {
+			return fmt.Errorf("com.openfaas.scale.type not available for Community Edition")
+		}
 	}
 
 	if v, ok := labels["com.openfaas.scale.max"]; ok {
@@ -100,6 +202,44 @@ func validateScalingLabels(request *types.FunctionDeployment) error {
 	return nil
 }
 
+// validateHPA checks the labels of a function which is scaled by a
+// HorizontalPodAutoscaler, whose utilization target is relative to the function's
+// requests, so the requests of its resource have to be set
+func validateHPA(request *types.FunctionDeployment) error {
+	labels := *request.Labels
+
+	scaleType, ok := labels[k8s.ScaleTypeLabel]
+	if !ok {
+		if _, ok := labels[k8s.ScaleTargetLabel]; ok {
+			return fmt.Errorf("%s: requires the %s label", k8s.ScaleTargetLabel, k8s.ScaleTypeLabel)
+		}
+		return nil
+	}
+
+	if err := k8s.ValidateHPA(labels); err != nil {
+		return err
+	}
+
+	if k8s.ScaleToZeroEnabled(labels) {
+		return fmt.Errorf("%s: cannot be combined with %s", k8s.ScaleTypeLabel, k8s.ScaleToZeroLabel)
+	}
+
+	var requested string
+	if request.Requests != nil {
+		if scaleType == "cpu" {
+			requested = request.Requests.CPU
+		} else {
+			requested = request.Requests.Memory
+		}
+	}
+
+	if len(requested) == 0 {
+		return fmt.Errorf("%s: requests.%s is required to scale on %s", k8s.ScaleTypeLabel, scaleType, scaleType)
+	}
+
+	return nil
+}
+
 // OpenFaaS CE license
 // This code is licensed under the OpenFaaS Community Edition (CE) EULA
 // A license is required to use public images, however a registry on localhost is supported
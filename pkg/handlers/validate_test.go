@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"testing"
 
+	"github.com/openfaas/faas-netes/pkg/k8s"
 	"github.com/openfaas/faas-provider/types"
 )
 
@@ -92,7 +93,7 @@ func Test_validateScalingLabels(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.Name, func(t *testing.T) {
-			gotErr := validateScalingLabels(&types.FunctionDeployment{Labels: &tc.Labels})
+			gotErr := validateScalingLabels(&types.FunctionDeployment{Labels: &tc.Labels}, k8s.DeploymentConfig{})
 			got := fmt.Errorf("")
 			if gotErr != nil {
 				got = gotErr
@@ -109,3 +110,164 @@ func Test_validateScalingLabels(t *testing.T) {
 	}
 
 }
+
+func Test_validateConstraints(t *testing.T) {
+	testCases := []struct {
+		Name        string
+		Constraints []string
+		Err         error
+	}{
+		{
+			Name:        "no constraints",
+			Constraints: nil,
+		},
+		{
+			Name:        "equality and set based constraints",
+			Constraints: []string{"node.kubernetes.io/instance-type=c5.large", "zone notin (a,b)"},
+		},
+		{
+			Name:        "invalid constraint",
+			Constraints: []string{"zone in a"},
+			Err:         fmt.Errorf(`constraints: invalid constraint "zone in a": unable to parse requirement: found 'a' expected: '('`),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			gotErr := validateConstraints(&types.FunctionDeployment{Constraints: tc.Constraints})
+			got := fmt.Errorf("")
+			if gotErr != nil {
+				got = gotErr
+			}
+			want := fmt.Errorf("")
+			if tc.Err != nil {
+				want = tc.Err
+			}
+
+			if got.Error() != want.Error() {
+				t.Errorf("got: %v, want: %v", got, want)
+			}
+		})
+	}
+}
+
+func Test_validateLoadBalancer(t *testing.T) {
+	cases := []struct {
+		name        string
+		annotations map[string]string
+		wantErr     bool
+	}{
+		{"no annotations", nil, false},
+		{"round-robin", map[string]string{"com.openfaas.loadbalancer": "round-robin"}, false},
+		{"consistent-hash", map[string]string{"com.openfaas.loadbalancer": "consistent-hash"}, false},
+		{"unknown strategy", map[string]string{"com.openfaas.loadbalancer": "fastest"}, true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			request := types.FunctionDeployment{Service: "figlet", Image: "ghcr.io/openfaas/figlet"}
+			if tc.annotations != nil {
+				request.Annotations = &tc.annotations
+			}
+
+			err := validateLoadBalancer(&request)
+			if tc.wantErr && err == nil {
+				t.Errorf("want error, got nil")
+			}
+			if !tc.wantErr && err != nil {
+				t.Errorf("want no error, got: %s", err)
+			}
+		})
+	}
+}
+
+func Test_validateScalingLabels_ScaleToZero(t *testing.T) {
+	cases := []struct {
+		name    string
+		labels  map[string]string
+		wantErr bool
+	}{
+		{name: "scale to zero", labels: map[string]string{"com.openfaas.scale.zero": "true", "com.openfaas.scale.zero-duration": "15m"}},
+		{name: "invalid duration", labels: map[string]string{"com.openfaas.scale.zero": "true", "com.openfaas.scale.zero-duration": "soon"}, wantErr: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateScalingLabels(&types.FunctionDeployment{Labels: &tc.labels}, k8s.DeploymentConfig{ScaleToZero: true})
+			if (err != nil) != tc.wantErr {
+				t.Errorf("want error: %v, got: %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func Test_validateScalingLabels_HPA(t *testing.T) {
+	cpu := &types.FunctionResources{CPU: "100m"}
+
+	cases := []struct {
+		name     string
+		labels   map[string]string
+		requests *types.FunctionResources
+		wantErr  bool
+	}{
+		{name: "cpu", labels: map[string]string{"com.openfaas.scale.type": "cpu", "com.openfaas.scale.target": "50"}, requests: cpu},
+		{name: "default target", labels: map[string]string{"com.openfaas.scale.type": "cpu"}, requests: cpu},
+		{name: "unsupported type", labels: map[string]string{"com.openfaas.scale.type": "rps"}, requests: cpu, wantErr: true},
+		{name: "invalid target", labels: map[string]string{"com.openfaas.scale.type": "cpu", "com.openfaas.scale.target": "0"}, requests: cpu, wantErr: true},
+		{name: "target without type", labels: map[string]string{"com.openfaas.scale.target": "50"}, wantErr: true},
+		{name: "missing requests", labels: map[string]string{"com.openfaas.scale.type": "memory"}, requests: cpu, wantErr: true},
+		{name: "max too high", labels: map[string]string{"com.openfaas.scale.type": "cpu", "com.openfaas.scale.max": "10"}, requests: cpu, wantErr: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateScalingLabels(&types.FunctionDeployment{Labels: &tc.labels, Requests: tc.requests}, k8s.DeploymentConfig{HPA: true})
+			if (err != nil) != tc.wantErr {
+				t.Errorf("want error: %v, got: %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func Test_validatePolicy(t *testing.T) {
+	cases := []struct {
+		name        string
+		annotations map[string]string
+		wantErr     bool
+	}{
+		{name: "no annotations"},
+		{name: "valid", annotations: map[string]string{"com.openfaas.timeout": "1m", "com.openfaas.retry.attempts": "2"}},
+		{name: "invalid attempts", annotations: map[string]string{"com.openfaas.retry.attempts": "-1"}, wantErr: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validatePolicy(&types.FunctionDeployment{Annotations: &tc.annotations})
+			if (err != nil) != tc.wantErr {
+				t.Errorf("want error: %v, got: %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func Test_validateLimits(t *testing.T) {
+	cases := []struct {
+		name        string
+		annotations map[string]string
+		wantErr     bool
+	}{
+		{name: "no annotations"},
+		{name: "valid", annotations: map[string]string{"com.openfaas.ratelimit": "100/m", "com.openfaas.max_inflight": "5"}},
+		{name: "invalid rate", annotations: map[string]string{"com.openfaas.ratelimit": "10/d"}, wantErr: true},
+		{name: "zero inflight", annotations: map[string]string{"com.openfaas.max_inflight": "0"}, wantErr: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateLimits(&types.FunctionDeployment{Annotations: &tc.annotations})
+			if (err != nil) != tc.wantErr {
+				t.Errorf("want error: %v, got: %v", tc.wantErr, err)
+			}
+		})
+	}
+}
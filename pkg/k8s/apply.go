@@ -0,0 +1,270 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+// FieldManager is the field manager used by faas-netes for server-side apply. Only
+// the fields set by faas-netes are owned by it, so fields set by other controllers
+// such as the replicas of an HPA or an injected sidecar are left alone.
+const FieldManager = "faas-netes"
+
+// ApplyDeployment applies deployment with server-side apply, a field which is owned
+// by another field manager with a different value results in a Conflict error. When
+// dryRun is set, the result is returned without being persisted.
+func ApplyDeployment(ctx context.Context, client kubernetes.Interface, namespace string, deployment *appsv1.Deployment, dryRun bool) (*appsv1.Deployment, error) {
+	return applyDeployment(ctx, client, namespace, deployment, metav1.ApplyOptions{FieldManager: FieldManager, DryRun: DryRunOptions(dryRun)})
+}
+
+// DryRunApplyDeployment is a dry-run of ApplyDeployment over existing. The fields that
+// faas-netes wrote to existing before it used server-side apply cannot be upgraded
+// by a dry-run, so they conflict with its own update operation. Those conflicts
+// would be resolved by the upgrade, so the dry-run is only forced when they are the
+// only conflicts, a conflict with any other field manager is returned.
+func DryRunApplyDeployment(ctx context.Context, client kubernetes.Interface, namespace string, deployment, existing *appsv1.Deployment) (*appsv1.Deployment, error) {
+	_, upgrade, err := upgradeManagedFieldsPatch(existing)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := metav1.ApplyOptions{FieldManager: FieldManager, DryRun: DryRunOptions(true)}
+	applied, err := applyDeployment(ctx, client, namespace, deployment, opts)
+	if err != nil && upgrade && onlyConflictsWithUpdate(err) {
+		opts.Force = true
+		return applyDeployment(ctx, client, namespace, deployment, opts)
+	}
+
+	return applied, err
+}
+
+func applyDeployment(ctx context.Context, client kubernetes.Interface, namespace string, deployment *appsv1.Deployment, opts metav1.ApplyOptions) (*appsv1.Deployment, error) {
+	config, err := deploymentApplyConfiguration(namespace, deployment)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.AppsV1().Deployments(namespace).Apply(ctx, config, opts)
+}
+
+// ApplyService applies service with server-side apply, a field which is owned by
+// another field manager with a different value results in a Conflict error. When
+// dryRun is set, the result is returned without being persisted.
+func ApplyService(ctx context.Context, client kubernetes.Interface, namespace string, service *corev1.Service, dryRun bool) (*corev1.Service, error) {
+	return applyService(ctx, client, namespace, service, metav1.ApplyOptions{FieldManager: FieldManager, DryRun: DryRunOptions(dryRun)})
+}
+
+// DryRunApplyService is the same as DryRunApplyDeployment for a Service
+func DryRunApplyService(ctx context.Context, client kubernetes.Interface, namespace string, service, existing *corev1.Service) (*corev1.Service, error) {
+	_, upgrade, err := upgradeManagedFieldsPatch(existing)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := metav1.ApplyOptions{FieldManager: FieldManager, DryRun: DryRunOptions(true)}
+	applied, err := applyService(ctx, client, namespace, service, opts)
+	if err != nil && upgrade && onlyConflictsWithUpdate(err) {
+		opts.Force = true
+		return applyService(ctx, client, namespace, service, opts)
+	}
+
+	return applied, err
+}
+
+func applyService(ctx context.Context, client kubernetes.Interface, namespace string, service *corev1.Service, opts metav1.ApplyOptions) (*corev1.Service, error) {
+	config, err := serviceApplyConfiguration(namespace, service)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.CoreV1().Services(namespace).Apply(ctx, config, opts)
+}
+
+// onlyConflictsWithUpdate returns true when err is an apply conflict in which every
+// field is owned by the update operation of FieldManager
+func onlyConflictsWithUpdate(err error) bool {
+	status, ok := err.(errors.APIStatus)
+	if !ok || !errors.IsConflict(err) {
+		return false
+	}
+
+	details := status.Status().Details
+	if details == nil || len(details.Causes) == 0 {
+		return false
+	}
+
+	// The API server names the update operation of a field manager as:
+	// conflict with "faas-netes" using apps/v1
+	prefix := fmt.Sprintf("conflict with %q using ", FieldManager)
+	for _, cause := range details.Causes {
+		if cause.Type != metav1.CauseTypeFieldManagerConflict || !strings.HasPrefix(cause.Message, prefix) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// DryRunOptions returns the DryRun value of the Kubernetes API's options for dryRun
+func DryRunOptions(dryRun bool) []string {
+	if dryRun {
+		return []string{metav1.DryRunAll}
+	}
+	return nil
+}
+
+// UpgradeDeploymentManagedFields hands the fields of a Deployment which faas-netes
+// created or updated before it used server-side apply over to FieldManager's apply
+// operation. Otherwise, fields removed from the function would never be removed
+// from the Deployment, as they would still be owned by the update operation.
+//
+// The replicas are released as well, they are only applied to raise a function to
+// its minimum, and leaving out a field that FieldManager owns would remove it.
+func UpgradeDeploymentManagedFields(ctx context.Context, client kubernetes.Interface, deployment *appsv1.Deployment) error {
+	deployments := client.AppsV1().Deployments(deployment.Namespace)
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		patch, ok, err := upgradeManagedFieldsPatch(deployment, "f:spec", "f:replicas")
+		if err != nil || !ok {
+			return err
+		}
+
+		_, err = deployments.Patch(ctx, deployment.Name, types.JSONPatchType, patch, metav1.PatchOptions{})
+		if errors.IsConflict(err) {
+			latest, getErr := deployments.Get(ctx, deployment.Name, metav1.GetOptions{})
+			if getErr != nil {
+				return getErr
+			}
+			deployment = latest
+		}
+		return err
+	})
+}
+
+// UpgradeServiceManagedFields is the same as UpgradeDeploymentManagedFields for a Service
+func UpgradeServiceManagedFields(ctx context.Context, client kubernetes.Interface, service *corev1.Service) error {
+	services := client.CoreV1().Services(service.Namespace)
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		patch, ok, err := upgradeManagedFieldsPatch(service)
+		if err != nil || !ok {
+			return err
+		}
+
+		_, err = services.Patch(ctx, service.Name, types.JSONPatchType, patch, metav1.PatchOptions{})
+		if errors.IsConflict(err) {
+			latest, getErr := services.Get(ctx, service.Name, metav1.GetOptions{})
+			if getErr != nil {
+				return getErr
+			}
+			service = latest
+		}
+		return err
+	})
+}
+
+// upgradeManagedFieldsPatch returns a JSON patch which converts the update entry of
+// FieldManager into an apply entry, when there is no apply entry already, and
+// removes the field at release from the apply entry. The patch also sets the
+// resourceVersion that obj was read at, so the API server rejects it with a Conflict
+// when the managedFields were changed in the meantime.
+func upgradeManagedFieldsPatch(obj metav1.Object, release ...string) ([]byte, bool, error) {
+	entries := obj.GetManagedFields()
+
+	apply := -1
+	update := -1
+	for i, e := range entries {
+		if e.Manager != FieldManager || len(e.Subresource) > 0 {
+			continue
+		}
+
+		if e.Operation == metav1.ManagedFieldsOperationApply && apply == -1 {
+			apply = i
+		}
+		if e.Operation == metav1.ManagedFieldsOperationUpdate && update == -1 {
+			update = i
+		}
+	}
+
+	upgraded := make([]metav1.ManagedFieldsEntry, len(entries))
+	copy(upgraded, entries)
+
+	changed := false
+	if apply == -1 && update != -1 {
+		apply = update
+		upgraded[apply].Operation = metav1.ManagedFieldsOperationApply
+		upgraded[apply].Time = nil
+		changed = true
+	}
+
+	if apply != -1 && len(release) > 0 && upgraded[apply].FieldsV1 != nil {
+		fields, ok, err := releaseField(upgraded[apply].FieldsV1.Raw, release)
+		if err != nil {
+			return nil, false, err
+		}
+		if ok {
+			upgraded[apply].FieldsV1 = &metav1.FieldsV1{Raw: fields}
+			changed = true
+		}
+	}
+
+	if !changed {
+		return nil, false, nil
+	}
+
+	ops := []map[string]interface{}{}
+	if rv := obj.GetResourceVersion(); len(rv) > 0 {
+		ops = append(ops, map[string]interface{}{"op": "replace", "path": "/metadata/resourceVersion", "value": rv})
+	}
+	ops = append(ops, map[string]interface{}{"op": "replace", "path": "/metadata/managedFields", "value": upgraded})
+
+	patch, err := json.Marshal(ops)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return patch, true, nil
+}
+
+// releaseField removes the field at path from the fieldsV1 of a managedFields
+// entry, false is returned when the entry does not own it
+func releaseField(raw []byte, path []string) ([]byte, bool, error) {
+	fields := map[string]interface{}{}
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, false, fmt.Errorf("unable to read managed fields: %s", err.Error())
+	}
+
+	parent := fields
+	for _, key := range path[:len(path)-1] {
+		next, ok := parent[key].(map[string]interface{})
+		if !ok {
+			return nil, false, nil
+		}
+		parent = next
+	}
+
+	if _, ok := parent[path[len(path)-1]]; !ok {
+		return nil, false, nil
+	}
+	delete(parent, path[len(path)-1])
+
+	out, err := json.Marshal(fields)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return out, true, nil
+}
@@ -0,0 +1,76 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+package k8s
+
+import (
+	"encoding/json"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	appsv1apply "k8s.io/client-go/applyconfigurations/apps/v1"
+	autoscalingv2apply "k8s.io/client-go/applyconfigurations/autoscaling/v2"
+	corev1apply "k8s.io/client-go/applyconfigurations/core/v1"
+)
+
+// The apply configurations below are converted from the objects that faas-netes
+// makes for a function, so that every field that is set is applied, including the
+// owner references of the Function in operator mode. A field which is left empty
+// is not owned by faas-netes, such as the replicas of a function which are managed
+// by an autoscaler.
+
+// deploymentApplyConfiguration returns the apply configuration of deployment in namespace
+func deploymentApplyConfiguration(namespace string, deployment *appsv1.Deployment) (*appsv1apply.DeploymentApplyConfiguration, error) {
+	config := &appsv1apply.DeploymentApplyConfiguration{}
+	if err := toApplyConfiguration(deployment, config); err != nil {
+		return nil, err
+	}
+
+	config.WithKind("Deployment").WithAPIVersion("apps/v1").WithNamespace(namespace)
+	config.Status = nil
+
+	return config, nil
+}
+
+// serviceApplyConfiguration returns the apply configuration of service in namespace
+func serviceApplyConfiguration(namespace string, service *corev1.Service) (*corev1apply.ServiceApplyConfiguration, error) {
+	config := &corev1apply.ServiceApplyConfiguration{}
+	if err := toApplyConfiguration(service, config); err != nil {
+		return nil, err
+	}
+
+	config.WithKind("Service").WithAPIVersion("v1").WithNamespace(namespace)
+	config.Status = nil
+
+	return config, nil
+}
+
+// hpaApplyConfiguration returns the apply configuration of hpa in namespace
+func hpaApplyConfiguration(namespace string, hpa *autoscalingv2.HorizontalPodAutoscaler) (*autoscalingv2apply.HorizontalPodAutoscalerApplyConfiguration, error) {
+	config := &autoscalingv2apply.HorizontalPodAutoscalerApplyConfiguration{}
+	if err := toApplyConfiguration(hpa, config); err != nil {
+		return nil, err
+	}
+
+	config.WithKind("HorizontalPodAutoscaler").WithAPIVersion("autoscaling/v2").WithNamespace(namespace)
+	config.Status = nil
+
+	return config, nil
+}
+
+// toApplyConfiguration converts a typed object into its apply configuration, the
+// apply configuration only has the fields which are set on obj
+func toApplyConfiguration(obj interface{}, config interface{}) error {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(data, config); err != nil {
+		return fmt.Errorf("unable to convert to an apply configuration: %s", err.Error())
+	}
+
+	return nil
+}
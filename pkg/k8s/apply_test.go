@@ -0,0 +1,152 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func Test_UpgradeManagedFields(t *testing.T) {
+	entries := []metav1.ManagedFieldsEntry{
+		{Manager: "kube-controller-manager", Operation: metav1.ManagedFieldsOperationUpdate},
+		{Manager: FieldManager, Operation: metav1.ManagedFieldsOperationUpdate},
+	}
+
+	deployment := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "figlet", Namespace: "openfaas-fn", ManagedFields: entries}}
+	kube := fake.NewSimpleClientset(deployment)
+
+	if err := UpgradeDeploymentManagedFields(context.Background(), kube, deployment); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	got, _ := kube.AppsV1().Deployments("openfaas-fn").Get(context.Background(), "figlet", metav1.GetOptions{})
+	if op := got.ManagedFields[1].Operation; op != metav1.ManagedFieldsOperationApply {
+		t.Errorf("want: %s, got: %s", metav1.ManagedFieldsOperationApply, op)
+	}
+	if op := got.ManagedFields[0].Operation; op != metav1.ManagedFieldsOperationUpdate {
+		t.Errorf("want other managers to be unchanged: %s, got: %s", metav1.ManagedFieldsOperationUpdate, op)
+	}
+
+	service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "figlet", Namespace: "openfaas-fn"}}
+	if err := UpgradeServiceManagedFields(context.Background(), kube, service); err != nil {
+		t.Errorf("want no patch without a faas-netes entry, got: %s", err)
+	}
+}
+
+func Test_UpgradeManagedFieldsPatch_ReleasesReplicas(t *testing.T) {
+	deployment := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{
+		Name:            "figlet",
+		ResourceVersion: "42",
+		ManagedFields: []metav1.ManagedFieldsEntry{{
+			Manager:   FieldManager,
+			Operation: metav1.ManagedFieldsOperationApply,
+			FieldsV1:  &metav1.FieldsV1{Raw: []byte(`{"f:spec":{"f:replicas":{},"f:revisionHistoryLimit":{}}}`)},
+		}},
+	}}
+
+	patch, ok, err := upgradeManagedFieldsPatch(deployment, "f:spec", "f:replicas")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !ok {
+		t.Fatalf("want a patch to release the replicas")
+	}
+
+	ops := []struct {
+		Op    string          `json:"op"`
+		Path  string          `json:"path"`
+		Value json.RawMessage `json:"value"`
+	}{}
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(ops) != 2 || ops[0].Path != "/metadata/resourceVersion" || string(ops[0].Value) != `"42"` {
+		t.Fatalf("want the patch to be conditional on resourceVersion 42, got: %s", string(patch))
+	}
+
+	entries := []metav1.ManagedFieldsEntry{}
+	if err := json.Unmarshal(ops[1].Value, &entries); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got := string(entries[0].FieldsV1.Raw); got != `{"f:spec":{"f:revisionHistoryLimit":{}}}` {
+		t.Errorf("want: %s, got: %s", `{"f:spec":{"f:revisionHistoryLimit":{}}}`, got)
+	}
+
+	// Once released, there is nothing left to patch
+	deployment.ManagedFields = entries
+	if _, ok, _ := upgradeManagedFieldsPatch(deployment, "f:spec", "f:replicas"); ok {
+		t.Errorf("want no patch when the replicas are not owned")
+	}
+}
+
+func Test_Apply_KeepsOwnerReferences(t *testing.T) {
+	owner := []metav1.OwnerReference{{
+		APIVersion: "openfaas.com/v1",
+		Kind:       "Function",
+		Name:       "figlet",
+		UID:        "figlet-uid",
+		Controller: boolp(true),
+	}}
+	meta := metav1.ObjectMeta{Name: "figlet", Namespace: "openfaas-fn", OwnerReferences: owner}
+
+	deployment := &appsv1.Deployment{
+		ObjectMeta: meta,
+		Spec: appsv1.DeploymentSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"faas_function": "figlet"}},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"faas_function": "figlet"}},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "figlet", Image: "ghcr.io/openfaas/figlet"}}},
+			},
+		},
+	}
+	service := &corev1.Service{
+		ObjectMeta: meta,
+		Spec:       corev1.ServiceSpec{Ports: []corev1.ServicePort{{Name: "http", Port: 8080}}},
+	}
+
+	// The objects are created by faas-netes before it used server-side apply, then
+	// their fields are handed over to its apply operation
+	ctx := context.Background()
+	kube := fake.NewClientset()
+	created, err := kube.AppsV1().Deployments("openfaas-fn").Create(ctx, deployment, metav1.CreateOptions{FieldManager: FieldManager})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	createdService, err := kube.CoreV1().Services("openfaas-fn").Create(ctx, service, metav1.CreateOptions{FieldManager: FieldManager})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if err := UpgradeDeploymentManagedFields(ctx, kube, created); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := UpgradeServiceManagedFields(ctx, kube, createdService); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	applied, err := ApplyDeployment(ctx, kube, "openfaas-fn", deployment, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(applied.OwnerReferences, owner) {
+		t.Errorf("want: %v, got: %v", owner, applied.OwnerReferences)
+	}
+
+	appliedService, err := ApplyService(ctx, kube, "openfaas-fn", service, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(appliedService.OwnerReferences, owner) {
+		t.Errorf("want: %v, got: %v", owner, appliedService.OwnerReferences)
+	}
+}
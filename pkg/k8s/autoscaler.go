@@ -0,0 +1,336 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+package k8s
+
+import (
+	"context"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/client-go/kubernetes"
+	appslister "k8s.io/client-go/listers/apps/v1"
+)
+
+// recommendation is a number of replicas computed for a function at a point in time
+type recommendation struct {
+	at       time.Time
+	replicas int32
+}
+
+// functionLoad is the load of a function observed by the proxy since it was last sampled
+type functionLoad struct {
+	inflight     int
+	peakInflight int
+	requests     int
+	sampledAt    time.Time
+
+	scaledAt        time.Time
+	recommendations []recommendation
+}
+
+// Autoscaler scales functions between the replicas given by their com.openfaas.scale.min
+// and com.openfaas.scale.max labels, by the requests per second and the requests in
+// flight which are proxied to them.
+//
+// A function is scaled up as soon as its load requires it, and is only scaled down to
+// the highest number of replicas recommended within the ScaleDownWindow, so that a
+// short drop in load does not remove replicas which are needed again soon after.
+type Autoscaler struct {
+	Client           kubernetes.Interface
+	DeploymentLister appslister.DeploymentLister
+
+	// DefaultNamespace is used for requests to a function without a namespace suffix
+	DefaultNamespace string
+
+	// Namespaces is optional and restricts the autoscaler to the managed namespaces
+	Namespaces *Namespaces
+
+	// TargetRPS is the requests per second for each replica, 0 disables it
+	TargetRPS float64
+
+	// TargetInflight is the requests in flight for each replica, 0 disables it
+	TargetInflight int
+
+	// MaxReplicas is used for functions without the com.openfaas.scale.max label
+	MaxReplicas int32
+
+	// Interval is how often the load is sampled and functions are scaled
+	Interval time.Duration
+
+	// Cooldown is the minimum time between two changes to the replicas of a function
+	Cooldown time.Duration
+
+	// ScaleDownWindow is how far back recommendations are considered before scaling down
+	ScaleDownWindow time.Duration
+
+	// Now returns the current time, it is replaced in tests
+	Now func() time.Time
+
+	lock      sync.Mutex
+	functions map[string]*functionLoad
+}
+
+// NewAutoscaler creates an Autoscaler which samples every 5 seconds, and which keeps
+// the replicas of a function for 5 minutes before scaling it down
+func NewAutoscaler(client kubernetes.Interface, lister appslister.DeploymentLister, defaultNamespace string) *Autoscaler {
+	return &Autoscaler{
+		Client:           client,
+		DeploymentLister: lister,
+		DefaultNamespace: defaultNamespace,
+		TargetRPS:        50,
+		TargetInflight:   10,
+		MaxReplicas:      1,
+		Interval:         time.Second * 5,
+		Cooldown:         time.Second * 30,
+		ScaleDownWindow:  time.Minute * 5,
+		Now:              time.Now,
+		functions:        map[string]*functionLoad{},
+	}
+}
+
+// Wrap returns a handler which records the load of each request served by next. The
+// function name is read from the "name" route variable, as set by the provider's router.
+func (a *Autoscaler) Wrap(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := mux.Vars(r)["name"]
+		if len(name) == 0 {
+			next(w, r)
+			return
+		}
+
+		namespace := getNamespace(name, a.DefaultNamespace)
+		functionName := strings.TrimSuffix(name, "."+namespace)
+
+		done := a.Start(functionName, namespace)
+		defer done()
+
+		next(w, r)
+	}
+}
+
+// Start records the start of a request to a function, the returned func records its end
+func (a *Autoscaler) Start(name, namespace string) func() {
+	key := name + "." + namespace
+
+	a.lock.Lock()
+	load := a.get(key)
+	load.requests++
+	load.inflight++
+	if load.inflight > load.peakInflight {
+		load.peakInflight = load.inflight
+	}
+	a.lock.Unlock()
+
+	return func() {
+		a.lock.Lock()
+		defer a.lock.Unlock()
+
+		if load := a.get(key); load.inflight > 0 {
+			load.inflight--
+		}
+	}
+}
+
+// Run scales functions every Interval until stopCh is closed
+func (a *Autoscaler) Run(stopCh <-chan struct{}) {
+	ticker := time.NewTicker(a.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := a.Scale(context.Background()); err != nil {
+				log.Printf("Unable to autoscale functions: %s", err.Error())
+			}
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// Scale samples the load of each function and scales the functions whose replicas
+// do not match their load
+func (a *Autoscaler) Scale(ctx context.Context) error {
+	req, err := labels.NewRequirement("faas_function", selection.Exists, []string{})
+	if err != nil {
+		return err
+	}
+
+	deployments, err := a.DeploymentLister.List(labels.NewSelector().Add(*req))
+	if err != nil {
+		return err
+	}
+
+	seen := map[string]bool{}
+	for _, deployment := range deployments {
+		key := deployment.Name + "." + deployment.Namespace
+		seen[key] = true
+
+		current, target, ok := a.target(deployment)
+		if !ok || target == current {
+			continue
+		}
+
+		if err := ScaleDeployment(ctx, a.Client, deployment.Namespace, deployment.Name, target); err != nil {
+			log.Printf("Unable to scale %s: %s", key, err.Error())
+			continue
+		}
+
+		a.lock.Lock()
+		a.get(key).scaledAt = a.Now()
+		a.lock.Unlock()
+
+		log.Printf("Autoscaled: %s, %d => %d replica(s)", key, current, target)
+	}
+
+	a.forget(seen)
+	return nil
+}
+
+// target samples the load of a function and returns its current and target replicas,
+// false is returned when the function is not to be scaled
+func (a *Autoscaler) target(deployment *appsv1.Deployment) (int32, int32, bool) {
+	// A function at zero replicas is left to be scaled up by its next request
+	if deployment.Spec.Replicas == nil || *deployment.Spec.Replicas == 0 {
+		return 0, 0, false
+	}
+
+	if a.Namespaces != nil && a.Namespaces.Validate(deployment.Namespace) != nil {
+		return 0, 0, false
+	}
+
+	// A function with an HPA is scaled by the HPA alone
+	if HPAEnabled(deployment.Spec.Template.Labels) {
+		return 0, 0, false
+	}
+
+	current := *deployment.Spec.Replicas
+	min, max := ScaleBounds(deployment.Spec.Template.Labels, a.MaxReplicas)
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	now := a.Now()
+	load := a.get(deployment.Name + "." + deployment.Namespace)
+
+	// The first sample only marks the start of the period which is measured
+	if load.sampledAt.IsZero() {
+		load.sampledAt = now
+		load.requests = 0
+		load.peakInflight = load.inflight
+		return 0, 0, false
+	}
+
+	elapsed := now.Sub(load.sampledAt).Seconds()
+	if elapsed <= 0 {
+		return 0, 0, false
+	}
+
+	desired := a.desiredReplicas(float64(load.requests)/elapsed, load.peakInflight)
+	desired = clamp(desired, min, max)
+
+	load.sampledAt = now
+	load.requests = 0
+	load.peakInflight = load.inflight
+
+	target := a.stabilise(load, now, desired, current)
+
+	// Replicas outside of the bounds are corrected straight away
+	if current < min || current > max {
+		return current, clamp(current, min, max), true
+	}
+
+	if target == current || now.Sub(load.scaledAt) < a.Cooldown {
+		return current, current, true
+	}
+
+	return current, target, true
+}
+
+// desiredReplicas returns the replicas needed to serve rps and inflight at the targets
+func (a *Autoscaler) desiredReplicas(rps float64, inflight int) int32 {
+	desired := int32(1)
+
+	if a.TargetRPS > 0 {
+		if r := int32(math.Ceil(rps / a.TargetRPS)); r > desired {
+			desired = r
+		}
+	}
+
+	if a.TargetInflight > 0 {
+		if r := int32(math.Ceil(float64(inflight) / float64(a.TargetInflight))); r > desired {
+			desired = r
+		}
+	}
+
+	return desired
+}
+
+// stabilise records desired and returns the replicas to scale to. Scaling up uses
+// desired, scaling down uses the highest recommendation within the ScaleDownWindow.
+func (a *Autoscaler) stabilise(load *functionLoad, now time.Time, desired, current int32) int32 {
+	load.recommendations = append(load.recommendations, recommendation{at: now, replicas: desired})
+
+	kept := load.recommendations[:0]
+	highest := desired
+	for _, r := range load.recommendations {
+		if now.Sub(r.at) > a.ScaleDownWindow {
+			continue
+		}
+		kept = append(kept, r)
+		if r.replicas > highest {
+			highest = r.replicas
+		}
+	}
+	load.recommendations = kept
+
+	if desired >= current {
+		return desired
+	}
+
+	if highest > current {
+		return current
+	}
+	return highest
+}
+
+// forget removes the load of functions which no longer exist
+func (a *Autoscaler) forget(seen map[string]bool) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	for key, load := range a.functions {
+		if !seen[key] && load.inflight == 0 {
+			delete(a.functions, key)
+		}
+	}
+}
+
+// get returns the load of a function, the lock must be held
+func (a *Autoscaler) get(key string) *functionLoad {
+	load, ok := a.functions[key]
+	if !ok {
+		load = &functionLoad{}
+		a.functions[key] = load
+	}
+	return load
+}
+
+func clamp(v, min, max int32) int32 {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
@@ -0,0 +1,288 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+package k8s
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubeinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+// testAutoscaler scales with a lister which is synced from the fake client before
+// each call to Scale, as the informer is not started
+type testAutoscaler struct {
+	*Autoscaler
+	kube    *fake.Clientset
+	indexer cache.Indexer
+}
+
+func (a *testAutoscaler) Scale(t *testing.T) {
+	t.Helper()
+
+	list, err := a.kube.AppsV1().Deployments("openfaas-fn").List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for i := range list.Items {
+		a.indexer.Update(&list.Items[i])
+	}
+
+	if err := a.Autoscaler.Scale(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+func newTestAutoscaler(t *testing.T, deployments ...*appsv1.Deployment) (*testAutoscaler, *fake.Clientset, *fakeClock) {
+	t.Helper()
+
+	kube := fake.NewSimpleClientset()
+	factory := kubeinformers.NewSharedInformerFactory(kube, 0)
+	informer := factory.Apps().V1().Deployments()
+
+	for _, d := range deployments {
+		kube.Tracker().Add(d)
+	}
+
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+
+	autoscaler := NewAutoscaler(kube, informer.Lister(), "openfaas-fn")
+	autoscaler.MaxReplicas = 5
+	autoscaler.TargetRPS = 10
+	autoscaler.TargetInflight = 0
+	autoscaler.Now = clock.Now
+	return &testAutoscaler{Autoscaler: autoscaler, kube: kube, indexer: informer.Informer().GetIndexer()}, kube, clock
+}
+
+// sendRequests records n requests to a function which have completed
+func sendRequests(a *testAutoscaler, name string, n int) {
+	for i := 0; i < n; i++ {
+		a.Start(name, "openfaas-fn")()
+	}
+}
+
+func Test_Autoscaler_ScalesUpByRequestsPerSecond(t *testing.T) {
+	autoscaler, kube, clock := newTestAutoscaler(t,
+		newScaleToZeroDeployment("figlet", 1, map[string]string{MaxScaleLabel: "3"}),
+	)
+
+	autoscaler.Scale(t)
+
+	// 25 rps needs 3 replicas at 10 rps each
+	sendRequests(autoscaler, "figlet", 250)
+	clock.Add(time.Second * 10)
+	autoscaler.Scale(t)
+
+	if got := replicasOf(t, kube, "figlet"); got != 3 {
+		t.Errorf("want: %d, got: %d", 3, got)
+	}
+}
+
+func Test_Autoscaler_ScalesUpByInflight(t *testing.T) {
+	autoscaler, kube, clock := newTestAutoscaler(t,
+		newScaleToZeroDeployment("figlet", 1, nil),
+	)
+	autoscaler.TargetRPS = 0
+	autoscaler.TargetInflight = 2
+
+	autoscaler.Scale(t)
+
+	var done []func()
+	for i := 0; i < 7; i++ {
+		done = append(done, autoscaler.Start("figlet", "openfaas-fn"))
+	}
+	// The peak is kept after the requests complete
+	for _, d := range done {
+		d()
+	}
+
+	clock.Add(time.Second * 5)
+	autoscaler.Scale(t)
+
+	// 7 in flight needs 4 replicas at 2 in flight each
+	if got := replicasOf(t, kube, "figlet"); got != 4 {
+		t.Errorf("want: %d, got: %d", 4, got)
+	}
+}
+
+func Test_Autoscaler_LimitedToMaxReplicas(t *testing.T) {
+	autoscaler, kube, clock := newTestAutoscaler(t,
+		newScaleToZeroDeployment("figlet", 1, map[string]string{MaxScaleLabel: "2"}),
+		newScaleToZeroDeployment("env", 1, nil),
+	)
+
+	autoscaler.Scale(t)
+
+	sendRequests(autoscaler, "figlet", 1000)
+	sendRequests(autoscaler, "env", 1000)
+	clock.Add(time.Second * 10)
+	autoscaler.Scale(t)
+
+	if got := replicasOf(t, kube, "figlet"); got != 2 {
+		t.Errorf("want figlet limited by its max label to: %d, got: %d", 2, got)
+	}
+	if got := replicasOf(t, kube, "env"); got != 5 {
+		t.Errorf("want env limited by MaxReplicas to: %d, got: %d", 5, got)
+	}
+}
+
+func Test_Autoscaler_CorrectsReplicasBelowMin(t *testing.T) {
+	autoscaler, kube, clock := newTestAutoscaler(t,
+		newScaleToZeroDeployment("figlet", 1, map[string]string{MinScaleLabel: "2"}),
+	)
+
+	autoscaler.Scale(t)
+	clock.Add(time.Second * 5)
+	autoscaler.Scale(t)
+
+	if got := replicasOf(t, kube, "figlet"); got != 2 {
+		t.Errorf("want figlet scaled to its min of: %d, got: %d", 2, got)
+	}
+}
+
+func Test_Autoscaler_Cooldown(t *testing.T) {
+	autoscaler, kube, clock := newTestAutoscaler(t,
+		newScaleToZeroDeployment("figlet", 1, nil),
+	)
+
+	autoscaler.Scale(t)
+	sendRequests(autoscaler, "figlet", 150)
+	clock.Add(time.Second * 10)
+	autoscaler.Scale(t)
+
+	if got := replicasOf(t, kube, "figlet"); got != 2 {
+		t.Fatalf("want: %d, got: %d", 2, got)
+	}
+
+	sendRequests(autoscaler, "figlet", 400)
+	clock.Add(time.Second * 10)
+	autoscaler.Scale(t)
+
+	if got := replicasOf(t, kube, "figlet"); got != 2 {
+		t.Errorf("want figlet left at %d replicas within the cooldown, got: %d", 2, got)
+	}
+
+	sendRequests(autoscaler, "figlet", 800)
+	clock.Add(time.Second * 20)
+	autoscaler.Scale(t)
+
+	if got := replicasOf(t, kube, "figlet"); got != 4 {
+		t.Errorf("want figlet scaled up to %d replicas after the cooldown, got: %d", 4, got)
+	}
+}
+
+func Test_Autoscaler_ScaleDownWindow(t *testing.T) {
+	autoscaler, kube, clock := newTestAutoscaler(t,
+		newScaleToZeroDeployment("figlet", 1, nil),
+	)
+	autoscaler.Cooldown = 0
+	autoscaler.ScaleDownWindow = time.Minute
+
+	autoscaler.Scale(t)
+	sendRequests(autoscaler, "figlet", 300)
+	clock.Add(time.Second * 10)
+	autoscaler.Scale(t)
+
+	if got := replicasOf(t, kube, "figlet"); got != 3 {
+		t.Fatalf("want: %d, got: %d", 3, got)
+	}
+
+	// No requests within the window keep the highest recommendation
+	for i := 0; i < 5; i++ {
+		clock.Add(time.Second * 10)
+		autoscaler.Scale(t)
+	}
+
+	if got := replicasOf(t, kube, "figlet"); got != 3 {
+		t.Errorf("want figlet kept at %d replicas within the window, got: %d", 3, got)
+	}
+
+	clock.Add(time.Second * 20)
+	autoscaler.Scale(t)
+
+	if got := replicasOf(t, kube, "figlet"); got != 1 {
+		t.Errorf("want figlet scaled down to %d replica after the window, got: %d", 1, got)
+	}
+}
+
+func Test_Autoscaler_SkipsFunctionsAtZero(t *testing.T) {
+	autoscaler, kube, clock := newTestAutoscaler(t,
+		newScaleToZeroDeployment("figlet", 0, map[string]string{ScaleToZeroLabel: "true"}),
+	)
+
+	autoscaler.Scale(t)
+	clock.Add(time.Second * 5)
+	autoscaler.Scale(t)
+
+	if got := replicasOf(t, kube, "figlet"); got != 0 {
+		t.Errorf("want figlet left at zero replicas, got: %d", got)
+	}
+}
+
+func Test_Autoscaler_SkipsFunctionsWithHPA(t *testing.T) {
+	autoscaler, kube, clock := newTestAutoscaler(t,
+		newScaleToZeroDeployment("figlet", 1, map[string]string{ScaleTypeLabel: "cpu"}),
+	)
+
+	autoscaler.Scale(t)
+	sendRequests(autoscaler, "figlet", 1000)
+	clock.Add(time.Second * 10)
+	autoscaler.Scale(t)
+
+	if got := replicasOf(t, kube, "figlet"); got != 1 {
+		t.Errorf("want figlet left to its HPA at %d replica, got: %d", 1, got)
+	}
+}
+
+func Test_Autoscaler_Wrap(t *testing.T) {
+	autoscaler, _, _ := newTestAutoscaler(t)
+
+	r := mux.NewRouter()
+	r.HandleFunc("/function/{name}", autoscaler.Wrap(func(w http.ResponseWriter, r *http.Request) {
+		autoscaler.lock.Lock()
+		defer autoscaler.lock.Unlock()
+
+		if got := autoscaler.functions["figlet.openfaas-fn"].inflight; got != 1 {
+			t.Errorf("want: %d request in flight, got: %d", 1, got)
+		}
+	}))
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/function/figlet.openfaas-fn", nil))
+
+	load := autoscaler.functions["figlet.openfaas-fn"]
+	if load.inflight != 0 || load.requests != 1 {
+		t.Errorf("want: 0 in flight and 1 request, got: %d in flight and %d requests", load.inflight, load.requests)
+	}
+}
+
+func Test_ScaleBounds(t *testing.T) {
+	cases := []struct {
+		name    string
+		labels  map[string]string
+		wantMin int32
+		wantMax int32
+	}{
+		{name: "defaults", labels: nil, wantMin: 1, wantMax: 5},
+		{name: "labels", labels: map[string]string{MinScaleLabel: "2", MaxScaleLabel: "4"}, wantMin: 2, wantMax: 4},
+		{name: "max below min", labels: map[string]string{MinScaleLabel: "3", MaxScaleLabel: "2"}, wantMin: 3, wantMax: 3},
+		{name: "invalid", labels: map[string]string{MinScaleLabel: "0", MaxScaleLabel: "many"}, wantMin: 1, wantMax: 5},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			min, max := ScaleBounds(tc.labels, 5)
+			if min != tc.wantMin || max != tc.wantMax {
+				t.Errorf("want: %d-%d, got: %d-%d", tc.wantMin, tc.wantMax, min, max)
+			}
+		})
+	}
+}
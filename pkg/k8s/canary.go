@@ -0,0 +1,125 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"strconv"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	appsv1apply "k8s.io/client-go/applyconfigurations/apps/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// CanaryAnnotationPrefix is the prefix of the annotations which describe the
+	// canary of a function, they are set on the function's Deployment
+	CanaryAnnotationPrefix = "com.openfaas.canary."
+
+	// CanaryWeightAnnotation is the percentage of the function's requests which are
+	// sent to its canary, from 0 to 100
+	CanaryWeightAnnotation = CanaryAnnotationPrefix + "weight"
+
+	// CanaryStateAnnotation is one of CanaryActive, CanaryPromoted or CanaryAborted
+	CanaryStateAnnotation = CanaryAnnotationPrefix + "state"
+
+	// CanaryImageAnnotation is the image of the canary
+	CanaryImageAnnotation = CanaryAnnotationPrefix + "image"
+
+	// CanaryLabel selects the pods of a canary by the name of its function. It is
+	// used instead of faas_function, so that the canary is not listed as a function
+	// and its pods are not selected by the function's Service.
+	CanaryLabel = "faas_canary"
+
+	// CanaryFieldManager owns the canary annotations of a function's Deployment, so
+	// that they are kept when faas-netes applies an update to the function
+	CanaryFieldManager = "faas-netes-canary"
+)
+
+const (
+	CanaryActive   = "active"
+	CanaryPromoted = "promoted"
+	CanaryAborted  = "aborted"
+)
+
+// CanaryName returns the name of the Deployment and Service of a function's canary
+func CanaryName(functionName string) string {
+	return functionName + "-canary"
+}
+
+// ParseCanaryWeight parses a canary weight, which is a percentage
+func ParseCanaryWeight(value string) (int, error) {
+	weight, err := strconv.Atoi(value)
+	if err != nil || weight < 0 || weight > 100 {
+		return 0, fmt.Errorf("canary weight must be a number between 0 and 100, got: %q", value)
+	}
+
+	return weight, nil
+}
+
+// CanaryWeight returns the weight of the canary of a function, or 0 when the
+// function has no active canary
+func CanaryWeight(deployment *appsv1.Deployment) int {
+	if deployment.Annotations[CanaryStateAnnotation] != CanaryActive {
+		return 0
+	}
+
+	weight, err := ParseCanaryWeight(deployment.Annotations[CanaryWeightAnnotation])
+	if err != nil {
+		return 0
+	}
+
+	return weight
+}
+
+// IsCanary returns true when deployment is the canary of a function
+func IsCanary(deployment *appsv1.Deployment) bool {
+	_, ok := deployment.Labels[CanaryLabel]
+	return ok
+}
+
+// ApplyCanaryAnnotations sets the canary annotations of a function's Deployment, any
+// canary annotation which is not given is removed
+func ApplyCanaryAnnotations(ctx context.Context, client kubernetes.Interface, namespace, name string, annotations map[string]string) (*appsv1.Deployment, error) {
+	config := appsv1apply.Deployment(name, namespace).WithAnnotations(annotations)
+
+	return client.AppsV1().Deployments(namespace).
+		Apply(ctx, config, metav1.ApplyOptions{FieldManager: CanaryFieldManager, Force: true})
+}
+
+// canaryAnnotations returns the canary annotations of a function's Deployment
+func canaryAnnotations(annotations map[string]string) map[string]string {
+	canary := map[string]string{}
+	for k, v := range annotations {
+		if strings.HasPrefix(k, CanaryAnnotationPrefix) {
+			canary[k] = v
+		}
+	}
+	return canary
+}
+
+// routeToCanary decides whether a request is sent to the canary of a function. A
+// request with a hash key is always sent to the same side, so that it sticks to
+// the same version as the consistent-hash strategy sticks to the same endpoint.
+func routeToCanary(weight int, hashKey string) bool {
+	if weight <= 0 {
+		return false
+	}
+	if weight >= 100 {
+		return true
+	}
+
+	if len(hashKey) > 0 {
+		h := fnv.New32a()
+		h.Write([]byte(hashKey))
+		return int(h.Sum32()%100) < weight
+	}
+
+	return rand.Intn(100) < weight
+}
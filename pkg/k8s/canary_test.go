@@ -0,0 +1,199 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+package k8s
+
+import (
+	"fmt"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubeinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func Test_ParseCanaryWeight(t *testing.T) {
+	cases := []struct {
+		value   string
+		want    int
+		wantErr bool
+	}{
+		{value: "0", want: 0},
+		{value: "25", want: 25},
+		{value: "100", want: 100},
+		{value: "101", wantErr: true},
+		{value: "-1", wantErr: true},
+		{value: "half", wantErr: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.value, func(t *testing.T) {
+			got, err := ParseCanaryWeight(tc.value)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("want error: %v, got: %v", tc.wantErr, err)
+			}
+			if got != tc.want {
+				t.Errorf("want: %d, got: %d", tc.want, got)
+			}
+		})
+	}
+}
+
+func Test_routeToCanary(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		if routeToCanary(0, "") {
+			t.Fatalf("want no requests sent to a canary with weight 0")
+		}
+		if !routeToCanary(100, "") {
+			t.Fatalf("want every request sent to a canary with weight 100")
+		}
+	}
+
+	canary := 0
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("user-%d", i)
+		got := routeToCanary(30, key)
+		if got != routeToCanary(30, key) {
+			t.Fatalf("want the same side for the hash key: %s", key)
+		}
+		if got {
+			canary++
+		}
+	}
+
+	if canary < 200 || canary > 400 {
+		t.Errorf("want around 300 of 1000 hash keys sent to the canary, got: %d", canary)
+	}
+}
+
+func newCanaryLookup(t *testing.T, annotations map[string]string, withCanaryEndpoints bool) *FunctionLookup {
+	t.Helper()
+
+	factory := kubeinformers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+
+	endpoints := factory.Core().V1().Endpoints()
+	names := map[string]string{"figlet": "10.0.0.1"}
+	if withCanaryEndpoints {
+		names["figlet-canary"] = "10.0.0.2"
+	}
+	for name, ip := range names {
+		endpoints.Informer().GetIndexer().Add(&corev1.Endpoints{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "openfaas-fn"},
+			Subsets: []corev1.EndpointSubset{{
+				Addresses: []corev1.EndpointAddress{{IP: ip}},
+			}},
+		})
+	}
+
+	deployments := factory.Apps().V1().Deployments()
+	deployments.Informer().GetIndexer().Add(&appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "figlet", Namespace: "openfaas-fn", Annotations: annotations},
+	})
+
+	lookup := NewFunctionLookup("openfaas-fn", endpoints.Lister())
+	lookup.DeploymentLister = deployments.Lister()
+	return lookup
+}
+
+func Test_FunctionLookup_RoutesToCanary(t *testing.T) {
+	cases := []struct {
+		name                string
+		annotations         map[string]string
+		withCanaryEndpoints bool
+		want                string
+	}{
+		{
+			name:                "no canary",
+			withCanaryEndpoints: true,
+			want:                "http://10.0.0.1:8080",
+		},
+		{
+			name:                "all traffic to the canary",
+			annotations:         map[string]string{CanaryStateAnnotation: CanaryActive, CanaryWeightAnnotation: "100"},
+			withCanaryEndpoints: true,
+			want:                "http://10.0.0.2:8080",
+		},
+		{
+			name:                "no traffic to the canary",
+			annotations:         map[string]string{CanaryStateAnnotation: CanaryActive, CanaryWeightAnnotation: "0"},
+			withCanaryEndpoints: true,
+			want:                "http://10.0.0.1:8080",
+		},
+		{
+			name:                "canary was aborted",
+			annotations:         map[string]string{CanaryStateAnnotation: CanaryAborted, CanaryWeightAnnotation: "100"},
+			withCanaryEndpoints: true,
+			want:                "http://10.0.0.1:8080",
+		},
+		{
+			name:        "canary is not ready",
+			annotations: map[string]string{CanaryStateAnnotation: CanaryActive, CanaryWeightAnnotation: "100"},
+			want:        "http://10.0.0.1:8080",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			lookup := newCanaryLookup(t, tc.annotations, tc.withCanaryEndpoints)
+
+			got, err := lookup.Resolve("figlet.openfaas-fn")
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if got.String() != tc.want {
+				t.Errorf("want: %s, got: %s", tc.want, got.String())
+			}
+		})
+	}
+}
+
+func Test_FunctionLookup_ResolvesCanaryService(t *testing.T) {
+	lookup := newCanaryLookup(t, map[string]string{CanaryStateAnnotation: CanaryActive, CanaryWeightAnnotation: "100"}, true)
+	lookup.ResolveToService = true
+
+	got, err := lookup.Resolve("figlet")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	want := "http://figlet-canary.openfaas-fn.svc.cluster.local:8080"
+	if got.String() != want {
+		t.Errorf("want: %s, got: %s", want, got.String())
+	}
+}
+
+func Test_AsFunctionStatus_CanaryAnnotations(t *testing.T) {
+	deployment := appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "figlet",
+			Annotations: map[string]string{
+				CanaryStateAnnotation:  CanaryActive,
+				CanaryWeightAnnotation: "20",
+				"topic":                "deployment-only",
+			},
+		},
+		Spec: appsv1.DeploymentSpec{
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{"topic": "cron"}},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{Name: "figlet", Image: "figlet:1.0"}},
+				},
+			},
+		},
+	}
+
+	status := AsFunctionStatus(deployment)
+
+	annotations := *status.Annotations
+	if annotations[CanaryWeightAnnotation] != "20" || annotations[CanaryStateAnnotation] != CanaryActive {
+		t.Errorf("want the canary weight and state, got: %v", annotations)
+	}
+	if annotations["topic"] != "cron" {
+		t.Errorf("want the annotations of the template, got: %v", annotations)
+	}
+	if _, ok := deployment.Spec.Template.Annotations[CanaryWeightAnnotation]; ok {
+		t.Errorf("want the Deployment to be left unchanged")
+	}
+}
@@ -0,0 +1,405 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+package k8s
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// States of the circuit of a function
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half-open"
+)
+
+const (
+	// CircuitStateAnnotation is added to the status of a function with the state of
+	// its circuit, it is not read from the function's deployment
+	CircuitStateAnnotation = "com.openfaas.circuit.state"
+
+	// EjectedEndpointsAnnotation is added to the status of a function with a comma
+	// separated list of its endpoints which are ejected
+	EjectedEndpointsAnnotation = "com.openfaas.circuit.ejected"
+)
+
+// ErrCircuitOpen is returned when resolving a function whose circuit is open
+var ErrCircuitOpen = errors.New("circuit open")
+
+// endpointHealth is the health of an endpoint, as observed by the proxy
+type endpointHealth struct {
+	function     string
+	failures     int
+	ejections    int
+	ejectedUntil time.Time
+	lastFailure  time.Time
+}
+
+// circuit is the error rate and state of the circuit of a function
+type circuit struct {
+	state       string
+	requests    int
+	failures    int
+	windowStart time.Time
+	openUntil   time.Time
+	probeAt     time.Time
+}
+
+// CircuitStatus is the state of the circuit of a function and its ejected endpoints
+type CircuitStatus struct {
+	State   string
+	Ejected []string
+}
+
+// CircuitReader reports the state of the circuit of a function, key is the function's
+// name and namespace
+type CircuitReader interface {
+	Status(key string) CircuitStatus
+}
+
+// CircuitBreaker tracks the health of the endpoints of functions from the results of
+// the requests proxied to them, so that a pod which hangs or fails is avoided before
+// its readiness probe catches up.
+//
+// An endpoint which fails ConsecutiveFailures requests in a row is ejected for the
+// EjectionTime, which is multiplied by the number of times it was ejected in a row.
+// A function whose requests fail at the ErrorThreshold within a Window has its circuit
+// opened, and its requests are rejected for the OpenTime. After that a single request
+// is let through, which closes the circuit when it succeeds or opens it again.
+type CircuitBreaker struct {
+	// ConsecutiveFailures is the number of failed requests in a row which ejects an endpoint
+	ConsecutiveFailures int
+
+	// EjectionTime is how long an endpoint is ejected for the first time
+	EjectionTime time.Duration
+
+	// MaxEjectionTime is the longest an endpoint is ejected for
+	MaxEjectionTime time.Duration
+
+	// MaxEjectionPercent is the share of a function's endpoints which can be ejected at once
+	MaxEjectionPercent int
+
+	// SlowRequest is the latency above which a request counts as failed, 0 disables it
+	SlowRequest time.Duration
+
+	// ErrorThreshold is the share of failed requests which opens the circuit, i.e. 0.5
+	ErrorThreshold float64
+
+	// MinRequests is the number of requests within the Window before the circuit can open
+	MinRequests int
+
+	// Window is the period over which the error rate of a function is measured
+	Window time.Duration
+
+	// OpenTime is how long requests are rejected for once the circuit opens
+	OpenTime time.Duration
+
+	// Now returns the current time, it is replaced in tests
+	Now func() time.Time
+
+	lock      sync.Mutex
+	endpoints map[string]*endpointHealth
+	circuits  map[string]*circuit
+	sweptAt   time.Time
+}
+
+// NewCircuitBreaker creates a CircuitBreaker which ejects an endpoint after 5 failures
+// in a row, and which opens the circuit of a function when half of at least 20
+// requests within 10 seconds fail
+func NewCircuitBreaker() *CircuitBreaker {
+	return &CircuitBreaker{
+		ConsecutiveFailures: 5,
+		EjectionTime:        time.Second * 30,
+		MaxEjectionTime:     time.Minute * 5,
+		MaxEjectionPercent:  50,
+		ErrorThreshold:      0.5,
+		MinRequests:         20,
+		Window:              time.Second * 10,
+		OpenTime:            time.Second * 30,
+		Now:                 time.Now,
+		endpoints:           map[string]*endpointHealth{},
+		circuits:            map[string]*circuit{},
+	}
+}
+
+// Allow returns false when requests to the function given by key are rejected, key
+// is the function's name and namespace i.e. "figlet.openfaas-fn"
+func (b *CircuitBreaker) Allow(key string) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	c, ok := b.circuits[key]
+	if !ok {
+		return true
+	}
+
+	now := b.Now()
+	switch c.state {
+	case CircuitOpen:
+		if now.Before(c.openUntil) {
+			return false
+		}
+		c.state = CircuitHalfOpen
+		c.probeAt = now
+		log.Printf("Circuit half-open: %s", key)
+		return true
+
+	case CircuitHalfOpen:
+		// A single request probes the function, another one is let through when the
+		// result of the probe was not recorded
+		if now.Sub(c.probeAt) < b.OpenTime {
+			return false
+		}
+		c.probeAt = now
+		return true
+	}
+
+	return true
+}
+
+// Available returns the addresses which are not ejected. Ejected addresses are kept
+// when more than MaxEjectionPercent of the addresses would be removed, those whose
+// ejection ends first are kept first.
+func (b *CircuitBreaker) Available(addresses []string) []string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	now := b.Now()
+	available := []string{}
+	ejected := []string{}
+	for _, a := range addresses {
+		if e, ok := b.endpoints[a]; ok && now.Before(e.ejectedUntil) {
+			ejected = append(ejected, a)
+			continue
+		}
+		available = append(available, a)
+	}
+
+	if len(ejected) == 0 {
+		return addresses
+	}
+
+	minAvailable := len(addresses) - len(addresses)*b.MaxEjectionPercent/100
+	if minAvailable < 1 {
+		minAvailable = 1
+	}
+
+	if len(available) < minAvailable {
+		sort.SliceStable(ejected, func(i, j int) bool {
+			return b.endpoints[ejected[i]].ejectedUntil.Before(b.endpoints[ejected[j]].ejectedUntil)
+		})
+		available = append(available, ejected[:minAvailable-len(available)]...)
+		sort.Strings(available)
+	}
+
+	return available
+}
+
+// Record records the result of a request to address, an endpoint of the function
+// given by key. A request fails when the endpoint could not be reached, responded
+// with a 5xx status or was slower than SlowRequest.
+func (b *CircuitBreaker) Record(key, address string, status int, err error, latency time.Duration) {
+	failed := err != nil ||
+		status >= http.StatusInternalServerError ||
+		(b.SlowRequest > 0 && latency > b.SlowRequest)
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	now := b.Now()
+	b.recordEndpoint(key, address, failed, now)
+	b.recordCircuit(key, failed, now)
+
+	if now.Sub(b.sweptAt) > b.Window {
+		b.sweep(now)
+	}
+}
+
+// recordEndpoint ejects address after ConsecutiveFailures, the lock must be held
+func (b *CircuitBreaker) recordEndpoint(key, address string, failed bool, now time.Time) {
+	e, ok := b.endpoints[address]
+	if !failed {
+		// An endpoint which succeeds after its ejection is healthy again
+		if ok && !now.Before(e.ejectedUntil) {
+			delete(b.endpoints, address)
+		}
+		return
+	}
+
+	if !ok {
+		e = &endpointHealth{}
+		b.endpoints[address] = e
+	}
+
+	e.function = key
+	e.failures++
+	e.lastFailure = now
+
+	if e.failures < b.ConsecutiveFailures || now.Before(e.ejectedUntil) {
+		return
+	}
+
+	e.failures = 0
+	e.ejections++
+	ejection := b.EjectionTime * time.Duration(e.ejections)
+	if ejection > b.MaxEjectionTime {
+		ejection = b.MaxEjectionTime
+	}
+	e.ejectedUntil = now.Add(ejection)
+
+	log.Printf("Ejected endpoint: %s of %s for %s", address, key, ejection)
+}
+
+// recordCircuit opens or closes the circuit of a function, the lock must be held
+func (b *CircuitBreaker) recordCircuit(key string, failed bool, now time.Time) {
+	c, ok := b.circuits[key]
+	if !ok {
+		c = &circuit{state: CircuitClosed, windowStart: now}
+		b.circuits[key] = c
+	}
+
+	switch c.state {
+	case CircuitHalfOpen:
+		if failed {
+			c.state = CircuitOpen
+			c.openUntil = now.Add(b.OpenTime)
+			log.Printf("Circuit opened: %s, probe failed", key)
+			return
+		}
+		*c = circuit{state: CircuitClosed, windowStart: now}
+		log.Printf("Circuit closed: %s", key)
+		return
+
+	case CircuitOpen:
+		// Requests which were in flight when the circuit opened are not counted
+		return
+	}
+
+	if now.Sub(c.windowStart) >= b.Window {
+		c.requests, c.failures = 0, 0
+		c.windowStart = now
+	}
+
+	c.requests++
+	if failed {
+		c.failures++
+	}
+
+	if c.requests >= b.MinRequests && float64(c.failures)/float64(c.requests) >= b.ErrorThreshold {
+		c.state = CircuitOpen
+		c.openUntil = now.Add(b.OpenTime)
+		log.Printf("Circuit opened: %s, %d of %d requests failed", key, c.failures, c.requests)
+	}
+}
+
+// sweep removes the endpoints and circuits which have not failed recently, such as
+// those of pods and functions which no longer exist, the lock must be held
+func (b *CircuitBreaker) sweep(now time.Time) {
+	b.sweptAt = now
+
+	for address, e := range b.endpoints {
+		if !now.Before(e.ejectedUntil) && now.Sub(e.lastFailure) > b.MaxEjectionTime {
+			delete(b.endpoints, address)
+		}
+	}
+
+	for key, c := range b.circuits {
+		if c.state == CircuitClosed && now.Sub(c.windowStart) > b.Window {
+			delete(b.circuits, key)
+		}
+	}
+}
+
+// Status returns the state of the circuit of the function given by key, and its
+// endpoints which are ejected
+func (b *CircuitBreaker) Status(key string) CircuitStatus {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	status := CircuitStatus{State: CircuitClosed}
+	if c, ok := b.circuits[key]; ok {
+		status.State = c.state
+	}
+
+	now := b.Now()
+	for address, e := range b.endpoints {
+		if e.function == key && now.Before(e.ejectedUntil) {
+			status.Ejected = append(status.Ejected, address)
+		}
+	}
+	sort.Strings(status.Ejected)
+
+	return status
+}
+
+// Annotations returns the annotations of status for the status of a function
+func (s CircuitStatus) Annotations() map[string]string {
+	annotations := map[string]string{CircuitStateAnnotation: s.State}
+	if len(s.Ejected) > 0 {
+		annotations[EjectedEndpointsAnnotation] = strings.Join(s.Ejected, ",")
+	}
+	return annotations
+}
+
+var (
+	circuitStateDesc = prometheus.NewDesc(
+		"faasnetes_circuit_state",
+		"State of the circuit of a function, 0 closed, 1 half-open, 2 open.",
+		[]string{"function_name"}, nil)
+
+	ejectedEndpointsDesc = prometheus.NewDesc(
+		"faasnetes_ejected_endpoints",
+		"Number of endpoints of a function which are ejected.",
+		[]string{"function_name"}, nil)
+)
+
+// Describe implements prometheus.Collector
+func (b *CircuitBreaker) Describe(ch chan<- *prometheus.Desc) {
+	ch <- circuitStateDesc
+	ch <- ejectedEndpointsDesc
+}
+
+// Collect implements prometheus.Collector, reporting each function which has failed recently
+func (b *CircuitBreaker) Collect(ch chan<- prometheus.Metric) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	now := b.Now()
+	ejected := map[string]int{}
+	for _, e := range b.endpoints {
+		if now.Before(e.ejectedUntil) {
+			ejected[e.function]++
+		}
+	}
+
+	states := map[string]float64{}
+	for key, c := range b.circuits {
+		switch c.state {
+		case CircuitHalfOpen:
+			states[key] = 1
+		case CircuitOpen:
+			states[key] = 2
+		default:
+			states[key] = 0
+		}
+	}
+	for key := range ejected {
+		if _, ok := states[key]; !ok {
+			states[key] = 0
+		}
+	}
+
+	for key, state := range states {
+		ch <- prometheus.MustNewConstMetric(circuitStateDesc, prometheus.GaugeValue, state, key)
+		ch <- prometheus.MustNewConstMetric(ejectedEndpointsDesc, prometheus.GaugeValue, float64(ejected[key]), key)
+	}
+}
@@ -0,0 +1,267 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+package k8s
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func newTestCircuitBreaker() (*CircuitBreaker, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+
+	b := NewCircuitBreaker()
+	b.Now = clock.Now
+	return b, clock
+}
+
+func recordN(b *CircuitBreaker, n int, address string, status int) {
+	for i := 0; i < n; i++ {
+		b.Record("figlet.openfaas-fn", address, status, nil, time.Millisecond)
+	}
+}
+
+func Test_CircuitBreaker_EjectsAfterConsecutiveFailures(t *testing.T) {
+	b, clock := newTestCircuitBreaker()
+	addresses := []string{"10.0.0.1:8080", "10.0.0.2:8080"}
+
+	recordN(b, b.ConsecutiveFailures-1, "10.0.0.1:8080", http.StatusBadGateway)
+	if got := b.Available(addresses); !reflect.DeepEqual(got, addresses) {
+		t.Fatalf("want: %v, got: %v", addresses, got)
+	}
+
+	b.Record("figlet.openfaas-fn", "10.0.0.1:8080", 0, errors.New("connection refused"), time.Millisecond)
+
+	want := []string{"10.0.0.2:8080"}
+	if got := b.Available(addresses); !reflect.DeepEqual(got, want) {
+		t.Fatalf("want: %v, got: %v", want, got)
+	}
+
+	clock.Add(b.EjectionTime)
+	if got := b.Available(addresses); !reflect.DeepEqual(got, addresses) {
+		t.Errorf("want the endpoint back after the ejection time, got: %v", got)
+	}
+}
+
+func Test_CircuitBreaker_SuccessResetsFailures(t *testing.T) {
+	b, _ := newTestCircuitBreaker()
+	addresses := []string{"10.0.0.1:8080", "10.0.0.2:8080"}
+
+	recordN(b, b.ConsecutiveFailures-1, "10.0.0.1:8080", http.StatusInternalServerError)
+	recordN(b, 1, "10.0.0.1:8080", http.StatusOK)
+	recordN(b, b.ConsecutiveFailures-1, "10.0.0.1:8080", http.StatusInternalServerError)
+
+	if got := b.Available(addresses); !reflect.DeepEqual(got, addresses) {
+		t.Errorf("want: %v, got: %v", addresses, got)
+	}
+}
+
+func Test_CircuitBreaker_EjectionTimeGrows(t *testing.T) {
+	b, clock := newTestCircuitBreaker()
+	addresses := []string{"10.0.0.1:8080", "10.0.0.2:8080"}
+
+	recordN(b, b.ConsecutiveFailures, "10.0.0.1:8080", http.StatusBadGateway)
+	clock.Add(b.EjectionTime)
+
+	// Failing again straight after its ejection doubles the time
+	recordN(b, b.ConsecutiveFailures, "10.0.0.1:8080", http.StatusBadGateway)
+	clock.Add(b.EjectionTime)
+
+	if got := b.Available(addresses); len(got) != 1 {
+		t.Errorf("want the endpoint to be ejected for twice as long, got: %v", got)
+	}
+}
+
+func Test_CircuitBreaker_SlowRequestsFail(t *testing.T) {
+	b, _ := newTestCircuitBreaker()
+	b.SlowRequest = time.Second
+	addresses := []string{"10.0.0.1:8080", "10.0.0.2:8080"}
+
+	for i := 0; i < b.ConsecutiveFailures; i++ {
+		b.Record("figlet.openfaas-fn", "10.0.0.1:8080", http.StatusOK, nil, time.Second*2)
+	}
+
+	if got := b.Available(addresses); len(got) != 1 || got[0] != "10.0.0.2:8080" {
+		t.Errorf("want the slow endpoint to be ejected, got: %v", got)
+	}
+}
+
+func Test_CircuitBreaker_MaxEjectionPercent(t *testing.T) {
+	b, _ := newTestCircuitBreaker()
+	addresses := []string{"10.0.0.1:8080", "10.0.0.2:8080", "10.0.0.3:8080", "10.0.0.4:8080"}
+
+	recordN(b, b.ConsecutiveFailures, "10.0.0.1:8080", http.StatusBadGateway)
+	recordN(b, b.ConsecutiveFailures, "10.0.0.2:8080", http.StatusBadGateway)
+	recordN(b, b.ConsecutiveFailures, "10.0.0.3:8080", http.StatusBadGateway)
+
+	// Half of the endpoints are kept, the first one ejected is the first one back
+	want := []string{"10.0.0.1:8080", "10.0.0.4:8080"}
+	if got := b.Available(addresses); !reflect.DeepEqual(got, want) {
+		t.Errorf("want: %v, got: %v", want, got)
+	}
+
+	single := []string{"10.0.0.1:8080"}
+	if got := b.Available(single); !reflect.DeepEqual(got, single) {
+		t.Errorf("want: %v, got: %v", single, got)
+	}
+}
+
+func Test_CircuitBreaker_OpensAndCloses(t *testing.T) {
+	b, clock := newTestCircuitBreaker()
+	key := "figlet.openfaas-fn"
+
+	// Spread over many endpoints, so that the error rate opens the circuit
+	for i := 0; i < b.MinRequests; i++ {
+		status := http.StatusOK
+		if i%2 == 0 {
+			status = http.StatusServiceUnavailable
+		}
+		b.Record(key, string(rune('a'+i)), status, nil, time.Millisecond)
+	}
+
+	if b.Allow(key) {
+		t.Fatalf("want the circuit to be open")
+	}
+	if got := b.Status(key).State; got != CircuitOpen {
+		t.Fatalf("want: %s, got: %s", CircuitOpen, got)
+	}
+
+	clock.Add(b.OpenTime)
+	if !b.Allow(key) {
+		t.Fatalf("want a probe to be allowed after the open time")
+	}
+	if b.Allow(key) {
+		t.Fatalf("want a single probe while half-open")
+	}
+
+	// A failed probe opens the circuit again
+	b.Record(key, "a", http.StatusBadGateway, nil, time.Millisecond)
+	if got := b.Status(key).State; got != CircuitOpen {
+		t.Fatalf("want: %s, got: %s", CircuitOpen, got)
+	}
+
+	clock.Add(b.OpenTime)
+	if !b.Allow(key) {
+		t.Fatalf("want a probe to be allowed after the open time")
+	}
+	b.Record(key, "b", http.StatusOK, nil, time.Millisecond)
+
+	if got := b.Status(key).State; got != CircuitClosed {
+		t.Errorf("want: %s, got: %s", CircuitClosed, got)
+	}
+	if !b.Allow(key) {
+		t.Errorf("want requests to be allowed once the circuit is closed")
+	}
+}
+
+func Test_CircuitBreaker_NeedsMinRequests(t *testing.T) {
+	b, _ := newTestCircuitBreaker()
+
+	recordN(b, b.MinRequests-1, "10.0.0.1:8080", http.StatusBadGateway)
+
+	if !b.Allow("figlet.openfaas-fn") {
+		t.Errorf("want the circuit to stay closed below the minimum requests")
+	}
+}
+
+func Test_CircuitBreaker_ErrorRateWindow(t *testing.T) {
+	b, clock := newTestCircuitBreaker()
+	key := "figlet.openfaas-fn"
+
+	for i := 0; i < b.MinRequests-1; i++ {
+		b.Record(key, string(rune('a'+i)), http.StatusBadGateway, nil, time.Millisecond)
+	}
+
+	// The failures of the previous window are not counted
+	clock.Add(b.Window)
+	b.Record(key, "z", http.StatusBadGateway, nil, time.Millisecond)
+
+	if !b.Allow(key) {
+		t.Errorf("want the circuit to stay closed")
+	}
+}
+
+func Test_CircuitBreaker_Status(t *testing.T) {
+	b, _ := newTestCircuitBreaker()
+
+	recordN(b, b.ConsecutiveFailures, "10.0.0.2:8080", http.StatusBadGateway)
+	recordN(b, b.ConsecutiveFailures, "10.0.0.1:8080", http.StatusBadGateway)
+	b.Record("nodeinfo.openfaas-fn", "10.0.0.3:8080", http.StatusOK, nil, time.Millisecond)
+
+	status := b.Status("figlet.openfaas-fn")
+	want := CircuitStatus{State: CircuitClosed, Ejected: []string{"10.0.0.1:8080", "10.0.0.2:8080"}}
+	if !reflect.DeepEqual(status, want) {
+		t.Errorf("want: %+v, got: %+v", want, status)
+	}
+
+	annotations := status.Annotations()
+	if got := annotations[EjectedEndpointsAnnotation]; got != "10.0.0.1:8080,10.0.0.2:8080" {
+		t.Errorf("want: %s, got: %s", "10.0.0.1:8080,10.0.0.2:8080", got)
+	}
+
+	if got := b.Status("nodeinfo.openfaas-fn"); len(got.Ejected) != 0 {
+		t.Errorf("want no ejected endpoints for nodeinfo, got: %v", got.Ejected)
+	}
+}
+
+func Test_CircuitBreaker_Collect(t *testing.T) {
+	b, _ := newTestCircuitBreaker()
+
+	recordN(b, b.ConsecutiveFailures, "10.0.0.1:8080", http.StatusBadGateway)
+
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(b)
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	got := map[string]float64{}
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			if m.GetLabel()[0].GetValue() == "figlet.openfaas-fn" {
+				got[family.GetName()] = m.GetGauge().GetValue()
+			}
+		}
+	}
+
+	want := map[string]float64{"faasnetes_circuit_state": 0, "faasnetes_ejected_endpoints": 1}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want: %v, got: %v", want, got)
+	}
+}
+
+func Test_FunctionLookup_Breaker(t *testing.T) {
+	lookup := newPolicyLookup(t, nil, "10.0.0.1", "10.0.0.2")
+	b, _ := newTestCircuitBreaker()
+	lookup.Breaker = b
+
+	for i := 0; i < b.ConsecutiveFailures; i++ {
+		lookup.RecordResult("figlet", "10.0.0.1:8080", http.StatusBadGateway, nil, time.Millisecond)
+	}
+
+	for i := 0; i < 10; i++ {
+		u, err := lookup.Resolve("figlet.openfaas-fn")
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if u.Host != "10.0.0.2:8080" {
+			t.Fatalf("want the endpoint which was not ejected, got: %s", u.Host)
+		}
+	}
+
+	for i := 0; i < b.MinRequests; i++ {
+		lookup.RecordResult("figlet", "10.0.0.2:8080", http.StatusBadGateway, nil, time.Millisecond)
+	}
+
+	if _, err := lookup.Resolve("figlet"); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("want: %s, got: %v", ErrCircuitOpen, err)
+	}
+}
@@ -19,4 +19,12 @@ type DeploymentConfig struct {
 	// SetNonRootUser will override the function image user to ensure that it is not root. When
 	// true, the user will set to 12000 for all functions.
 	SetNonRootUser bool
+	// ProfilesNamespace is the namespace in which OpenFaaS Profiles are looked up
+	ProfilesNamespace string
+	// ScaleToZero accepts the scale to zero labels, it is set when faas-netes is
+	// configured to scale idle functions to zero
+	ScaleToZero bool
+	// HPA accepts the com.openfaas.scale.type and com.openfaas.scale.target labels,
+	// it is set when faas-netes is configured to create HorizontalPodAutoscalers
+	HPA bool
 }
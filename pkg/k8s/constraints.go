@@ -0,0 +1,206 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+package k8s
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	types "github.com/openfaas/faas-provider/types"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+)
+
+// ConstraintsAnnotation is added to the Pod template of a function with its constraints
+// as a JSON list, so that they can be read back apart from the node affinity which
+// Profiles merge into the Pod template.
+const ConstraintsAnnotation = "com.openfaas.constraints"
+
+// ParseConstraints converts function constraints into a node selector and the
+// node selector requirements for a required node affinity. Each constraint uses
+// the Kubernetes label selector syntax, i.e.
+//
+//	node.kubernetes.io/instance-type=c5.large
+//	kubernetes.io/arch!=arm64
+//	topology.kubernetes.io/zone in (eu-west-1a,eu-west-1b)
+//	node-role.kubernetes.io/control-plane notin (true)
+//
+// Equality constraints become part of the node selector, all others become match
+// expressions of the node affinity.
+func ParseConstraints(constraints []string) (map[string]string, []corev1.NodeSelectorRequirement, error) {
+	nodeSelector := map[string]string{}
+	expressions := []corev1.NodeSelectorRequirement{}
+
+	for _, constraint := range constraints {
+		selector, err := labels.Parse(constraint)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid constraint %q: %s", constraint, err.Error())
+		}
+
+		requirements, _ := selector.Requirements()
+		if len(requirements) == 0 {
+			return nil, nil, fmt.Errorf("invalid constraint %q: no requirement found", constraint)
+		}
+
+		for _, r := range requirements {
+			values := r.Values().List()
+
+			switch r.Operator() {
+			case selection.Equals, selection.DoubleEquals:
+				if v, ok := nodeSelector[r.Key()]; ok && v != values[0] {
+					return nil, nil, fmt.Errorf("invalid constraint %q: %s is already constrained to %s", constraint, r.Key(), v)
+				}
+				nodeSelector[r.Key()] = values[0]
+			case selection.In:
+				expressions = append(expressions, nodeRequirement(r.Key(), corev1.NodeSelectorOpIn, values))
+			case selection.NotEquals, selection.NotIn:
+				expressions = append(expressions, nodeRequirement(r.Key(), corev1.NodeSelectorOpNotIn, values))
+			case selection.Exists:
+				expressions = append(expressions, nodeRequirement(r.Key(), corev1.NodeSelectorOpExists, nil))
+			case selection.DoesNotExist:
+				expressions = append(expressions, nodeRequirement(r.Key(), corev1.NodeSelectorOpDoesNotExist, nil))
+			case selection.GreaterThan:
+				expressions = append(expressions, nodeRequirement(r.Key(), corev1.NodeSelectorOpGt, values))
+			case selection.LessThan:
+				expressions = append(expressions, nodeRequirement(r.Key(), corev1.NodeSelectorOpLt, values))
+			default:
+				return nil, nil, fmt.Errorf("invalid constraint %q: operator %s is not supported", constraint, r.Operator())
+			}
+		}
+	}
+
+	return nodeSelector, expressions, nil
+}
+
+func nodeRequirement(key string, operator corev1.NodeSelectorOperator, values []string) corev1.NodeSelectorRequirement {
+	return corev1.NodeSelectorRequirement{
+		Key:      key,
+		Operator: operator,
+		Values:   values,
+	}
+}
+
+// ConfigureConstraints sets the node selector and required node affinity of the function
+// Deployment from the constraints in the request, replacing any previous values.
+// This method is safe for both create and update operations.
+func (f *FunctionFactory) ConfigureConstraints(request types.FunctionDeployment, deployment *appsv1.Deployment) error {
+	nodeSelector, expressions, err := ParseConstraints(request.Constraints)
+	if err != nil {
+		return err
+	}
+
+	podSpec := &deployment.Spec.Template.Spec
+	podSpec.NodeSelector = nodeSelector
+	podSpec.Affinity = nil
+
+	// The Deployment and its Pod template may share the same annotations map
+	annotations := make(map[string]string, len(deployment.Spec.Template.Annotations)+1)
+	for k, v := range deployment.Spec.Template.Annotations {
+		annotations[k] = v
+	}
+	delete(annotations, ConstraintsAnnotation)
+	if len(request.Constraints) > 0 {
+		value, err := json.Marshal(request.Constraints)
+		if err != nil {
+			return err
+		}
+		annotations[ConstraintsAnnotation] = string(value)
+	}
+	deployment.Spec.Template.Annotations = annotations
+
+	if len(expressions) > 0 {
+		podSpec.Affinity = &corev1.Affinity{
+			NodeAffinity: &corev1.NodeAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+					NodeSelectorTerms: []corev1.NodeSelectorTerm{
+						{MatchExpressions: expressions},
+					},
+				},
+			},
+		}
+	}
+
+	return nil
+}
+
+// ReadFunctionConstraints returns the constraints of a function Deployment from its
+// ConstraintsAnnotation. Deployments which were created without the annotation have
+// their node selector and required node affinity converted back into constraints.
+func ReadFunctionConstraints(deployment appsv1.Deployment) []string {
+	if value, ok := deployment.Spec.Template.Annotations[ConstraintsAnnotation]; ok {
+		constraints := []string{}
+		if err := json.Unmarshal([]byte(value), &constraints); err == nil {
+			if len(constraints) == 0 {
+				return nil
+			}
+			return constraints
+		}
+	}
+
+	podSpec := deployment.Spec.Template.Spec
+	constraints := []string{}
+
+	keys := make([]string, 0, len(podSpec.NodeSelector))
+	for k := range podSpec.NodeSelector {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		constraints = append(constraints, fmt.Sprintf("%s=%s", k, podSpec.NodeSelector[k]))
+	}
+
+	if podSpec.Affinity != nil &&
+		podSpec.Affinity.NodeAffinity != nil &&
+		podSpec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution != nil {
+
+		for _, term := range podSpec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms {
+			for _, expr := range term.MatchExpressions {
+				if constraint := formatConstraint(expr); len(constraint) > 0 {
+					constraints = append(constraints, constraint)
+				}
+			}
+		}
+	}
+
+	if len(constraints) == 0 {
+		return nil
+	}
+
+	return constraints
+}
+
+func formatConstraint(expr corev1.NodeSelectorRequirement) string {
+	var op selection.Operator
+
+	switch expr.Operator {
+	case corev1.NodeSelectorOpIn:
+		op = selection.In
+	case corev1.NodeSelectorOpNotIn:
+		op = selection.NotIn
+		if len(expr.Values) == 1 {
+			op = selection.NotEquals
+		}
+	case corev1.NodeSelectorOpExists:
+		op = selection.Exists
+	case corev1.NodeSelectorOpDoesNotExist:
+		op = selection.DoesNotExist
+	case corev1.NodeSelectorOpGt:
+		op = selection.GreaterThan
+	case corev1.NodeSelectorOpLt:
+		op = selection.LessThan
+	default:
+		return ""
+	}
+
+	r, err := labels.NewRequirement(expr.Key, op, expr.Values)
+	if err != nil {
+		return ""
+	}
+
+	return r.String()
+}
@@ -0,0 +1,155 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+package k8s
+
+import (
+	"reflect"
+	"testing"
+
+	vv1 "github.com/openfaas/faas-netes/pkg/apis/openfaas/v1"
+	types "github.com/openfaas/faas-provider/types"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func Test_ParseConstraints(t *testing.T) {
+	cases := []struct {
+		name         string
+		constraints  []string
+		nodeSelector map[string]string
+		expressions  []corev1.NodeSelectorRequirement
+		wantErr      bool
+	}{
+		{
+			name:         "no constraints",
+			nodeSelector: map[string]string{},
+			expressions:  []corev1.NodeSelectorRequirement{},
+		},
+		{
+			name:         "equality becomes a node selector",
+			constraints:  []string{"node.kubernetes.io/instance-type=c5.large", "kubernetes.io/os==linux"},
+			nodeSelector: map[string]string{"node.kubernetes.io/instance-type": "c5.large", "kubernetes.io/os": "linux"},
+			expressions:  []corev1.NodeSelectorRequirement{},
+		},
+		{
+			name:         "inequality becomes notin",
+			constraints:  []string{"kubernetes.io/arch!=arm64"},
+			nodeSelector: map[string]string{},
+			expressions: []corev1.NodeSelectorRequirement{
+				{Key: "kubernetes.io/arch", Operator: corev1.NodeSelectorOpNotIn, Values: []string{"arm64"}},
+			},
+		},
+		{
+			name:         "in and notin sets",
+			constraints:  []string{"topology.kubernetes.io/zone in (eu-west-1b,eu-west-1a)", "pool notin (spot)"},
+			nodeSelector: map[string]string{},
+			expressions: []corev1.NodeSelectorRequirement{
+				{Key: "topology.kubernetes.io/zone", Operator: corev1.NodeSelectorOpIn, Values: []string{"eu-west-1a", "eu-west-1b"}},
+				{Key: "pool", Operator: corev1.NodeSelectorOpNotIn, Values: []string{"spot"}},
+			},
+		},
+		{
+			name:        "invalid syntax",
+			constraints: []string{"zone in eu-west-1a"},
+			wantErr:     true,
+		},
+		{
+			name:        "conflicting equality",
+			constraints: []string{"zone=a", "zone=b"},
+			wantErr:     true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			nodeSelector, expressions, err := ParseConstraints(tc.constraints)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if !reflect.DeepEqual(nodeSelector, tc.nodeSelector) {
+				t.Errorf("want node selector: %v, got: %v", tc.nodeSelector, nodeSelector)
+			}
+			if !reflect.DeepEqual(expressions, tc.expressions) {
+				t.Errorf("want expressions: %v, got: %v", tc.expressions, expressions)
+			}
+		})
+	}
+}
+
+func Test_ConfigureConstraints_RoundTrip(t *testing.T) {
+	constraints := []string{
+		"node.kubernetes.io/instance-type=c5.large",
+		"kubernetes.io/arch!=arm64",
+		"topology.kubernetes.io/zone in (eu-west-1a,eu-west-1b)",
+	}
+
+	factory := mockFactory()
+	deployment := &appsv1.Deployment{}
+
+	if err := factory.ConfigureConstraints(types.FunctionDeployment{Constraints: constraints}, deployment); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	got := ReadFunctionConstraints(*deployment)
+	if !reflect.DeepEqual(got, constraints) {
+		t.Errorf("want: %v, got: %v", constraints, got)
+	}
+
+	// Removing the constraints on update clears the affinity
+	if err := factory.ConfigureConstraints(types.FunctionDeployment{}, deployment); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if deployment.Spec.Template.Spec.Affinity != nil {
+		t.Errorf("want affinity to be removed")
+	}
+
+	if got := ReadFunctionConstraints(*deployment); got != nil {
+		t.Errorf("want no constraints, got: %v", got)
+	}
+}
+
+func Test_ReadFunctionConstraints_IgnoresProfileAffinity(t *testing.T) {
+	constraints := []string{"kubernetes.io/arch!=arm64"}
+
+	factory := mockFactory()
+	deployment := &appsv1.Deployment{}
+	if err := factory.ConfigureConstraints(types.FunctionDeployment{Constraints: constraints}, deployment); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	gpu := newProfile("gpu", vv1.ProfileSpec{
+		Affinity: &corev1.Affinity{
+			NodeAffinity: &corev1.NodeAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+					NodeSelectorTerms: []corev1.NodeSelectorTerm{
+						{MatchExpressions: []corev1.NodeSelectorRequirement{nodeRequirement("gpu", corev1.NodeSelectorOpIn, []string{"a100"})}},
+						{MatchExpressions: []corev1.NodeSelectorRequirement{nodeRequirement("gpu", corev1.NodeSelectorOpIn, []string{"h100"})}},
+					},
+				},
+			},
+		},
+	})
+	factory.ApplyProfile(*gpu, deployment)
+
+	if got := ReadFunctionConstraints(*deployment); !reflect.DeepEqual(got, constraints) {
+		t.Errorf("want: %v, got: %v", constraints, got)
+	}
+
+	deployment.Spec.Template.Spec.Containers = []corev1.Container{{Name: "figlet"}}
+	status := AsFunctionStatus(*deployment)
+	if _, ok := (*status.Annotations)[ConstraintsAnnotation]; ok {
+		t.Errorf("want the constraints annotation to be hidden, got: %v", *status.Annotations)
+	}
+	if !reflect.DeepEqual(status.Constraints, constraints) {
+		t.Errorf("want: %v, got: %v", constraints, status.Constraints)
+	}
+}
@@ -0,0 +1,167 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+package k8s
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	discoverylister "k8s.io/client-go/listers/discovery/v1"
+)
+
+// NewEndpointSliceFunctionLookup creates a FunctionLookup which resolves functions
+// from their EndpointSlices instead of the deprecated core/v1 Endpoints, which are
+// truncated at 1000 addresses.
+func NewEndpointSliceFunctionLookup(ns string, lister discoverylister.EndpointSliceLister) *FunctionLookup {
+	l := NewFunctionLookup(ns, nil)
+	l.EndpointSliceLister = lister
+
+	return l
+}
+
+// sliceAddresses returns the sorted addresses of the function from all of the
+// EndpointSlices of its Service
+func (l *FunctionLookup) sliceAddresses(functionName, namespace string) ([]string, error) {
+	selector := labels.SelectorFromSet(labels.Set{discoveryv1.LabelServiceName: functionName})
+
+	slices, err := l.EndpointSliceLister.EndpointSlices(namespace).List(selector)
+	if err != nil {
+		return nil, fmt.Errorf("error listing \"%s.%s\": %s", functionName, namespace, err.Error())
+	}
+
+	if len(slices) == 0 {
+		return nil, fmt.Errorf("no endpoint slices available for \"%s.%s\"", functionName, namespace)
+	}
+
+	addresses := endpointSliceAddresses(slices, l.Zone, l.Port)
+	if len(addresses) == 0 {
+		return nil, fmt.Errorf("no addresses in endpoint slices for \"%s.%s\"", functionName, namespace)
+	}
+
+	return addresses, nil
+}
+
+// endpointSliceAddresses picks the addresses to use from slices in the same way
+// as kube-proxy:
+//
+//   - ready endpoints are used, when there are none then endpoints which are
+//     still serving whilst terminating are used instead
+//   - when zone is set and every endpoint has a topology hint, only the endpoints
+//     hinted for zone are used, unless none are hinted for it
+func endpointSliceAddresses(slices []*discoveryv1.EndpointSlice, zone string, defaultPort int32) []string {
+	ready := []sliceEndpoint{}
+	terminating := []sliceEndpoint{}
+
+	for _, slice := range slices {
+		if slice.AddressType != discoveryv1.AddressTypeIPv4 && slice.AddressType != discoveryv1.AddressTypeIPv6 {
+			continue
+		}
+
+		port := endpointSlicePort(slice.Ports, defaultPort)
+
+		for _, ep := range slice.Endpoints {
+			if len(ep.Addresses) == 0 {
+				continue
+			}
+
+			switch {
+			case isReady(ep):
+				ready = append(ready, sliceEndpoint{ep, port})
+			case isServingTerminating(ep):
+				terminating = append(terminating, sliceEndpoint{ep, port})
+			}
+		}
+	}
+
+	endpoints := ready
+	if len(endpoints) == 0 {
+		endpoints = terminating
+	}
+
+	endpoints = filterByZoneHints(endpoints, zone)
+
+	seen := map[string]bool{}
+	addresses := []string{}
+	for _, ep := range endpoints {
+		// Consumers must use the first address, the others are duplicates
+		// for dual-stack or are ignored
+		address := net.JoinHostPort(ep.Addresses[0], strconv.Itoa(int(ep.port)))
+		if !seen[address] {
+			seen[address] = true
+			addresses = append(addresses, address)
+		}
+	}
+
+	sort.Strings(addresses)
+
+	return addresses
+}
+
+// isReady follows the API's guidance that a nil ready condition means ready
+func isReady(ep discoveryv1.Endpoint) bool {
+	if ep.Conditions.Ready != nil {
+		return *ep.Conditions.Ready
+	}
+
+	return ep.Conditions.Terminating == nil || !*ep.Conditions.Terminating
+}
+
+func isServingTerminating(ep discoveryv1.Endpoint) bool {
+	serving := ep.Conditions.Serving != nil && *ep.Conditions.Serving
+	terminating := ep.Conditions.Terminating != nil && *ep.Conditions.Terminating
+
+	return serving && terminating
+}
+
+// sliceEndpoint is an endpoint with the port of the slice it belongs to
+type sliceEndpoint struct {
+	discoveryv1.Endpoint
+	port int32
+}
+
+// endpointSlicePort returns the port named http, or the first port when there is
+// no port with that name
+func endpointSlicePort(ports []discoveryv1.EndpointPort, defaultPort int32) int32 {
+	for _, p := range ports {
+		if p.Name != nil && *p.Name == "http" && p.Port != nil {
+			return *p.Port
+		}
+	}
+
+	if len(ports) > 0 && ports[0].Port != nil {
+		return *ports[0].Port
+	}
+
+	return defaultPort
+}
+
+func filterByZoneHints(endpoints []sliceEndpoint, zone string) []sliceEndpoint {
+	if len(zone) == 0 {
+		return endpoints
+	}
+
+	inZone := []sliceEndpoint{}
+	for _, ep := range endpoints {
+		if ep.Hints == nil || len(ep.Hints.ForZones) == 0 {
+			return endpoints
+		}
+
+		for _, z := range ep.Hints.ForZones {
+			if z.Name == zone {
+				inZone = append(inZone, ep)
+				break
+			}
+		}
+	}
+
+	if len(inZone) == 0 {
+		return endpoints
+	}
+
+	return inZone
+}
@@ -0,0 +1,193 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+package k8s
+
+import (
+	"reflect"
+	"testing"
+
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubeinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newEndpointSlice(name, service string, endpoints ...discoveryv1.Endpoint) *discoveryv1.EndpointSlice {
+	return &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "openfaas-fn",
+			Labels:    map[string]string{discoveryv1.LabelServiceName: service},
+		},
+		AddressType: discoveryv1.AddressTypeIPv4,
+		Endpoints:   endpoints,
+	}
+}
+
+func withPort(slice *discoveryv1.EndpointSlice, name string, port int32) *discoveryv1.EndpointSlice {
+	slice.Ports = append(slice.Ports, discoveryv1.EndpointPort{Name: &name, Port: &port})
+	return slice
+}
+
+func endpoint(ip string, ready, serving, terminating bool, zones ...string) discoveryv1.Endpoint {
+	ep := discoveryv1.Endpoint{
+		Addresses: []string{ip},
+		Conditions: discoveryv1.EndpointConditions{
+			Ready:       &ready,
+			Serving:     &serving,
+			Terminating: &terminating,
+		},
+	}
+
+	if len(zones) > 0 {
+		ep.Hints = &discoveryv1.EndpointHints{}
+		for _, z := range zones {
+			ep.Hints.ForZones = append(ep.Hints.ForZones, discoveryv1.ForZone{Name: z})
+		}
+	}
+
+	return ep
+}
+
+func Test_EndpointSliceFunctionLookup_MultipleSlices(t *testing.T) {
+	factory := kubeinformers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+	slices := factory.Discovery().V1().EndpointSlices()
+	indexer := slices.Informer().GetIndexer()
+
+	indexer.Add(newEndpointSlice("figlet-abc", "figlet",
+		endpoint("10.0.0.1", true, true, false),
+		endpoint("10.0.0.2", false, false, false),
+	))
+	indexer.Add(newEndpointSlice("figlet-def", "figlet",
+		endpoint("10.0.0.3", true, true, false),
+		// Duplicated across slices whilst an endpoint is moved
+		endpoint("10.0.0.1", true, true, false),
+	))
+	indexer.Add(newEndpointSlice("nodeinfo-abc", "nodeinfo",
+		endpoint("10.0.1.1", true, true, false),
+	))
+
+	lookup := NewEndpointSliceFunctionLookup("openfaas-fn", slices.Lister())
+
+	got, err := lookup.addresses("figlet", "openfaas-fn")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	want := []string{"10.0.0.1:8080", "10.0.0.3:8080"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want: %v, got: %v", want, got)
+	}
+
+	u, err := lookup.Resolve("nodeinfo")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if u.String() != "http://10.0.1.1:8080" {
+		t.Errorf("want: http://10.0.1.1:8080, got: %s", u.String())
+	}
+
+	if _, err := lookup.Resolve("missing"); err == nil {
+		t.Errorf("want an error for a function without endpoint slices")
+	}
+
+	if _, err := lookup.Resolve("figlet.kube-system"); err == nil {
+		t.Errorf("want an error for the kube-system namespace")
+	}
+}
+
+func Test_endpointSliceAddresses(t *testing.T) {
+	cases := []struct {
+		name   string
+		slices []*discoveryv1.EndpointSlice
+		zone   string
+		want   []string
+	}{
+		{
+			name: "nil ready condition is treated as ready",
+			slices: []*discoveryv1.EndpointSlice{
+				newEndpointSlice("a", "figlet", discoveryv1.Endpoint{Addresses: []string{"10.0.0.1"}}),
+			},
+			want: []string{"10.0.0.1:8080"},
+		},
+		{
+			name: "terminating endpoints are ignored when some are ready",
+			slices: []*discoveryv1.EndpointSlice{
+				newEndpointSlice("a", "figlet",
+					endpoint("10.0.0.1", true, true, false),
+					endpoint("10.0.0.2", false, true, true),
+				),
+			},
+			want: []string{"10.0.0.1:8080"},
+		},
+		{
+			name: "serving terminating endpoints are used when none are ready",
+			slices: []*discoveryv1.EndpointSlice{
+				newEndpointSlice("a", "figlet",
+					endpoint("10.0.0.1", false, false, true),
+					endpoint("10.0.0.2", false, true, true),
+				),
+			},
+			want: []string{"10.0.0.2:8080"},
+		},
+		{
+			name: "topology hints prefer the same zone",
+			zone: "eu-west-1a",
+			slices: []*discoveryv1.EndpointSlice{
+				newEndpointSlice("a", "figlet", endpoint("10.0.0.1", true, true, false, "eu-west-1a")),
+				newEndpointSlice("b", "figlet", endpoint("10.0.0.2", true, true, false, "eu-west-1b")),
+			},
+			want: []string{"10.0.0.1:8080"},
+		},
+		{
+			name: "topology hints are ignored unless every endpoint has one",
+			zone: "eu-west-1a",
+			slices: []*discoveryv1.EndpointSlice{
+				newEndpointSlice("a", "figlet",
+					endpoint("10.0.0.1", true, true, false, "eu-west-1a"),
+					endpoint("10.0.0.2", true, true, false),
+				),
+			},
+			want: []string{"10.0.0.1:8080", "10.0.0.2:8080"},
+		},
+		{
+			name: "topology hints are ignored when none match the zone",
+			zone: "eu-west-1c",
+			slices: []*discoveryv1.EndpointSlice{
+				newEndpointSlice("a", "figlet",
+					endpoint("10.0.0.1", true, true, false, "eu-west-1a"),
+					endpoint("10.0.0.2", true, true, false, "eu-west-1b"),
+				),
+			},
+			want: []string{"10.0.0.1:8080", "10.0.0.2:8080"},
+		},
+		{
+			name: "the port of each slice is used",
+			slices: []*discoveryv1.EndpointSlice{
+				withPort(newEndpointSlice("a", "figlet", endpoint("10.0.0.1", true, true, false)), "http", 3000),
+				withPort(newEndpointSlice("b", "figlet", endpoint("10.0.0.2", true, true, false)), "metrics", 8081),
+			},
+			want: []string{"10.0.0.1:3000", "10.0.0.2:8081"},
+		},
+		{
+			name: "FQDN slices are skipped",
+			slices: []*discoveryv1.EndpointSlice{
+				{
+					AddressType: discoveryv1.AddressTypeFQDN,
+					Endpoints:   []discoveryv1.Endpoint{{Addresses: []string{"figlet.example.com"}}},
+				},
+			},
+			want: []string{},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := endpointSliceAddresses(tc.slices, tc.zone, 8080)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("want: %v, got: %v", tc.want, got)
+			}
+		})
+	}
+}
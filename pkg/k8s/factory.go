@@ -19,13 +19,31 @@ import (
 type FunctionFactory struct {
 	Client kubernetes.Interface
 	Config DeploymentConfig
+
+	// Profiles looks up the OpenFaaS Profiles referenced by functions, it is
+	// nil when no OpenFaaS client is available. The Lister created by
+	// NewFunctionFactory calls the API, so it is replaced by the lister of an
+	// informer wherever one is running.
+	Profiles ProfileGetter
 }
 
 func NewFunctionFactory(clientset kubernetes.Interface, config DeploymentConfig, faasclient openfaasv1.OpenfaasV1Interface) FunctionFactory {
-	return FunctionFactory{
+	factory := FunctionFactory{
 		Client: clientset,
 		Config: config,
 	}
+
+	if faasclient != nil {
+		factory.Profiles = &Lister{f: faasclient}
+	}
+
+	return factory
+}
+
+// ProfileGetter returns a lister for the Profiles within a namespace, it is
+// implemented by Lister and by the generated ProfileLister.
+type ProfileGetter interface {
+	Profiles(namespace string) v1.ProfileNamespaceLister
 }
 
 type Lister struct {
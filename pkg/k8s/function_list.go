@@ -8,11 +8,11 @@ import (
 
 type FunctionList struct {
 	deployLister      v1.DeploymentLister
-	namespace         string
+	namespaces        *Namespaces
 	functionsSelector labels.Selector
 }
 
-func NewFunctionList(namespace string, deployLister v1.DeploymentLister) *FunctionList {
+func NewFunctionList(namespaces *Namespaces, deployLister v1.DeploymentLister) *FunctionList {
 
 	sel := labels.NewSelector()
 	req, _ := labels.NewRequirement("faas_function", selection.Exists, []string{})
@@ -20,16 +20,24 @@ func NewFunctionList(namespace string, deployLister v1.DeploymentLister) *Functi
 
 	return &FunctionList{
 		deployLister:      deployLister,
-		namespace:         namespace,
+		namespaces:        namespaces,
 		functionsSelector: onlyFunctions,
 	}
 }
 
+// Count returns the number of functions across all of the managed namespaces
 func (f *FunctionList) Count() (int, error) {
-	list, err := f.deployLister.Deployments(f.namespace).List(f.functionsSelector)
+	list, err := f.deployLister.List(f.functionsSelector)
 	if err != nil {
 		return 0, err
 	}
 
-	return len(list), nil
+	count := 0
+	for _, deployment := range list {
+		if f.namespaces.Validate(deployment.Namespace) == nil {
+			count++
+		}
+	}
+
+	return count, nil
 }
@@ -0,0 +1,56 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+package k8s
+
+import (
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubeinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func Test_FunctionList_CountsManagedNamespaces(t *testing.T) {
+	factory := kubeinformers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+	informer := factory.Apps().V1().Deployments()
+
+	deployments := []struct{ name, namespace string }{
+		{"figlet", "openfaas-fn"},
+		{"nodeinfo", "openfaas-fn"},
+		{"figlet", "staging-fn"},
+		{"figlet", "default"},
+	}
+	for _, d := range deployments {
+		informer.Informer().GetIndexer().Add(&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      d.name,
+				Namespace: d.namespace,
+				Labels:    map[string]string{"faas_function": d.name},
+			},
+		})
+	}
+	informer.Informer().GetIndexer().Add(&appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "gateway", Namespace: "openfaas-fn"},
+	})
+
+	namespaces := NewNamespaces("openfaas-fn", newNamespaceLister(
+		newNamespace("staging-fn", map[string]string{NamespaceAnnotation: "1"}),
+		newNamespace("default", nil),
+	))
+
+	count, err := NewFunctionList(namespaces, informer.Lister()).Count()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if count != 3 {
+		t.Errorf("want: %d, got: %d", 3, count)
+	}
+
+	count, _ = NewFunctionList(NewNamespaces("openfaas-fn", nil), informer.Lister()).Count()
+	if count != 2 {
+		t.Errorf("want: %d, got: %d", 2, count)
+	}
+}
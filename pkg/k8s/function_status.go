@@ -22,6 +22,23 @@ func AsFunctionStatus(item appsv1.Deployment) *types.FunctionStatus {
 	functionContainer := item.Spec.Template.Spec.Containers[0]
 
 	labels := item.Spec.Template.Labels
+
+	// The canary annotations are set on the Deployment, so that changing them does not
+	// roll out the function's pods. The constraints are returned on their own.
+	annotations := item.Spec.Template.Annotations
+	canary := canaryAnnotations(item.Annotations)
+	if _, ok := annotations[ConstraintsAnnotation]; ok || len(canary) > 0 {
+		annotations = make(map[string]string, len(item.Spec.Template.Annotations)+len(canary))
+		for k, v := range item.Spec.Template.Annotations {
+			if k != ConstraintsAnnotation {
+				annotations[k] = v
+			}
+		}
+		for k, v := range canary {
+			annotations[k] = v
+		}
+	}
+
 	function := types.FunctionStatus{
 		Name:              item.Name,
 		Replicas:          replicas,
@@ -29,9 +46,10 @@ func AsFunctionStatus(item appsv1.Deployment) *types.FunctionStatus {
 		AvailableReplicas: uint64(item.Status.AvailableReplicas),
 		InvocationCount:   0,
 		Labels:            &labels,
-		Annotations:       &item.Spec.Template.Annotations,
+		Annotations:       &annotations,
 		Namespace:         item.Namespace,
 		Secrets:           ReadFunctionSecretsSpec(item),
+		Constraints:       ReadFunctionConstraints(item),
 		CreatedAt:         item.CreationTimestamp.Time,
 	}
 
@@ -0,0 +1,139 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// ScaleTypeLabel is the resource a function is scaled on by a
+	// HorizontalPodAutoscaler, either "cpu" or "memory"
+	ScaleTypeLabel = "com.openfaas.scale.type"
+
+	// ScaleTargetLabel is the average utilization of the resource given by
+	// ScaleTypeLabel, as a percentage of the function's requests, i.e. 50
+	ScaleTargetLabel = "com.openfaas.scale.target"
+
+	// DefaultScaleTarget is used for functions without the ScaleTargetLabel
+	DefaultScaleTarget = 50
+)
+
+// HPAEnabled returns true when the labels of a function have it scaled by a
+// HorizontalPodAutoscaler
+func HPAEnabled(labels map[string]string) bool {
+	v := labels[ScaleTypeLabel]
+	return v == string(corev1.ResourceCPU) || v == string(corev1.ResourceMemory)
+}
+
+// ValidateHPA checks the scaling labels of a function which is scaled by a
+// HorizontalPodAutoscaler
+func ValidateHPA(labels map[string]string) error {
+	if v := labels[ScaleTypeLabel]; !HPAEnabled(labels) {
+		return fmt.Errorf("%s: %q is not supported, use cpu or memory", ScaleTypeLabel, v)
+	}
+
+	if _, err := scaleTarget(labels); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// scaleTarget returns the target utilization given by the labels of a function
+func scaleTarget(labels map[string]string) (int32, error) {
+	v, ok := labels[ScaleTargetLabel]
+	if !ok {
+		return DefaultScaleTarget, nil
+	}
+
+	target, err := strconv.Atoi(v)
+	if err != nil || target < 1 {
+		return 0, fmt.Errorf("%s: %q must be a percentage greater than zero", ScaleTargetLabel, v)
+	}
+
+	return int32(target), nil
+}
+
+// MakeHPA creates the HorizontalPodAutoscaler of a function from its labels, which
+// scales its Deployment between the com.openfaas.scale.min and com.openfaas.scale.max
+// labels. maxReplicas is used when the function has no com.openfaas.scale.max label.
+func MakeHPA(name string, labels map[string]string, maxReplicas int32) (*autoscalingv2.HorizontalPodAutoscaler, error) {
+	if err := ValidateHPA(labels); err != nil {
+		return nil, err
+	}
+
+	target, _ := scaleTarget(labels)
+	min, max := ScaleBounds(labels, maxReplicas)
+
+	return &autoscalingv2.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: map[string]string{"faas_function": name},
+		},
+		Spec: autoscalingv2.HorizontalPodAutoscalerSpec{
+			ScaleTargetRef: autoscalingv2.CrossVersionObjectReference{
+				APIVersion: "apps/v1",
+				Kind:       "Deployment",
+				Name:       name,
+			},
+			MinReplicas: &min,
+			MaxReplicas: max,
+			Metrics: []autoscalingv2.MetricSpec{{
+				Type: autoscalingv2.ResourceMetricSourceType,
+				Resource: &autoscalingv2.ResourceMetricSource{
+					Name: corev1.ResourceName(labels[ScaleTypeLabel]),
+					Target: autoscalingv2.MetricTarget{
+						Type:               autoscalingv2.UtilizationMetricType,
+						AverageUtilization: &target,
+					},
+				},
+			}},
+		},
+	}, nil
+}
+
+// ApplyHPA applies hpa with server-side apply in the same way as ApplyDeployment
+func ApplyHPA(ctx context.Context, client kubernetes.Interface, namespace string, hpa *autoscalingv2.HorizontalPodAutoscaler, dryRun bool) (*autoscalingv2.HorizontalPodAutoscaler, error) {
+	config, err := hpaApplyConfiguration(namespace, hpa)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.AutoscalingV2().HorizontalPodAutoscalers(namespace).
+		Apply(ctx, config, metav1.ApplyOptions{FieldManager: FieldManager, DryRun: DryRunOptions(dryRun)})
+}
+
+// DeleteHPA deletes the HorizontalPodAutoscaler of a function, when it has one. An
+// HPA with the same name which was not created for the function is left alone.
+func DeleteHPA(ctx context.Context, client kubernetes.Interface, namespace, name string, dryRun bool) (bool, error) {
+	hpas := client.AutoscalingV2().HorizontalPodAutoscalers(namespace)
+
+	hpa, err := hpas.Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	if hpa.Labels["faas_function"] != name {
+		return false, nil
+	}
+
+	err = hpas.Delete(ctx, name, metav1.DeleteOptions{DryRun: DryRunOptions(dryRun)})
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return false, err
+	}
+
+	return true, nil
+}
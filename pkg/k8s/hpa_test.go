@@ -0,0 +1,54 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+package k8s
+
+import (
+	"context"
+	"testing"
+
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func Test_MakeHPA(t *testing.T) {
+	hpa, err := MakeHPA("figlet", map[string]string{ScaleTypeLabel: "memory", MinScaleLabel: "2"}, 5)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if hpa.Spec.ScaleTargetRef.Kind != "Deployment" || hpa.Spec.ScaleTargetRef.Name != "figlet" {
+		t.Errorf("want the figlet Deployment as the target, got: %v", hpa.Spec.ScaleTargetRef)
+	}
+	if *hpa.Spec.MinReplicas != 2 || hpa.Spec.MaxReplicas != 5 {
+		t.Errorf("want: 2-5 replicas, got: %d-%d", *hpa.Spec.MinReplicas, hpa.Spec.MaxReplicas)
+	}
+
+	metric := hpa.Spec.Metrics[0].Resource
+	if metric.Name != corev1.ResourceMemory {
+		t.Errorf("want: %s, got: %s", corev1.ResourceMemory, metric.Name)
+	}
+	if *metric.Target.AverageUtilization != DefaultScaleTarget {
+		t.Errorf("want: %d, got: %d", DefaultScaleTarget, *metric.Target.AverageUtilization)
+	}
+}
+
+func Test_DeleteHPA_LeavesOtherHPAs(t *testing.T) {
+	kube := fake.NewClientset(&autoscalingv2.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{Name: "figlet", Namespace: "openfaas-fn"},
+	})
+
+	deleted, err := DeleteHPA(context.Background(), kube, "openfaas-fn", "figlet", false)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if deleted {
+		t.Errorf("want an HPA without the faas_function label to be left alone")
+	}
+
+	if _, err := kube.AutoscalingV2().HorizontalPodAutoscalers("openfaas-fn").Get(context.Background(), "figlet", metav1.GetOptions{}); err != nil {
+		t.Errorf("want the HPA to exist, got: %s", err)
+	}
+}
@@ -0,0 +1,114 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+	"github.com/openfaas/faas-provider/httputil"
+)
+
+// InvocationCounter reports the number of invocations of each function within a namespace
+type InvocationCounter interface {
+	// InvocationCounts returns the invocation count of each function in namespace
+	// keyed by the function's name
+	InvocationCounts(ctx context.Context, namespace string) (map[string]float64, error)
+}
+
+type invocationKey struct {
+	namespace   string
+	name        string
+	statusClass string
+}
+
+// InvocationTracker counts the invocations made through the function proxy
+// per function, namespace and class of HTTP status code i.e. 2xx or 5xx.
+// The counts are held in memory and reset when the process restarts.
+type InvocationTracker struct {
+	defaultNamespace string
+
+	lock   sync.RWMutex
+	counts map[invocationKey]uint64
+}
+
+// NewInvocationTracker creates an InvocationTracker, functions invoked without a
+// namespace suffix are counted against defaultNamespace.
+func NewInvocationTracker(defaultNamespace string) *InvocationTracker {
+	return &InvocationTracker{
+		defaultNamespace: defaultNamespace,
+		counts:           map[invocationKey]uint64{},
+	}
+}
+
+// Wrap returns a handler which counts each request served by next. The function
+// name is read from the "name" route variable, as set by the provider's router.
+func (t *InvocationTracker) Wrap(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := mux.Vars(r)["name"]
+		if len(name) == 0 {
+			next(w, r)
+			return
+		}
+
+		ww := httputil.NewHttpWriteInterceptor(w)
+		next(ww, r)
+
+		namespace := getNamespace(name, t.defaultNamespace)
+		functionName := strings.TrimSuffix(name, "."+namespace)
+
+		t.Add(functionName, namespace, ww.Status())
+	}
+}
+
+// Add records a single invocation of a function which returned status
+func (t *InvocationTracker) Add(name, namespace string, status int) {
+	key := invocationKey{
+		namespace:   namespace,
+		name:        name,
+		statusClass: statusClass(status),
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.counts[key]++
+}
+
+// StatusClassCounts returns the invocations of a function grouped by class of status code
+func (t *InvocationTracker) StatusClassCounts(name, namespace string) map[string]uint64 {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	counts := map[string]uint64{}
+	for k, v := range t.counts {
+		if k.name == name && k.namespace == namespace {
+			counts[k.statusClass] += v
+		}
+	}
+
+	return counts
+}
+
+// InvocationCounts returns the total invocations of each function in namespace
+func (t *InvocationTracker) InvocationCounts(ctx context.Context, namespace string) (map[string]float64, error) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	counts := map[string]float64{}
+	for k, v := range t.counts {
+		if k.namespace == namespace {
+			counts[k.name] += float64(v)
+		}
+	}
+
+	return counts, nil
+}
+
+func statusClass(status int) string {
+	return fmt.Sprintf("%dxx", status/100)
+}
@@ -0,0 +1,98 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func Test_InvocationTracker_Wrap(t *testing.T) {
+	tracker := NewInvocationTracker("openfaas-fn")
+
+	handler := tracker.Wrap(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("fail") == "1" {
+			http.Error(w, "failed", http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte("OK"))
+	})
+
+	invoke := func(name, query string) {
+		req := httptest.NewRequest(http.MethodPost, "/function/"+name+query, nil)
+		req = mux.SetURLVars(req, map[string]string{"name": name})
+		handler(httptest.NewRecorder(), req)
+	}
+
+	invoke("nodeinfo", "")
+	invoke("nodeinfo", "")
+	invoke("nodeinfo.openfaas-fn", "?fail=1")
+	invoke("figlet.staging", "")
+
+	counts, err := tracker.InvocationCounts(context.Background(), "openfaas-fn")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if counts["nodeinfo"] != 3 {
+		t.Errorf("want nodeinfo invocations: 3, got: %f", counts["nodeinfo"])
+	}
+	if _, ok := counts["figlet"]; ok {
+		t.Errorf("want figlet to be counted in the staging namespace only")
+	}
+
+	classes := tracker.StatusClassCounts("nodeinfo", "openfaas-fn")
+	if classes["2xx"] != 2 || classes["5xx"] != 1 {
+		t.Errorf("want 2xx: 2 and 5xx: 1, got: %v", classes)
+	}
+
+	staging, _ := tracker.InvocationCounts(context.Background(), "staging")
+	if staging["figlet"] != 1 {
+		t.Errorf("want figlet invocations: 1, got: %f", staging["figlet"])
+	}
+}
+
+func Test_PrometheusInvocationCounter(t *testing.T) {
+	var query string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query().Get("query")
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[
+			{"metric":{"function_name":"nodeinfo.openfaas-fn"},"value":[1700000000.1,"42"]},
+			{"metric":{"function_name":"figlet.openfaas-fn"},"value":[1700000000.1,"7"]}
+		]}}`)
+	}))
+	defer s.Close()
+
+	counter := NewPrometheusInvocationCounter(s.URL+"/", s.Client())
+	counts, err := counter.InvocationCounts(context.Background(), "openfaas-fn")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if !strings.Contains(query, `function_name=~".+\\.openfaas-fn"`) {
+		t.Errorf("want query filtered by namespace, got: %s", query)
+	}
+	if counts["nodeinfo"] != 42 || counts["figlet"] != 7 {
+		t.Errorf("want nodeinfo: 42, figlet: 7, got: %v", counts)
+	}
+}
+
+func Test_PrometheusInvocationCounter_Error(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer s.Close()
+
+	counter := NewPrometheusInvocationCounter(s.URL, s.Client())
+	if _, err := counter.InvocationCounts(context.Background(), "openfaas-fn"); err == nil {
+		t.Fatal("expected an error")
+	}
+}
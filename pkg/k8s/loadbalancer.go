@@ -0,0 +1,179 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+package k8s
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"sync"
+)
+
+const (
+	// LoadBalancerRandom picks an endpoint at random, this is the default
+	LoadBalancerRandom = "random"
+
+	// LoadBalancerRoundRobin picks each endpoint of a function in turn
+	LoadBalancerRoundRobin = "round-robin"
+
+	// LoadBalancerLeastOutstanding picks the endpoint with the fewest in-flight requests
+	LoadBalancerLeastOutstanding = "least-outstanding"
+
+	// LoadBalancerConsistentHash picks the same endpoint for requests with the same
+	// value for the hash header, for as long as that endpoint is available
+	LoadBalancerConsistentHash = "consistent-hash"
+)
+
+const (
+	// LoadBalancerAnnotation overrides the load balancing strategy for a function
+	LoadBalancerAnnotation = "com.openfaas.loadbalancer"
+
+	// LoadBalancerHashHeaderAnnotation overrides the header used by the
+	// consistent-hash strategy for a function
+	LoadBalancerHashHeaderAnnotation = "com.openfaas.loadbalancer.hash-header"
+
+	// DefaultHashHeader is the header used by the consistent-hash strategy
+	// when none is configured
+	DefaultHashHeader = "X-Session-Id"
+)
+
+// LoadBalancer picks one of the addresses of a function for a request.
+type LoadBalancer interface {
+	// Pick returns one of addresses for the function identified by key, i.e.
+	// "name.namespace". hashKey is the value of the hash header for the
+	// request, and is empty when not set.
+	Pick(key string, addresses []string, hashKey string) string
+}
+
+// ValidateLoadBalancer returns an error if strategy is not a known load balancing strategy
+func ValidateLoadBalancer(strategy string) error {
+	switch strategy {
+	case LoadBalancerRandom, LoadBalancerRoundRobin, LoadBalancerLeastOutstanding, LoadBalancerConsistentHash:
+		return nil
+	}
+	return fmt.Errorf("load balancer %q is not supported, use one of: %s, %s, %s or %s",
+		strategy, LoadBalancerRandom, LoadBalancerRoundRobin, LoadBalancerLeastOutstanding, LoadBalancerConsistentHash)
+}
+
+func newLoadBalancers(inflight *InflightRequests) map[string]LoadBalancer {
+	random := randomBalancer{}
+
+	return map[string]LoadBalancer{
+		LoadBalancerRandom:           random,
+		LoadBalancerRoundRobin:       &roundRobinBalancer{next: map[string]uint64{}},
+		LoadBalancerLeastOutstanding: &leastOutstandingBalancer{inflight: inflight},
+		LoadBalancerConsistentHash:   &consistentHashBalancer{fallback: random},
+	}
+}
+
+type randomBalancer struct{}
+
+func (randomBalancer) Pick(key string, addresses []string, hashKey string) string {
+	return addresses[rand.Intn(len(addresses))]
+}
+
+type roundRobinBalancer struct {
+	lock sync.Mutex
+	next map[string]uint64
+}
+
+func (b *roundRobinBalancer) Pick(key string, addresses []string, hashKey string) string {
+	b.lock.Lock()
+	i := b.next[key]
+	b.next[key] = i + 1
+	b.lock.Unlock()
+
+	return addresses[i%uint64(len(addresses))]
+}
+
+type leastOutstandingBalancer struct {
+	inflight *InflightRequests
+}
+
+// Pick returns the address with the fewest in-flight requests, ties are broken
+// at random so that idle endpoints share new requests evenly.
+func (b *leastOutstandingBalancer) Pick(key string, addresses []string, hashKey string) string {
+	offset := rand.Intn(len(addresses))
+
+	best := ""
+	var bestCount int64
+	for i := range addresses {
+		address := addresses[(offset+i)%len(addresses)]
+		count := b.inflight.Count(address)
+		if best == "" || count < bestCount {
+			best = address
+			bestCount = count
+		}
+	}
+
+	return best
+}
+
+// consistentHashBalancer uses rendezvous hashing, so that only the requests for
+// an endpoint which is removed get moved to another endpoint.
+type consistentHashBalancer struct {
+	fallback LoadBalancer
+}
+
+func (b *consistentHashBalancer) Pick(key string, addresses []string, hashKey string) string {
+	if len(hashKey) == 0 {
+		return b.fallback.Pick(key, addresses, hashKey)
+	}
+
+	best := ""
+	var bestScore uint64
+	for _, address := range addresses {
+		h := fnv.New64a()
+		h.Write([]byte(hashKey))
+		h.Write([]byte{0})
+		h.Write([]byte(address))
+
+		if score := h.Sum64(); best == "" || score > bestScore {
+			best = address
+			bestScore = score
+		}
+	}
+
+	return best
+}
+
+// InflightRequests counts the requests in progress to each endpoint, keyed by
+// the endpoint's address i.e. 10.0.0.1:8080
+type InflightRequests struct {
+	lock   sync.RWMutex
+	counts map[string]int64
+}
+
+// NewInflightRequests creates an empty InflightRequests
+func NewInflightRequests() *InflightRequests {
+	return &InflightRequests{counts: map[string]int64{}}
+}
+
+// Start records the start of a request to address, the returned func must be
+// called once the request completes.
+func (i *InflightRequests) Start(address string) func() {
+	i.lock.Lock()
+	i.counts[address]++
+	i.lock.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			i.lock.Lock()
+			defer i.lock.Unlock()
+
+			i.counts[address]--
+			if i.counts[address] <= 0 {
+				delete(i.counts, address)
+			}
+		})
+	}
+}
+
+// Count returns the number of requests in progress to address
+func (i *InflightRequests) Count(address string) int64 {
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+	return i.counts[address]
+}
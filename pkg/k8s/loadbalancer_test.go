@@ -0,0 +1,159 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+package k8s
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubeinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func Test_RoundRobinBalancer(t *testing.T) {
+	b := newLoadBalancers(NewInflightRequests())[LoadBalancerRoundRobin]
+	addresses := []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}
+
+	got := []string{}
+	for i := 0; i < 4; i++ {
+		got = append(got, b.Pick("figlet.openfaas-fn", addresses, ""))
+	}
+
+	want := []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.1"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("want: %v, got: %v", want, got)
+		}
+	}
+
+	// Each function has its own position
+	if v := b.Pick("nodeinfo.openfaas-fn", addresses, ""); v != "10.0.0.1" {
+		t.Errorf("want: 10.0.0.1, got: %s", v)
+	}
+}
+
+func Test_LeastOutstandingBalancer(t *testing.T) {
+	inflight := NewInflightRequests()
+	b := newLoadBalancers(inflight)[LoadBalancerLeastOutstanding]
+	addresses := []string{"10.0.0.1", "10.0.0.2"}
+
+	done1 := inflight.Start("10.0.0.1")
+	inflight.Start("10.0.0.1")
+
+	for i := 0; i < 10; i++ {
+		if v := b.Pick("figlet.openfaas-fn", addresses, ""); v != "10.0.0.2" {
+			t.Fatalf("want the idle endpoint: 10.0.0.2, got: %s", v)
+		}
+	}
+
+	done1()
+	done1()
+	if got := inflight.Count("10.0.0.1"); got != 1 {
+		t.Errorf("want done to be idempotent, in-flight: 1, got: %d", got)
+	}
+}
+
+func Test_ConsistentHashBalancer(t *testing.T) {
+	b := newLoadBalancers(NewInflightRequests())[LoadBalancerConsistentHash]
+	addresses := []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4"}
+
+	picked := map[string]string{}
+	for i := 0; i < 50; i++ {
+		session := fmt.Sprintf("session-%d", i)
+		picked[session] = b.Pick("figlet.openfaas-fn", addresses, session)
+
+		if again := b.Pick("figlet.openfaas-fn", addresses, session); again != picked[session] {
+			t.Fatalf("want session %s to stick to: %s, got: %s", session, picked[session], again)
+		}
+	}
+
+	// Removing an endpoint only moves the sessions which were using it
+	remaining := []string{"10.0.0.1", "10.0.0.2", "10.0.0.4"}
+	for session, address := range picked {
+		if address == "10.0.0.3" {
+			continue
+		}
+		if got := b.Pick("figlet.openfaas-fn", remaining, session); got != address {
+			t.Errorf("want session %s to stay on: %s, got: %s", session, address, got)
+		}
+	}
+}
+
+func Test_FunctionLookup_AllSubsets(t *testing.T) {
+	factory := kubeinformers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+	endpoints := factory.Core().V1().Endpoints()
+	endpoints.Informer().GetIndexer().Add(&corev1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Name: "figlet", Namespace: "openfaas-fn"},
+		Subsets: []corev1.EndpointSubset{
+			{Addresses: []corev1.EndpointAddress{{IP: "10.0.0.2"}}},
+			{Addresses: []corev1.EndpointAddress{{IP: "10.0.0.1"}, {IP: "10.0.0.3"}}},
+		},
+	})
+
+	lookup := NewFunctionLookup("openfaas-fn", endpoints.Lister())
+	lookup.LoadBalancer = LoadBalancerRoundRobin
+
+	seen := map[string]bool{}
+	for i := 0; i < 3; i++ {
+		u, err := lookup.Resolve("figlet")
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		seen[u.Hostname()] = true
+	}
+
+	if len(seen) != 3 {
+		t.Errorf("want all 3 addresses across subsets, got: %v", seen)
+	}
+}
+
+func Test_FunctionLookup_AnnotationOverridesStrategy(t *testing.T) {
+	factory := kubeinformers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+	deployments := factory.Apps().V1().Deployments()
+	deployments.Informer().GetIndexer().Add(&appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "testfunc", Namespace: "testDefault"},
+		Spec: appsv1.DeploymentSpec{
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{
+						LoadBalancerAnnotation:           LoadBalancerConsistentHash,
+						LoadBalancerHashHeaderAnnotation: "X-User",
+					},
+				},
+			},
+		},
+	})
+
+	lookup := NewFunctionLookup("testDefault", FakeLister{})
+	lookup.DeploymentLister = deployments.Lister()
+
+	strategy, header := lookup.loadBalancing("testfunc", "testDefault")
+	if strategy != LoadBalancerConsistentHash || header != "X-User" {
+		t.Errorf("want: %s and X-User, got: %s and %s", LoadBalancerConsistentHash, strategy, header)
+	}
+
+	strategy, header = lookup.loadBalancing("other", "testDefault")
+	if strategy != LoadBalancerRandom || header != DefaultHashHeader {
+		t.Errorf("want defaults: %s and %s, got: %s and %s", LoadBalancerRandom, DefaultHashHeader, strategy, header)
+	}
+
+	r, _ := http.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("X-User", "alex")
+	_, done, err := lookup.ResolveRequest("testfunc", r)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if got := lookup.Inflight.Count("127.0.0.1:8080"); got != 1 {
+		t.Errorf("want 1 in-flight request, got: %d", got)
+	}
+	done()
+	if got := lookup.Inflight.Count("127.0.0.1:8080"); got != 0 {
+		t.Errorf("want 0 in-flight requests, got: %d", got)
+	}
+}
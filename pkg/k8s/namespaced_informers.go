@@ -0,0 +1,191 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+package k8s
+
+import (
+	"time"
+
+	faasinformers "github.com/openfaas/faas-netes/pkg/client/informers/externalversions/openfaas/v1"
+	faaslisters "github.com/openfaas/faas-netes/pkg/client/listers/openfaas/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	appsinformers "k8s.io/client-go/informers/apps/v1"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	discoveryinformers "k8s.io/client-go/informers/discovery/v1"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	discoverylisters "k8s.io/client-go/listers/discovery/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Owns returns true when obj is in a managed namespace, it can be used as the
+// FilterFunc of a cache.FilteringResourceEventHandler
+func (n *Namespaces) Owns(obj interface{}) bool {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+
+	o, err := meta.Accessor(obj)
+	if err != nil {
+		return false
+	}
+
+	return n.Validate(o.GetNamespace()) == nil
+}
+
+// NewNamespacedDeploymentInformer limits the events and lister of informer to the
+// managed namespaces. With a ClusterRole the informers watch every namespace, so
+// that namespaces can be annotated with "openfaas" at any time.
+func NewNamespacedDeploymentInformer(informer appsinformers.DeploymentInformer, namespaces *Namespaces) appsinformers.DeploymentInformer {
+	return &namespacedDeploymentInformer{informer: newNamespacedInformer(informer.Informer(), namespaces)}
+}
+
+// NewNamespacedEndpointsInformer limits the events and lister of informer to the
+// managed namespaces
+func NewNamespacedEndpointsInformer(informer coreinformers.EndpointsInformer, namespaces *Namespaces) coreinformers.EndpointsInformer {
+	return &namespacedEndpointsInformer{informer: newNamespacedInformer(informer.Informer(), namespaces)}
+}
+
+// NewNamespacedEndpointSliceInformer limits the events and lister of informer to the
+// managed namespaces
+func NewNamespacedEndpointSliceInformer(informer discoveryinformers.EndpointSliceInformer, namespaces *Namespaces) discoveryinformers.EndpointSliceInformer {
+	return &namespacedEndpointSliceInformer{informer: newNamespacedInformer(informer.Informer(), namespaces)}
+}
+
+// NewNamespacedFunctionInformer limits the events and lister of informer to the
+// managed namespaces
+func NewNamespacedFunctionInformer(informer faasinformers.FunctionInformer, namespaces *Namespaces) faasinformers.FunctionInformer {
+	return &namespacedFunctionInformer{informer: newNamespacedInformer(informer.Informer(), namespaces)}
+}
+
+type namespacedDeploymentInformer struct {
+	informer cache.SharedIndexInformer
+}
+
+func (i *namespacedDeploymentInformer) Informer() cache.SharedIndexInformer {
+	return i.informer
+}
+
+func (i *namespacedDeploymentInformer) Lister() appslisters.DeploymentLister {
+	return appslisters.NewDeploymentLister(i.informer.GetIndexer())
+}
+
+type namespacedEndpointsInformer struct {
+	informer cache.SharedIndexInformer
+}
+
+func (i *namespacedEndpointsInformer) Informer() cache.SharedIndexInformer {
+	return i.informer
+}
+
+func (i *namespacedEndpointsInformer) Lister() corelisters.EndpointsLister {
+	return corelisters.NewEndpointsLister(i.informer.GetIndexer())
+}
+
+type namespacedEndpointSliceInformer struct {
+	informer cache.SharedIndexInformer
+}
+
+func (i *namespacedEndpointSliceInformer) Informer() cache.SharedIndexInformer {
+	return i.informer
+}
+
+func (i *namespacedEndpointSliceInformer) Lister() discoverylisters.EndpointSliceLister {
+	return discoverylisters.NewEndpointSliceLister(i.informer.GetIndexer())
+}
+
+type namespacedFunctionInformer struct {
+	informer cache.SharedIndexInformer
+}
+
+func (i *namespacedFunctionInformer) Informer() cache.SharedIndexInformer {
+	return i.informer
+}
+
+func (i *namespacedFunctionInformer) Lister() faaslisters.FunctionLister {
+	return faaslisters.NewFunctionLister(i.informer.GetIndexer())
+}
+
+// namespacedInformer only passes on the events of objects in the managed
+// namespaces, and hides the other objects from its indexer
+type namespacedInformer struct {
+	cache.SharedIndexInformer
+	indexer    cache.Indexer
+	namespaces *Namespaces
+}
+
+func newNamespacedInformer(informer cache.SharedIndexInformer, namespaces *Namespaces) cache.SharedIndexInformer {
+	return &namespacedInformer{
+		SharedIndexInformer: informer,
+		indexer:             &namespacedIndexer{Indexer: informer.GetIndexer(), namespaces: namespaces},
+		namespaces:          namespaces,
+	}
+}
+
+func (i *namespacedInformer) AddEventHandler(handler cache.ResourceEventHandler) (cache.ResourceEventHandlerRegistration, error) {
+	return i.SharedIndexInformer.AddEventHandler(i.filter(handler))
+}
+
+func (i *namespacedInformer) AddEventHandlerWithResyncPeriod(handler cache.ResourceEventHandler, resyncPeriod time.Duration) (cache.ResourceEventHandlerRegistration, error) {
+	return i.SharedIndexInformer.AddEventHandlerWithResyncPeriod(i.filter(handler), resyncPeriod)
+}
+
+func (i *namespacedInformer) GetStore() cache.Store {
+	return i.indexer
+}
+
+func (i *namespacedInformer) GetIndexer() cache.Indexer {
+	return i.indexer
+}
+
+func (i *namespacedInformer) filter(handler cache.ResourceEventHandler) cache.ResourceEventHandler {
+	return cache.FilteringResourceEventHandler{
+		FilterFunc: i.namespaces.Owns,
+		Handler:    handler,
+	}
+}
+
+// namespacedIndexer hides the objects which are not in a managed namespace
+type namespacedIndexer struct {
+	cache.Indexer
+	namespaces *Namespaces
+}
+
+func (i *namespacedIndexer) List() []interface{} {
+	return i.owned(i.Indexer.List())
+}
+
+func (i *namespacedIndexer) Get(obj interface{}) (interface{}, bool, error) {
+	return i.own(i.Indexer.Get(obj))
+}
+
+func (i *namespacedIndexer) GetByKey(key string) (interface{}, bool, error) {
+	return i.own(i.Indexer.GetByKey(key))
+}
+
+func (i *namespacedIndexer) Index(indexName string, obj interface{}) ([]interface{}, error) {
+	items, err := i.Indexer.Index(indexName, obj)
+	return i.owned(items), err
+}
+
+func (i *namespacedIndexer) ByIndex(indexName, indexedValue string) ([]interface{}, error) {
+	items, err := i.Indexer.ByIndex(indexName, indexedValue)
+	return i.owned(items), err
+}
+
+func (i *namespacedIndexer) own(item interface{}, exists bool, err error) (interface{}, bool, error) {
+	if err != nil || !exists || !i.namespaces.Owns(item) {
+		return nil, false, err
+	}
+	return item, true, nil
+}
+
+func (i *namespacedIndexer) owned(items []interface{}) []interface{} {
+	out := make([]interface{}, 0, len(items))
+	for _, item := range items {
+		if i.namespaces.Owns(item) {
+			out = append(out, item)
+		}
+	}
+	return out
+}
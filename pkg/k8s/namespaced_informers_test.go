@@ -0,0 +1,125 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+package k8s
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	kubeinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+func newNamespacedDeployment(name, namespace string) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    map[string]string{"faas_function": name},
+		},
+	}
+}
+
+func Test_NamespacedDeploymentInformer_Lister(t *testing.T) {
+	factory := kubeinformers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+	informer := factory.Apps().V1().Deployments()
+
+	for _, ns := range []string{"openfaas-fn", "staging-fn", "default"} {
+		informer.Informer().GetIndexer().Add(newNamespacedDeployment("figlet", ns))
+	}
+
+	namespaces := NewNamespaces("openfaas-fn", newNamespaceLister(
+		newNamespace("staging-fn", map[string]string{NamespaceAnnotation: "1"}),
+		newNamespace("default", nil),
+	))
+
+	lister := NewNamespacedDeploymentInformer(informer, namespaces).Lister()
+
+	list, err := lister.List(labels.Everything())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	got := []string{}
+	for _, d := range list {
+		got = append(got, d.Namespace)
+	}
+	sort.Strings(got)
+
+	if len(got) != 2 || got[0] != "openfaas-fn" || got[1] != "staging-fn" {
+		t.Errorf("want: %v, got: %v", []string{"openfaas-fn", "staging-fn"}, got)
+	}
+
+	if _, err := lister.Deployments("staging-fn").Get("figlet"); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	if _, err := lister.Deployments("default").Get("figlet"); !errors.IsNotFound(err) {
+		t.Errorf("want: not found, got: %v", err)
+	}
+
+	items, _ := lister.Deployments("default").List(labels.Everything())
+	if len(items) != 0 {
+		t.Errorf("want: %d, got: %d", 0, len(items))
+	}
+}
+
+func Test_NamespacedDeploymentInformer_FiltersEvents(t *testing.T) {
+	kube := fake.NewSimpleClientset()
+	factory := kubeinformers.NewSharedInformerFactory(kube, 0)
+
+	namespaces := NewNamespaces("openfaas-fn", newNamespaceLister(
+		newNamespace("default", nil),
+	))
+	informer := NewNamespacedDeploymentInformer(factory.Apps().V1().Deployments(), namespaces)
+
+	var lock sync.Mutex
+	added := []string{}
+	informer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			lock.Lock()
+			defer lock.Unlock()
+			added = append(added, obj.(*appsv1.Deployment).Namespace)
+		},
+	})
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go informer.Informer().Run(stopCh)
+	cache.WaitForCacheSync(stopCh, informer.Informer().HasSynced)
+
+	for _, ns := range []string{"default", "openfaas-fn"} {
+		if _, err := kube.AppsV1().Deployments(ns).Create(context.Background(), newNamespacedDeployment("figlet", ns), metav1.CreateOptions{}); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	deadline := time.Now().Add(time.Second * 5)
+	for time.Now().Before(deadline) {
+		lock.Lock()
+		n := len(added)
+		lock.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	// Give an event for the unmanaged namespace time to arrive, if it was passed on
+	time.Sleep(time.Millisecond * 100)
+
+	lock.Lock()
+	defer lock.Unlock()
+	if len(added) != 1 || added[0] != "openfaas-fn" {
+		t.Errorf("want: %v, got: %v", []string{"openfaas-fn"}, added)
+	}
+}
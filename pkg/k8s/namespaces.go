@@ -0,0 +1,87 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+package k8s
+
+import (
+	"fmt"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	corelister "k8s.io/client-go/listers/core/v1"
+)
+
+// NamespaceAnnotation marks a namespace as managed by OpenFaaS, the value is ignored
+const NamespaceAnnotation = "openfaas"
+
+// Namespaces decides which namespaces functions can be managed in. The default
+// namespace is always managed, when Lister is set every namespace annotated with
+// "openfaas" is also managed.
+type Namespaces struct {
+	DefaultNamespace string
+
+	// Lister is used to find the annotated namespaces, it requires a ClusterRole
+	Lister corelister.NamespaceLister
+}
+
+// NewNamespaces creates Namespaces for defaultNamespace, lister is optional and
+// enables the namespaces annotated with "openfaas"
+func NewNamespaces(defaultNamespace string, lister corelister.NamespaceLister) *Namespaces {
+	return &Namespaces{
+		DefaultNamespace: defaultNamespace,
+		Lister:           lister,
+	}
+}
+
+// List returns the sorted names of the managed namespaces
+func (n *Namespaces) List() ([]string, error) {
+	if n.Lister == nil {
+		return []string{n.DefaultNamespace}, nil
+	}
+
+	items, err := n.Lister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+
+	set := []string{n.DefaultNamespace}
+	for _, ns := range items {
+		if _, ok := ns.Annotations[NamespaceAnnotation]; ok && ns.Name != n.DefaultNamespace && ns.Name != "kube-system" {
+			set = append(set, ns.Name)
+		}
+	}
+
+	sort.Strings(set)
+
+	return set, nil
+}
+
+// Validate returns an error when functions cannot be managed in namespace
+func (n *Namespaces) Validate(namespace string) error {
+	if namespace == n.DefaultNamespace {
+		return nil
+	}
+
+	if n.Lister == nil {
+		return fmt.Errorf("namespace must be: %s", n.DefaultNamespace)
+	}
+
+	if namespace == "kube-system" {
+		return fmt.Errorf("namespace %s is not allowed", namespace)
+	}
+
+	ns, err := n.Lister.Get(namespace)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return fmt.Errorf("namespace %s not found", namespace)
+		}
+		return err
+	}
+
+	if _, ok := ns.Annotations[NamespaceAnnotation]; !ok {
+		return fmt.Errorf("namespace %s is not annotated with %q", namespace, NamespaceAnnotation)
+	}
+
+	return nil
+}
@@ -0,0 +1,116 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+package k8s
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubeinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	corelister "k8s.io/client-go/listers/core/v1"
+)
+
+func newNamespaceLister(namespaces ...*corev1.Namespace) corelister.NamespaceLister {
+	factory := kubeinformers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+	informer := factory.Core().V1().Namespaces()
+	for _, ns := range namespaces {
+		informer.Informer().GetIndexer().Add(ns)
+	}
+
+	return informer.Lister()
+}
+
+func newNamespace(name string, annotations map[string]string) *corev1.Namespace {
+	return &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: annotations},
+	}
+}
+
+func Test_Namespaces_Validate(t *testing.T) {
+	lister := newNamespaceLister(
+		newNamespace("staging-fn", map[string]string{NamespaceAnnotation: "1"}),
+		newNamespace("default", nil),
+		newNamespace("kube-system", map[string]string{NamespaceAnnotation: "1"}),
+	)
+
+	cases := []struct {
+		name      string
+		lister    corelister.NamespaceLister
+		namespace string
+		wantErr   bool
+	}{
+		{name: "default namespace without a lister", namespace: "openfaas-fn"},
+		{name: "annotated namespace without a lister", namespace: "staging-fn", wantErr: true},
+		{name: "default namespace", lister: lister, namespace: "openfaas-fn"},
+		{name: "annotated namespace", lister: lister, namespace: "staging-fn"},
+		{name: "namespace without the annotation", lister: lister, namespace: "default", wantErr: true},
+		{name: "missing namespace", lister: lister, namespace: "dev-fn", wantErr: true},
+		{name: "kube-system is never managed", lister: lister, namespace: "kube-system", wantErr: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := NewNamespaces("openfaas-fn", tc.lister).Validate(tc.namespace)
+			if tc.wantErr != (err != nil) {
+				t.Errorf("want error: %v, got: %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func Test_Namespaces_List(t *testing.T) {
+	lister := newNamespaceLister(
+		newNamespace("staging-fn", map[string]string{NamespaceAnnotation: "1"}),
+		newNamespace("dev-fn", map[string]string{NamespaceAnnotation: "true"}),
+		newNamespace("default", nil),
+		newNamespace("kube-system", map[string]string{NamespaceAnnotation: "1"}),
+	)
+
+	got, err := NewNamespaces("openfaas-fn", lister).List()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	want := []string{"dev-fn", "openfaas-fn", "staging-fn"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want: %v, got: %v", want, got)
+	}
+
+	got, _ = NewNamespaces("openfaas-fn", nil).List()
+	if !reflect.DeepEqual(got, []string{"openfaas-fn"}) {
+		t.Errorf("want: [openfaas-fn], got: %v", got)
+	}
+}
+
+func Test_FunctionLookup_ResolvesManagedNamespaces(t *testing.T) {
+	factory := kubeinformers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+	endpoints := factory.Core().V1().Endpoints()
+	for _, ns := range []string{"openfaas-fn", "staging-fn", "default"} {
+		endpoints.Informer().GetIndexer().Add(&corev1.Endpoints{
+			ObjectMeta: metav1.ObjectMeta{Name: "figlet", Namespace: ns},
+			Subsets: []corev1.EndpointSubset{
+				{Addresses: []corev1.EndpointAddress{{IP: "10.0.0.1"}}},
+			},
+		})
+	}
+
+	lookup := NewFunctionLookup("openfaas-fn", endpoints.Lister())
+	lookup.Namespaces = NewNamespaces("openfaas-fn", newNamespaceLister(
+		newNamespace("staging-fn", map[string]string{NamespaceAnnotation: "1"}),
+		newNamespace("default", nil),
+	))
+
+	for _, name := range []string{"figlet", "figlet.openfaas-fn", "figlet.staging-fn"} {
+		if _, err := lookup.Resolve(name); err != nil {
+			t.Errorf("want %s to resolve, got: %s", name, err)
+		}
+	}
+
+	if _, err := lookup.Resolve("figlet.default"); err == nil {
+		t.Errorf("want an error for a namespace without the annotation")
+	}
+}
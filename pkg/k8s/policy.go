@@ -0,0 +1,122 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+package k8s
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/openfaas/faas-netes/pkg/proxy"
+	ftypes "github.com/openfaas/faas-provider/types"
+)
+
+const (
+	// TimeoutAnnotation is the time allowed for an invocation of a function including
+	// its retries, i.e. 30s
+	TimeoutAnnotation = "com.openfaas.timeout"
+
+	// RetryAttemptsAnnotation is the number of times an invocation of a function with
+	// an idempotent method is retried on another endpoint
+	RetryAttemptsAnnotation = "com.openfaas.retry.attempts"
+
+	// RetryCodesAnnotation is a comma separated list of the status codes of a
+	// function which are retried, i.e. 502,503,504
+	RetryCodesAnnotation = "com.openfaas.retry.codes"
+
+	// MaxRetryAttempts is the highest value of RetryAttemptsAnnotation
+	MaxRetryAttempts = 10
+)
+
+// ParsePolicy returns the timeout and retries given by the annotations of a function
+func ParsePolicy(annotations map[string]string) (proxy.Policy, error) {
+	policy := proxy.Policy{RetryCodes: proxy.DefaultRetryCodes}
+
+	if v, ok := annotations[TimeoutAnnotation]; ok {
+		timeout := ftypes.ParseIntOrDurationValue(v, 0)
+		if timeout <= 0 {
+			return proxy.Policy{}, fmt.Errorf("%s: %q must be a duration greater than zero", TimeoutAnnotation, v)
+		}
+		policy.Timeout = timeout
+	}
+
+	if v, ok := annotations[RetryAttemptsAnnotation]; ok {
+		attempts, err := strconv.Atoi(v)
+		if err != nil || attempts < 0 || attempts > MaxRetryAttempts {
+			return proxy.Policy{}, fmt.Errorf("%s: %q must be between 0 and %d", RetryAttemptsAnnotation, v, MaxRetryAttempts)
+		}
+		policy.RetryAttempts = attempts
+	}
+
+	if v, ok := annotations[RetryCodesAnnotation]; ok {
+		codes := []int{}
+		for _, c := range strings.Split(v, ",") {
+			code, err := strconv.Atoi(strings.TrimSpace(c))
+			if err != nil || code < http.StatusContinue || code > 599 {
+				return proxy.Policy{}, fmt.Errorf("%s: %q is not a list of status codes", RetryCodesAnnotation, v)
+			}
+			codes = append(codes, code)
+		}
+		policy.RetryCodes = codes
+	}
+
+	return policy, nil
+}
+
+// Policy returns the timeout and retries of a function, from its annotations
+func (l *FunctionLookup) Policy(name string) proxy.Policy {
+	if l.DeploymentLister == nil {
+		return proxy.Policy{}
+	}
+
+	namespace := getNamespace(name, l.DefaultNamespace)
+	functionName := strings.TrimSuffix(name, "."+namespace)
+	if l.verifyNamespace(namespace) != nil {
+		return proxy.Policy{}
+	}
+
+	deployment, err := l.DeploymentLister.Deployments(namespace).Get(functionName)
+	if err != nil {
+		return proxy.Policy{}
+	}
+
+	// Invalid annotations are rejected when the function is deployed
+	policy, err := ParsePolicy(deployment.Spec.Template.Annotations)
+	if err != nil {
+		return proxy.Policy{}
+	}
+
+	return policy
+}
+
+// Policy returns the timeout and retries of a function from its FunctionLookup
+func (s *ScaleFromZero) Policy(name string) proxy.Policy {
+	return s.Lookup.Policy(name)
+}
+
+// untried returns the addresses which are not in tried, or all of the addresses when
+// each of them was already tried
+func untried(addresses, tried []string) []string {
+	if len(tried) == 0 {
+		return addresses
+	}
+
+	skip := map[string]bool{}
+	for _, t := range tried {
+		skip[t] = true
+	}
+
+	remaining := []string{}
+	for _, a := range addresses {
+		if !skip[a] {
+			remaining = append(remaining, a)
+		}
+	}
+
+	if len(remaining) == 0 {
+		return addresses
+	}
+	return remaining
+}
@@ -0,0 +1,120 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+package k8s
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/openfaas/faas-netes/pkg/proxy"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubeinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// newPolicyLookup creates a FunctionLookup for figlet with an endpoint for each of ips
+func newPolicyLookup(t *testing.T, annotations map[string]string, ips ...string) *FunctionLookup {
+	t.Helper()
+
+	factory := kubeinformers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+
+	addresses := []corev1.EndpointAddress{}
+	for _, ip := range ips {
+		addresses = append(addresses, corev1.EndpointAddress{IP: ip})
+	}
+
+	endpoints := factory.Core().V1().Endpoints()
+	endpoints.Informer().GetIndexer().Add(&corev1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Name: "figlet", Namespace: "openfaas-fn"},
+		Subsets:    []corev1.EndpointSubset{{Addresses: addresses}},
+	})
+
+	deployments := factory.Apps().V1().Deployments()
+	deployments.Informer().GetIndexer().Add(&appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "figlet", Namespace: "openfaas-fn"},
+		Spec: appsv1.DeploymentSpec{
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Annotations: annotations},
+			},
+		},
+	})
+
+	lookup := NewFunctionLookup("openfaas-fn", endpoints.Lister())
+	lookup.DeploymentLister = deployments.Lister()
+	return lookup
+}
+
+func Test_ParsePolicy(t *testing.T) {
+	cases := []struct {
+		name        string
+		annotations map[string]string
+		want        proxy.Policy
+		wantErr     bool
+	}{
+		{name: "defaults", want: proxy.Policy{RetryCodes: proxy.DefaultRetryCodes}},
+		{
+			name:        "all",
+			annotations: map[string]string{TimeoutAnnotation: "30s", RetryAttemptsAnnotation: "2", RetryCodesAnnotation: "429, 503"},
+			want:        proxy.Policy{Timeout: time.Second * 30, RetryAttempts: 2, RetryCodes: []int{429, 503}},
+		},
+		{name: "timeout in seconds", annotations: map[string]string{TimeoutAnnotation: "5"}, want: proxy.Policy{Timeout: time.Second * 5, RetryCodes: proxy.DefaultRetryCodes}},
+		{name: "invalid timeout", annotations: map[string]string{TimeoutAnnotation: "soon"}, wantErr: true},
+		{name: "too many attempts", annotations: map[string]string{RetryAttemptsAnnotation: "11"}, wantErr: true},
+		{name: "invalid codes", annotations: map[string]string{RetryCodesAnnotation: "503,oops"}, wantErr: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParsePolicy(tc.annotations)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("want error: %v, got: %v", tc.wantErr, err)
+			}
+			if !tc.wantErr && !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("want: %+v, got: %+v", tc.want, got)
+			}
+		})
+	}
+}
+
+func Test_FunctionLookup_RetryAvoidsTriedEndpoints(t *testing.T) {
+	lookup := newPolicyLookup(t, nil, "10.0.0.1", "10.0.0.2")
+
+	r, _ := http.NewRequest(http.MethodGet, "/", nil)
+	r = proxy.WithTriedEndpoints(r, []string{"10.0.0.1:8080"})
+
+	for i := 0; i < 10; i++ {
+		u, done, err := lookup.ResolveRequest("figlet", r)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		done()
+
+		if u.Host != "10.0.0.2:8080" {
+			t.Fatalf("want the endpoint which was not tried, got: %s", u.Host)
+		}
+	}
+
+	// Each endpoint was tried, so any of them can be used again
+	r = proxy.WithTriedEndpoints(r, []string{"10.0.0.1:8080", "10.0.0.2:8080"})
+	if _, _, err := lookup.ResolveRequest("figlet", r); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func Test_FunctionLookup_Policy(t *testing.T) {
+	lookup := newPolicyLookup(t, map[string]string{TimeoutAnnotation: "10s", RetryAttemptsAnnotation: "3"}, "10.0.0.1")
+
+	policy := lookup.Policy("figlet.openfaas-fn")
+	if policy.Timeout != time.Second*10 || policy.RetryAttempts != 3 {
+		t.Errorf("want: 10s and 3 attempts, got: %s and %d", policy.Timeout, policy.RetryAttempts)
+	}
+
+	if policy := lookup.Policy("env"); policy.Timeout != 0 || policy.RetryAttempts != 0 {
+		t.Errorf("want no policy for an unknown function, got: %+v", policy)
+	}
+}
@@ -0,0 +1,53 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+package k8s
+
+import (
+	"fmt"
+	"strconv"
+
+	types "github.com/openfaas/faas-provider/types"
+)
+
+// PortAnnotation overrides the port that a function listens on
+const PortAnnotation = "com.openfaas.port"
+
+// ParsePort parses a TCP port and checks that it is within the range 1-65535
+func ParsePort(value string) (int32, error) {
+	port, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("port %q must be a number", value)
+	}
+
+	if err := ValidatePort(port); err != nil {
+		return 0, err
+	}
+
+	return int32(port), nil
+}
+
+// ValidatePort checks that port is within the range 1-65535
+func ValidatePort(port int) error {
+	if port < 1 || port > 65535 {
+		return fmt.Errorf("port %d must be between 1 and 65535", port)
+	}
+
+	return nil
+}
+
+// FunctionPort returns the port that the function listens on, given by the
+// com.openfaas.port annotation, or RuntimeHTTPPort when not set.
+func (f *FunctionFactory) FunctionPort(r types.FunctionDeployment) (int32, error) {
+	if r.Annotations != nil {
+		if v, ok := (*r.Annotations)[PortAnnotation]; ok {
+			port, err := ParsePort(v)
+			if err != nil {
+				return 0, fmt.Errorf("%s: %s", PortAnnotation, err.Error())
+			}
+			return port, nil
+		}
+	}
+
+	return f.Config.RuntimeHTTPPort, nil
+}
@@ -0,0 +1,63 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+package k8s
+
+import (
+	"testing"
+
+	types "github.com/openfaas/faas-provider/types"
+)
+
+func Test_ParsePort(t *testing.T) {
+	cases := []struct {
+		value   string
+		want    int32
+		wantErr bool
+	}{
+		{value: "8080", want: 8080},
+		{value: "1", want: 1},
+		{value: "65535", want: 65535},
+		{value: "0", wantErr: true},
+		{value: "65536", wantErr: true},
+		{value: "-1", wantErr: true},
+		{value: "http", wantErr: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.value, func(t *testing.T) {
+			got, err := ParsePort(tc.value)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("want an error for: %s", tc.value)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if got != tc.want {
+				t.Errorf("want: %d, got: %d", tc.want, got)
+			}
+		})
+	}
+}
+
+func Test_FunctionPort(t *testing.T) {
+	f := mockFactory()
+	f.Config.RuntimeHTTPPort = 8080
+
+	port, err := f.FunctionPort(types.FunctionDeployment{})
+	if err != nil || port != 8080 {
+		t.Errorf("want: 8080, got: %d, err: %v", port, err)
+	}
+
+	port, err = f.FunctionPort(types.FunctionDeployment{Annotations: &map[string]string{PortAnnotation: "3000"}})
+	if err != nil || port != 3000 {
+		t.Errorf("want: 3000, got: %d, err: %v", port, err)
+	}
+
+	if _, err := f.FunctionPort(types.FunctionDeployment{Annotations: &map[string]string{PortAnnotation: "70000"}}); err == nil {
+		t.Errorf("want an error for an out of range port")
+	}
+}
@@ -21,13 +21,18 @@ type FunctionProbes struct {
 func (f *FunctionFactory) MakeProbes(r types.FunctionDeployment) (*FunctionProbes, error) {
 	var handler corev1.ProbeHandler
 
+	port, err := f.FunctionPort(r)
+	if err != nil {
+		return nil, err
+	}
+
 	if f.Config.HTTPProbe {
 		handler = corev1.ProbeHandler{
 			HTTPGet: &corev1.HTTPGetAction{
 				Path: "/_/health",
 				Port: intstr.IntOrString{
 					Type:   intstr.Int,
-					IntVal: int32(f.Config.RuntimeHTTPPort),
+					IntVal: port,
 				},
 			},
 		}
@@ -0,0 +1,381 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+package k8s
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	vv1 "github.com/openfaas/faas-netes/pkg/apis/openfaas/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+)
+
+// ProfileAnnotationKey is the function annotation used to reference one or more
+// Profiles, as a comma separated list, i.e. `com.openfaas.profile: gpu,spot`
+const ProfileAnnotationKey = "com.openfaas.profile"
+
+// ProfileGenerationsAnnotation is added to the function Deployment with the generation
+// of each Profile that was applied to it, i.e. `gpu=3,spot=1`
+const ProfileGenerationsAnnotation = "com.openfaas.profile.generations"
+
+// ParseProfileNames reads the Profile names from the function annotations, in the
+// order that they are to be applied.
+func ParseProfileNames(annotations map[string]string) []string {
+	if annotations == nil {
+		return nil
+	}
+
+	value, ok := annotations[ProfileAnnotationKey]
+	if !ok {
+		return nil
+	}
+
+	names := []string{}
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if len(name) > 0 {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+// GetProfiles returns the Profiles referenced by the function annotations, in order.
+// An error is returned when a referenced Profile does not exist.
+func (f *FunctionFactory) GetProfiles(namespace string, annotations map[string]string) ([]vv1.Profile, error) {
+	names := ParseProfileNames(annotations)
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	if f.Profiles == nil {
+		return nil, fmt.Errorf("profiles are not available, unable to apply: %s", strings.Join(names, ","))
+	}
+
+	profiles := make([]vv1.Profile, 0, len(names))
+	for _, name := range names {
+		profile, err := f.Profiles.Profiles(namespace).Get(name)
+		if err != nil {
+			return nil, fmt.Errorf("unable to get profile %q from %s: %s", name, namespace, err.Error())
+		}
+
+		profiles = append(profiles, *profile)
+	}
+
+	return profiles, nil
+}
+
+// ApplyProfiles merges the Profiles into the function Deployment in order. An error is
+// returned without changing the Deployment when two Profiles set conflicting values.
+func (f *FunctionFactory) ApplyProfiles(profiles []vv1.Profile, deployment *appsv1.Deployment) error {
+	if err := checkProfileConflicts(profiles); err != nil {
+		return err
+	}
+
+	for _, profile := range profiles {
+		f.ApplyProfile(profile, deployment)
+	}
+
+	setProfileGenerations(profiles, deployment)
+
+	return nil
+}
+
+// setProfileGenerations records the generation of the applied Profiles on the Deployment,
+// the Pod template is left as it is so that a generation alone does not roll out Pods.
+func setProfileGenerations(profiles []vv1.Profile, deployment *appsv1.Deployment) {
+	if len(profiles) == 0 {
+		return
+	}
+
+	generations := make([]string, 0, len(profiles))
+	for _, profile := range profiles {
+		generations = append(generations, fmt.Sprintf("%s=%d", profile.Name, profile.Generation))
+	}
+
+	// The Deployment and its Pod template may share the same annotations map
+	annotations := make(map[string]string, len(deployment.Annotations)+1)
+	for k, v := range deployment.Annotations {
+		annotations[k] = v
+	}
+	annotations[ProfileGenerationsAnnotation] = strings.Join(generations, ",")
+	deployment.Annotations = annotations
+}
+
+// ParseProfileGenerations reads the generation of each Profile that was applied to a
+// function Deployment by name. Malformed entries are skipped.
+func ParseProfileGenerations(annotations map[string]string) map[string]int64 {
+	generations := map[string]int64{}
+
+	value, ok := annotations[ProfileGenerationsAnnotation]
+	if !ok {
+		return generations
+	}
+
+	for _, entry := range strings.Split(value, ",") {
+		name, generation, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || len(name) == 0 {
+			continue
+		}
+
+		n, err := strconv.ParseInt(generation, 10, 64)
+		if err != nil {
+			continue
+		}
+
+		generations[name] = n
+	}
+
+	return generations
+}
+
+// ApplyProfile merges a single Profile into the function Deployment.
+func (f *FunctionFactory) ApplyProfile(profile vv1.Profile, deployment *appsv1.Deployment) {
+	spec := profile.Spec
+	podSpec := &deployment.Spec.Template.Spec
+
+	for _, toleration := range spec.Tolerations {
+		if !containsToleration(podSpec.Tolerations, toleration) {
+			podSpec.Tolerations = append(podSpec.Tolerations, toleration)
+		}
+	}
+
+	if spec.RuntimeClassName != nil {
+		podSpec.RuntimeClassName = spec.RuntimeClassName
+	}
+
+	if spec.PodSecurityContext != nil {
+		if podSpec.SecurityContext == nil {
+			podSpec.SecurityContext = &corev1.PodSecurityContext{}
+		}
+		mergeNonZeroFields(podSpec.SecurityContext, spec.PodSecurityContext)
+	}
+
+	if spec.Affinity != nil {
+		podSpec.Affinity = mergeAffinity(podSpec.Affinity, spec.Affinity)
+	}
+
+	for _, constraint := range spec.TopologySpreadConstraints {
+		if !containsTopologySpreadConstraint(podSpec.TopologySpreadConstraints, constraint) {
+			podSpec.TopologySpreadConstraints = append(podSpec.TopologySpreadConstraints, constraint)
+		}
+	}
+
+	if len(spec.DNSPolicy) > 0 {
+		podSpec.DNSPolicy = spec.DNSPolicy
+	}
+
+	if spec.DNSConfig != nil {
+		if podSpec.DNSConfig == nil {
+			podSpec.DNSConfig = &corev1.PodDNSConfig{}
+		}
+		podSpec.DNSConfig.Nameservers = appendUnique(podSpec.DNSConfig.Nameservers, spec.DNSConfig.Nameservers...)
+		podSpec.DNSConfig.Searches = appendUnique(podSpec.DNSConfig.Searches, spec.DNSConfig.Searches...)
+		for _, option := range spec.DNSConfig.Options {
+			if !containsDNSOption(podSpec.DNSConfig.Options, option) {
+				podSpec.DNSConfig.Options = append(podSpec.DNSConfig.Options, option)
+			}
+		}
+	}
+
+	if spec.Resources != nil && len(podSpec.Containers) > 0 {
+		resources := &podSpec.Containers[0].Resources
+		if resources.Limits == nil {
+			resources.Limits = corev1.ResourceList{}
+		}
+		if resources.Requests == nil {
+			resources.Requests = corev1.ResourceList{}
+		}
+		for k, v := range spec.Resources.Limits {
+			resources.Limits[k] = v
+		}
+		for k, v := range spec.Resources.Requests {
+			resources.Requests[k] = v
+		}
+	}
+
+	if len(spec.PriorityClassName) > 0 {
+		podSpec.PriorityClassName = spec.PriorityClassName
+	}
+
+	if spec.Strategy != nil {
+		deployment.Spec.Strategy = *spec.Strategy.DeepCopy()
+	}
+}
+
+// mergeAffinity returns the Profile's affinity, with the node affinity of the function,
+// built from its constraints, kept. The required node selector terms of both must
+// match, so the function's requirements are added to each of the Profile's terms.
+func mergeAffinity(current, profile *corev1.Affinity) *corev1.Affinity {
+	merged := profile.DeepCopy()
+	if current == nil || current.NodeAffinity == nil {
+		return merged
+	}
+
+	if merged.NodeAffinity == nil {
+		merged.NodeAffinity = &corev1.NodeAffinity{}
+	}
+	nodeAffinity := merged.NodeAffinity
+
+	if required := current.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution; required != nil {
+		if nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil ||
+			len(nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms) == 0 {
+			nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution = required.DeepCopy()
+		} else {
+			terms := []corev1.NodeSelectorTerm{}
+			for _, term := range nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms {
+				for _, requiredTerm := range required.NodeSelectorTerms {
+					t := *term.DeepCopy()
+					t.MatchExpressions = append(t.MatchExpressions, requiredTerm.MatchExpressions...)
+					t.MatchFields = append(t.MatchFields, requiredTerm.MatchFields...)
+					terms = append(terms, t)
+				}
+			}
+			nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms = terms
+		}
+	}
+
+	for _, preferred := range current.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution {
+		nodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution = append(nodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution, *preferred.DeepCopy())
+	}
+
+	return merged
+}
+
+// checkProfileConflicts returns an error when two Profiles set a different value for a
+// field that is replaced rather than merged.
+func checkProfileConflicts(profiles []vv1.Profile) error {
+	for i := 0; i < len(profiles); i++ {
+		for j := i + 1; j < len(profiles); j++ {
+			a, b := profiles[i], profiles[j]
+			if field := conflictingField(a.Spec, b.Spec); len(field) > 0 {
+				return fmt.Errorf("profiles %q and %q set conflicting values for %s", a.Name, b.Name, field)
+			}
+		}
+	}
+
+	return nil
+}
+
+func conflictingField(a, b vv1.ProfileSpec) string {
+	if a.RuntimeClassName != nil && b.RuntimeClassName != nil && *a.RuntimeClassName != *b.RuntimeClassName {
+		return "runtimeClassName"
+	}
+
+	if a.Affinity != nil && b.Affinity != nil && !equality.Semantic.DeepEqual(a.Affinity, b.Affinity) {
+		return "affinity"
+	}
+
+	if len(a.DNSPolicy) > 0 && len(b.DNSPolicy) > 0 && a.DNSPolicy != b.DNSPolicy {
+		return "dnsPolicy"
+	}
+
+	if len(a.PriorityClassName) > 0 && len(b.PriorityClassName) > 0 && a.PriorityClassName != b.PriorityClassName {
+		return "priorityClassName"
+	}
+
+	if a.Strategy != nil && b.Strategy != nil && !equality.Semantic.DeepEqual(a.Strategy, b.Strategy) {
+		return "strategy"
+	}
+
+	if a.PodSecurityContext != nil && b.PodSecurityContext != nil {
+		if field := conflictingNonZeroField(a.PodSecurityContext, b.PodSecurityContext); len(field) > 0 {
+			return "podSecurityContext." + field
+		}
+	}
+
+	if a.Resources != nil && b.Resources != nil {
+		for k, v := range a.Resources.Limits {
+			if other, ok := b.Resources.Limits[k]; ok && !other.Equal(v) {
+				return "resources.limits." + string(k)
+			}
+		}
+		for k, v := range a.Resources.Requests {
+			if other, ok := b.Resources.Requests[k]; ok && !other.Equal(v) {
+				return "resources.requests." + string(k)
+			}
+		}
+	}
+
+	return ""
+}
+
+// mergeNonZeroFields copies each non-zero field of src into dst, both must be pointers
+// to the same struct type.
+func mergeNonZeroFields(dst, src interface{}) {
+	d := reflect.ValueOf(dst).Elem()
+	s := reflect.ValueOf(src).Elem()
+
+	for i := 0; i < s.NumField(); i++ {
+		if !s.Field(i).IsZero() {
+			d.Field(i).Set(s.Field(i))
+		}
+	}
+}
+
+// conflictingNonZeroField returns the name of the first field which is set in both a and b
+// to different values.
+func conflictingNonZeroField(a, b interface{}) string {
+	av := reflect.ValueOf(a).Elem()
+	bv := reflect.ValueOf(b).Elem()
+
+	for i := 0; i < av.NumField(); i++ {
+		if av.Field(i).IsZero() || bv.Field(i).IsZero() {
+			continue
+		}
+		if !equality.Semantic.DeepEqual(av.Field(i).Interface(), bv.Field(i).Interface()) {
+			return av.Type().Field(i).Name
+		}
+	}
+
+	return ""
+}
+
+func containsToleration(tolerations []corev1.Toleration, toleration corev1.Toleration) bool {
+	for _, t := range tolerations {
+		if t.MatchToleration(&toleration) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsTopologySpreadConstraint(constraints []corev1.TopologySpreadConstraint, constraint corev1.TopologySpreadConstraint) bool {
+	for _, c := range constraints {
+		if equality.Semantic.DeepEqual(c, constraint) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsDNSOption(options []corev1.PodDNSConfigOption, option corev1.PodDNSConfigOption) bool {
+	for _, o := range options {
+		if equality.Semantic.DeepEqual(o, option) {
+			return true
+		}
+	}
+	return false
+}
+
+func appendUnique(values []string, items ...string) []string {
+	for _, item := range items {
+		found := false
+		for _, v := range values {
+			if v == item {
+				found = true
+				break
+			}
+		}
+		if !found {
+			values = append(values, item)
+		}
+	}
+	return values
+}
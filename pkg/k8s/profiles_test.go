@@ -0,0 +1,241 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+package k8s
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	vv1 "github.com/openfaas/faas-netes/pkg/apis/openfaas/v1"
+	faasfake "github.com/openfaas/faas-netes/pkg/client/clientset/versioned/fake"
+	types "github.com/openfaas/faas-provider/types"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func Test_ParseProfileNames(t *testing.T) {
+	cases := []struct {
+		name        string
+		annotations map[string]string
+		want        []string
+	}{
+		{"nil annotations", nil, nil},
+		{"no profile annotation", map[string]string{"topic": "cron"}, nil},
+		{"single profile", map[string]string{ProfileAnnotationKey: "gpu"}, []string{"gpu"}},
+		{"profiles keep order and are trimmed", map[string]string{ProfileAnnotationKey: " spot, gpu ,,"}, []string{"spot", "gpu"}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := ParseProfileNames(tc.annotations)
+			if len(got) == 0 && len(tc.want) == 0 {
+				return
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("want: %v, got: %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func newProfile(name string, spec vv1.ProfileSpec) *vv1.Profile {
+	return &vv1.Profile{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "openfaas", Generation: 1},
+		Spec:       spec,
+	}
+}
+
+func newProfileDeployment() *appsv1.Deployment {
+	return &appsv1.Deployment{
+		Spec: appsv1.DeploymentSpec{
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					DNSPolicy:  corev1.DNSClusterFirst,
+					Containers: []corev1.Container{{Name: "env"}},
+				},
+			},
+		},
+	}
+}
+
+func Test_GetProfiles_MissingProfile(t *testing.T) {
+	factory := NewFunctionFactory(fake.NewSimpleClientset(), DeploymentConfig{}, faasfake.NewSimpleClientset().OpenfaasV1())
+
+	_, err := factory.GetProfiles("openfaas", map[string]string{ProfileAnnotationKey: "gpu"})
+	if err == nil {
+		t.Fatal("expected an error for a missing profile")
+	}
+}
+
+func Test_ApplyProfiles_MergesInOrder(t *testing.T) {
+	runtimeClass := "gvisor"
+	spot := newProfile("spot", vv1.ProfileSpec{
+		Tolerations: []corev1.Toleration{{Key: "spot", Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoSchedule}},
+		Resources: &corev1.ResourceRequirements{
+			Limits: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("128Mi")},
+		},
+	})
+	sandbox := newProfile("sandbox", vv1.ProfileSpec{
+		RuntimeClassName:  &runtimeClass,
+		PriorityClassName: "low",
+		PodSecurityContext: &corev1.PodSecurityContext{
+			RunAsNonRoot: boolp(true),
+		},
+	})
+
+	factory := NewFunctionFactory(fake.NewSimpleClientset(), DeploymentConfig{}, faasfake.NewSimpleClientset(spot, sandbox).OpenfaasV1())
+	profiles, err := factory.GetProfiles("openfaas", map[string]string{ProfileAnnotationKey: "spot,sandbox"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if profiles[0].Name != "spot" || profiles[1].Name != "sandbox" {
+		t.Fatalf("want profiles in annotation order, got: %s, %s", profiles[0].Name, profiles[1].Name)
+	}
+
+	deployment := newProfileDeployment()
+	if err := factory.ApplyProfiles(profiles, deployment); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	podSpec := deployment.Spec.Template.Spec
+	if len(podSpec.Tolerations) != 1 {
+		t.Errorf("want 1 toleration, got: %d", len(podSpec.Tolerations))
+	}
+	if podSpec.RuntimeClassName == nil || *podSpec.RuntimeClassName != runtimeClass {
+		t.Errorf("want runtimeClassName: %s, got: %v", runtimeClass, podSpec.RuntimeClassName)
+	}
+	if podSpec.PriorityClassName != "low" {
+		t.Errorf("want priorityClassName: low, got: %s", podSpec.PriorityClassName)
+	}
+	if podSpec.SecurityContext == nil || podSpec.SecurityContext.RunAsNonRoot == nil || !*podSpec.SecurityContext.RunAsNonRoot {
+		t.Errorf("want runAsNonRoot to be set, got: %v", podSpec.SecurityContext)
+	}
+	memory := podSpec.Containers[0].Resources.Limits[corev1.ResourceMemory]
+	if memory.String() != "128Mi" {
+		t.Errorf("want memory limit: 128Mi, got: %s", memory.String())
+	}
+}
+
+func Test_ApplyProfiles_RecordsGenerations(t *testing.T) {
+	spot := newProfile("spot", vv1.ProfileSpec{})
+	gpu := newProfile("gpu", vv1.ProfileSpec{})
+	gpu.Generation = 4
+
+	deployment := newProfileDeployment()
+	annotations := map[string]string{ProfileAnnotationKey: "spot,gpu"}
+	deployment.Annotations = annotations
+	deployment.Spec.Template.Annotations = annotations
+
+	factory := NewFunctionFactory(fake.NewSimpleClientset(), DeploymentConfig{}, nil)
+	if err := factory.ApplyProfiles([]vv1.Profile{*spot, *gpu}, deployment); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	want := "spot=1,gpu=4"
+	if got := deployment.Annotations[ProfileGenerationsAnnotation]; got != want {
+		t.Errorf("want: %s, got: %s", want, got)
+	}
+
+	if _, ok := deployment.Spec.Template.Annotations[ProfileGenerationsAnnotation]; ok {
+		t.Errorf("want the Pod template annotations to be unchanged, got: %v", deployment.Spec.Template.Annotations)
+	}
+
+	generations := ParseProfileGenerations(deployment.Annotations)
+	if generations["spot"] != 1 || generations["gpu"] != 4 {
+		t.Errorf("want generations spot=1 and gpu=4, got: %v", generations)
+	}
+}
+
+func Test_ApplyProfiles_Conflict(t *testing.T) {
+	gvisor := "gvisor"
+	kata := "kata"
+	profiles := []vv1.Profile{
+		*newProfile("gvisor", vv1.ProfileSpec{RuntimeClassName: &gvisor}),
+		*newProfile("kata", vv1.ProfileSpec{RuntimeClassName: &kata}),
+	}
+
+	factory := mockFactory()
+	deployment := newProfileDeployment()
+
+	err := factory.ApplyProfiles(profiles, deployment)
+	if err == nil {
+		t.Fatal("expected a conflict error")
+	}
+
+	if !strings.Contains(err.Error(), "runtimeClassName") {
+		t.Errorf("want conflict on runtimeClassName, got: %s", err)
+	}
+
+	if deployment.Spec.Template.Spec.RuntimeClassName != nil {
+		t.Errorf("want Deployment to be unchanged on conflict")
+	}
+}
+
+func Test_ApplyProfiles_SecurityContextConflict(t *testing.T) {
+	profiles := []vv1.Profile{
+		*newProfile("a", vv1.ProfileSpec{PodSecurityContext: &corev1.PodSecurityContext{RunAsUser: int64p(1000)}}),
+		*newProfile("b", vv1.ProfileSpec{PodSecurityContext: &corev1.PodSecurityContext{RunAsUser: int64p(2000)}}),
+	}
+
+	factory := mockFactory()
+	err := factory.ApplyProfiles(profiles, newProfileDeployment())
+	if err == nil || !strings.Contains(err.Error(), "podSecurityContext.RunAsUser") {
+		t.Fatalf("want conflict on podSecurityContext.RunAsUser, got: %v", err)
+	}
+}
+
+func Test_ApplyProfiles_KeepsConstraintNodeAffinity(t *testing.T) {
+	profile := newProfile("gpu", vv1.ProfileSpec{
+		Affinity: &corev1.Affinity{
+			NodeAffinity: &corev1.NodeAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+					NodeSelectorTerms: []corev1.NodeSelectorTerm{
+						{MatchExpressions: []corev1.NodeSelectorRequirement{{Key: "gpu", Operator: corev1.NodeSelectorOpExists}}},
+					},
+				},
+			},
+			PodAntiAffinity: &corev1.PodAntiAffinity{},
+		},
+	})
+
+	factory := mockFactory()
+	deployment := newProfileDeployment()
+	request := types.FunctionDeployment{Constraints: []string{"kubernetes.io/arch!=arm64"}}
+	if err := factory.ConfigureConstraints(request, deployment); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if err := factory.ApplyProfiles([]vv1.Profile{*profile}, deployment); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	affinity := deployment.Spec.Template.Spec.Affinity
+	if affinity == nil || affinity.PodAntiAffinity == nil {
+		t.Fatalf("want the Profile's pod anti-affinity, got: %v", affinity)
+	}
+
+	want := []corev1.NodeSelectorTerm{
+		{MatchExpressions: []corev1.NodeSelectorRequirement{
+			{Key: "gpu", Operator: corev1.NodeSelectorOpExists},
+			{Key: "kubernetes.io/arch", Operator: corev1.NodeSelectorOpNotIn, Values: []string{"arm64"}},
+		}},
+	}
+	got := affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("want: %v, got: %v", want, got)
+	}
+}
+
+func boolp(b bool) *bool {
+	return &b
+}
+
+func int64p(i int64) *int64 {
+	return &i
+}
@@ -0,0 +1,98 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// PrometheusInvocationCounter reads invocation counts from the gateway_function_invocation_total
+// metric recorded by the OpenFaaS gateway, so that the counts survive restarts and
+// include invocations made through every replica of the gateway.
+type PrometheusInvocationCounter struct {
+	baseURL string
+	client  *http.Client
+}
+
+// NewPrometheusInvocationCounter creates an InvocationCounter which queries the
+// Prometheus HTTP API at baseURL i.e. http://prometheus.openfaas:9090
+func NewPrometheusInvocationCounter(baseURL string, client *http.Client) *PrometheusInvocationCounter {
+	return &PrometheusInvocationCounter{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		client:  client,
+	}
+}
+
+type prometheusQueryResponse struct {
+	Status string `json:"status"`
+	Error  string `json:"error"`
+	Data   struct {
+		Result []struct {
+			Metric map[string]string `json:"metric"`
+			Value  []interface{}     `json:"value"`
+		} `json:"result"`
+	} `json:"data"`
+}
+
+// InvocationCounts returns the total invocations of each function in namespace
+func (p *PrometheusInvocationCounter) InvocationCounts(ctx context.Context, namespace string) (map[string]float64, error) {
+	query := fmt.Sprintf(`sum by (function_name) (gateway_function_invocation_total{function_name=~".+\\.%s"})`,
+		regexp.QuoteMeta(namespace))
+
+	u := fmt.Sprintf("%s/api/v1/query?query=%s", p.baseURL, url.QueryEscape(query))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to query Prometheus: %s", err.Error())
+	}
+	defer res.Body.Close()
+
+	body, _ := io.ReadAll(res.Body)
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code from Prometheus: %d, body: %s", res.StatusCode, string(body))
+	}
+
+	result := prometheusQueryResponse{}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal Prometheus response: %s", err.Error())
+	}
+
+	if result.Status != "success" {
+		return nil, fmt.Errorf("Prometheus query failed: %s", result.Error)
+	}
+
+	counts := map[string]float64{}
+	for _, r := range result.Data.Result {
+		if len(r.Value) != 2 {
+			continue
+		}
+
+		v, ok := r.Value[1].(string)
+		if !ok {
+			continue
+		}
+
+		count, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			continue
+		}
+
+		name := strings.TrimSuffix(r.Metric["function_name"], "."+namespace)
+		counts[name] += count
+	}
+
+	return counts, nil
+}
@@ -8,22 +8,41 @@ package k8s
 
 import (
 	"fmt"
-	"math/rand"
+	"net"
+	"net/http"
 	"net/url"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/openfaas/faas-netes/pkg/proxy"
+	corev1 "k8s.io/api/core/v1"
+	appslister "k8s.io/client-go/listers/apps/v1"
 	corelister "k8s.io/client-go/listers/core/v1"
+	discoverylister "k8s.io/client-go/listers/discovery/v1"
 )
 
-// watchdogPort for the OpenFaaS function watchdog
+// watchdogPort for the OpenFaaS function watchdog, used when no port is configured
 const watchdogPort = 8080
 
+// DefaultClusterDomain is the default DNS domain of a Kubernetes cluster
+const DefaultClusterDomain = "cluster.local"
+
 func NewFunctionLookup(ns string, lister corelister.EndpointsLister) *FunctionLookup {
+	inflight := NewInflightRequests()
+
 	return &FunctionLookup{
 		DefaultNamespace: ns,
 		EndpointLister:   lister,
 		Listers:          map[string]corelister.EndpointsNamespaceLister{},
+		LoadBalancer:     LoadBalancerRandom,
+		HashHeader:       DefaultHashHeader,
+		ClusterDomain:    DefaultClusterDomain,
+		Port:             watchdogPort,
+		Inflight:         inflight,
+		balancers:        newLoadBalancers(inflight),
 		lock:             sync.RWMutex{},
 	}
 }
@@ -33,6 +52,46 @@ type FunctionLookup struct {
 	EndpointLister   corelister.EndpointsLister
 	Listers          map[string]corelister.EndpointsNamespaceLister
 
+	// EndpointSliceLister is used instead of EndpointLister when set
+	EndpointSliceLister discoverylister.EndpointSliceLister
+
+	// Port is used when the function's endpoints do not specify a port
+	Port int32
+
+	// ResolveToService resolves the Service's DNS name instead of a pod IP, which is
+	// required by service meshes such as Istio and Linkerd to apply mTLS
+	ResolveToService bool
+
+	// ClusterDomain is the DNS domain of the cluster used when ResolveToService is set
+	ClusterDomain string
+
+	// Zone is the topology zone of faas-netes, when set the topology hints of
+	// EndpointSlices are used to prefer endpoints in the same zone
+	Zone string
+
+	// DeploymentLister is optional and is used to read the load balancing and
+	// canary annotations of each function
+	DeploymentLister appslister.DeploymentLister
+
+	// Namespaces is optional and restricts lookups to the managed namespaces,
+	// when nil every namespace except kube-system can be resolved
+	Namespaces *Namespaces
+
+	// LoadBalancer is the default load balancing strategy
+	LoadBalancer string
+
+	// HashHeader is the default header used by the consistent-hash strategy
+	HashHeader string
+
+	// Inflight counts the requests in progress to each endpoint
+	Inflight *InflightRequests
+
+	// Breaker is optional and skips the endpoints which it ejected, and rejects the
+	// requests to functions whose circuit is open
+	Breaker *CircuitBreaker
+
+	balancers map[string]LoadBalancer
+
 	lock sync.RWMutex
 }
 
@@ -56,17 +115,105 @@ func getNamespace(name, defaultNamespace string) string {
 	return namespace
 }
 
+// Resolve returns the URL of one of the endpoints of a function
 func (l *FunctionLookup) Resolve(name string) (url.URL, error) {
+	u, done, err := l.ResolveRequest(name, nil)
+	if err != nil {
+		return url.URL{}, err
+	}
+	done()
+
+	return u, nil
+}
+
+// ResolveRequest returns the URL of one of the endpoints of a function, picked by the
+// load balancing strategy of the function. When the function has a canary, the share of
+// requests given by its weight is sent to the canary instead. The returned func must be
+// called once the request to the endpoint has completed. r may be nil when no request
+// is available.
+func (l *FunctionLookup) ResolveRequest(name string, r *http.Request) (url.URL, func(), error) {
 	functionName := name
 	namespace := getNamespace(name, l.DefaultNamespace)
 	if err := l.verifyNamespace(namespace); err != nil {
-		return url.URL{}, err
+		return url.URL{}, nil, err
 	}
 
 	if strings.Contains(name, ".") {
 		functionName = strings.TrimSuffix(name, "."+namespace)
 	}
 
+	if l.Breaker != nil && !l.Breaker.Allow(functionName+"."+namespace) {
+		return url.URL{}, nil, fmt.Errorf("%w for \"%s.%s\"", ErrCircuitOpen, functionName, namespace)
+	}
+
+	strategy, hashHeader := l.loadBalancing(functionName, namespace)
+
+	hashKey := ""
+	if r != nil {
+		hashKey = r.Header.Get(hashHeader)
+	}
+
+	target := functionName
+	if routeToCanary(l.canaryWeight(functionName, namespace), hashKey) {
+		target = CanaryName(functionName)
+	}
+
+	addresses, err := l.addresses(target, namespace)
+	if err != nil && target != functionName {
+		// The canary has no ready endpoints yet
+		target = functionName
+		addresses, err = l.addresses(target, namespace)
+	}
+	if err != nil {
+		return url.URL{}, nil, err
+	}
+
+	if l.ResolveToService {
+		// The Service's port is the same as the function's port
+		_, port, _ := net.SplitHostPort(addresses[0])
+		host := net.JoinHostPort(fmt.Sprintf("%s.%s.svc.%s", target, namespace, l.ClusterDomain), port)
+
+		return url.URL{Scheme: "http", Host: host}, l.Inflight.Start(host), nil
+	}
+
+	if l.Breaker != nil {
+		addresses = l.Breaker.Available(addresses)
+	}
+
+	// A retry is sent to an endpoint which the request was not already sent to
+	address := l.balancers[strategy].Pick(target+"."+namespace, untried(addresses, proxy.TriedEndpoints(r)), hashKey)
+
+	urlStr := fmt.Sprintf("http://%s", address)
+
+	urlRes, err := url.Parse(urlStr)
+	if err != nil {
+		return url.URL{}, nil, err
+	}
+
+	return *urlRes, l.Inflight.Start(address), nil
+}
+
+// RecordResult records the result of a request to one of the endpoints of a function
+// with the Breaker, when it is set
+func (l *FunctionLookup) RecordResult(name, endpoint string, status int, err error, latency time.Duration) {
+	if l.Breaker == nil {
+		return
+	}
+
+	namespace := getNamespace(name, l.DefaultNamespace)
+	functionName := strings.TrimSuffix(name, "."+namespace)
+
+	l.Breaker.Record(functionName+"."+namespace, endpoint, status, err, latency)
+}
+
+// addresses returns the sorted, ready addresses from all subsets of the
+// function's Endpoints, or from its EndpointSlices when configured. Each
+// address is formatted as host:port, using the port of the endpoint.
+func (l *FunctionLookup) addresses(functionName, namespace string) ([]string, error) {
+	if l.EndpointSliceLister != nil {
+		return l.sliceAddresses(functionName, namespace)
+	}
+
 	nsEndpointLister := l.GetLister(namespace)
 
 	if nsEndpointLister == nil {
@@ -77,36 +224,99 @@ func (l *FunctionLookup) Resolve(name string) (url.URL, error) {
 
 	svc, err := nsEndpointLister.Get(functionName)
 	if err != nil {
-		return url.URL{}, fmt.Errorf("error listing \"%s.%s\": %s", functionName, namespace, err.Error())
+		return nil, fmt.Errorf("error listing \"%s.%s\": %s", functionName, namespace, err.Error())
 	}
 
 	if len(svc.Subsets) == 0 {
-		return url.URL{}, fmt.Errorf("no subsets available for \"%s.%s\"", functionName, namespace)
+		return nil, fmt.Errorf("no subsets available for \"%s.%s\"", functionName, namespace)
 	}
 
-	all := len(svc.Subsets[0].Addresses)
-	if len(svc.Subsets[0].Addresses) == 0 {
-		return url.URL{}, fmt.Errorf("no addresses in subset for \"%s.%s\"", functionName, namespace)
+	seen := map[string]bool{}
+	addresses := []string{}
+	for _, subset := range svc.Subsets {
+		port := endpointsPort(subset.Ports, l.Port)
+
+		for _, a := range subset.Addresses {
+			address := net.JoinHostPort(a.IP, strconv.Itoa(int(port)))
+			if !seen[address] {
+				seen[address] = true
+				addresses = append(addresses, address)
+			}
+		}
 	}
 
-	target := rand.Intn(all)
+	if len(addresses) == 0 {
+		return nil, fmt.Errorf("no addresses in subset for \"%s.%s\"", functionName, namespace)
+	}
 
-	serviceIP := svc.Subsets[0].Addresses[target].IP
+	sort.Strings(addresses)
 
-	urlStr := fmt.Sprintf("http://%s:%d", serviceIP, watchdogPort)
+	return addresses, nil
+}
 
-	urlRes, err := url.Parse(urlStr)
+// endpointsPort returns the port named http, or the first port when there is no
+// port with that name
+func endpointsPort(ports []corev1.EndpointPort, defaultPort int32) int32 {
+	for _, p := range ports {
+		if p.Name == "http" {
+			return p.Port
+		}
+	}
+
+	if len(ports) > 0 {
+		return ports[0].Port
+	}
+
+	return defaultPort
+}
+
+// loadBalancing returns the load balancing strategy and hash header for a function,
+// the annotations of the function override the defaults of the FunctionLookup.
+func (l *FunctionLookup) loadBalancing(functionName, namespace string) (string, string) {
+	strategy := l.LoadBalancer
+	hashHeader := l.HashHeader
+
+	if l.DeploymentLister != nil {
+		if deployment, err := l.DeploymentLister.Deployments(namespace).Get(functionName); err == nil {
+			annotations := deployment.Spec.Template.Annotations
+			if v, ok := annotations[LoadBalancerAnnotation]; ok && ValidateLoadBalancer(v) == nil {
+				strategy = v
+			}
+			if v := annotations[LoadBalancerHashHeaderAnnotation]; len(v) > 0 {
+				hashHeader = v
+			}
+		}
+	}
+
+	if _, ok := l.balancers[strategy]; !ok {
+		strategy = LoadBalancerRandom
+	}
+
+	return strategy, hashHeader
+}
+
+// canaryWeight returns the weight of the function's canary, or 0 when it has none
+func (l *FunctionLookup) canaryWeight(functionName, namespace string) int {
+	if l.DeploymentLister == nil {
+		return 0
+	}
+
+	deployment, err := l.DeploymentLister.Deployments(namespace).Get(functionName)
 	if err != nil {
-		return url.URL{}, err
+		return 0
 	}
 
-	return *urlRes, nil
+	return CanaryWeight(deployment)
 }
 
 func (l *FunctionLookup) verifyNamespace(name string) error {
-	if name != "kube-system" {
-		return nil
+	if name == "kube-system" {
+		return fmt.Errorf("namespace not allowed")
+	}
+
+	if l.Namespaces != nil {
+		return l.Namespaces.Validate(name)
 	}
-	// ToDo use global namepace parse and validation
-	return fmt.Errorf("namespace not allowed")
+
+	return nil
 }
@@ -8,7 +8,10 @@ import (
 	corelister "k8s.io/client-go/listers/core/v1"
 
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	kubeinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
 )
 
 type FakeLister struct {
@@ -92,3 +95,80 @@ func Test_FunctionLookup(t *testing.T) {
 		})
 	}
 }
+
+func Test_FunctionLookup_ResolveToService(t *testing.T) {
+	resolver := NewFunctionLookup("testDefault", FakeLister{})
+	resolver.ResolveToService = true
+	resolver.ClusterDomain = "cluster.example"
+
+	cases := []struct {
+		name     string
+		funcName string
+		expError string
+		expUrl   string
+	}{
+		{
+			name:     "function without namespace uses default namespace",
+			funcName: "testfunc",
+			expUrl:   "http://testfunc.testDefault.svc.cluster.example:8080",
+		},
+		{
+			name:     "function with namespace uses the given namespace",
+			funcName: "testfunc.othernamespace",
+			expUrl:   "http://testfunc.othernamespace.svc.cluster.example:8080",
+		},
+		{
+			name:     "kube-system is not allowed",
+			funcName: "testfunc.kube-system",
+			expError: "namespace not allowed",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			url, err := resolver.Resolve(tc.funcName)
+			if tc.expError == "" && err != nil {
+				t.Fatalf("expected no error, got %s", err)
+			}
+
+			if tc.expError != "" && (err == nil || !strings.Contains(err.Error(), tc.expError)) {
+				t.Fatalf("expected %s, got %s", tc.expError, err)
+			}
+
+			if url.String() != tc.expUrl {
+				t.Fatalf("expected url %s, got %s", tc.expUrl, url.String())
+			}
+		})
+	}
+}
+
+func Test_FunctionLookup_UsesEndpointPort(t *testing.T) {
+	factory := kubeinformers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+	endpoints := factory.Core().V1().Endpoints()
+	endpoints.Informer().GetIndexer().Add(&corev1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Name: "figlet", Namespace: "openfaas-fn"},
+		Subsets: []corev1.EndpointSubset{{
+			Addresses: []corev1.EndpointAddress{{IP: "10.0.0.1"}},
+			Ports:     []corev1.EndpointPort{{Name: "http", Port: 3000}},
+		}},
+	})
+
+	lookup := NewFunctionLookup("openfaas-fn", endpoints.Lister())
+
+	u, err := lookup.Resolve("figlet")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if u.String() != "http://10.0.0.1:3000" {
+		t.Errorf("want: http://10.0.0.1:3000, got: %s", u.String())
+	}
+
+	lookup.ResolveToService = true
+	u, err = lookup.Resolve("figlet")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if u.String() != "http://figlet.openfaas-fn.svc.cluster.local:3000" {
+		t.Errorf("want: http://figlet.openfaas-fn.svc.cluster.local:3000, got: %s", u.String())
+	}
+}
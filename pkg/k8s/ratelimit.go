@@ -0,0 +1,253 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+package k8s
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/openfaas/faas-provider/httputil"
+	"golang.org/x/time/rate"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// RateLimitAnnotation is the number of requests a function accepts per second,
+	// minute or hour i.e. 10/s or 600/m. Up to that number of requests can be sent
+	// at once, after which they are accepted at an even rate.
+	RateLimitAnnotation = "com.openfaas.ratelimit"
+
+	// MaxInflightAnnotation is the number of requests to a function which can be in
+	// flight at once
+	MaxInflightAnnotation = "com.openfaas.max_inflight"
+)
+
+// Limits are the rate limit and the cap on requests in flight of a function, a zero
+// value is not limited
+type Limits struct {
+	Rate        rate.Limit
+	Burst       int
+	MaxInflight int
+}
+
+// ParseLimits returns the limits given by the annotations of a function
+func ParseLimits(annotations map[string]string) (Limits, error) {
+	limits := Limits{}
+
+	if v, ok := annotations[RateLimitAnnotation]; ok {
+		invalid := fmt.Errorf("%s: %q must be a number of requests per s, m or h, i.e. 10/s", RateLimitAnnotation, v)
+
+		count, unit, _ := strings.Cut(v, "/")
+		n, err := strconv.Atoi(strings.TrimSpace(count))
+		if err != nil || n < 1 {
+			return Limits{}, invalid
+		}
+
+		var per time.Duration
+		switch strings.TrimSpace(unit) {
+		case "", "s":
+			per = time.Second
+		case "m":
+			per = time.Minute
+		case "h":
+			per = time.Hour
+		default:
+			return Limits{}, invalid
+		}
+
+		limits.Rate = rate.Limit(float64(n) / per.Seconds())
+		limits.Burst = n
+	}
+
+	if v, ok := annotations[MaxInflightAnnotation]; ok {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return Limits{}, fmt.Errorf("%s: %q must be a number greater than zero", MaxInflightAnnotation, v)
+		}
+		limits.MaxInflight = n
+	}
+
+	return limits, nil
+}
+
+// functionLimiter holds the tokens and the requests in flight of a function
+type functionLimiter struct {
+	limits   Limits
+	bucket   *rate.Limiter
+	inflight int
+}
+
+// RateLimiter rejects the requests to functions above the limits given by their
+// com.openfaas.ratelimit and com.openfaas.max_inflight annotations. The limits are
+// read from the Deployment informer, so that a change to them applies straight away.
+type RateLimiter struct {
+	// DefaultNamespace is used for requests to a function without a namespace suffix
+	DefaultNamespace string
+
+	// Now returns the current time, it is replaced in tests
+	Now func() time.Time
+
+	lock      sync.Mutex
+	functions map[string]*functionLimiter
+}
+
+// NewRateLimiter creates a RateLimiter which reads the limits of functions from the
+// Deployments of informer
+func NewRateLimiter(defaultNamespace string, informer cache.SharedIndexInformer) *RateLimiter {
+	l := &RateLimiter{
+		DefaultNamespace: defaultNamespace,
+		Now:              time.Now,
+		functions:        map[string]*functionLimiter{},
+	}
+
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: l.update,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			l.update(newObj)
+		},
+		DeleteFunc: l.delete,
+	})
+
+	return l
+}
+
+// Update sets the limits of a function from the annotations of its Deployment
+func (l *RateLimiter) Update(deployment *appsv1.Deployment) {
+	if _, ok := deployment.Labels["faas_function"]; !ok {
+		return
+	}
+
+	key := deployment.Name + "." + deployment.Namespace
+
+	limits, err := ParseLimits(deployment.Spec.Template.Annotations)
+	if err != nil {
+		log.Printf("Unable to read the limits of %s: %s", key, err.Error())
+		limits = Limits{}
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	f, ok := l.functions[key]
+	if limits == (Limits{}) {
+		delete(l.functions, key)
+		return
+	}
+
+	if !ok {
+		f = &functionLimiter{}
+		l.functions[key] = f
+	} else if f.limits == limits {
+		return
+	}
+
+	f.limits = limits
+	switch {
+	case limits.Rate == 0:
+		f.bucket = nil
+	case f.bucket == nil:
+		f.bucket = rate.NewLimiter(limits.Rate, limits.Burst)
+	default:
+		// The tokens already taken are kept, so that changing the limit does not
+		// allow a burst of requests
+		now := l.Now()
+		f.bucket.SetLimitAt(now, limits.Rate)
+		f.bucket.SetBurstAt(now, limits.Burst)
+	}
+}
+
+func (l *RateLimiter) update(obj interface{}) {
+	if deployment, ok := obj.(*appsv1.Deployment); ok {
+		l.Update(deployment)
+	}
+}
+
+func (l *RateLimiter) delete(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+
+	deployment, ok := obj.(*appsv1.Deployment)
+	if !ok {
+		return
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	delete(l.functions, deployment.Name+"."+deployment.Namespace)
+}
+
+// Acquire takes a token and a slot for a request in flight for the function given by
+// name, with an optional namespace suffix, the returned func releases the slot. When
+// the request is rejected, the time after which it can be retried is returned instead.
+func (l *RateLimiter) Acquire(name string) (func(), time.Duration, bool) {
+	namespace := getNamespace(name, l.DefaultNamespace)
+	key := strings.TrimSuffix(name, "."+namespace) + "." + namespace
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	f, ok := l.functions[key]
+	if !ok {
+		return func() {}, 0, true
+	}
+
+	if f.limits.MaxInflight > 0 && f.inflight >= f.limits.MaxInflight {
+		return nil, time.Second, false
+	}
+
+	if f.bucket != nil {
+		now := l.Now()
+		r := f.bucket.ReserveN(now, 1)
+		if delay := r.DelayFrom(now); delay > 0 {
+			r.CancelAt(now)
+			return nil, delay, false
+		}
+	}
+
+	f.inflight++
+	return func() {
+		l.lock.Lock()
+		defer l.lock.Unlock()
+
+		if f.inflight > 0 {
+			f.inflight--
+		}
+	}, 0, true
+}
+
+// Wrap returns a handler which rejects the requests to a function above its limits
+// with 429 and a Retry-After header. The function name is read from the "name" route
+// variable, as set by the provider's router.
+func (l *RateLimiter) Wrap(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := mux.Vars(r)["name"]
+		if len(name) == 0 {
+			next(w, r)
+			return
+		}
+
+		namespace := getNamespace(name, l.DefaultNamespace)
+		functionName := strings.TrimSuffix(name, "."+namespace)
+
+		release, retryAfter, ok := l.Acquire(name)
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			httputil.Errorf(w, http.StatusTooManyRequests, "Rate limit exceeded for: %s.", functionName)
+			return
+		}
+		defer release()
+
+		next(w, r)
+	}
+}
@@ -0,0 +1,211 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+package k8s
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/time/rate"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubeinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+func newLimitedDeployment(annotations map[string]string) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "figlet",
+			Namespace: "openfaas-fn",
+			Labels:    map[string]string{"faas_function": "figlet"},
+		},
+		Spec: appsv1.DeploymentSpec{
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Annotations: annotations},
+			},
+		},
+	}
+}
+
+func newTestRateLimiter(t *testing.T, annotations map[string]string) (*RateLimiter, *fakeClock) {
+	t.Helper()
+
+	factory := kubeinformers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+
+	l := NewRateLimiter("openfaas-fn", factory.Apps().V1().Deployments().Informer())
+	l.Now = clock.Now
+	l.Update(newLimitedDeployment(annotations))
+
+	return l, clock
+}
+
+func Test_ParseLimits(t *testing.T) {
+	cases := []struct {
+		name        string
+		annotations map[string]string
+		want        Limits
+		wantErr     bool
+	}{
+		{name: "none", want: Limits{}},
+		{name: "per second", annotations: map[string]string{RateLimitAnnotation: "10/s"}, want: Limits{Rate: 10, Burst: 10}},
+		{name: "without a unit", annotations: map[string]string{RateLimitAnnotation: "5"}, want: Limits{Rate: 5, Burst: 5}},
+		{name: "per minute", annotations: map[string]string{RateLimitAnnotation: "120/m"}, want: Limits{Rate: 2, Burst: 120}},
+		{name: "max inflight", annotations: map[string]string{MaxInflightAnnotation: "3"}, want: Limits{MaxInflight: 3}},
+		{name: "invalid unit", annotations: map[string]string{RateLimitAnnotation: "10/d"}, wantErr: true},
+		{name: "zero rate", annotations: map[string]string{RateLimitAnnotation: "0/s"}, wantErr: true},
+		{name: "invalid max inflight", annotations: map[string]string{MaxInflightAnnotation: "many"}, wantErr: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParseLimits(tc.annotations)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("want error: %v, got: %v", tc.wantErr, err)
+			}
+			if !tc.wantErr && got != tc.want {
+				t.Errorf("want: %+v, got: %+v", tc.want, got)
+			}
+		})
+	}
+}
+
+func Test_RateLimiter_RateLimit(t *testing.T) {
+	l, clock := newTestRateLimiter(t, map[string]string{RateLimitAnnotation: "2/s"})
+
+	for i := 0; i < 2; i++ {
+		release, _, ok := l.Acquire("figlet.openfaas-fn")
+		if !ok {
+			t.Fatalf("want request %d to be accepted", i+1)
+		}
+		release()
+	}
+
+	_, retryAfter, ok := l.Acquire("figlet.openfaas-fn")
+	if ok {
+		t.Fatalf("want the request to be rejected")
+	}
+	if retryAfter != time.Millisecond*500 {
+		t.Errorf("want: %s, got: %s", time.Millisecond*500, retryAfter)
+	}
+
+	clock.Add(retryAfter)
+	if _, _, ok := l.Acquire("figlet.openfaas-fn"); !ok {
+		t.Errorf("want the request to be accepted after %s", retryAfter)
+	}
+}
+
+func Test_RateLimiter_DefaultNamespace(t *testing.T) {
+	l, _ := newTestRateLimiter(t, map[string]string{MaxInflightAnnotation: "1"})
+
+	if _, _, ok := l.Acquire("figlet"); !ok {
+		t.Fatalf("want the first request to be accepted")
+	}
+
+	if _, _, ok := l.Acquire("figlet.openfaas-fn"); ok {
+		t.Errorf("want a function without a namespace to share the limits of the default namespace")
+	}
+}
+
+func Test_RateLimiter_MaxInflight(t *testing.T) {
+	l, _ := newTestRateLimiter(t, map[string]string{MaxInflightAnnotation: "1"})
+
+	release, _, ok := l.Acquire("figlet.openfaas-fn")
+	if !ok {
+		t.Fatalf("want the first request to be accepted")
+	}
+
+	if _, _, ok := l.Acquire("figlet.openfaas-fn"); ok {
+		t.Fatalf("want the second request to be rejected while the first is in flight")
+	}
+
+	release()
+	if _, _, ok := l.Acquire("figlet.openfaas-fn"); !ok {
+		t.Errorf("want a request to be accepted once the first completed")
+	}
+}
+
+func Test_RateLimiter_Unlimited(t *testing.T) {
+	l, _ := newTestRateLimiter(t, nil)
+
+	for i := 0; i < 100; i++ {
+		if _, _, ok := l.Acquire("figlet.openfaas-fn"); !ok {
+			t.Fatalf("want a function without limits to accept each request")
+		}
+	}
+}
+
+func Test_RateLimiter_ReloadsLimits(t *testing.T) {
+	l, clock := newTestRateLimiter(t, map[string]string{RateLimitAnnotation: "1/s", MaxInflightAnnotation: "5"})
+
+	if _, _, ok := l.Acquire("figlet.openfaas-fn"); !ok {
+		t.Fatalf("want the first request to be accepted")
+	}
+
+	// The request in flight is kept when the limits change
+	l.update(newLimitedDeployment(map[string]string{RateLimitAnnotation: "3/s", MaxInflightAnnotation: "2"}))
+
+	f := l.functions["figlet.openfaas-fn"]
+	if f.bucket.Limit() != rate.Limit(3) || f.bucket.Burst() != 3 || f.inflight != 1 {
+		t.Errorf("want a rate of 3, a burst of 3 and 1 in flight, got: %v, %d and %d", f.bucket.Limit(), f.bucket.Burst(), f.inflight)
+	}
+
+	clock.Add(time.Second)
+	if _, _, ok := l.Acquire("figlet.openfaas-fn"); !ok {
+		t.Fatalf("want the request to be accepted under the new limits")
+	}
+	if _, _, ok := l.Acquire("figlet.openfaas-fn"); ok {
+		t.Fatalf("want the request to be rejected by the new max inflight")
+	}
+
+	l.update(newLimitedDeployment(nil))
+	if _, _, ok := l.Acquire("figlet.openfaas-fn"); !ok {
+		t.Errorf("want the request to be accepted once the limits were removed")
+	}
+}
+
+func Test_RateLimiter_Delete(t *testing.T) {
+	l, _ := newTestRateLimiter(t, map[string]string{MaxInflightAnnotation: "1"})
+
+	l.delete(cache.DeletedFinalStateUnknown{Key: "openfaas-fn/figlet", Obj: newLimitedDeployment(nil)})
+
+	if len(l.functions) != 0 {
+		t.Errorf("want the limits to be removed, got: %d", len(l.functions))
+	}
+}
+
+func Test_RateLimiter_Wrap(t *testing.T) {
+	l, _ := newTestRateLimiter(t, map[string]string{RateLimitAnnotation: "1/m"})
+
+	handler := l.Wrap(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	invoke := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/function/figlet.openfaas-fn", nil)
+		req = mux.SetURLVars(req, map[string]string{"name": "figlet.openfaas-fn"})
+
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		return rr
+	}
+
+	if rr := invoke(); rr.Code != http.StatusOK {
+		t.Fatalf("want status: %d, got: %d", http.StatusOK, rr.Code)
+	}
+
+	rr := invoke()
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("want status: %d, got: %d", http.StatusTooManyRequests, rr.Code)
+	}
+	if got := rr.Header().Get("Retry-After"); got != "60" {
+		t.Errorf("want Retry-After: %s, got: %s", "60", got)
+	}
+}
@@ -0,0 +1,114 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+package k8s
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// RevisionAnnotation is set on a Deployment and its ReplicaSets by the Deployment
+// controller, a ReplicaSet is kept for each revision up to the RevisionHistoryLimit
+const RevisionAnnotation = "deployment.kubernetes.io/revision"
+
+// FunctionRevision is a summary of one revision of a function
+type FunctionRevision struct {
+	Revision int64  `json:"revision"`
+	Image    string `json:"image"`
+
+	// EnvHash changes when the environment variables of the function change, the
+	// values are not given as they may contain configuration which is not public
+	EnvHash string `json:"envHash"`
+
+	// Current is true for the revision which the Deployment is rolling out or running
+	Current bool `json:"current"`
+
+	Replicas  int32     `json:"replicas"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// ListRevisions returns the ReplicaSets owned by deployment, the newest revision first
+func ListRevisions(ctx context.Context, client kubernetes.Interface, deployment *appsv1.Deployment) ([]appsv1.ReplicaSet, error) {
+	selector, err := metav1.LabelSelectorAsSelector(deployment.Spec.Selector)
+	if err != nil {
+		return nil, err
+	}
+
+	list, err := client.AppsV1().ReplicaSets(deployment.Namespace).
+		List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, err
+	}
+
+	owned := []appsv1.ReplicaSet{}
+	for _, rs := range list.Items {
+		if owner := metav1.GetControllerOf(&rs); owner != nil && owner.UID == deployment.UID {
+			owned = append(owned, rs)
+		}
+	}
+
+	sort.SliceStable(owned, func(i, j int) bool {
+		return Revision(&owned[i].ObjectMeta) > Revision(&owned[j].ObjectMeta)
+	})
+
+	return owned, nil
+}
+
+// Revision returns the revision of a Deployment or ReplicaSet, or 0 when it has none
+func Revision(meta *metav1.ObjectMeta) int64 {
+	revision, err := strconv.ParseInt(meta.Annotations[RevisionAnnotation], 10, 64)
+	if err != nil {
+		return 0
+	}
+	return revision
+}
+
+// AsFunctionRevision summarises the ReplicaSet of a revision of deployment
+func AsFunctionRevision(deployment *appsv1.Deployment, rs appsv1.ReplicaSet) FunctionRevision {
+	revision := FunctionRevision{
+		Revision:  Revision(&rs.ObjectMeta),
+		Current:   Revision(&rs.ObjectMeta) == Revision(&deployment.ObjectMeta),
+		Replicas:  rs.Status.Replicas,
+		CreatedAt: rs.CreationTimestamp.Time,
+	}
+
+	if containers := rs.Spec.Template.Spec.Containers; len(containers) > 0 {
+		revision.Image = containers[0].Image
+		revision.EnvHash = EnvHash(containers[0].Env)
+	}
+
+	return revision
+}
+
+// EnvHash returns a short hash of the names and values of env, independent of
+// their order
+func EnvHash(env []corev1.EnvVar) string {
+	pairs := make([]string, 0, len(env))
+	for _, e := range env {
+		value := e.Value
+		if e.ValueFrom != nil {
+			value = e.ValueFrom.String()
+		}
+		pairs = append(pairs, fmt.Sprintf("%s=%s", e.Name, value))
+	}
+	sort.Strings(pairs)
+
+	h := sha256.New()
+	for _, p := range pairs {
+		h.Write([]byte(p))
+		h.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(h.Sum(nil))[:12]
+}
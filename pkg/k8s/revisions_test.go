@@ -0,0 +1,101 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+package k8s
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newRevisionReplicaSet(name, revision string, owner types.UID, image string) *appsv1.ReplicaSet {
+	controller := true
+	return &appsv1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "openfaas-fn",
+			Labels:      map[string]string{"faas_function": "figlet"},
+			Annotations: map[string]string{RevisionAnnotation: revision},
+			OwnerReferences: []metav1.OwnerReference{
+				{APIVersion: "apps/v1", Kind: "Deployment", Name: "figlet", UID: owner, Controller: &controller},
+			},
+		},
+		Spec: appsv1.ReplicaSetSpec{
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{Name: "figlet", Image: image}},
+				},
+			},
+		},
+	}
+}
+
+func Test_ListRevisions(t *testing.T) {
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "figlet",
+			Namespace:   "openfaas-fn",
+			UID:         "figlet-uid",
+			Annotations: map[string]string{RevisionAnnotation: "10"},
+		},
+		Spec: appsv1.DeploymentSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"faas_function": "figlet"}},
+		},
+	}
+
+	kube := fake.NewSimpleClientset(
+		newRevisionReplicaSet("figlet-a", "2", "figlet-uid", "figlet:0.2"),
+		newRevisionReplicaSet("figlet-b", "10", "figlet-uid", "figlet:0.10"),
+		newRevisionReplicaSet("figlet-c", "9", "figlet-uid", "figlet:0.9"),
+		// Left behind by a previous Deployment with the same name
+		newRevisionReplicaSet("figlet-d", "1", "other-uid", "figlet:0.1"),
+	)
+
+	got, err := ListRevisions(context.Background(), kube, deployment)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	want := []string{"figlet-b", "figlet-c", "figlet-a"}
+	if len(got) != len(want) {
+		t.Fatalf("want: %d revisions, got: %d", len(want), len(got))
+	}
+	for i, rs := range got {
+		if rs.Name != want[i] {
+			t.Errorf("want: %s at %d, got: %s", want[i], i, rs.Name)
+		}
+	}
+
+	revision := AsFunctionRevision(deployment, got[0])
+	if revision.Revision != 10 || !revision.Current || revision.Image != "figlet:0.10" {
+		t.Errorf("want the current revision 10 with image figlet:0.10, got: %+v", revision)
+	}
+
+	if revision := AsFunctionRevision(deployment, got[1]); revision.Current {
+		t.Errorf("want revision %d not to be current", revision.Revision)
+	}
+}
+
+func Test_EnvHash(t *testing.T) {
+	a := []corev1.EnvVar{{Name: "write_debug", Value: "true"}, {Name: "fprocess", Value: "cat"}}
+	b := []corev1.EnvVar{{Name: "fprocess", Value: "cat"}, {Name: "write_debug", Value: "true"}}
+	c := []corev1.EnvVar{{Name: "fprocess", Value: "cat"}, {Name: "write_debug", Value: "false"}}
+
+	if EnvHash(a) != EnvHash(b) {
+		t.Errorf("want the same hash for the same env-vars in a different order")
+	}
+
+	if EnvHash(a) == EnvHash(c) {
+		t.Errorf("want a different hash when a value changes")
+	}
+
+	if len(EnvHash(nil)) != 12 {
+		t.Errorf("want a hash of 12 characters, got: %q", EnvHash(nil))
+	}
+}
@@ -0,0 +1,389 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/selection"
+	v1apps "k8s.io/client-go/informers/apps/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// RolloutStatus is the progress of the latest rollout of a function
+type RolloutStatus struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+
+	// Complete is true when every replica runs the latest revision and is available
+	Complete bool `json:"complete"`
+
+	// Failed is true when the Deployment exceeded its progress deadline, or a pod of
+	// the latest revision cannot start i.e. CrashLoopBackOff or Unschedulable
+	Failed bool `json:"failed"`
+
+	Replicas          int32 `json:"replicas"`
+	UpdatedReplicas   int32 `json:"updatedReplicas"`
+	AvailableReplicas int32 `json:"availableReplicas"`
+
+	// Reason is why the rollout is not complete i.e. ImagePullBackOff, CrashLoopBackOff
+	// or Unschedulable, it is read from the pods of the function and their events
+	Reason string `json:"reason,omitempty"`
+
+	Message string `json:"message,omitempty"`
+}
+
+// podFailureReasons are the waiting reasons of a container which stop a rollout
+// from completing without a change to the function
+var podFailureReasons = map[string]bool{
+	"ErrImagePull":               true,
+	"ImagePullBackOff":           true,
+	"InvalidImageName":           true,
+	"CrashLoopBackOff":           true,
+	"CreateContainerConfigError": true,
+	"CreateContainerError":       true,
+	"RunContainerError":          true,
+}
+
+// failFastReasons are the reasons of a pod of the latest revision which fail its
+// rollout straight away, instead of once the Deployment's progress deadline passed
+var failFastReasons = map[string]bool{
+	"ErrImagePull":     true,
+	"ImagePullBackOff": true,
+	"CrashLoopBackOff": true,
+	"Unschedulable":    true,
+}
+
+// RolloutWatcher reads the rollout status of functions from the Deployment informer
+type RolloutWatcher struct {
+	Client   kubernetes.Interface
+	Informer v1apps.DeploymentInformer
+
+	// PollInterval is how often the status is read again when the informer has not
+	// seen a change to the Deployment
+	PollInterval time.Duration
+
+	// MaxWait limits how long a caller can wait for a rollout, so that the response
+	// can be written before the server's write timeout. Zero means no limit.
+	MaxWait time.Duration
+}
+
+// NewRolloutWatcher creates a RolloutWatcher, the informer must be started
+func NewRolloutWatcher(client kubernetes.Interface, informer v1apps.DeploymentInformer) *RolloutWatcher {
+	return &RolloutWatcher{
+		Client:       client,
+		Informer:     informer,
+		PollInterval: time.Second * 2,
+	}
+}
+
+// Status returns the status of the latest rollout of a function
+func (w *RolloutWatcher) Status(ctx context.Context, namespace, name string) (*RolloutStatus, error) {
+	deployment, err := w.getFunction(namespace, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return w.describe(ctx, deployment, 0), nil
+}
+
+// Wait waits for the rollout of generation of a function to complete or to fail, a
+// rollout which is still progressing when timeout passes is returned as it is.
+func (w *RolloutWatcher) Wait(ctx context.Context, namespace, name string, generation int64, timeout time.Duration) (*RolloutStatus, error) {
+	changed := make(chan struct{}, 1)
+	notify := func(obj interface{}) {
+		if d, ok := obj.(*appsv1.Deployment); ok && d.Namespace == namespace && d.Name == name {
+			select {
+			case changed <- struct{}{}:
+			default:
+			}
+		}
+	}
+
+	registration, err := w.Informer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    notify,
+		UpdateFunc: func(_, obj interface{}) { notify(obj) },
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer w.Informer.Informer().RemoveEventHandler(registration)
+
+	ticker := time.NewTicker(w.PollInterval)
+	defer ticker.Stop()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		// A Deployment which was just created may not be in the informer's cache yet
+		deployment, err := w.getFunction(namespace, name)
+		if err != nil && !errors.IsNotFound(err) {
+			return nil, err
+		}
+
+		if deployment != nil {
+			status := w.describe(ctx, deployment, generation)
+			if status.Complete || status.Failed {
+				return status, nil
+			}
+		}
+
+		select {
+		case <-changed:
+		case <-ticker.C:
+		case <-timer.C:
+			if deployment == nil {
+				return nil, err
+			}
+			return w.describe(ctx, deployment, generation), nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (w *RolloutWatcher) getFunction(namespace, name string) (*appsv1.Deployment, error) {
+	deployment, err := w.Informer.Lister().Deployments(namespace).Get(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := deployment.Labels["faas_function"]; !ok && !IsCanary(deployment) {
+		return nil, errors.NewNotFound(schema.GroupResource{Group: "apps", Resource: "deployments"}, name)
+	}
+
+	return deployment, nil
+}
+
+// describe returns the rollout status of deployment, with the reason from its pods
+// when the rollout is not complete. Once the Deployment was observed, only the pods
+// of its latest revision are read, and the rollout fails when one of them cannot
+// start.
+func (w *RolloutWatcher) describe(ctx context.Context, deployment *appsv1.Deployment, generation int64) *RolloutStatus {
+	status := rolloutProgress(deployment, generation)
+	if status.Complete {
+		return status
+	}
+
+	podTemplateHash := ""
+	if observed(deployment, generation) {
+		hash, err := latestPodTemplateHash(ctx, w.Client, deployment)
+		if err != nil {
+			status.Message = fmt.Sprintf("%s, unable to read the revisions: %s", status.Message, err.Error())
+			return status
+		}
+		podTemplateHash = hash
+	}
+
+	reason, message, err := podFailureReason(ctx, w.Client, deployment, podTemplateHash)
+	if err != nil {
+		status.Message = fmt.Sprintf("%s, unable to read the pods: %s", status.Message, err.Error())
+		return status
+	}
+
+	if len(reason) > 0 {
+		status.Reason = reason
+		status.Message = message
+	}
+
+	if len(podTemplateHash) > 0 && failFastReasons[reason] {
+		status.Failed = true
+	}
+
+	return status
+}
+
+// observed returns true when the Deployment controller has seen generation of
+// deployment, or a later one
+func observed(deployment *appsv1.Deployment, generation int64) bool {
+	if deployment.Generation > generation {
+		generation = deployment.Generation
+	}
+	return deployment.Status.ObservedGeneration >= generation
+}
+
+// latestPodTemplateHash returns the pod-template-hash label of the ReplicaSet of the
+// latest revision of deployment, or an empty string when it was not created yet
+func latestPodTemplateHash(ctx context.Context, client kubernetes.Interface, deployment *appsv1.Deployment) (string, error) {
+	revisions, err := ListRevisions(ctx, client, deployment)
+	if err != nil {
+		return "", err
+	}
+
+	for _, rs := range revisions {
+		if Revision(&rs.ObjectMeta) == Revision(&deployment.ObjectMeta) {
+			return rs.Labels[appsv1.DefaultDeploymentUniqueLabelKey], nil
+		}
+	}
+	return "", nil
+}
+
+// rolloutProgress returns the rollout status of deployment from its conditions and
+// replicas, in the same way as "kubectl rollout status"
+func rolloutProgress(deployment *appsv1.Deployment, generation int64) *RolloutStatus {
+	status := &RolloutStatus{
+		Name:              deployment.Name,
+		Namespace:         deployment.Namespace,
+		Replicas:          deployment.Status.Replicas,
+		UpdatedReplicas:   deployment.Status.UpdatedReplicas,
+		AvailableReplicas: deployment.Status.AvailableReplicas,
+	}
+
+	if !observed(deployment, generation) {
+		status.Message = "waiting for the Deployment to be observed"
+		return status
+	}
+
+	for _, c := range deployment.Status.Conditions {
+		if c.Type == appsv1.DeploymentProgressing && c.Reason == "ProgressDeadlineExceeded" {
+			status.Failed = true
+			status.Reason = c.Reason
+			status.Message = c.Message
+			return status
+		}
+
+		if c.Type == appsv1.DeploymentReplicaFailure && c.Status == corev1.ConditionTrue {
+			status.Reason = c.Reason
+			status.Message = c.Message
+		}
+	}
+
+	desired := int32(1)
+	if deployment.Spec.Replicas != nil {
+		desired = *deployment.Spec.Replicas
+	}
+
+	var progress string
+	switch {
+	case status.UpdatedReplicas < desired:
+		progress = fmt.Sprintf("%d out of %d new replicas have been updated", status.UpdatedReplicas, desired)
+	case status.Replicas > status.UpdatedReplicas:
+		progress = fmt.Sprintf("%d old replicas are pending termination", status.Replicas-status.UpdatedReplicas)
+	case status.AvailableReplicas < status.UpdatedReplicas:
+		progress = fmt.Sprintf("%d of %d updated replicas are available", status.AvailableReplicas, status.UpdatedReplicas)
+	default:
+		status.Complete = true
+		status.Reason = ""
+		status.Message = ""
+		return status
+	}
+
+	// A ReplicaFailure such as an exceeded quota explains the progress better
+	if len(status.Reason) == 0 {
+		status.Message = progress
+	}
+
+	return status
+}
+
+// podFailureReason returns the first reason found for a pod of deployment not
+// becoming ready, from the container statuses, the pod's conditions and then its
+// warning events. When podTemplateHash is set, only the pods with that
+// pod-template-hash are read.
+func podFailureReason(ctx context.Context, client kubernetes.Interface, deployment *appsv1.Deployment, podTemplateHash string) (string, string, error) {
+	selector, err := metav1.LabelSelectorAsSelector(deployment.Spec.Selector)
+	if err != nil {
+		return "", "", err
+	}
+
+	if len(podTemplateHash) > 0 {
+		requirement, err := labels.NewRequirement(appsv1.DefaultDeploymentUniqueLabelKey, selection.Equals, []string{podTemplateHash})
+		if err != nil {
+			return "", "", err
+		}
+		selector = selector.Add(*requirement)
+	}
+
+	pods, err := client.CoreV1().Pods(deployment.Namespace).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return "", "", err
+	}
+
+	for _, pod := range pods.Items {
+		if pod.DeletionTimestamp != nil {
+			continue
+		}
+
+		statuses := append(pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses...)
+		for _, s := range statuses {
+			if s.State.Waiting != nil && podFailureReasons[s.State.Waiting.Reason] {
+				return s.State.Waiting.Reason, s.State.Waiting.Message, nil
+			}
+		}
+
+		for _, c := range pod.Status.Conditions {
+			if c.Type == corev1.PodScheduled && c.Status == corev1.ConditionFalse && len(c.Reason) > 0 {
+				return c.Reason, c.Message, nil
+			}
+		}
+	}
+
+	for _, pod := range pods.Items {
+		if pod.DeletionTimestamp != nil || isPodReady(pod) {
+			continue
+		}
+
+		event, err := lastWarningEvent(ctx, client, pod)
+		if err != nil {
+			return "", "", err
+		}
+
+		if event != nil {
+			return event.Reason, event.Message, nil
+		}
+	}
+
+	return "", "", nil
+}
+
+func lastWarningEvent(ctx context.Context, client kubernetes.Interface, pod corev1.Pod) (*corev1.Event, error) {
+	selector := fields.Set{
+		"involvedObject.kind": "Pod",
+		"involvedObject.name": pod.Name,
+		"type":                corev1.EventTypeWarning,
+	}.AsSelector()
+
+	events, err := client.CoreV1().Events(pod.Namespace).List(ctx, metav1.ListOptions{FieldSelector: selector.String()})
+	if err != nil {
+		return nil, err
+	}
+
+	warnings := []corev1.Event{}
+	for _, e := range events.Items {
+		if e.InvolvedObject.Name == pod.Name && e.Type == corev1.EventTypeWarning {
+			warnings = append(warnings, e)
+		}
+	}
+
+	if len(warnings) == 0 {
+		return nil, nil
+	}
+
+	sort.Slice(warnings, func(i, j int) bool {
+		return warnings[i].LastTimestamp.Before(&warnings[j].LastTimestamp)
+	})
+
+	return &warnings[len(warnings)-1], nil
+}
+
+func isPodReady(pod corev1.Pod) bool {
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
@@ -0,0 +1,369 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+package k8s
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubeinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newRolloutDeployment(replicas int32, status appsv1.DeploymentStatus) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       "figlet",
+			Namespace:  "openfaas-fn",
+			Generation: 2,
+			Labels:     map[string]string{"faas_function": "figlet"},
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"faas_function": "figlet"}},
+		},
+		Status: status,
+	}
+}
+
+func newRolloutPod(name string, status corev1.PodStatus) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "openfaas-fn",
+			Labels:    map[string]string{"faas_function": "figlet"},
+		},
+		Status: status,
+	}
+}
+
+func newTestRolloutWatcher(t *testing.T, kube *fake.Clientset) *RolloutWatcher {
+	t.Helper()
+
+	factory := kubeinformers.NewSharedInformerFactory(kube, 0)
+	deployments := factory.Apps().V1().Deployments()
+	deployments.Informer()
+
+	stopCh := make(chan struct{})
+	t.Cleanup(func() { close(stopCh) })
+
+	factory.Start(stopCh)
+	factory.WaitForCacheSync(stopCh)
+
+	watcher := NewRolloutWatcher(kube, deployments)
+	watcher.PollInterval = time.Millisecond * 10
+	return watcher
+}
+
+func Test_rolloutProgress(t *testing.T) {
+	cases := []struct {
+		name         string
+		replicas     int32
+		status       appsv1.DeploymentStatus
+		wantComplete bool
+		wantFailed   bool
+		wantReason   string
+	}{
+		{
+			name:         "complete",
+			replicas:     2,
+			status:       appsv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 2, UpdatedReplicas: 2, AvailableReplicas: 2},
+			wantComplete: true,
+		},
+		{
+			name:     "not observed",
+			replicas: 1,
+			status:   appsv1.DeploymentStatus{ObservedGeneration: 1, Replicas: 1, UpdatedReplicas: 1, AvailableReplicas: 1},
+		},
+		{
+			name:     "replicas not updated",
+			replicas: 2,
+			status:   appsv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 2, UpdatedReplicas: 1, AvailableReplicas: 2},
+		},
+		{
+			name:     "old replicas pending termination",
+			replicas: 1,
+			status:   appsv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 2, UpdatedReplicas: 1, AvailableReplicas: 1},
+		},
+		{
+			name:     "updated replicas unavailable",
+			replicas: 1,
+			status:   appsv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 1, UpdatedReplicas: 1},
+		},
+		{
+			name:     "progress deadline exceeded",
+			replicas: 1,
+			status: appsv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 1, UpdatedReplicas: 1,
+				Conditions: []appsv1.DeploymentCondition{
+					{Type: appsv1.DeploymentProgressing, Status: corev1.ConditionFalse, Reason: "ProgressDeadlineExceeded", Message: "ReplicaSet \"figlet-7d9f\" has timed out progressing."},
+				}},
+			wantFailed: true,
+			wantReason: "ProgressDeadlineExceeded",
+		},
+		{
+			name:     "replica failure",
+			replicas: 1,
+			status: appsv1.DeploymentStatus{ObservedGeneration: 2,
+				Conditions: []appsv1.DeploymentCondition{
+					{Type: appsv1.DeploymentReplicaFailure, Status: corev1.ConditionTrue, Reason: "FailedCreate", Message: "exceeded quota"},
+				}},
+			wantReason: "FailedCreate",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := rolloutProgress(newRolloutDeployment(tc.replicas, tc.status), 0)
+
+			if got.Complete != tc.wantComplete {
+				t.Errorf("want complete: %v, got: %v", tc.wantComplete, got.Complete)
+			}
+			if got.Failed != tc.wantFailed {
+				t.Errorf("want failed: %v, got: %v", tc.wantFailed, got.Failed)
+			}
+			if got.Reason != tc.wantReason {
+				t.Errorf("want reason: %q, got: %q", tc.wantReason, got.Reason)
+			}
+			if !got.Complete && len(got.Message) == 0 {
+				t.Errorf("want a message for an incomplete rollout")
+			}
+		})
+	}
+}
+
+func Test_RolloutWatcher_Status_ReadsPodReason(t *testing.T) {
+	unavailable := appsv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 1, UpdatedReplicas: 1}
+
+	cases := []struct {
+		name        string
+		pods        []*corev1.Pod
+		events      []*corev1.Event
+		wantReason  string
+		wantMessage string
+	}{
+		{
+			name: "image pull back off",
+			pods: []*corev1.Pod{newRolloutPod("figlet-1", corev1.PodStatus{
+				ContainerStatuses: []corev1.ContainerStatus{{
+					Name:  "figlet",
+					State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ImagePullBackOff", Message: "Back-off pulling image"}},
+				}},
+			})},
+			wantReason:  "ImagePullBackOff",
+			wantMessage: "Back-off pulling image",
+		},
+		{
+			name: "crash loop back off",
+			pods: []*corev1.Pod{newRolloutPod("figlet-1", corev1.PodStatus{
+				ContainerStatuses: []corev1.ContainerStatus{{
+					Name:  "figlet",
+					State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}},
+				}},
+			})},
+			wantReason: "CrashLoopBackOff",
+		},
+		{
+			name: "unschedulable",
+			pods: []*corev1.Pod{newRolloutPod("figlet-1", corev1.PodStatus{
+				Conditions: []corev1.PodCondition{
+					{Type: corev1.PodScheduled, Status: corev1.ConditionFalse, Reason: "Unschedulable", Message: "0/3 nodes are available: 3 Insufficient memory."},
+				},
+			})},
+			wantReason:  "Unschedulable",
+			wantMessage: "0/3 nodes are available: 3 Insufficient memory.",
+		},
+		{
+			name: "warning event",
+			pods: []*corev1.Pod{newRolloutPod("figlet-1", corev1.PodStatus{})},
+			events: []*corev1.Event{
+				{
+					ObjectMeta:     metav1.ObjectMeta{Name: "figlet-1.1", Namespace: "openfaas-fn"},
+					InvolvedObject: corev1.ObjectReference{Kind: "Pod", Name: "figlet-1", Namespace: "openfaas-fn"},
+					Type:           corev1.EventTypeWarning,
+					Reason:         "FailedMount",
+					Message:        "secret \"api-key\" not found",
+					LastTimestamp:  metav1.NewTime(time.Now()),
+				},
+				{
+					ObjectMeta:     metav1.ObjectMeta{Name: "figlet-1.2", Namespace: "openfaas-fn"},
+					InvolvedObject: corev1.ObjectReference{Kind: "Pod", Name: "figlet-1", Namespace: "openfaas-fn"},
+					Type:           corev1.EventTypeNormal,
+					Reason:         "Scheduled",
+					LastTimestamp:  metav1.NewTime(time.Now()),
+				},
+			},
+			wantReason:  "FailedMount",
+			wantMessage: "secret \"api-key\" not found",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			kube := fake.NewSimpleClientset(newRolloutDeployment(1, unavailable))
+			for _, pod := range tc.pods {
+				kube.Tracker().Add(pod)
+			}
+			for _, event := range tc.events {
+				kube.Tracker().Add(event)
+			}
+
+			got, err := newTestRolloutWatcher(t, kube).Status(context.Background(), "openfaas-fn", "figlet")
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if got.Complete {
+				t.Errorf("want an incomplete rollout")
+			}
+			if got.Reason != tc.wantReason {
+				t.Errorf("want reason: %q, got: %q", tc.wantReason, got.Reason)
+			}
+			if len(tc.wantMessage) > 0 && got.Message != tc.wantMessage {
+				t.Errorf("want message: %q, got: %q", tc.wantMessage, got.Message)
+			}
+		})
+	}
+}
+
+func Test_RolloutWatcher_Wait_Completes(t *testing.T) {
+	deployment := newRolloutDeployment(1, appsv1.DeploymentStatus{ObservedGeneration: 1})
+	kube := fake.NewSimpleClientset(deployment)
+	watcher := newTestRolloutWatcher(t, kube)
+
+	go func() {
+		time.Sleep(time.Millisecond * 50)
+
+		ready := deployment.DeepCopy()
+		ready.Status = appsv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 1, UpdatedReplicas: 1, AvailableReplicas: 1}
+		kube.AppsV1().Deployments("openfaas-fn").UpdateStatus(context.Background(), ready, metav1.UpdateOptions{})
+	}()
+
+	got, err := watcher.Wait(context.Background(), "openfaas-fn", "figlet", 2, time.Second*5)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if !got.Complete {
+		t.Errorf("want a complete rollout, got: %+v", got)
+	}
+}
+
+func Test_RolloutWatcher_Wait_TimesOutWithReason(t *testing.T) {
+	kube := fake.NewSimpleClientset(
+		newRolloutDeployment(1, appsv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 1, UpdatedReplicas: 1}),
+		newRolloutPod("figlet-1", corev1.PodStatus{
+			ContainerStatuses: []corev1.ContainerStatus{{
+				Name:  "figlet",
+				State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CreateContainerConfigError"}},
+			}},
+		}),
+	)
+
+	got, err := newTestRolloutWatcher(t, kube).Wait(context.Background(), "openfaas-fn", "figlet", 2, time.Millisecond*50)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if got.Complete || got.Failed {
+		t.Errorf("want a rollout which is still progressing, got: %+v", got)
+	}
+	if got.Reason != "CreateContainerConfigError" {
+		t.Errorf("want reason: %q, got: %q", "CreateContainerConfigError", got.Reason)
+	}
+}
+
+// newRolloutRevision returns the Deployment with its ReplicaSets of revisions 1
+// and 2, the pods of revision n are labelled with the pod-template-hash "rev-n"
+func newRolloutRevision(status appsv1.DeploymentStatus) (*appsv1.Deployment, []runtime.Object) {
+	deployment := newRolloutDeployment(1, status)
+	deployment.UID = "figlet-uid"
+	deployment.Annotations = map[string]string{RevisionAnnotation: "2"}
+
+	objects := []runtime.Object{deployment}
+	for _, revision := range []string{"1", "2"} {
+		objects = append(objects, &appsv1.ReplicaSet{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            "figlet-rev-" + revision,
+				Namespace:       "openfaas-fn",
+				Labels:          map[string]string{"faas_function": "figlet", appsv1.DefaultDeploymentUniqueLabelKey: "rev-" + revision},
+				Annotations:     map[string]string{RevisionAnnotation: revision},
+				OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(deployment, appsv1.SchemeGroupVersion.WithKind("Deployment"))},
+			},
+		})
+	}
+
+	return deployment, objects
+}
+
+func Test_RolloutWatcher_Wait_FailsFast(t *testing.T) {
+	waiting := func(reason string) corev1.PodStatus {
+		return corev1.PodStatus{
+			ContainerStatuses: []corev1.ContainerStatus{{
+				Name:  "figlet",
+				State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: reason}},
+			}},
+		}
+	}
+	unschedulable := corev1.PodStatus{
+		Conditions: []corev1.PodCondition{
+			{Type: corev1.PodScheduled, Status: corev1.ConditionFalse, Reason: "Unschedulable", Message: "0/3 nodes are available: 3 Insufficient memory."},
+		},
+	}
+
+	cases := []struct {
+		name       string
+		revision   string
+		status     corev1.PodStatus
+		wantFailed bool
+		wantReason string
+	}{
+		{name: "crash loop back off", revision: "2", status: waiting("CrashLoopBackOff"), wantFailed: true, wantReason: "CrashLoopBackOff"},
+		{name: "image pull back off", revision: "2", status: waiting("ImagePullBackOff"), wantFailed: true, wantReason: "ImagePullBackOff"},
+		{name: "error pulling image", revision: "2", status: waiting("ErrImagePull"), wantFailed: true, wantReason: "ErrImagePull"},
+		{name: "unschedulable", revision: "2", status: unschedulable, wantFailed: true, wantReason: "Unschedulable"},
+		{name: "previous revision crashing", revision: "1", status: waiting("CrashLoopBackOff")},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, objects := newRolloutRevision(appsv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 2, UpdatedReplicas: 1})
+
+			pod := newRolloutPod("figlet-1", tc.status)
+			pod.Labels[appsv1.DefaultDeploymentUniqueLabelKey] = "rev-" + tc.revision
+			kube := fake.NewSimpleClientset(append(objects, pod)...)
+
+			timeout := time.Millisecond * 500
+			start := time.Now()
+			got, err := newTestRolloutWatcher(t, kube).Wait(context.Background(), "openfaas-fn", "figlet", 2, timeout)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if got.Failed != tc.wantFailed {
+				t.Errorf("want failed: %v, got: %+v", tc.wantFailed, got)
+			}
+			if got.Reason != tc.wantReason {
+				t.Errorf("want reason: %q, got: %q", tc.wantReason, got.Reason)
+			}
+			if tc.wantFailed && time.Since(start) >= timeout {
+				t.Errorf("want the rollout to fail before the timeout, took: %s", time.Since(start))
+			}
+		})
+	}
+}
+
+func Test_RolloutWatcher_IgnoresOtherDeployments(t *testing.T) {
+	deployment := newRolloutDeployment(1, appsv1.DeploymentStatus{})
+	deployment.Labels = nil
+
+	_, err := newTestRolloutWatcher(t, fake.NewSimpleClientset(deployment)).Status(context.Background(), "openfaas-fn", "figlet")
+	if err == nil {
+		t.Errorf("want an error for a Deployment which is not a function")
+	}
+}
@@ -0,0 +1,49 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+package k8s
+
+import (
+	"context"
+	"strconv"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// MinScaleLabel is the minimum number of replicas of a function
+	MinScaleLabel = "com.openfaas.scale.min"
+
+	// MaxScaleLabel is the maximum number of replicas of a function
+	MaxScaleLabel = "com.openfaas.scale.max"
+)
+
+// ScaleBounds returns the minimum and maximum replicas given by the labels of a
+// function. The minimum is at least 1, and the maximum defaults to maxReplicas but
+// is never below the minimum.
+func ScaleBounds(labels map[string]string, maxReplicas int32) (int32, int32) {
+	min, max := int32(1), maxReplicas
+
+	if v, err := strconv.Atoi(labels[MinScaleLabel]); err == nil && v > 0 {
+		min = int32(v)
+	}
+	if v, err := strconv.Atoi(labels[MaxScaleLabel]); err == nil && v > 0 {
+		max = int32(v)
+	}
+
+	if max < min {
+		max = min
+	}
+	return min, max
+}
+
+// ScaleDeployment sets the replicas of the Deployment of a function
+func ScaleDeployment(ctx context.Context, client kubernetes.Interface, namespace, name string, replicas int32) error {
+	patch := []byte(`{"spec":{"replicas":` + strconv.Itoa(int(replicas)) + `}}`)
+
+	_, err := client.AppsV1().Deployments(namespace).
+		Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{})
+	return err
+}
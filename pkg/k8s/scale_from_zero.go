@@ -0,0 +1,207 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+package k8s
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// ScaleFromZero resolves the endpoints of functions in the same way as its
+// FunctionLookup, but when a function with the com.openfaas.scale.zero label has no
+// ready endpoints, the request is held while the function is scaled up, until one of
+// its endpoints becomes ready or the Timeout is reached.
+type ScaleFromZero struct {
+	Lookup *FunctionLookup
+	Client kubernetes.Interface
+
+	// Idle is optional and records the requests to each function for the IdleScaler
+	Idle *IdleScaler
+
+	// Timeout is how long a request is held for while its function is scaled up
+	Timeout time.Duration
+
+	// MaxQueue is the number of requests held for each function, further requests
+	// are rejected until the function is ready
+	MaxQueue int
+
+	lock    sync.Mutex
+	waiting map[string]int
+	changed map[string]chan struct{}
+}
+
+// NewScaleFromZero creates a ScaleFromZero which is notified of changes to the
+// endpoints of functions by informer, which is the informer of either the Endpoints
+// or the EndpointSlices used by lookup.
+func NewScaleFromZero(lookup *FunctionLookup, client kubernetes.Interface, informer cache.SharedIndexInformer, timeout time.Duration, maxQueue int) *ScaleFromZero {
+	s := &ScaleFromZero{
+		Lookup:   lookup,
+		Client:   client,
+		Timeout:  timeout,
+		MaxQueue: maxQueue,
+		waiting:  map[string]int{},
+		changed:  map[string]chan struct{}{},
+	}
+
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: s.endpointsChanged,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			s.endpointsChanged(newObj)
+		},
+	})
+
+	return s
+}
+
+// ResolveRequest returns the URL of one of the endpoints of a function, scaling it
+// up from zero when required
+func (s *ScaleFromZero) ResolveRequest(name string, r *http.Request) (url.URL, func(), error) {
+	namespace := getNamespace(name, s.Lookup.DefaultNamespace)
+	functionName := strings.TrimSuffix(name, "."+namespace)
+
+	u, done, err := s.Lookup.ResolveRequest(name, r)
+	if err == nil {
+		return u, s.track(functionName, namespace, done), nil
+	}
+
+	// A function whose circuit is open has endpoints, which are failing
+	if errors.Is(err, ErrCircuitOpen) || s.Lookup.DeploymentLister == nil || s.Lookup.verifyNamespace(namespace) != nil {
+		return url.URL{}, nil, err
+	}
+
+	deployment, getErr := s.Lookup.DeploymentLister.Deployments(namespace).Get(functionName)
+	if getErr != nil || !ScaleToZeroEnabled(deployment.Spec.Template.Labels) {
+		return url.URL{}, nil, err
+	}
+
+	key := functionName + "." + namespace
+	if !s.enqueue(key) {
+		return url.URL{}, nil, fmt.Errorf("too many requests waiting for %s to scale up from zero", key)
+	}
+	defer s.dequeue(key)
+
+	ctx := context.Background()
+	if r != nil {
+		ctx = r.Context()
+	}
+
+	if deployment.Spec.Replicas == nil || *deployment.Spec.Replicas == 0 {
+		replicas, _ := ScaleBounds(deployment.Spec.Template.Labels, 0)
+		if err := ScaleDeployment(ctx, s.Client, namespace, functionName, replicas); err != nil {
+			return url.URL{}, nil, fmt.Errorf("unable to scale %s up from zero: %s", key, err.Error())
+		}
+		log.Printf("Scaling up from zero: %s to %d replica(s)", key, replicas)
+	}
+
+	start := time.Now()
+	timeout := time.NewTimer(s.Timeout)
+	defer timeout.Stop()
+
+	for {
+		// Taken before resolving, so that a change in between is not missed
+		changed := s.endpointsChangedFor(key)
+
+		u, done, err := s.Lookup.ResolveRequest(name, r)
+		if err == nil {
+			log.Printf("Scaled up from zero: %s in %s", key, time.Since(start).Round(time.Millisecond))
+			return u, s.track(functionName, namespace, done), nil
+		}
+
+		select {
+		case <-changed:
+		case <-timeout.C:
+			return url.URL{}, nil, fmt.Errorf("timed out after %s waiting for %s to scale up from zero", s.Timeout, key)
+		case <-ctx.Done():
+			return url.URL{}, nil, ctx.Err()
+		}
+	}
+}
+
+// RecordResult records the result of a request with the FunctionLookup
+func (s *ScaleFromZero) RecordResult(name, endpoint string, status int, err error, latency time.Duration) {
+	s.Lookup.RecordResult(name, endpoint, status, err, latency)
+}
+
+// track records the request with the IdleScaler
+func (s *ScaleFromZero) track(functionName, namespace string, done func()) func() {
+	if s.Idle == nil {
+		return done
+	}
+
+	end := s.Idle.Start(functionName, namespace)
+	return func() {
+		done()
+		end()
+	}
+}
+
+func (s *ScaleFromZero) enqueue(key string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.waiting[key] >= s.MaxQueue {
+		return false
+	}
+
+	s.waiting[key]++
+	return true
+}
+
+func (s *ScaleFromZero) dequeue(key string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.waiting[key]--
+	if s.waiting[key] <= 0 {
+		delete(s.waiting, key)
+		delete(s.changed, key)
+	}
+}
+
+// endpointsChangedFor returns a channel which is closed when the endpoints of the
+// function given by key change
+func (s *ScaleFromZero) endpointsChangedFor(key string) <-chan struct{} {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	ch, ok := s.changed[key]
+	if !ok {
+		ch = make(chan struct{})
+		s.changed[key] = ch
+	}
+	return ch
+}
+
+// endpointsChanged wakes up the requests waiting for the function of obj
+func (s *ScaleFromZero) endpointsChanged(obj interface{}) {
+	var key string
+	switch v := obj.(type) {
+	case *corev1.Endpoints:
+		key = v.Name + "." + v.Namespace
+	case *discoveryv1.EndpointSlice:
+		key = v.Labels[discoveryv1.LabelServiceName] + "." + v.Namespace
+	default:
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if ch, ok := s.changed[key]; ok {
+		close(ch)
+		delete(s.changed, key)
+	}
+}
@@ -0,0 +1,144 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+package k8s
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubeinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newTestScaleFromZero(t *testing.T, labels map[string]string) (*ScaleFromZero, *fake.Clientset) {
+	t.Helper()
+
+	kube := fake.NewSimpleClientset(newScaleToZeroDeployment("figlet", 0, labels))
+
+	factory := kubeinformers.NewSharedInformerFactory(kube, 0)
+	endpoints := factory.Core().V1().Endpoints()
+	deployments := factory.Apps().V1().Deployments()
+	endpoints.Informer()
+	deployments.Informer()
+
+	lookup := NewFunctionLookup("openfaas-fn", endpoints.Lister())
+	lookup.DeploymentLister = deployments.Lister()
+
+	scaler := NewScaleFromZero(lookup, kube, endpoints.Informer(), time.Second*5, 10)
+
+	stopCh := make(chan struct{})
+	t.Cleanup(func() { close(stopCh) })
+
+	factory.Start(stopCh)
+	factory.WaitForCacheSync(stopCh)
+
+	return scaler, kube
+}
+
+func Test_ScaleFromZero_HoldsRequestUntilReady(t *testing.T) {
+	scaler, kube := newTestScaleFromZero(t, map[string]string{ScaleToZeroLabel: "true", MinScaleLabel: "2"})
+
+	go func() {
+		ctx := context.Background()
+
+		// The Deployment is scaled up, then its pod becomes ready
+		for {
+			deployment, err := kube.AppsV1().Deployments("openfaas-fn").Get(ctx, "figlet", metav1.GetOptions{})
+			if err == nil && *deployment.Spec.Replicas > 0 {
+				break
+			}
+			time.Sleep(time.Millisecond * 10)
+		}
+
+		kube.CoreV1().Endpoints("openfaas-fn").Create(ctx, &corev1.Endpoints{
+			ObjectMeta: metav1.ObjectMeta{Name: "figlet", Namespace: "openfaas-fn"},
+		}, metav1.CreateOptions{})
+
+		time.Sleep(time.Millisecond * 50)
+
+		kube.CoreV1().Endpoints("openfaas-fn").Update(ctx, &corev1.Endpoints{
+			ObjectMeta: metav1.ObjectMeta{Name: "figlet", Namespace: "openfaas-fn"},
+			Subsets: []corev1.EndpointSubset{{
+				Addresses: []corev1.EndpointAddress{{IP: "10.0.0.1"}},
+			}},
+		}, metav1.UpdateOptions{})
+	}()
+
+	u, done, err := scaler.ResolveRequest("figlet.openfaas-fn", httptest.NewRequest("GET", "/function/figlet", nil))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	done()
+
+	if u.String() != "http://10.0.0.1:8080" {
+		t.Errorf("want: http://10.0.0.1:8080, got: %s", u.String())
+	}
+
+	if got := replicasOf(t, kube, "figlet"); got != 2 {
+		t.Errorf("want figlet scaled up to its minimum of 2 replicas, got: %d", got)
+	}
+}
+
+func Test_ScaleFromZero_TimesOut(t *testing.T) {
+	scaler, _ := newTestScaleFromZero(t, map[string]string{ScaleToZeroLabel: "true"})
+	scaler.Timeout = time.Millisecond * 50
+
+	_, _, err := scaler.ResolveRequest("figlet", nil)
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("want a timeout error, got: %v", err)
+	}
+}
+
+func Test_ScaleFromZero_QueueDepth(t *testing.T) {
+	scaler, _ := newTestScaleFromZero(t, map[string]string{ScaleToZeroLabel: "true"})
+	scaler.MaxQueue = 1
+	scaler.Timeout = time.Millisecond * 200
+
+	errs := make(chan error)
+	go func() {
+		_, _, err := scaler.ResolveRequest("figlet", nil)
+		errs <- err
+	}()
+
+	// Wait for the first request to be held
+	for {
+		scaler.lock.Lock()
+		waiting := scaler.waiting["figlet.openfaas-fn"]
+		scaler.lock.Unlock()
+		if waiting == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	_, _, err := scaler.ResolveRequest("figlet", nil)
+	if err == nil || !strings.Contains(err.Error(), "too many requests") {
+		t.Errorf("want the request to be rejected, got: %v", err)
+	}
+
+	if err := <-errs; err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("want the held request to time out, got: %v", err)
+	}
+}
+
+func Test_ScaleFromZero_IgnoresFunctionsWhichDidNotOptIn(t *testing.T) {
+	scaler, kube := newTestScaleFromZero(t, nil)
+
+	start := time.Now()
+	if _, _, err := scaler.ResolveRequest("figlet", nil); err == nil {
+		t.Fatalf("want an error for a function without endpoints")
+	}
+
+	if time.Since(start) > time.Second {
+		t.Errorf("want the request to fail without being held")
+	}
+	if got := replicasOf(t, kube, "figlet"); got != 0 {
+		t.Errorf("want figlet to be left at zero replicas, got: %d", got)
+	}
+}
@@ -0,0 +1,211 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+package k8s
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/client-go/kubernetes"
+	appslister "k8s.io/client-go/listers/apps/v1"
+
+	ftypes "github.com/openfaas/faas-provider/types"
+)
+
+const (
+	// ScaleToZeroLabel opts a function into being scaled to zero when it is idle
+	ScaleToZeroLabel = "com.openfaas.scale.zero"
+
+	// ScaleToZeroDurationLabel is how long a function has to be idle for before it
+	// is scaled to zero, i.e. 15m
+	ScaleToZeroDurationLabel = "com.openfaas.scale.zero-duration"
+)
+
+// ScaleToZeroEnabled returns true when the labels of a function opt it into being
+// scaled to zero
+func ScaleToZeroEnabled(labels map[string]string) bool {
+	return labels[ScaleToZeroLabel] == "true"
+}
+
+// ScaleToZeroDuration returns the idle duration given by the labels of a function,
+// or fallback when it has none
+func ScaleToZeroDuration(labels map[string]string, fallback time.Duration) time.Duration {
+	if v, ok := labels[ScaleToZeroDurationLabel]; ok {
+		if d := ftypes.ParseIntOrDurationValue(v, 0); d > 0 {
+			return d
+		}
+	}
+	return fallback
+}
+
+// functionActivity is the activity of a function seen by the proxy
+type functionActivity struct {
+	inflight int
+	last     time.Time
+}
+
+// IdleScaler scales functions with the com.openfaas.scale.zero label to zero
+// replicas, once no request has been proxied to them for their idle duration. The
+// activity is recorded in memory, so a function which is first seen after a restart
+// is given its whole idle duration.
+type IdleScaler struct {
+	Client           kubernetes.Interface
+	DeploymentLister appslister.DeploymentLister
+
+	// Namespaces is optional and restricts the scaler to the managed namespaces
+	Namespaces *Namespaces
+
+	// DefaultIdle is used for functions without the com.openfaas.scale.zero-duration label
+	DefaultIdle time.Duration
+
+	// Interval is how often functions are checked for being idle
+	Interval time.Duration
+
+	// Now returns the current time, it is replaced in tests
+	Now func() time.Time
+
+	lock     sync.Mutex
+	activity map[string]*functionActivity
+}
+
+// NewIdleScaler creates an IdleScaler which checks for idle functions every 30 seconds
+func NewIdleScaler(client kubernetes.Interface, lister appslister.DeploymentLister, defaultIdle time.Duration) *IdleScaler {
+	return &IdleScaler{
+		Client:           client,
+		DeploymentLister: lister,
+		DefaultIdle:      defaultIdle,
+		Interval:         time.Second * 30,
+		Now:              time.Now,
+		activity:         map[string]*functionActivity{},
+	}
+}
+
+// Start records the start of a request to a function, the returned func records
+// its end. A function is not idle while a request to it is in progress.
+func (s *IdleScaler) Start(name, namespace string) func() {
+	key := name + "." + namespace
+
+	s.lock.Lock()
+	a := s.get(key)
+	a.inflight++
+	a.last = s.Now()
+	s.lock.Unlock()
+
+	return func() {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+
+		a := s.get(key)
+		if a.inflight > 0 {
+			a.inflight--
+		}
+		a.last = s.Now()
+	}
+}
+
+// Run checks for idle functions every Interval until stopCh is closed
+func (s *IdleScaler) Run(stopCh <-chan struct{}) {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.ScaleIdle(context.Background()); err != nil {
+				log.Printf("Unable to scale idle functions to zero: %s", err.Error())
+			}
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// ScaleIdle scales each idle function which has opted in to zero replicas
+func (s *IdleScaler) ScaleIdle(ctx context.Context) error {
+	req, err := labels.NewRequirement("faas_function", selection.Exists, []string{})
+	if err != nil {
+		return err
+	}
+
+	deployments, err := s.DeploymentLister.List(labels.NewSelector().Add(*req))
+	if err != nil {
+		return err
+	}
+
+	seen := map[string]bool{}
+	for _, deployment := range deployments {
+		key := deployment.Name + "." + deployment.Namespace
+		seen[key] = true
+
+		if !s.isIdle(deployment) {
+			continue
+		}
+
+		if err := ScaleDeployment(ctx, s.Client, deployment.Namespace, deployment.Name, 0); err != nil {
+			log.Printf("Unable to scale %s to zero: %s", key, err.Error())
+			continue
+		}
+
+		// A function which is scaled up by other means is given its whole idle duration
+		s.lock.Lock()
+		s.get(key).last = s.Now()
+		s.lock.Unlock()
+
+		log.Printf("Scaled to zero: %s, idle for: %s", key, ScaleToZeroDuration(deployment.Spec.Template.Labels, s.DefaultIdle))
+	}
+
+	s.forget(seen)
+	return nil
+}
+
+// isIdle returns true when deployment can be scaled to zero
+func (s *IdleScaler) isIdle(deployment *appsv1.Deployment) bool {
+	labels := deployment.Spec.Template.Labels
+	if !ScaleToZeroEnabled(labels) || deployment.Spec.Replicas == nil || *deployment.Spec.Replicas == 0 {
+		return false
+	}
+
+	if s.Namespaces != nil && s.Namespaces.Validate(deployment.Namespace) != nil {
+		return false
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	key := deployment.Name + "." + deployment.Namespace
+	if _, ok := s.activity[key]; !ok {
+		s.activity[key] = &functionActivity{last: s.Now()}
+		return false
+	}
+
+	a := s.activity[key]
+	return a.inflight == 0 && s.Now().Sub(a.last) >= ScaleToZeroDuration(labels, s.DefaultIdle)
+}
+
+// forget removes the activity of functions which no longer exist
+func (s *IdleScaler) forget(seen map[string]bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for key, a := range s.activity {
+		if !seen[key] && a.inflight == 0 {
+			delete(s.activity, key)
+		}
+	}
+}
+
+// get returns the activity of a function, the lock must be held
+func (s *IdleScaler) get(key string) *functionActivity {
+	a, ok := s.activity[key]
+	if !ok {
+		a = &functionActivity{}
+		s.activity[key] = a
+	}
+	return a
+}
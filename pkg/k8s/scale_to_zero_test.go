@@ -0,0 +1,164 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+package k8s
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubeinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newScaleToZeroDeployment(name string, replicas int32, labels map[string]string) *appsv1.Deployment {
+	templateLabels := map[string]string{"faas_function": name}
+	for k, v := range labels {
+		templateLabels[k] = v
+	}
+
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "openfaas-fn",
+			Labels:    map[string]string{"faas_function": name},
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: templateLabels},
+			},
+		},
+	}
+}
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Add(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newTestIdleScaler(t *testing.T, deployments ...*appsv1.Deployment) (*IdleScaler, *fake.Clientset, *fakeClock) {
+	t.Helper()
+
+	kube := fake.NewSimpleClientset()
+	factory := kubeinformers.NewSharedInformerFactory(kube, 0)
+	informer := factory.Apps().V1().Deployments()
+
+	for _, d := range deployments {
+		kube.Tracker().Add(d)
+		informer.Informer().GetIndexer().Add(d)
+	}
+
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+
+	scaler := NewIdleScaler(kube, informer.Lister(), time.Minute*15)
+	scaler.Now = clock.Now
+	return scaler, kube, clock
+}
+
+func replicasOf(t *testing.T, kube *fake.Clientset, name string) int32 {
+	t.Helper()
+
+	deployment, err := kube.AppsV1().Deployments("openfaas-fn").Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return *deployment.Spec.Replicas
+}
+
+func Test_IdleScaler_ScalesIdleFunctionsToZero(t *testing.T) {
+	scaler, kube, clock := newTestIdleScaler(t,
+		newScaleToZeroDeployment("figlet", 1, map[string]string{ScaleToZeroLabel: "true"}),
+		newScaleToZeroDeployment("env", 1, map[string]string{ScaleToZeroLabel: "true", ScaleToZeroDurationLabel: "5m"}),
+		newScaleToZeroDeployment("nodeinfo", 1, nil),
+	)
+	ctx := context.Background()
+
+	// The functions are first seen, so are given their whole idle duration
+	scaler.ScaleIdle(ctx)
+
+	clock.Add(time.Minute * 6)
+	scaler.ScaleIdle(ctx)
+
+	if got := replicasOf(t, kube, "env"); got != 0 {
+		t.Errorf("want env scaled to zero after its 5m idle duration, got: %d replicas", got)
+	}
+	if got := replicasOf(t, kube, "figlet"); got != 1 {
+		t.Errorf("want figlet to keep running within the default idle duration, got: %d replicas", got)
+	}
+
+	clock.Add(time.Minute * 10)
+	scaler.ScaleIdle(ctx)
+
+	if got := replicasOf(t, kube, "figlet"); got != 0 {
+		t.Errorf("want figlet scaled to zero after the default idle duration, got: %d replicas", got)
+	}
+	if got := replicasOf(t, kube, "nodeinfo"); got != 1 {
+		t.Errorf("want nodeinfo which did not opt in to keep running, got: %d replicas", got)
+	}
+}
+
+func Test_IdleScaler_RequestsKeepFunctionRunning(t *testing.T) {
+	scaler, kube, clock := newTestIdleScaler(t,
+		newScaleToZeroDeployment("figlet", 1, map[string]string{ScaleToZeroLabel: "true", ScaleToZeroDurationLabel: "5m"}),
+	)
+	ctx := context.Background()
+
+	scaler.ScaleIdle(ctx)
+
+	// A long running request is in progress for longer than the idle duration
+	done := scaler.Start("figlet", "openfaas-fn")
+	clock.Add(time.Minute * 10)
+	scaler.ScaleIdle(ctx)
+
+	if got := replicasOf(t, kube, "figlet"); got != 1 {
+		t.Errorf("want figlet to keep running with a request in progress, got: %d replicas", got)
+	}
+
+	done()
+	clock.Add(time.Minute * 4)
+	scaler.ScaleIdle(ctx)
+
+	if got := replicasOf(t, kube, "figlet"); got != 1 {
+		t.Errorf("want the idle duration to start at the end of the last request, got: %d replicas", got)
+	}
+
+	clock.Add(time.Minute)
+	scaler.ScaleIdle(ctx)
+
+	if got := replicasOf(t, kube, "figlet"); got != 0 {
+		t.Errorf("want figlet scaled to zero, got: %d replicas", got)
+	}
+}
+
+func Test_ScaleToZeroDuration(t *testing.T) {
+	cases := []struct {
+		name   string
+		labels map[string]string
+		want   time.Duration
+	}{
+		{name: "default", labels: nil, want: time.Minute * 15},
+		{name: "duration", labels: map[string]string{ScaleToZeroDurationLabel: "2m"}, want: time.Minute * 2},
+		{name: "seconds", labels: map[string]string{ScaleToZeroDurationLabel: "90"}, want: time.Second * 90},
+		{name: "invalid", labels: map[string]string{ScaleToZeroDurationLabel: "soon"}, want: time.Minute * 15},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := ScaleToZeroDuration(tc.labels, time.Minute*15); got != tc.want {
+				t.Errorf("want: %s, got: %s", tc.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+package k8s
+
+import (
+	types "github.com/openfaas/faas-provider/types"
+	corev1 "k8s.io/api/core/v1"
+	metricsv1beta1 "k8s.io/metrics/pkg/apis/metrics/v1beta1"
+)
+
+// AggregateFunctionUsage sums the CPU and memory used by each container of each pod,
+// grouped by the faas_function label of the pod.
+func AggregateFunctionUsage(items []metricsv1beta1.PodMetrics) map[string]*types.FunctionUsage {
+	usage := map[string]*types.FunctionUsage{}
+
+	for _, pod := range items {
+		name, ok := pod.Labels["faas_function"]
+		if !ok {
+			continue
+		}
+
+		fnUsage, ok := usage[name]
+		if !ok {
+			fnUsage = &types.FunctionUsage{}
+			usage[name] = fnUsage
+		}
+
+		for _, container := range pod.Containers {
+			if cpu, ok := container.Usage[corev1.ResourceCPU]; ok {
+				fnUsage.CPU += float64(cpu.MilliValue())
+			}
+			if memory, ok := container.Usage[corev1.ResourceMemory]; ok {
+				fnUsage.TotalMemoryBytes += float64(memory.Value())
+			}
+		}
+	}
+
+	return usage
+}
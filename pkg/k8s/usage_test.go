@@ -0,0 +1,58 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+package k8s
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	metricsv1beta1 "k8s.io/metrics/pkg/apis/metrics/v1beta1"
+)
+
+func newPodMetrics(function, pod string, usage ...corev1.ResourceList) metricsv1beta1.PodMetrics {
+	m := metricsv1beta1.PodMetrics{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      pod,
+			Namespace: "openfaas-fn",
+			Labels:    map[string]string{"faas_function": function},
+		},
+	}
+	for i, u := range usage {
+		m.Containers = append(m.Containers, metricsv1beta1.ContainerMetrics{Name: string(rune('a' + i)), Usage: u})
+	}
+	return m
+}
+
+func resourceList(cpu, memory string) corev1.ResourceList {
+	return corev1.ResourceList{
+		corev1.ResourceCPU:    resource.MustParse(cpu),
+		corev1.ResourceMemory: resource.MustParse(memory),
+	}
+}
+
+func Test_AggregateFunctionUsage(t *testing.T) {
+	items := []metricsv1beta1.PodMetrics{
+		newPodMetrics("nodeinfo", "nodeinfo-1", resourceList("5m", "10Mi"), resourceList("1m", "1Mi")),
+		newPodMetrics("nodeinfo", "nodeinfo-2", resourceList("10m", "5Mi")),
+		newPodMetrics("figlet", "figlet-1", resourceList("250m", "1Gi")),
+		{ObjectMeta: metav1.ObjectMeta{Name: "not-a-function"}},
+	}
+
+	usage := AggregateFunctionUsage(items)
+	if len(usage) != 2 {
+		t.Fatalf("want usage for 2 functions, got: %d", len(usage))
+	}
+
+	if got := usage["nodeinfo"].CPU; got != 16 {
+		t.Errorf("want nodeinfo CPU: 16, got: %f", got)
+	}
+	if got := usage["nodeinfo"].TotalMemoryBytes; got != 16*1024*1024 {
+		t.Errorf("want nodeinfo memory: %d, got: %f", 16*1024*1024, got)
+	}
+	if got := usage["figlet"].CPU; got != 250 {
+		t.Errorf("want figlet CPU: 250, got: %f", got)
+	}
+}
@@ -0,0 +1,77 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// DefaultRetryCodes are the status codes of a function which are retried, when it
+// does not give its own
+var DefaultRetryCodes = []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+
+// Policy is the timeout and retries of the invocations of a function
+type Policy struct {
+	// Timeout is the time allowed for an invocation including its retries, 0 leaves
+	// the invocation to the timeouts of the proxy
+	Timeout time.Duration
+
+	// RetryAttempts is the number of times an invocation with an idempotent method
+	// is retried on another endpoint, when the endpoint could not be reached or
+	// responded with one of the RetryCodes
+	RetryAttempts int
+
+	// RetryCodes are the status codes which are retried
+	RetryCodes []int
+}
+
+// PolicyResolver is implemented by a RequestResolver which has a Policy for each
+// function, the proxy uses the zero Policy for a RequestResolver without one
+type PolicyResolver interface {
+	Policy(functionName string) Policy
+}
+
+// retryStatus returns true when status is one of the RetryCodes
+func (p Policy) retryStatus(status int) bool {
+	for _, code := range p.RetryCodes {
+		if code == status {
+			return true
+		}
+	}
+	return false
+}
+
+// idempotent returns true for the methods which can be sent more than once
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet,
+		http.MethodHead,
+		http.MethodOptions,
+		http.MethodPut,
+		http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+type triedEndpointsKey struct{}
+
+// WithTriedEndpoints returns a copy of r which records the endpoints which it was
+// already sent to
+func WithTriedEndpoints(r *http.Request, endpoints []string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), triedEndpointsKey{}, endpoints))
+}
+
+// TriedEndpoints returns the host:port of each endpoint which r was already sent to,
+// a RequestResolver avoids them when the function has other endpoints
+func TriedEndpoints(r *http.Request) []string {
+	if r == nil {
+		return nil
+	}
+
+	endpoints, _ := r.Context().Value(triedEndpointsKey{}).([]string)
+	return endpoints
+}
@@ -0,0 +1,182 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// policyResolver resolves the first endpoint which a request was not already sent to
+type policyResolver struct {
+	endpoints []url.URL
+	policy    Policy
+	resolved  []string
+	deadlines []bool
+}
+
+func (p *policyResolver) ResolveRequest(functionName string, r *http.Request) (url.URL, func(), error) {
+	_, hasDeadline := r.Context().Deadline()
+	p.deadlines = append(p.deadlines, hasDeadline)
+
+	tried := map[string]bool{}
+	for _, e := range TriedEndpoints(r) {
+		tried[e] = true
+	}
+
+	for _, e := range p.endpoints {
+		if !tried[e.Host] {
+			p.resolved = append(p.resolved, e.Host)
+			return e, func() {}, nil
+		}
+	}
+	return p.endpoints[0], func() {}, nil
+}
+
+func (p *policyResolver) Policy(functionName string) Policy {
+	return p.policy
+}
+
+func newPolicyResolver(t *testing.T, policy Policy, handlers ...http.HandlerFunc) *policyResolver {
+	t.Helper()
+
+	resolver := &policyResolver{policy: policy}
+	for _, h := range handlers {
+		server := httptest.NewServer(h)
+		t.Cleanup(server.Close)
+
+		u, _ := url.Parse(server.URL)
+		resolver.endpoints = append(resolver.endpoints, *u)
+	}
+	return resolver
+}
+
+func invoke(handler http.HandlerFunc, method, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/function/figlet", strings.NewReader(body))
+	req = mux.SetURLVars(req, map[string]string{"name": "figlet"})
+
+	rr := httptest.NewRecorder()
+	handler(rr, req)
+	return rr
+}
+
+func unavailable(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusServiceUnavailable)
+}
+
+func echo(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	w.Write(body)
+}
+
+func Test_Policy_RetriesOnAnotherEndpoint(t *testing.T) {
+	resolver := newPolicyResolver(t, Policy{RetryAttempts: 2, RetryCodes: DefaultRetryCodes}, unavailable, echo)
+	handler := NewHandlerFunc(newTestConfig(), resolver, false)
+
+	rr := invoke(handler, http.MethodPut, "hello")
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want: %d, got: %d", http.StatusOK, rr.Code)
+	}
+	if rr.Body.String() != "hello" {
+		t.Errorf("want the body to be sent again, got: %q", rr.Body.String())
+	}
+	if len(resolver.resolved) != 2 || resolver.resolved[0] == resolver.resolved[1] {
+		t.Errorf("want the retry to be sent to another endpoint, got: %v", resolver.resolved)
+	}
+}
+
+func Test_Policy_DoesNotRetryPost(t *testing.T) {
+	resolver := newPolicyResolver(t, Policy{RetryAttempts: 2, RetryCodes: DefaultRetryCodes}, unavailable, echo)
+	handler := NewHandlerFunc(newTestConfig(), resolver, false)
+
+	rr := invoke(handler, http.MethodPost, "hello")
+
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("want: %d, got: %d", http.StatusServiceUnavailable, rr.Code)
+	}
+	if len(resolver.resolved) != 1 {
+		t.Errorf("want a POST to be sent once, got: %d", len(resolver.resolved))
+	}
+}
+
+func Test_Policy_ReturnsLastResponseAfterRetries(t *testing.T) {
+	resolver := newPolicyResolver(t, Policy{RetryAttempts: 1, RetryCodes: []int{http.StatusServiceUnavailable}}, unavailable, unavailable, echo)
+	handler := NewHandlerFunc(newTestConfig(), resolver, false)
+
+	rr := invoke(handler, http.MethodGet, "")
+
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("want: %d, got: %d", http.StatusServiceUnavailable, rr.Code)
+	}
+	if len(resolver.resolved) != 2 {
+		t.Errorf("want: 2 attempts, got: %d", len(resolver.resolved))
+	}
+}
+
+func Test_Policy_Timeout(t *testing.T) {
+	slow := func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+		}
+	}
+
+	resolver := newPolicyResolver(t, Policy{Timeout: time.Millisecond * 50}, slow)
+	handler := NewHandlerFunc(newTestConfig(), resolver, false)
+
+	start := time.Now()
+	rr := invoke(handler, http.MethodGet, "")
+
+	if rr.Code != http.StatusGatewayTimeout {
+		t.Errorf("want: %d, got: %d", http.StatusGatewayTimeout, rr.Code)
+	}
+	if time.Since(start) > time.Millisecond*500 {
+		t.Errorf("want the invocation to be cancelled by the function's timeout, took: %s", time.Since(start))
+	}
+}
+
+// recordingResolver records the status of each request sent to an endpoint
+type recordingResolver struct {
+	*policyResolver
+	statuses []int
+}
+
+func (r *recordingResolver) RecordResult(functionName, endpoint string, status int, err error, latency time.Duration) {
+	r.statuses = append(r.statuses, status)
+}
+
+func Test_Policy_TimeoutIsPassedToResolver(t *testing.T) {
+	resolver := newPolicyResolver(t, Policy{Timeout: time.Second, RetryAttempts: 1, RetryCodes: DefaultRetryCodes}, unavailable, echo)
+	handler := NewHandlerFunc(newTestConfig(), resolver, false)
+
+	invoke(handler, http.MethodGet, "")
+
+	want := []bool{true, true}
+	if !reflect.DeepEqual(resolver.deadlines, want) {
+		t.Errorf("want: %v, got: %v", want, resolver.deadlines)
+	}
+}
+
+func Test_RecordsResultOfEachAttempt(t *testing.T) {
+	resolver := &recordingResolver{
+		policyResolver: newPolicyResolver(t, Policy{RetryAttempts: 2, RetryCodes: DefaultRetryCodes}, unavailable, echo),
+	}
+	handler := NewHandlerFunc(newTestConfig(), resolver, false)
+
+	invoke(handler, http.MethodGet, "")
+
+	want := []int{http.StatusServiceUnavailable, http.StatusOK}
+	if !reflect.DeepEqual(resolver.statuses, want) {
+		t.Errorf("want: %v, got: %v", want, resolver.statuses)
+	}
+}
@@ -0,0 +1,304 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+// Package proxy invokes functions by proxying requests to one of their endpoints.
+//
+// It follows the proxy from faas-provider, but resolves an endpoint for each request
+// rather than for each function name, so that the request's headers can be used for
+// sticky sessions and so that in-flight requests can be tracked per endpoint.
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"time"
+
+	"github.com/gorilla/mux"
+	fhttputil "github.com/openfaas/faas-provider/httputil"
+	fproxy "github.com/openfaas/faas-provider/proxy"
+	"github.com/openfaas/faas-provider/types"
+)
+
+const (
+	defaultContentType     = "text/plain"
+	openFaaSInternalHeader = "X-OpenFaaS-Internal"
+)
+
+// RequestResolver resolves the URL of one of the endpoints of a function for a request.
+// The returned func is called once the request to the endpoint has completed.
+type RequestResolver interface {
+	ResolveRequest(functionName string, r *http.Request) (url.URL, func(), error)
+}
+
+// ResultRecorder is implemented by a RequestResolver which tracks the health of the
+// endpoints of functions. The proxy records the status, or the error, and the time
+// to the response headers of each request sent to an endpoint.
+type ResultRecorder interface {
+	RecordResult(functionName, endpoint string, status int, err error, latency time.Duration)
+}
+
+// NewHandlerFunc creates a http.HandlerFunc to proxy function requests via resolver.
+// When resolver is a PolicyResolver, the timeout and retries of each function are
+// applied, and when it is a ResultRecorder the result of each request is recorded.
+// When verbose is set to true, the timing of each invocation is logged.
+func NewHandlerFunc(config types.FaaSConfig, resolver RequestResolver, verbose bool) http.HandlerFunc {
+	if resolver == nil {
+		panic("NewHandlerFunc: empty proxy handler resolver, cannot be nil")
+	}
+
+	proxyClient := fproxy.NewProxyClientFromConfig(config)
+
+	reverseProxy := httputil.ReverseProxy{}
+	reverseProxy.Director = func(req *http.Request) {
+		// At least an empty director is required to prevent runtime errors.
+		req.URL.Scheme = "http"
+	}
+	reverseProxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+	}
+
+	// Errors are common during disconnect of client, no need to log them.
+	reverseProxy.ErrorLog = log.New(io.Discard, "", 0)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Body != nil {
+			defer r.Body.Close()
+		}
+
+		switch r.Method {
+		case http.MethodPost,
+			http.MethodPut,
+			http.MethodPatch,
+			http.MethodDelete,
+			http.MethodGet,
+			http.MethodOptions,
+			http.MethodHead:
+			proxyRequest(w, r, proxyClient, resolver, &reverseProxy, verbose)
+
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func proxyRequest(w http.ResponseWriter, originalReq *http.Request, proxyClient *http.Client, resolver RequestResolver, reverseProxy *httputil.ReverseProxy, verbose bool) {
+	ctx := originalReq.Context()
+
+	pathVars := mux.Vars(originalReq)
+	functionName := pathVars["name"]
+	if functionName == "" {
+		w.Header().Add(openFaaSInternalHeader, "proxy")
+
+		fhttputil.Errorf(w, http.StatusBadRequest, "Provide function name in the request path")
+		return
+	}
+
+	streaming := originalReq.Header.Get("Accept") == "text/event-stream" ||
+		originalReq.Header.Get("Upgrade") == "websocket"
+
+	var policy Policy
+	if p, ok := resolver.(PolicyResolver); ok && !streaming {
+		policy = p.Policy(functionName)
+	}
+
+	if policy.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, policy.Timeout)
+		defer cancel()
+	}
+
+	// The body is kept in memory so that it can be sent again
+	retries := 0
+	var body []byte
+	if policy.RetryAttempts > 0 && idempotent(originalReq.Method) {
+		retries = policy.RetryAttempts
+
+		if originalReq.Body != nil {
+			var err error
+			if body, err = io.ReadAll(originalReq.Body); err != nil {
+				fhttputil.Errorf(w, http.StatusBadRequest, "Unable to read request body for: %s.", functionName)
+				return
+			}
+		}
+	}
+
+	// The resolver is given the deadline of the invocation, so that it does not
+	// wait for an endpoint for longer than the function is allowed to run
+	resolveReq := originalReq.WithContext(ctx)
+
+	functionAddr, done, err := resolver.ResolveRequest(functionName, resolveReq)
+	if err != nil {
+		w.Header().Add(openFaaSInternalHeader, "proxy")
+
+		log.Printf("resolver error: no endpoints for %s: %s\n", functionName, err.Error())
+		fhttputil.Errorf(w, http.StatusServiceUnavailable, "No endpoints available for: %s.", functionName)
+		return
+	}
+
+	if verbose {
+		start := time.Now()
+		defer func() {
+			seconds := time.Since(start)
+			log.Printf("%s took %f seconds\n", functionName, seconds.Seconds())
+		}()
+	}
+
+	var tried []string
+	for attempt := 0; ; attempt++ {
+		proxyReq, err := buildProxyRequest(originalReq, functionAddr, pathVars["params"])
+		if err != nil {
+			done()
+			w.Header().Add(openFaaSInternalHeader, "proxy")
+
+			fhttputil.Errorf(w, http.StatusInternalServerError, "Failed to resolve service: %s.", functionName)
+			return
+		}
+
+		if body != nil {
+			proxyReq.Body = io.NopCloser(bytes.NewReader(body))
+			proxyReq.ContentLength = int64(len(body))
+		}
+
+		if streaming {
+			if proxyReq.Body != nil {
+				defer proxyReq.Body.Close()
+			}
+			defer done()
+			originalReq.URL = proxyReq.URL
+
+			reverseProxy.ServeHTTP(w, originalReq)
+			return
+		}
+
+		sent := time.Now()
+		response, err := proxyClient.Do(proxyReq.WithContext(ctx))
+		recordResult(resolver, originalReq, functionName, functionAddr.Host, response, err, time.Since(sent))
+
+		retry := err != nil || policy.retryStatus(response.StatusCode)
+		if retry && attempt < retries && ctx.Err() == nil {
+			tried = append(tried, functionAddr.Host)
+
+			// The next endpoint is resolved before the response is discarded, so
+			// that the response is still returned when there is no endpoint to retry
+			nextAddr, nextDone, resolveErr := resolver.ResolveRequest(functionName, WithTriedEndpoints(resolveReq, tried))
+			if resolveErr == nil {
+				if response != nil {
+					io.Copy(io.Discard, response.Body)
+					response.Body.Close()
+				}
+				if proxyReq.Body != nil {
+					proxyReq.Body.Close()
+				}
+				done()
+
+				if verbose {
+					log.Printf("retrying %s on %s, attempt %d of %d\n", functionName, nextAddr.Host, attempt+1, retries)
+				}
+
+				functionAddr, done = nextAddr, nextDone
+				continue
+			}
+		}
+		if proxyReq.Body != nil {
+			defer proxyReq.Body.Close()
+		}
+		defer done()
+
+		if err != nil {
+			log.Printf("error with proxy request to: %s, %s\n", proxyReq.URL.String(), err.Error())
+
+			w.Header().Add(openFaaSInternalHeader, "proxy")
+
+			if policy.Timeout > 0 && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				fhttputil.Errorf(w, http.StatusGatewayTimeout, "Timed out after %s invoking: %s.", policy.Timeout, functionName)
+				return
+			}
+
+			fhttputil.Errorf(w, http.StatusInternalServerError, "Can't reach service for: %s.", functionName)
+			return
+		}
+
+		if response.Body != nil {
+			defer response.Body.Close()
+		}
+
+		copyHeaders(w.Header(), &response.Header)
+		w.Header().Set("Content-Type", getContentType(originalReq.Header, response.Header))
+
+		w.WriteHeader(response.StatusCode)
+		if response.Body != nil {
+			io.Copy(w, response.Body)
+		}
+		return
+	}
+}
+
+// recordResult records the result of a request when resolver is a ResultRecorder, a
+// request which failed as the caller went away is not held against the endpoint
+func recordResult(resolver RequestResolver, originalReq *http.Request, functionName, endpoint string, response *http.Response, err error, latency time.Duration) {
+	recorder, ok := resolver.(ResultRecorder)
+	if !ok || originalReq.Context().Err() != nil {
+		return
+	}
+
+	status := 0
+	if response != nil {
+		status = response.StatusCode
+	}
+	recorder.RecordResult(functionName, endpoint, status, err, latency)
+}
+
+// buildProxyRequest creates a request object for the proxy request, it will ensure that
+// the original request headers are preserved as well as setting openfaas system headers
+func buildProxyRequest(originalReq *http.Request, baseURL url.URL, extraPath string) (*http.Request, error) {
+	u := url.URL{
+		Scheme:   baseURL.Scheme,
+		Host:     baseURL.Host,
+		Path:     extraPath,
+		RawQuery: originalReq.URL.RawQuery,
+	}
+
+	upstreamReq, err := http.NewRequest(originalReq.Method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	copyHeaders(upstreamReq.Header, &originalReq.Header)
+
+	if len(originalReq.Host) > 0 && upstreamReq.Header.Get("X-Forwarded-Host") == "" {
+		upstreamReq.Header["X-Forwarded-Host"] = []string{originalReq.Host}
+	}
+	if upstreamReq.Header.Get("X-Forwarded-For") == "" {
+		upstreamReq.Header["X-Forwarded-For"] = []string{originalReq.RemoteAddr}
+	}
+
+	if originalReq.Body != nil {
+		upstreamReq.Body = originalReq.Body
+	}
+
+	return upstreamReq, nil
+}
+
+// copyHeaders clones the header values from the source into the destination.
+func copyHeaders(destination http.Header, source *http.Header) {
+	for k, v := range *source {
+		vClone := make([]string, len(v))
+		copy(vClone, v)
+		destination[k] = vClone
+	}
+}
+
+// getContentType resolves the correct Content-Type for a proxied function.
+func getContentType(request http.Header, proxyResponse http.Header) string {
+	if v := proxyResponse.Get("Content-Type"); len(v) > 0 {
+		return v
+	}
+	if v := request.Get("Content-Type"); len(v) > 0 {
+		return v
+	}
+	return defaultContentType
+}
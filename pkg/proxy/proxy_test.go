@@ -0,0 +1,81 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/openfaas/faas-provider/types"
+)
+
+type fakeResolver struct {
+	target   url.URL
+	err      error
+	header   string
+	released int
+}
+
+func (f *fakeResolver) ResolveRequest(functionName string, r *http.Request) (url.URL, func(), error) {
+	if f.err != nil {
+		return url.URL{}, nil, f.err
+	}
+	f.header = r.Header.Get("X-Session-Id")
+	return f.target, func() { f.released++ }, nil
+}
+
+func newTestConfig() types.FaaSConfig {
+	return types.FaaSConfig{ReadTimeout: time.Second, WriteTimeout: time.Second}
+}
+
+func Test_NewHandlerFunc_ProxiesToResolvedEndpoint(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"path":%q}`, r.URL.Path)
+	}))
+	defer upstream.Close()
+
+	target, _ := url.Parse(upstream.URL)
+	resolver := &fakeResolver{target: *target}
+	handler := NewHandlerFunc(newTestConfig(), resolver, false)
+
+	req := httptest.NewRequest(http.MethodPost, "/function/figlet/hello", nil)
+	req.Header.Set("X-Session-Id", "abc")
+	req = mux.SetURLVars(req, map[string]string{"name": "figlet", "params": "hello"})
+	rr := httptest.NewRecorder()
+	handler(rr, req)
+
+	body, _ := io.ReadAll(rr.Body)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want status: %d, got: %d, body: %s", http.StatusOK, rr.Code, string(body))
+	}
+	if string(body) != `{"path":"/hello"}` {
+		t.Errorf("want the sub-path to be proxied, got: %s", string(body))
+	}
+	if resolver.header != "abc" {
+		t.Errorf("want the request to be given to the resolver, got header: %q", resolver.header)
+	}
+	if resolver.released != 1 {
+		t.Errorf("want the endpoint to be released once, got: %d", resolver.released)
+	}
+}
+
+func Test_NewHandlerFunc_NoEndpoints(t *testing.T) {
+	handler := NewHandlerFunc(newTestConfig(), &fakeResolver{err: fmt.Errorf("no addresses")}, false)
+
+	req := httptest.NewRequest(http.MethodGet, "/function/figlet", nil)
+	req = mux.SetURLVars(req, map[string]string{"name": "figlet"})
+	rr := httptest.NewRecorder()
+	handler(rr, req)
+
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("want status: %d, got: %d", http.StatusServiceUnavailable, rr.Code)
+	}
+}
@@ -0,0 +1,117 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+package queue
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/gorilla/mux"
+	fhttputil "github.com/openfaas/faas-provider/httputil"
+	"github.com/openfaas/faas-provider/types"
+)
+
+const (
+	// CallbackURLHeader is the URL which the result of an asynchronous request is posted to
+	CallbackURLHeader = "X-Callback-Url"
+
+	// CallIDHeader identifies an asynchronous request, and is sent to the function
+	// and to the callback
+	CallIDHeader = "X-Call-Id"
+)
+
+// MakeQueueHandler creates a handler which accepts requests for /async-function/{name}
+// and publishes them to queuer. 202 is returned with the X-Call-Id header of the
+// request, which is generated when the caller did not give one.
+func MakeQueueHandler(queuer types.RequestQueuer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Body != nil {
+			defer r.Body.Close()
+		}
+
+		vars := mux.Vars(r)
+		name := vars["name"]
+		if name == "" {
+			fhttputil.Errorf(w, http.StatusBadRequest, "Provide function name in the request path")
+			return
+		}
+
+		var callbackURL *url.URL
+		if v := r.Header.Get(CallbackURLHeader); len(v) > 0 {
+			u, err := parseCallbackURL(v)
+			if err != nil {
+				fhttputil.Errorf(w, http.StatusBadRequest, "%s: %s", CallbackURLHeader, err.Error())
+				return
+			}
+			callbackURL = u
+		}
+
+		var body []byte
+		if r.Body != nil {
+			var err error
+			if body, err = io.ReadAll(r.Body); err != nil {
+				fhttputil.Errorf(w, http.StatusBadRequest, "unable to read request body: %s", err.Error())
+				return
+			}
+		}
+
+		header := r.Header.Clone()
+		callID := header.Get(CallIDHeader)
+		if len(callID) == 0 {
+			callID = newCallID()
+			header.Set(CallIDHeader, callID)
+		}
+
+		req := &types.QueueRequest{
+			Header:      header,
+			Host:        r.Host,
+			Body:        body,
+			Method:      r.Method,
+			Path:        vars["params"],
+			QueryString: r.URL.RawQuery,
+			Function:    name,
+			CallbackURL: callbackURL,
+		}
+
+		if err := queuer.Queue(req); err != nil {
+			log.Printf("Unable to queue request for: %s, error: %s", name, err.Error())
+
+			status := http.StatusInternalServerError
+			if errors.Is(err, ErrQueueFull) {
+				status = http.StatusTooManyRequests
+			}
+			fhttputil.Errorf(w, status, "Unable to queue request for: %s.", name)
+			return
+		}
+
+		w.Header().Set(CallIDHeader, callID)
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// parseCallbackURL parses an absolute http or https URL
+func parseCallbackURL(v string) (*url.URL, error) {
+	u, err := url.Parse(v)
+	if err != nil {
+		return nil, err
+	}
+
+	if (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		return nil, fmt.Errorf("%q must be an absolute http or https URL", v)
+	}
+
+	return u, nil
+}
+
+func newCallID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,78 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+package queue
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func newQueueRequest(body, callbackURL string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/async-function/figlet/hello?name=faas", strings.NewReader(body))
+	if len(callbackURL) > 0 {
+		r.Header.Set(CallbackURLHeader, callbackURL)
+	}
+	return mux.SetURLVars(r, map[string]string{"name": "figlet", "params": "hello"})
+}
+
+func Test_MakeQueueHandler_QueuesRequest(t *testing.T) {
+	queue := NewMemoryQueue(1)
+	handler := MakeQueueHandler(queue)
+
+	rr := httptest.NewRecorder()
+	handler(rr, newQueueRequest("hi", "http://receiver:8080/result"))
+
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("want: %d, got: %d, body: %s", http.StatusAccepted, rr.Code, rr.Body.String())
+	}
+
+	callID := rr.Header().Get(CallIDHeader)
+	if len(callID) == 0 {
+		t.Errorf("want a call id to be generated")
+	}
+
+	req, err := queue.Dequeue(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if req.Function != "figlet" || req.Path != "hello" || req.QueryString != "name=faas" || string(req.Body) != "hi" {
+		t.Errorf("want the request for figlet to be queued, got: %+v", req)
+	}
+	if req.CallbackURL == nil || req.CallbackURL.String() != "http://receiver:8080/result" {
+		t.Errorf("want the callback URL to be queued, got: %v", req.CallbackURL)
+	}
+	if got := req.Header.Get(CallIDHeader); got != callID {
+		t.Errorf("want: %s, got: %s", callID, got)
+	}
+}
+
+func Test_MakeQueueHandler_InvalidCallbackURL(t *testing.T) {
+	handler := MakeQueueHandler(NewMemoryQueue(1))
+
+	rr := httptest.NewRecorder()
+	handler(rr, newQueueRequest("hi", "/result"))
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("want: %d, got: %d", http.StatusBadRequest, rr.Code)
+	}
+}
+
+func Test_MakeQueueHandler_QueueFull(t *testing.T) {
+	handler := MakeQueueHandler(NewMemoryQueue(1))
+
+	handler(httptest.NewRecorder(), newQueueRequest("1", ""))
+
+	rr := httptest.NewRecorder()
+	handler(rr, newQueueRequest("2", ""))
+
+	if rr.Code != http.StatusTooManyRequests {
+		t.Errorf("want: %d, got: %d", http.StatusTooManyRequests, rr.Code)
+	}
+}
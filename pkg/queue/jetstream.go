@@ -0,0 +1,261 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/openfaas/faas-provider/types"
+)
+
+// errCodeStreamStoreFailed is returned by JetStream when a message is published to
+// a stream which holds its maximum number of messages
+const errCodeStreamStoreFailed jetstream.ErrorCode = 10077
+
+// consumerName is the durable consumer shared by each replica of faas-netes
+const consumerName = "faas-netes"
+
+// streamPublisher publishes messages to a stream, as implemented by jetstream.JetStream
+type streamPublisher interface {
+	Publish(ctx context.Context, subject string, data []byte, opts ...jetstream.PublishOpt) (*jetstream.PubAck, error)
+}
+
+// streamConsumer fetches the messages of a stream, as implemented by jetstream.Consumer
+type streamConsumer interface {
+	Next(opts ...jetstream.FetchOpt) (jetstream.Msg, error)
+}
+
+// JetStreamQueue is a Queue which holds requests in a NATS JetStream stream, so that
+// they are kept when faas-netes restarts. A request is acked once it was invoked, and
+// is reported to be in progress until then, so that it is only redelivered when
+// faas-netes stops working on it.
+type JetStreamQueue struct {
+	conn     *nats.Conn
+	js       streamPublisher
+	consumer streamConsumer
+	subject  string
+
+	// FetchWait is how long Dequeue waits for a request before checking its context
+	FetchWait time.Duration
+
+	// PublishTimeout is how long Queue waits for the stream to store a request
+	PublishTimeout time.Duration
+
+	// ProgressInterval is how often a dequeued request is reported to be in progress,
+	// it has to be shorter than the AckWait of the consumer
+	ProgressInterval time.Duration
+
+	lock    sync.Mutex
+	pending map[*types.QueueRequest]*pendingMsg
+}
+
+// pendingMsg is the message of a dequeued request, which is reported to be in
+// progress until it is stopped
+type pendingMsg struct {
+	msg     jetstream.Msg
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// stop returns once the message is no longer reported to be in progress
+func (p *pendingMsg) stop() {
+	close(p.done)
+	<-p.stopped
+}
+
+// NewJetStreamQueue connects to the NATS server at url and creates or updates stream
+// and its consumer. The stream holds up to size requests, and a request is
+// redelivered when it is neither acked nor reported in progress within ackWait.
+func NewJetStreamQueue(ctx context.Context, url, stream string, size int, ackWait time.Duration) (*JetStreamQueue, error) {
+	conn, err := nats.Connect(url, nats.Name("faas-netes"), nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to NATS: %s: %w", url, err)
+	}
+
+	js, err := jetstream.New(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	// The stream name is used as its only subject
+	s, err := js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:      stream,
+		Subjects:  []string{stream},
+		Retention: jetstream.WorkQueuePolicy,
+		Discard:   jetstream.DiscardNew,
+		MaxMsgs:   int64(size),
+		Storage:   jetstream.FileStorage,
+	})
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("unable to create stream: %s: %w", stream, err)
+	}
+
+	consumer, err := s.CreateOrUpdateConsumer(ctx, jetstream.ConsumerConfig{
+		Durable:   consumerName,
+		AckPolicy: jetstream.AckExplicitPolicy,
+		AckWait:   ackWait,
+	})
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("unable to create consumer for stream: %s: %w", stream, err)
+	}
+
+	return &JetStreamQueue{
+		conn:             conn,
+		js:               js,
+		consumer:         consumer,
+		subject:          stream,
+		FetchWait:        time.Second * 5,
+		PublishTimeout:   time.Second * 5,
+		ProgressInterval: ackWait / 3,
+		pending:          map[*types.QueueRequest]*pendingMsg{},
+	}, nil
+}
+
+// Queue publishes req to the stream, or returns ErrQueueFull when the stream is full
+func (q *JetStreamQueue) Queue(req *types.QueueRequest) error {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), q.PublishTimeout)
+	defer cancel()
+
+	if _, err := q.js.Publish(ctx, q.subject, data); err != nil {
+		if streamFull(err) {
+			return ErrQueueFull
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Dequeue returns the oldest request in the stream. Errors from NATS are logged
+// and retried, so that the workers keep running while the server is unavailable.
+func (q *JetStreamQueue) Dequeue(ctx context.Context) (*types.QueueRequest, error) {
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		msg, err := q.consumer.Next(jetstream.FetchMaxWait(q.FetchWait))
+		if err != nil {
+			if !errors.Is(err, nats.ErrTimeout) {
+				log.Printf("Unable to fetch from stream: %s, error: %s", q.subject, err.Error())
+
+				select {
+				case <-time.After(q.FetchWait):
+				case <-ctx.Done():
+				}
+			}
+			continue
+		}
+
+		req := &types.QueueRequest{}
+		if err := json.Unmarshal(msg.Data(), req); err != nil {
+			log.Printf("Unable to decode request from stream: %s, error: %s", q.subject, err.Error())
+
+			// A request which cannot be decoded is never redelivered
+			msg.Term()
+			continue
+		}
+
+		pending := &pendingMsg{msg: msg, done: make(chan struct{}), stopped: make(chan struct{})}
+		go q.inProgress(req, pending)
+
+		q.lock.Lock()
+		q.pending[req] = pending
+		q.lock.Unlock()
+
+		return req, nil
+	}
+}
+
+// inProgress resets the AckWait of the message of req each ProgressInterval, while
+// it waits for its limits, backs off or is invoked
+func (q *JetStreamQueue) inProgress(req *types.QueueRequest, pending *pendingMsg) {
+	defer close(pending.stopped)
+
+	ticker := time.NewTicker(q.ProgressInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := pending.msg.InProgress(); err != nil {
+				log.Printf("Unable to extend the ack wait of request for: %s, error: %s", req.Function, err.Error())
+			}
+		case <-pending.done:
+			return
+		}
+	}
+}
+
+// Ack removes req from the stream
+func (q *JetStreamQueue) Ack(req *types.QueueRequest) error {
+	pending, err := q.take(req)
+	if err != nil {
+		return err
+	}
+
+	return pending.msg.Ack()
+}
+
+// Requeue naks req, so that the stream keeps it and redelivers it once delay has
+// passed
+func (q *JetStreamQueue) Requeue(req *types.QueueRequest, delay time.Duration) error {
+	pending, err := q.take(req)
+	if err != nil {
+		return err
+	}
+
+	return pending.msg.NakWithDelay(delay)
+}
+
+// take removes req from the pending requests, and stops reporting it in progress
+func (q *JetStreamQueue) take(req *types.QueueRequest) (*pendingMsg, error) {
+	q.lock.Lock()
+	pending, ok := q.pending[req]
+	delete(q.pending, req)
+	q.lock.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("request for: %s was not dequeued", req.Function)
+	}
+
+	pending.stop()
+	return pending, nil
+}
+
+// Close closes the connection to NATS, requests which were not acked are redelivered
+// once their AckWait has passed
+func (q *JetStreamQueue) Close() {
+	q.lock.Lock()
+	for req, pending := range q.pending {
+		pending.stop()
+		delete(q.pending, req)
+	}
+	q.lock.Unlock()
+
+	q.conn.Close()
+}
+
+// streamFull returns true when err was returned for a stream which holds its
+// maximum number of messages
+func streamFull(err error) bool {
+	var apiErr *jetstream.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode == errCodeStreamStoreFailed
+}
@@ -0,0 +1,372 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/openfaas/faas-provider/types"
+)
+
+// fakeStream is a work queue stream with a single consumer. A message which was
+// not acked is redelivered by expire, as JetStream does once its AckWait passed.
+type fakeStream struct {
+	lock     sync.Mutex
+	size     int
+	queued   []*fakeMsg
+	inFlight []*fakeMsg
+}
+
+func (s *fakeStream) Publish(ctx context.Context, subject string, data []byte, opts ...jetstream.PublishOpt) (*jetstream.PubAck, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if len(s.queued)+len(s.inFlight) >= s.size {
+		return nil, &jetstream.APIError{Code: 503, ErrorCode: errCodeStreamStoreFailed, Description: "maximum messages exceeded"}
+	}
+
+	s.queued = append(s.queued, &fakeMsg{stream: s, data: data})
+	return &jetstream.PubAck{Stream: subject}, nil
+}
+
+// Next returns nats.ErrTimeout without waiting for FetchMaxWait, when the stream
+// has no messages to deliver
+func (s *fakeStream) Next(opts ...jetstream.FetchOpt) (jetstream.Msg, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if len(s.queued) == 0 {
+		return nil, nats.ErrTimeout
+	}
+
+	msg := s.queued[0]
+	s.queued = s.queued[1:]
+	s.inFlight = append(s.inFlight, msg)
+	msg.deliveries++
+
+	return msg, nil
+}
+
+// expire redelivers the messages which were not acked
+func (s *fakeStream) expire() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.queued = append(s.queued, s.inFlight...)
+	s.inFlight = nil
+}
+
+func (s *fakeStream) len() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return len(s.queued) + len(s.inFlight)
+}
+
+// remove deletes msg from the messages in flight, the lock has to be held
+func (s *fakeStream) remove(msg *fakeMsg) {
+	for i, m := range s.inFlight {
+		if m == msg {
+			s.inFlight = append(s.inFlight[:i], s.inFlight[i+1:]...)
+			return
+		}
+	}
+}
+
+// fakeMsg is a message of a fakeStream, the methods of jetstream.Msg which it does
+// not implement are not used by JetStreamQueue
+type fakeMsg struct {
+	jetstream.Msg
+
+	stream     *fakeStream
+	data       []byte
+	deliveries int
+	acked      bool
+	terminated bool
+	inProgress int
+	nakDelay   time.Duration
+}
+
+func (m *fakeMsg) Data() []byte {
+	return m.data
+}
+
+func (m *fakeMsg) Ack() error {
+	m.stream.lock.Lock()
+	defer m.stream.lock.Unlock()
+
+	m.acked = true
+	m.stream.remove(m)
+	return nil
+}
+
+func (m *fakeMsg) Term() error {
+	m.stream.lock.Lock()
+	defer m.stream.lock.Unlock()
+
+	m.terminated = true
+	m.stream.remove(m)
+	return nil
+}
+
+// NakWithDelay redelivers the message straight away, and records delay
+func (m *fakeMsg) NakWithDelay(delay time.Duration) error {
+	m.stream.lock.Lock()
+	defer m.stream.lock.Unlock()
+
+	m.nakDelay = delay
+	m.stream.remove(m)
+	m.stream.queued = append(m.stream.queued, m)
+	return nil
+}
+
+func (m *fakeMsg) InProgress() error {
+	m.stream.lock.Lock()
+	defer m.stream.lock.Unlock()
+
+	m.inProgress++
+	return nil
+}
+
+func (m *fakeMsg) progress() int {
+	m.stream.lock.Lock()
+	defer m.stream.lock.Unlock()
+
+	return m.inProgress
+}
+
+func newTestJetStreamQueue(t *testing.T, stream *fakeStream) *JetStreamQueue {
+	q := &JetStreamQueue{
+		js:               stream,
+		consumer:         stream,
+		subject:          "faas-request",
+		FetchWait:        time.Millisecond * 10,
+		PublishTimeout:   time.Second,
+		ProgressInterval: time.Millisecond * 10,
+		pending:          map[*types.QueueRequest]*pendingMsg{},
+	}
+	t.Cleanup(q.Close)
+
+	return q
+}
+
+func dequeue(t *testing.T, q *JetStreamQueue) *types.QueueRequest {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	req, err := q.Dequeue(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return req
+}
+
+func Test_streamFull(t *testing.T) {
+	full := fmt.Errorf("nats: %w", &jetstream.APIError{Code: 503, ErrorCode: errCodeStreamStoreFailed, Description: "maximum messages exceeded"})
+	if !streamFull(full) {
+		t.Errorf("want a full stream for: %s", full)
+	}
+
+	if streamFull(errors.New("nats: timeout")) {
+		t.Errorf("want a timeout not to be a full stream")
+	}
+}
+
+func Test_JetStreamQueue_RequestRoundTrip(t *testing.T) {
+	callbackURL, _ := url.Parse("http://gateway.openfaas:8080/function/receiver")
+	req := newTestQueueRequest(callbackURL)
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	got := &types.QueueRequest{}
+	if err := json.Unmarshal(data, got); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if got.CallbackURL.String() != callbackURL.String() {
+		t.Errorf("want: %s, got: %s", callbackURL, got.CallbackURL)
+	}
+	if got.Function != req.Function || string(got.Body) != string(req.Body) {
+		t.Errorf("want: %s with body %q, got: %s with body %q", req.Function, req.Body, got.Function, got.Body)
+	}
+}
+
+func Test_JetStreamQueue_QueueDequeueAck(t *testing.T) {
+	stream := &fakeStream{size: 10}
+	q := newTestJetStreamQueue(t, stream)
+
+	callbackURL, _ := url.Parse("http://gateway.openfaas:8080/function/receiver")
+	if err := q.Queue(newTestQueueRequest(callbackURL)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	req := dequeue(t, q)
+	if req.Function != "figlet" || string(req.Body) != "faas" || req.CallbackURL.String() != callbackURL.String() {
+		t.Errorf("want: figlet with body %q, got: %s with body %q", "faas", req.Function, req.Body)
+	}
+
+	if err := q.Ack(req); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if n := stream.len(); n != 0 {
+		t.Errorf("want the request to be removed from the stream, got: %d messages", n)
+	}
+
+	if err := q.Ack(req); err == nil {
+		t.Errorf("want an error for a request which was already acked")
+	}
+}
+
+func Test_JetStreamQueue_Full(t *testing.T) {
+	q := newTestJetStreamQueue(t, &fakeStream{size: 1})
+
+	if err := q.Queue(newTestQueueRequest(nil)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if err := q.Queue(newTestQueueRequest(nil)); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("want: %s, got: %v", ErrQueueFull, err)
+	}
+}
+
+func Test_JetStreamQueue_RedeliversUnacked(t *testing.T) {
+	stream := &fakeStream{size: 10}
+	q := newTestJetStreamQueue(t, stream)
+
+	if err := q.Queue(newTestQueueRequest(nil)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	dequeue(t, q)
+	msg := stream.inFlight[0]
+
+	// The AckWait passed before the request was acked
+	stream.expire()
+
+	req := dequeue(t, q)
+	if req.Function != "figlet" {
+		t.Errorf("want: %s, got: %s", "figlet", req.Function)
+	}
+	if msg.deliveries != 2 {
+		t.Errorf("want: %d deliveries, got: %d", 2, msg.deliveries)
+	}
+
+	if err := q.Ack(req); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if n := stream.len(); n != 0 {
+		t.Errorf("want the request to be removed from the stream, got: %d messages", n)
+	}
+}
+
+func Test_JetStreamQueue_Requeue(t *testing.T) {
+	stream := &fakeStream{size: 10}
+	q := newTestJetStreamQueue(t, stream)
+
+	if err := q.Queue(newTestQueueRequest(nil)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	req := dequeue(t, q)
+	msg := stream.inFlight[0]
+
+	if err := q.Requeue(req, time.Second); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if msg.nakDelay != time.Second {
+		t.Errorf("want: %s, got: %s", time.Second, msg.nakDelay)
+	}
+
+	reports := msg.progress()
+	time.Sleep(q.ProgressInterval * 5)
+	if got := msg.progress(); got != reports {
+		t.Errorf("want no reports once requeued: %d, got: %d", reports, got)
+	}
+
+	if err := q.Ack(req); err == nil {
+		t.Errorf("want an error for a request which was requeued")
+	}
+
+	req = dequeue(t, q)
+	if msg.deliveries != 2 {
+		t.Errorf("want: %d deliveries, got: %d", 2, msg.deliveries)
+	}
+	if err := q.Ack(req); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+func Test_JetStreamQueue_ReportsInProgress(t *testing.T) {
+	stream := &fakeStream{size: 10}
+	q := newTestJetStreamQueue(t, stream)
+
+	if err := q.Queue(newTestQueueRequest(nil)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	req := dequeue(t, q)
+	msg := stream.inFlight[0]
+
+	deadline := time.Now().Add(time.Second * 5)
+	for msg.progress() < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("want the request to be reported in progress, got: %d reports", msg.progress())
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	if err := q.Ack(req); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	reports := msg.progress()
+	time.Sleep(q.ProgressInterval * 5)
+	if got := msg.progress(); got != reports {
+		t.Errorf("want no reports once acked: %d, got: %d", reports, got)
+	}
+}
+
+func Test_JetStreamQueue_TerminatesInvalidRequest(t *testing.T) {
+	stream := &fakeStream{size: 10}
+	q := newTestJetStreamQueue(t, stream)
+
+	stream.Publish(context.Background(), q.subject, []byte("not json"))
+	invalid := stream.queued[0]
+
+	if err := q.Queue(newTestQueueRequest(nil)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if req := dequeue(t, q); req.Function != "figlet" {
+		t.Errorf("want: %s, got: %s", "figlet", req.Function)
+	}
+	if !invalid.terminated {
+		t.Errorf("want the invalid request to be terminated")
+	}
+}
+
+func Test_JetStreamQueue_DequeueStops(t *testing.T) {
+	q := newTestJetStreamQueue(t, &fakeStream{size: 10})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+
+	if _, err := q.Dequeue(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("want: %s, got: %v", context.DeadlineExceeded, err)
+	}
+}
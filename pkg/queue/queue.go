@@ -0,0 +1,112 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+// Package queue invokes functions asynchronously. Requests to /async-function/{name}
+// are accepted straight away and published to a Queue, then a pool of workers
+// invokes each function and posts its result to the request's X-Callback-Url.
+//
+// The MemoryQueue loses its requests when faas-netes restarts, it is meant for tests
+// and local use. The JetStreamQueue keeps them in a NATS JetStream stream.
+package queue
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/openfaas/faas-provider/types"
+)
+
+// ErrQueueFull is returned by Queue when no more requests can be held
+var ErrQueueFull = errors.New("queue is full")
+
+// Queue holds asynchronous requests until they are invoked by a worker
+type Queue interface {
+	types.RequestQueuer
+
+	// Dequeue blocks until a request is available or ctx is done
+	Dequeue(ctx context.Context) (*types.QueueRequest, error)
+
+	// Ack marks a request returned by Dequeue as processed, a durable queue
+	// redelivers a request which is not acked
+	Ack(req *types.QueueRequest) error
+
+	// Requeue returns a request returned by Dequeue to the queue, it is delivered
+	// again once delay has passed
+	Requeue(req *types.QueueRequest, delay time.Duration) error
+
+	// Close releases the queue once the workers have stopped
+	Close()
+}
+
+// MemoryQueue is a Queue which holds up to a fixed number of requests in memory
+type MemoryQueue struct {
+	requests chan *types.QueueRequest
+	done     chan struct{}
+}
+
+// NewMemoryQueue creates a MemoryQueue which holds up to size requests
+func NewMemoryQueue(size int) *MemoryQueue {
+	return &MemoryQueue{
+		requests: make(chan *types.QueueRequest, size),
+		done:     make(chan struct{}),
+	}
+}
+
+// Queue adds req to the queue, or returns ErrQueueFull when the queue is full
+func (q *MemoryQueue) Queue(req *types.QueueRequest) error {
+	select {
+	case q.requests <- req:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Dequeue returns the oldest request in the queue
+func (q *MemoryQueue) Dequeue(ctx context.Context) (*types.QueueRequest, error) {
+	select {
+	case req := <-q.requests:
+		return req, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Ack does nothing, a request is removed from a MemoryQueue when it is dequeued
+func (q *MemoryQueue) Ack(req *types.QueueRequest) error {
+	return nil
+}
+
+// Requeue adds req to the queue again once delay has passed, without holding up
+// the caller. A requeued request is held until there is room for it in the queue.
+func (q *MemoryQueue) Requeue(req *types.QueueRequest, delay time.Duration) error {
+	go func() {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+		case <-q.done:
+			return
+		}
+
+		select {
+		case q.requests <- req:
+		case <-q.done:
+		}
+	}()
+
+	return nil
+}
+
+// Close drops the requests which wait to be requeued, the requests in a MemoryQueue
+// are lost when faas-netes stops
+func (q *MemoryQueue) Close() {
+	close(q.done)
+}
+
+// Len returns the number of requests in the queue
+func (q *MemoryQueue) Len() int {
+	return len(q.requests)
+}
@@ -0,0 +1,293 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+package queue
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/openfaas/faas-netes/pkg/proxy"
+	"github.com/openfaas/faas-provider/types"
+)
+
+const (
+	// FunctionStatusHeader is the status code of the function, sent to the callback
+	FunctionStatusHeader = "X-Function-Status"
+
+	// FunctionNameHeader is the name of the function, sent to the callback
+	FunctionNameHeader = "X-Function-Name"
+
+	// DurationHeader is how long the invocation took in seconds, sent to the callback
+	DurationHeader = "X-Duration-Seconds"
+)
+
+// Limiter admits the invocations of a function given by its name, with an optional
+// namespace suffix, as implemented by k8s.RateLimiter
+type Limiter interface {
+	Acquire(name string) (release func(), retryAfter time.Duration, ok bool)
+}
+
+// RateLimitedError is returned by Process when the Limiter did not admit an
+// invocation of a function
+type RateLimitedError struct {
+	Function string
+
+	// RetryAfter is how long to wait before the function is invoked again
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitedError) Error() string {
+	return fmt.Sprintf("rate limit reached for: %s, retry after: %s", e.Function, e.RetryAfter)
+}
+
+// Worker invokes the requests of a Queue with a pool of goroutines. An invocation
+// which fails to reach the function, or which the function rejects with 429, 502,
+// 503 or 504, is retried with an exponential backoff.
+type Worker struct {
+	Queue    Queue
+	Resolver proxy.RequestResolver
+	Client   *http.Client
+
+	// Limiter when set admits each invocation, a request which its function's limits
+	// reject is requeued to be invoked after the limiter's retry after, so that the
+	// workers go on to the requests of other functions. Waiting for a limit is not
+	// counted as a retry.
+	Limiter Limiter
+
+	// Concurrency is the number of requests invoked at once
+	Concurrency int
+
+	// MaxRetries is the number of times a failed invocation is retried
+	MaxRetries int
+
+	// Backoff is the delay before the first retry, it is doubled for each retry
+	Backoff time.Duration
+}
+
+// NewWorker creates a Worker which invokes 10 requests at once and retries each
+// failed invocation up to 3 times
+func NewWorker(queue Queue, resolver proxy.RequestResolver, client *http.Client) *Worker {
+	return &Worker{
+		Queue:       queue,
+		Resolver:    resolver,
+		Client:      client,
+		Concurrency: 10,
+		MaxRetries:  3,
+		Backoff:     time.Second,
+	}
+}
+
+// Run invokes requests until stopCh is closed, requests which are in progress are
+// completed before it returns
+func (w *Worker) Run(stopCh <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-stopCh
+		cancel()
+	}()
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < w.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for {
+				req, err := w.Queue.Dequeue(ctx)
+				if err != nil {
+					return
+				}
+				var limited *RateLimitedError
+				if err := w.Process(context.Background(), req); errors.As(err, &limited) {
+					if err := w.Queue.Requeue(req, limited.RetryAfter); err != nil {
+						log.Printf("Unable to requeue request for: %s, error: %s", req.Function, err.Error())
+					}
+					continue
+				}
+
+				if err := w.Queue.Ack(req); err != nil {
+					log.Printf("Unable to ack request for: %s, error: %s", req.Function, err.Error())
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+}
+
+// Process invokes req and posts the result to its callback. When the Limiter does
+// not admit the invocation a *RateLimitedError is returned and nothing is posted,
+// so that req can be queued again.
+func (w *Worker) Process(ctx context.Context, req *types.QueueRequest) error {
+	start := time.Now()
+
+	res, err := w.invokeWithRetries(ctx, req)
+	duration := time.Since(start)
+
+	var limited *RateLimitedError
+	if errors.As(err, &limited) {
+		return err
+	}
+
+	callID := req.Header.Get(CallIDHeader)
+	if err != nil {
+		log.Printf("Async invocation failed: %s, call id: %s, error: %s", req.Function, callID, err.Error())
+		res = &result{status: http.StatusServiceUnavailable, body: []byte(err.Error())}
+	} else {
+		log.Printf("Async invocation: %s, call id: %s, status: %d, took: %.4fs", req.Function, callID, res.status, duration.Seconds())
+	}
+
+	if req.CallbackURL == nil {
+		return nil
+	}
+
+	if err := w.callback(ctx, req, res, duration); err != nil {
+		log.Printf("Unable to post result of: %s to callback: %s, error: %s", req.Function, req.CallbackURL.String(), err.Error())
+	}
+	return nil
+}
+
+// result is the response of a function
+type result struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+func (w *Worker) invokeWithRetries(ctx context.Context, req *types.QueueRequest) (*result, error) {
+	backoff := w.Backoff
+
+	for attempt := 0; ; attempt++ {
+		release, err := w.acquire(req.Function)
+		if err != nil {
+			return nil, err
+		}
+
+		res, err := w.invoke(ctx, req)
+		release()
+		if !retryable(res, err) || attempt >= w.MaxRetries {
+			return res, err
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+// acquire asks the Limiter to admit an invocation of function, the returned func
+// releases it
+func (w *Worker) acquire(function string) (func(), error) {
+	if w.Limiter == nil {
+		return func() {}, nil
+	}
+
+	release, retryAfter, ok := w.Limiter.Acquire(function)
+	if !ok {
+		return nil, &RateLimitedError{Function: function, RetryAfter: retryAfter}
+	}
+	return release, nil
+}
+
+// retryable returns true when the function could not be reached, or was not ready
+func retryable(res *result, err error) bool {
+	if err != nil {
+		return true
+	}
+
+	switch res.status {
+	case http.StatusTooManyRequests,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+func (w *Worker) invoke(ctx context.Context, req *types.QueueRequest) (*result, error) {
+	// The endpoint is resolved for each attempt, so that a retry can use another one
+	r, err := http.NewRequestWithContext(ctx, req.Method, "/", bytes.NewReader(req.Body))
+	if err != nil {
+		return nil, err
+	}
+	r.Header = req.Header.Clone()
+
+	functionAddr, done, err := w.Resolver.ResolveRequest(req.Function, r)
+	if err != nil {
+		return nil, fmt.Errorf("no endpoints available for: %s: %s", req.Function, err.Error())
+	}
+	defer done()
+
+	u := url.URL{
+		Scheme:   functionAddr.Scheme,
+		Host:     functionAddr.Host,
+		Path:     req.Path,
+		RawQuery: req.QueryString,
+	}
+
+	// Parsed from its string, so that the path is given a leading slash
+	if r.URL, err = url.Parse(u.String()); err != nil {
+		return nil, err
+	}
+	r.Host = r.URL.Host
+
+	if len(req.Host) > 0 && r.Header.Get("X-Forwarded-Host") == "" {
+		r.Header.Set("X-Forwarded-Host", req.Host)
+	}
+
+	res, err := w.Client.Do(r)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result{status: res.StatusCode, header: res.Header, body: body}, nil
+}
+
+// callback posts the result of an invocation to the callback URL of req
+func (w *Worker) callback(ctx context.Context, req *types.QueueRequest, res *result, duration time.Duration) error {
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, req.CallbackURL.String(), bytes.NewReader(res.body))
+	if err != nil {
+		return err
+	}
+
+	if v := res.header.Get("Content-Type"); len(v) > 0 {
+		r.Header.Set("Content-Type", v)
+	}
+	r.Header.Set(CallIDHeader, req.Header.Get(CallIDHeader))
+	r.Header.Set(FunctionNameHeader, req.Function)
+	r.Header.Set(FunctionStatusHeader, strconv.Itoa(res.status))
+	r.Header.Set(DurationHeader, strconv.FormatFloat(duration.Seconds(), 'f', 4, 64))
+
+	callbackRes, err := w.Client.Do(r)
+	if err != nil {
+		return err
+	}
+	defer callbackRes.Body.Close()
+	io.Copy(io.Discard, callbackRes.Body)
+
+	if callbackRes.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("unexpected status code: %d", callbackRes.StatusCode)
+	}
+
+	return nil
+}
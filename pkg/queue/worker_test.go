@@ -0,0 +1,320 @@
+// License: OpenFaaS Community Edition (CE) EULA
+// Copyright (c) 2017,2019-2024 OpenFaaS Author(s)
+
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/openfaas/faas-provider/types"
+)
+
+type fakeResolver struct {
+	target url.URL
+	err    error
+}
+
+func (f *fakeResolver) ResolveRequest(functionName string, r *http.Request) (url.URL, func(), error) {
+	if f.err != nil {
+		return url.URL{}, nil, f.err
+	}
+	return f.target, func() {}, nil
+}
+
+// callbackReceiver records the results posted to it
+type callbackReceiver struct {
+	lock    sync.Mutex
+	headers []http.Header
+	bodies  []string
+}
+
+func (c *callbackReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.headers = append(c.headers, r.Header.Clone())
+	c.bodies = append(c.bodies, string(body))
+}
+
+func newTestWorker(t *testing.T, function http.HandlerFunc) (*Worker, *callbackReceiver, *url.URL) {
+	t.Helper()
+
+	upstream := httptest.NewServer(function)
+	t.Cleanup(upstream.Close)
+
+	receiver := &callbackReceiver{}
+	callback := httptest.NewServer(receiver)
+	t.Cleanup(callback.Close)
+
+	target, _ := url.Parse(upstream.URL)
+	callbackURL, _ := url.Parse(callback.URL)
+
+	worker := NewWorker(NewMemoryQueue(10), &fakeResolver{target: *target}, http.DefaultClient)
+	worker.Backoff = time.Millisecond
+	return worker, receiver, callbackURL
+}
+
+func newTestQueueRequest(callbackURL *url.URL) *types.QueueRequest {
+	return &types.QueueRequest{
+		Header:      http.Header{CallIDHeader: []string{"call-1"}},
+		Method:      http.MethodPost,
+		Path:        "hello",
+		Body:        []byte("faas"),
+		Function:    "figlet",
+		CallbackURL: callbackURL,
+	}
+}
+
+func Test_Worker_PostsResultToCallback(t *testing.T) {
+	worker, receiver, callbackURL := newTestWorker(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, "%s %s", r.URL.Path, string(body))
+	})
+
+	worker.Process(context.Background(), newTestQueueRequest(callbackURL))
+
+	if len(receiver.bodies) != 1 {
+		t.Fatalf("want one callback, got: %d", len(receiver.bodies))
+	}
+	if receiver.bodies[0] != "/hello faas" {
+		t.Errorf("want the function's response, got: %s", receiver.bodies[0])
+	}
+
+	header := receiver.headers[0]
+	if got := header.Get(FunctionStatusHeader); got != "201" {
+		t.Errorf("want status: 201, got: %s", got)
+	}
+	if got := header.Get(CallIDHeader); got != "call-1" {
+		t.Errorf("want call id: call-1, got: %s", got)
+	}
+	if got := header.Get(FunctionNameHeader); got != "figlet" {
+		t.Errorf("want function: figlet, got: %s", got)
+	}
+}
+
+func Test_Worker_RetriesUnavailableFunction(t *testing.T) {
+	attempts := 0
+	worker, receiver, callbackURL := newTestWorker(t, func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	})
+
+	worker.Process(context.Background(), newTestQueueRequest(callbackURL))
+
+	if attempts != 3 {
+		t.Errorf("want: 3 attempts, got: %d", attempts)
+	}
+	if got := receiver.headers[0].Get(FunctionStatusHeader); got != "200" {
+		t.Errorf("want status: 200, got: %s", got)
+	}
+	if receiver.bodies[0] != "faas" {
+		t.Errorf("want the body to be sent on each attempt, got: %s", receiver.bodies[0])
+	}
+}
+
+// fakeLimiter rejects the first invocations of each function given by reject, then
+// admits the rest
+type fakeLimiter struct {
+	lock       sync.Mutex
+	reject     map[string]int
+	retryAfter time.Duration
+	names      []string
+	inflight   int
+}
+
+func (f *fakeLimiter) Acquire(name string) (func(), time.Duration, bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.names = append(f.names, name)
+	if f.reject[name] > 0 {
+		f.reject[name]--
+		return nil, f.retryAfter, false
+	}
+
+	f.inflight++
+	return func() {
+		f.lock.Lock()
+		defer f.lock.Unlock()
+		f.inflight--
+	}, 0, true
+}
+
+func Test_Worker_ReturnsRateLimited(t *testing.T) {
+	attempts := 0
+	worker, receiver, callbackURL := newTestWorker(t, func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusOK)
+	})
+	limiter := &fakeLimiter{reject: map[string]int{"figlet": 1}, retryAfter: time.Second}
+	worker.Limiter = limiter
+	worker.MaxRetries = 0
+
+	err := worker.Process(context.Background(), newTestQueueRequest(callbackURL))
+
+	var limited *RateLimitedError
+	if !errors.As(err, &limited) {
+		t.Fatalf("want: a rate limited error, got: %v", err)
+	}
+	if limited.Function != "figlet" || limited.RetryAfter != time.Second {
+		t.Errorf("want: figlet after %s, got: %s after %s", time.Second, limited.Function, limited.RetryAfter)
+	}
+	if attempts != 0 {
+		t.Errorf("want: 0 attempts, got: %d", attempts)
+	}
+	if len(receiver.bodies) != 0 {
+		t.Errorf("want no callback for a rate limited request, got: %d", len(receiver.bodies))
+	}
+
+	// A rejection by the limiter does not use up a retry
+	if err := worker.Process(context.Background(), newTestQueueRequest(callbackURL)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if attempts != 1 {
+		t.Errorf("want: 1 attempt, got: %d", attempts)
+	}
+	if limiter.inflight != 0 {
+		t.Errorf("want the invocation to be released, got: %d in flight", limiter.inflight)
+	}
+	if got := receiver.headers[0].Get(FunctionStatusHeader); got != "200" {
+		t.Errorf("want status: 200, got: %s", got)
+	}
+}
+
+func Test_Worker_Run_RequeuesRateLimited(t *testing.T) {
+	worker, receiver, callbackURL := newTestWorker(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	worker.Limiter = &fakeLimiter{reject: map[string]int{"figlet": 2}, retryAfter: time.Millisecond * 100}
+	worker.Concurrency = 1
+
+	stopCh := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		worker.Run(stopCh)
+		close(stopped)
+	}()
+
+	env := newTestQueueRequest(callbackURL)
+	env.Function = "env"
+	for _, req := range []*types.QueueRequest{newTestQueueRequest(callbackURL), env} {
+		if err := worker.Queue.Queue(req); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	deadline := time.Now().Add(time.Second * 5)
+	for {
+		receiver.lock.Lock()
+		n := len(receiver.headers)
+		receiver.lock.Unlock()
+
+		if n == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("want: 2 callbacks, got: %d", n)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	close(stopCh)
+	<-stopped
+
+	// The only worker invoked env while figlet waited for its limit
+	got := []string{}
+	for _, header := range receiver.headers {
+		got = append(got, header.Get(FunctionNameHeader))
+	}
+	want := []string{"env", "figlet"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want: %v, got: %v", want, got)
+	}
+}
+
+func Test_Worker_GivesUpAfterMaxRetries(t *testing.T) {
+	attempts := 0
+	worker, receiver, callbackURL := newTestWorker(t, func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	worker.MaxRetries = 2
+
+	worker.Process(context.Background(), newTestQueueRequest(callbackURL))
+
+	if attempts != 3 {
+		t.Errorf("want: 3 attempts, got: %d", attempts)
+	}
+	if got := receiver.headers[0].Get(FunctionStatusHeader); got != "502" {
+		t.Errorf("want status: 502, got: %s", got)
+	}
+}
+
+func Test_Worker_DoesNotRetryFunctionErrors(t *testing.T) {
+	attempts := 0
+	worker, _, callbackURL := newTestWorker(t, func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	worker.Process(context.Background(), newTestQueueRequest(callbackURL))
+
+	if attempts != 1 {
+		t.Errorf("want: 1 attempt, got: %d", attempts)
+	}
+}
+
+func Test_Worker_Run(t *testing.T) {
+	worker, receiver, callbackURL := newTestWorker(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+
+	stopCh := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		worker.Run(stopCh)
+		close(stopped)
+	}()
+
+	for i := 0; i < 5; i++ {
+		if err := worker.Queue.Queue(newTestQueueRequest(callbackURL)); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	deadline := time.Now().Add(time.Second * 5)
+	for {
+		receiver.lock.Lock()
+		n := len(receiver.bodies)
+		receiver.lock.Unlock()
+
+		if n == 5 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("want: 5 callbacks, got: %d", n)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	close(stopCh)
+	<-stopped
+}